@@ -1,23 +1,81 @@
 package main
 
 import (
+	"TotalControl/backend/catalogue"
+	"TotalControl/backend/dependencies"
+	"TotalControl/backend/downloads"
+	"TotalControl/backend/httpclient"
+	"TotalControl/backend/images"
+	"TotalControl/backend/install"
+	"TotalControl/backend/loadorder"
+	"TotalControl/backend/modpack"
+	"TotalControl/backend/mods"
+	"TotalControl/backend/profiles"
+	"TotalControl/backend/scripting"
+	"TotalControl/backend/secrets"
+	"TotalControl/backend/updates"
 	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"io/fs"
+	"path"
+	"strings"
 )
 
+const pluginsDir = "plugins"
+
 // App struct
 type App struct {
-	ctx context.Context
+	ctx       context.Context
+	downloads *downloads.Manager
+	secrets   *secrets.Vault
+}
+
+// SecretsState tells the frontend whether it has to ask for the vault passphrase.
+type SecretsState struct {
+	Locked             bool `json:"locked"`
+	RequiresPassphrase bool `json:"requires_passphrase"`
+}
+
+// PluginSecret is a secret declared in a plugin manifest. The value is never sent to the frontend.
+type PluginSecret struct {
+	PluginID   string `json:"plugin_id"`
+	PluginName string `json:"plugin_name"`
+	secrets.Declaration
+	IsSet bool `json:"is_set"`
+}
+
+// ProfileList is the profiles of a game and the name of the active one, empty if none is.
+type ProfileList struct {
+	Profiles []profiles.Profile `json:"profiles"`
+	Active   string             `json:"active"`
 }
 
 // NewApp creates a new App application struct
 func NewApp() *App {
-	return &App{}
+	return &App{
+		downloads: downloads.Default(),
+		secrets:   secrets.Default(),
+	}
 }
 
 // startup is called at application startup
 func (a *App) startup(ctx context.Context) {
 	// Perform your setup here
 	a.ctx = ctx
+	if err := httpclient.DefaultLimiter().LoadFile(httpclient.DefaultRateLimitsFile); err != nil {
+		log.Errorf("Failed to load rate limits, using the defaults: %v", err)
+	}
+	a.downloads.OnProgress(func(progress downloads.Progress) {
+		runtime.EventsEmit(a.ctx, "download:progress", progress)
+	})
+	if err := install.Default().Recover(); err != nil {
+		log.Errorf("Failed to roll back interrupted mod installs: %v", err)
+	}
+	install.Default().OnStep(func(plan *install.Plan, step install.Step) {
+		runtime.EventsEmit(a.ctx, "install:step", plan.GameID, step)
+	})
 }
 
 // domReady is called after the front-end dom has been loaded
@@ -37,4 +95,417 @@ func (a *App) beforeClose(ctx context.Context) (prevent bool) {
 func (a *App) shutdown(ctx context.Context) {
 	// Perform your teardown here
 	// 在此处做一些资源释放的操作
+	a.downloads.Close()
+}
+
+// EnqueueDownload queues a download, progress is reported through the "download:progress" event.
+// checksumAlgorithm and checksum may be empty to skip verification.
+func (a *App) EnqueueDownload(url string, destination string, checksumAlgorithm string, checksum string, priority int) (string, error) {
+	request := downloads.Request{
+		URL:         url,
+		Destination: destination,
+		Priority:    priority,
+		Retries:     3,
+	}
+	if checksumAlgorithm != "" {
+		request.Checksum = &downloads.Checksum{Algorithm: checksumAlgorithm, Value: checksum}
+	}
+	download, err := a.downloads.Enqueue(request)
+	if err != nil {
+		return "", err
+	}
+	return download.ID, nil
+}
+
+// CancelDownload cancels a queued or running download.
+func (a *App) CancelDownload(id string) error {
+	return a.downloads.Cancel(id)
+}
+
+// GetDownloads returns the state of all downloads.
+func (a *App) GetDownloads() []downloads.Progress {
+	return a.downloads.List()
+}
+
+// ClearFinishedDownloads removes completed, failed and cancelled downloads from the list.
+func (a *App) ClearFinishedDownloads() {
+	a.downloads.Forget()
+}
+
+// GetNetworkStats returns request and throttling counters per host.
+func (a *App) GetNetworkStats() map[string]httpclient.HostStats {
+	return httpclient.DefaultLimiter().Stats()
+}
+
+// GetGameThumbnail returns the capsule artwork of a game cropped to the size of Steam header images.
+func (a *App) GetGameThumbnail(slug string) (*images.Thumbnail, error) {
+	if slug == "" || slug == "." || slug == ".." || strings.ContainsAny(slug, "/\\") {
+		return nil, fmt.Errorf("invalid game %q", slug)
+	}
+	data, err := fs.ReadFile(assets, path.Join("frontend/dist/images", slug, "capsule.jpg"))
+	if err != nil {
+		return nil, err
+	}
+	return images.DefaultThumbnails().Get(data, images.GameThumbnailWidth, images.GameThumbnailHeight)
+}
+
+// GetCatalogueMods returns a page of a game's cached mod catalogue. It is empty until RefreshCatalogue ran once.
+func (a *App) GetCatalogueMods(gameID string, query catalogue.Query) (*catalogue.Page, error) {
+	return catalogue.DefaultStore().Query(gameID, query)
+}
+
+// RefreshCatalogue fetches what changed in the catalogue of a plugin's game since the last refresh.
+func (a *App) RefreshCatalogue(pluginID string) error {
+	plugin, err := a.loadPlugin(pluginID)
+	if err != nil {
+		return err
+	}
+	defer plugin.Close()
+	provider, err := plugin.Provider()
+	if err != nil {
+		return err
+	}
+	return catalogue.DefaultStore().Refresh(a.ctx, provider.GameID(), provider)
+}
+
+// GetModThumbnail returns the thumbnail of an installed mod. Thumbnails are only generated when the UI asks for
+// them, and are cached afterwards.
+func (a *App) GetModThumbnail(pluginID string, modID string) (*images.Thumbnail, error) {
+	plugin, err := a.loadPlugin(pluginID)
+	if err != nil {
+		return nil, err
+	}
+	defer plugin.Close()
+	provider, err := plugin.Provider()
+	if err != nil {
+		return nil, err
+	}
+	mod, err := provider.GetMod(a.ctx, modID)
+	if err != nil {
+		return nil, err
+	}
+	return mod.Thumbnail(images.DefaultThumbnails())
+}
+
+// InstallMod installs a mod through a plugin, the latest version if version is empty.
+func (a *App) InstallMod(pluginID string, modID string, version string) (*mods.Mod, error) {
+	var mod *mods.Mod
+	err := a.withProvider(pluginID, func(provider *scripting.LuaProvider) (err error) {
+		mod, err = provider.InstallMod(a.ctx, modID, version)
+		return err
+	})
+	return mod, err
+}
+
+// UpdateMod replaces an installed mod with another version, the latest if version is empty.
+func (a *App) UpdateMod(pluginID string, modID string, version string) (*mods.Mod, error) {
+	var mod *mods.Mod
+	err := a.withProvider(pluginID, func(provider *scripting.LuaProvider) (err error) {
+		mod, err = provider.UpdateMod(a.ctx, modID, version)
+		return err
+	})
+	return mod, err
+}
+
+// UninstallMod removes an installed mod through a plugin.
+func (a *App) UninstallMod(pluginID string, modID string) error {
+	return a.withProvider(pluginID, func(provider *scripting.LuaProvider) error {
+		return provider.UninstallMod(a.ctx, modID)
+	})
+}
+
+// GetLoadOrder returns the saved load order and rules of a game.
+func (a *App) GetLoadOrder(gameID string) (*loadorder.State, error) {
+	return loadorder.DefaultStore().Load(gameID)
+}
+
+// SortLoadOrder sorts the enabled mods of a plugin's game with the saved rules and returns the new order.
+func (a *App) SortLoadOrder(pluginID string) ([]string, error) {
+	var order []string
+	err := a.withProvider(pluginID, func(provider *scripting.LuaProvider) error {
+		enabled, writer, err := a.loadOrderInput(provider)
+		if err != nil {
+			return err
+		}
+		order, err = loadorder.DefaultStore().Update(a.ctx, provider.GameID(), enabled, writer)
+		return err
+	})
+	return order, err
+}
+
+// AddLoadOrderRule adds a rule to the load order of a plugin's game and returns the new order. A rule that
+// contradicts the dependencies or the other rules is refused.
+func (a *App) AddLoadOrderRule(pluginID string, rule loadorder.Rule) ([]string, error) {
+	var order []string
+	err := a.withProvider(pluginID, func(provider *scripting.LuaProvider) error {
+		enabled, writer, err := a.loadOrderInput(provider)
+		if err != nil {
+			return err
+		}
+		order, err = loadorder.DefaultStore().AddRule(a.ctx, provider.GameID(), rule, enabled, writer)
+		return err
+	})
+	return order, err
+}
+
+// RemoveLoadOrderRule removes a rule from the load order of a plugin's game and returns the new order.
+func (a *App) RemoveLoadOrderRule(pluginID string, rule loadorder.Rule) ([]string, error) {
+	var order []string
+	err := a.withProvider(pluginID, func(provider *scripting.LuaProvider) error {
+		enabled, writer, err := a.loadOrderInput(provider)
+		if err != nil {
+			return err
+		}
+		order, err = loadorder.DefaultStore().RemoveRule(a.ctx, provider.GameID(), rule, enabled, writer)
+		return err
+	})
+	return order, err
+}
+
+// loadOrderInput returns the enabled mods of a provider, and the provider as the writer of the load order if its
+// game has a load order file.
+func (a *App) loadOrderInput(provider *scripting.LuaProvider) ([]dependencies.Release, loadorder.Writer, error) {
+	installed, err := provider.InstalledMods(a.ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var enabled []dependencies.Release
+	for _, mod := range installed {
+		if mod.Enabled {
+			enabled = append(enabled, dependencies.ReleaseFromMod(mod))
+		}
+	}
+	if !provider.Capabilities().LoadOrder {
+		return enabled, nil, nil
+	}
+	return enabled, provider, nil
+}
+
+// GetProfiles lists the profiles of a game.
+func (a *App) GetProfiles(gameID string) (*ProfileList, error) {
+	list, active, err := profiles.DefaultManager().List(gameID)
+	if err != nil {
+		return nil, err
+	}
+	return &ProfileList{Profiles: list, Active: active}, nil
+}
+
+// CreateProfile saves the enabled mods of a plugin's game as a new profile.
+func (a *App) CreateProfile(pluginID string, name string) (*profiles.Profile, error) {
+	var profile *profiles.Profile
+	err := a.withProvider(pluginID, func(provider *scripting.LuaProvider) (err error) {
+		profile, err = profiles.DefaultManager().Create(a.ctx, provider, name)
+		return err
+	})
+	return profile, err
+}
+
+func (a *App) CloneProfile(gameID string, name string, newName string) (*profiles.Profile, error) {
+	return profiles.DefaultManager().Clone(gameID, name, newName)
+}
+
+func (a *App) RenameProfile(gameID string, name string, newName string) error {
+	return profiles.DefaultManager().Rename(gameID, name, newName)
+}
+
+func (a *App) DeleteProfile(gameID string, name string) error {
+	return profiles.DefaultManager().Delete(gameID, name)
+}
+
+// SwitchProfile makes the installed mods of a plugin's game match a profile. It is refused while the game runs.
+func (a *App) SwitchProfile(pluginID string, name string) error {
+	return a.withProvider(pluginID, func(provider *scripting.LuaProvider) error {
+		return profiles.DefaultManager().Switch(a.ctx, provider, name)
+	})
+}
+
+// ExportModpack describes the enabled mods of a plugin's game as a modpack, with its mod settings if settings is set.
+func (a *App) ExportModpack(pluginID string, name string, settings bool) (*modpack.Manifest, error) {
+	var manifest *modpack.Manifest
+	err := a.withProvider(pluginID, func(provider *scripting.LuaProvider) (err error) {
+		manifest, err = modpack.Export(a.ctx, provider, modpack.ExportOptions{Name: name, Settings: settings})
+		return err
+	})
+	return manifest, err
+}
+
+// ExportModpackShareCode is ExportModpack as a share code. Share codes leave out the mod settings.
+func (a *App) ExportModpackShareCode(pluginID string, name string) (string, error) {
+	manifest, err := a.ExportModpack(pluginID, name, false)
+	if err != nil {
+		return "", err
+	}
+	return modpack.EncodeShareCode(manifest)
+}
+
+// DiffModpack lists what importing a modpack would change. source is a share code or the path of a bundle.
+func (a *App) DiffModpack(pluginID string, source string) ([]modpack.Change, error) {
+	manifest, bundle, err := openModpack(source)
+	if err != nil {
+		return nil, err
+	}
+	if bundle != nil {
+		defer bundle.Close()
+	}
+	var changes []modpack.Change
+	err = a.withProvider(pluginID, func(provider *scripting.LuaProvider) error {
+		installed, err := provider.InstalledMods(a.ctx)
+		if err != nil {
+			return err
+		}
+		changes = modpack.Diff(manifest, installed)
+		return nil
+	})
+	return changes, err
+}
+
+// ImportModpack installs and enables the mods of a modpack, see DiffModpack. The mod settings are only overwritten
+// if settings is set.
+func (a *App) ImportModpack(pluginID string, source string, settings bool) error {
+	manifest, bundle, err := openModpack(source)
+	if err != nil {
+		return err
+	}
+	if bundle != nil {
+		defer bundle.Close()
+	}
+	return a.withProvider(pluginID, func(provider *scripting.LuaProvider) error {
+		return modpack.Import(a.ctx, provider, manifest, modpack.ImportOptions{Bundle: bundle, Settings: settings})
+	})
+}
+
+// openModpack reads a modpack from a bundle file or a share code. The caller must close the bundle if it is not nil.
+func openModpack(source string) (*modpack.Manifest, *modpack.Bundle, error) {
+	if strings.HasSuffix(strings.ToLower(source), modpack.Extension) {
+		bundle, err := modpack.OpenBundle(source)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bundle.Manifest, bundle, nil
+	}
+	manifest, err := modpack.DecodeShareCode(source)
+	return manifest, nil, err
+}
+
+// CheckUpdates lists the updates of the installed mods of a plugin's game. gameVersion may be empty to offer
+// releases for every game version.
+func (a *App) CheckUpdates(pluginID string, gameVersion string) (*updates.Report, error) {
+	var report *updates.Report
+	err := a.withProvider(pluginID, func(provider *scripting.LuaProvider) (err error) {
+		report, err = updates.Check(a.ctx, provider, updates.CheckOptions{GameVersion: gameVersion})
+		return err
+	})
+	return report, err
+}
+
+// ApplyUpdates installs the selected updates as one batch, either all of them or none.
+func (a *App) ApplyUpdates(pluginID string, selected []updates.Update) error {
+	return a.withProvider(pluginID, func(provider *scripting.LuaProvider) error {
+		return updates.Apply(a.ctx, provider, selected)
+	})
+}
+
+// PinMod keeps a mod at a version, CheckUpdates no longer offers updates for it.
+func (a *App) PinMod(gameID string, modID string, modVersion string) error {
+	return updates.DefaultPins().Pin(gameID, modID, modVersion)
+}
+
+func (a *App) UnpinMod(gameID string, modID string) error {
+	return updates.DefaultPins().Unpin(gameID, modID)
+}
+
+// GetPinnedMods returns the pinned mods of a game with their versions.
+func (a *App) GetPinnedMods(gameID string) (map[string]string, error) {
+	return updates.DefaultPins().Load(gameID)
+}
+
+// withProvider loads a plugin, runs fn with its mod provider and closes the plugin again.
+func (a *App) withProvider(pluginID string, fn func(provider *scripting.LuaProvider) error) error {
+	plugin, err := a.loadPlugin(pluginID)
+	if err != nil {
+		return err
+	}
+	defer plugin.Close()
+	provider, err := plugin.Provider()
+	if err != nil {
+		return err
+	}
+	return fn(provider)
+}
+
+// loadPlugin loads an installed plugin by its ID. The caller must close it.
+func (a *App) loadPlugin(pluginID string) (*scripting.LuaPlugin, error) {
+	plugins, err := scripting.FindPlugins(pluginsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range plugins {
+		if info.Id.String() != pluginID {
+			continue
+		}
+		if strings.HasSuffix(info.PluginDir, scripting.PluginExtension) {
+			return scripting.LoadLuaPluginFromZip(info.PluginDir, scripting.WithSecrets(a.secrets))
+		}
+		return scripting.LoadLuaPlugin(info.PluginDir, scripting.WithSecrets(a.secrets))
+	}
+	return nil, fmt.Errorf("plugin %s is not installed", pluginID)
+}
+
+func (a *App) GetSecretsState() SecretsState {
+	return SecretsState{Locked: a.secrets.Locked(), RequiresPassphrase: a.secrets.RequiresPassphrase()}
+}
+
+// UnlockSecrets unlocks the vault with a passphrase, creating a passphrase protected vault if there is none yet.
+func (a *App) UnlockSecrets(passphrase string) error {
+	return a.secrets.UnlockWithPassphrase(passphrase)
+}
+
+// GetDeclaredSecrets lists the secrets all installed plugins declare, and whether they are set.
+func (a *App) GetDeclaredSecrets() ([]PluginSecret, error) {
+	plugins, err := scripting.FindPlugins(pluginsDir)
+	if err != nil {
+		return nil, err
+	}
+	var result []PluginSecret
+	for _, plugin := range plugins {
+		for _, declaration := range plugin.Secrets {
+			// A locked vault reports every secret as unset, the frontend asks to unlock it first
+			_, err := a.secrets.Get(plugin.Id.String(), declaration.Name)
+			result = append(result, PluginSecret{
+				PluginID:    plugin.Id.String(),
+				PluginName:  plugin.Name,
+				Declaration: declaration,
+				IsSet:       err == nil,
+			})
+		}
+	}
+	return result, nil
+}
+
+// SetPluginSecret stores a secret for a plugin. Only secrets declared in the plugin's manifest can be set.
+func (a *App) SetPluginSecret(pluginID string, name string, value string) error {
+	if err := a.checkDeclaredSecret(pluginID, name); err != nil {
+		return err
+	}
+	return a.secrets.Set(pluginID, name, value)
+}
+
+func (a *App) DeletePluginSecret(pluginID string, name string) error {
+	if err := a.checkDeclaredSecret(pluginID, name); err != nil {
+		return err
+	}
+	return a.secrets.Delete(pluginID, name)
+}
+
+func (a *App) checkDeclaredSecret(pluginID string, name string) error {
+	declared, err := a.GetDeclaredSecrets()
+	if err != nil {
+		return err
+	}
+	for _, secret := range declared {
+		if secret.PluginID == pluginID && secret.Name == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("plugin %s does not declare a secret named %s", pluginID, name)
 }
@@ -0,0 +1,301 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Format int
+
+const (
+	FormatUnknown Format = iota
+	FormatZip
+	FormatTar
+	FormatTarGz
+)
+
+// ErrUnsafePath is returned when an archive entry would be written outside the destination directory.
+var ErrUnsafePath = errors.New("archive entry escapes the destination directory")
+
+// ErrUnsupportedFormat is returned when the archive format cannot be detected from the file name.
+var ErrUnsupportedFormat = errors.New("unsupported archive format")
+
+type Entry struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	IsDir   bool        `json:"is_dir"`
+}
+
+type ExtractOptions struct {
+	// Include limits extraction to entries matching at least one of these globs. Empty means everything.
+	Include []string
+	// Exclude skips entries matching any of these globs, even if they are included.
+	Exclude []string
+	// StripComponents removes this many leading path elements from every entry, like tar --strip-components.
+	StripComponents int
+}
+
+type CreateOptions struct {
+	Include []string
+	Exclude []string
+}
+
+func (f Format) String() string {
+	switch f {
+	case FormatZip:
+		return "zip"
+	case FormatTar:
+		return "tar"
+	case FormatTarGz:
+		return "tar.gz"
+	default:
+		return "unknown"
+	}
+}
+
+// DetectFormat guesses the archive format from the file name. The Factorio .tcplugin extension is a zip.
+func DetectFormat(archivePath string) Format {
+	name := strings.ToLower(filepath.Base(archivePath))
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTarGz
+	case strings.HasSuffix(name, ".tar"):
+		return FormatTar
+	case strings.HasSuffix(name, ".zip"), strings.HasSuffix(name, ".tcplugin"):
+		return FormatZip
+	default:
+		return FormatUnknown
+	}
+}
+
+// List returns the entries of an archive without extracting them.
+func List(archivePath string) ([]Entry, error) {
+	switch DetectFormat(archivePath) {
+	case FormatZip:
+		return listZip(archivePath)
+	case FormatTar:
+		return listTar(archivePath, false)
+	case FormatTarGz:
+		return listTar(archivePath, true)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, archivePath)
+	}
+}
+
+// Extract extracts an archive into destination and returns the paths of the extracted files.
+// Entries containing ../ or absolute paths are rejected with ErrUnsafePath before anything is written for them.
+func Extract(archivePath string, destination string, options ExtractOptions) ([]string, error) {
+	if err := os.MkdirAll(destination, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	switch DetectFormat(archivePath) {
+	case FormatZip:
+		return extractZip(archivePath, destination, options)
+	case FormatTar:
+		return extractTar(archivePath, destination, options, false)
+	case FormatTarGz:
+		return extractTar(archivePath, destination, options, true)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, archivePath)
+	}
+}
+
+// Create packs the contents of sourceDir into a new archive. Entry names are relative to sourceDir.
+func Create(archivePath string, sourceDir string, options CreateOptions) error {
+	info, err := os.Stat(sourceDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("source is not a directory: %s", sourceDir)
+	}
+
+	switch DetectFormat(archivePath) {
+	case FormatZip:
+		return createZip(archivePath, sourceDir, options)
+	case FormatTar:
+		return createTar(archivePath, sourceDir, options, false)
+	case FormatTarGz:
+		return createTar(archivePath, sourceDir, options, true)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, archivePath)
+	}
+}
+
+// sanitizeEntryName normalises an entry name to a clean slash separated relative path
+// and rejects anything that could escape the destination directory.
+func sanitizeEntryName(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	if len(name) >= 2 && name[1] == ':' {
+		// Windows drive letters are absolute even when we are not running on Windows.
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+		}
+	}
+	return path.Clean(name), nil
+}
+
+// stripComponents removes count leading elements from name. It returns an empty string
+// when nothing is left, which means the entry should be skipped.
+func stripComponents(name string, count int) string {
+	if count <= 0 {
+		return name
+	}
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	if len(parts) <= count {
+		return ""
+	}
+	return strings.Join(parts[count:], "/")
+}
+
+// resolveTarget maps a raw entry name to its path on disk. An empty target means the entry is filtered out.
+func resolveTarget(destination string, rawName string, options ExtractOptions) (string, string, error) {
+	name, err := sanitizeEntryName(rawName)
+	if err != nil {
+		return "", "", err
+	}
+	name = stripComponents(name, options.StripComponents)
+	if name == "" || name == "." {
+		return "", "", nil
+	}
+	if !shouldInclude(name, options.Include, options.Exclude) {
+		return "", "", nil
+	}
+
+	target := filepath.Join(destination, filepath.FromSlash(name))
+	if !isWithin(destination, target) {
+		return "", "", fmt.Errorf("%w: %s", ErrUnsafePath, rawName)
+	}
+	if err := checkNoSymlinks(destination, target); err != nil {
+		return "", "", fmt.Errorf("%w: %s", err, rawName)
+	}
+	return name, target, nil
+}
+
+// checkNoSymlinks rejects a target inside destination if it or any of its parents is a symbolic link. Checking
+// the path text is not enough, an archive can create a link to "." and then a link to ".." through it.
+func checkNoSymlinks(destination string, target string) error {
+	rel, err := filepath.Rel(destination, target)
+	if err != nil {
+		return err
+	}
+	current := destination
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is a symbolic link", ErrUnsafePath, current)
+		}
+	}
+	return nil
+}
+
+func isWithin(root string, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+func shouldInclude(name string, include []string, exclude []string) bool {
+	for _, pattern := range exclude {
+		if MatchGlob(pattern, name) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, pattern := range include {
+		if MatchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob matches a slash separated path against a glob. Besides the path.Match syntax,
+// a "**" element matches any number of directories, and a pattern without a slash is matched
+// against the base name only (so "*.lua" matches "a/b/c.lua").
+func MatchGlob(pattern string, name string) bool {
+	pattern = strings.Trim(strings.ReplaceAll(pattern, "\\", "/"), "/")
+	name = strings.Trim(name, "/")
+	if !strings.Contains(pattern, "/") && pattern != "**" {
+		matched, err := path.Match(pattern, path.Base(name))
+		return err == nil && matched
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		matched, err := path.Match(pattern[0], name[0])
+		if err != nil || !matched {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+// walkSource calls fn for every file and directory below sourceDir that passes the filters.
+func walkSource(sourceDir string, options CreateOptions, fn func(filePath string, name string, info os.FileInfo) error) error {
+	return filepath.Walk(sourceDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(sourceDir, filePath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		if info.IsDir() {
+			for _, pattern := range options.Exclude {
+				if MatchGlob(pattern, name) {
+					return filepath.SkipDir
+				}
+			}
+			return fn(filePath, name, info)
+		}
+		if !shouldInclude(name, options.Include, options.Exclude) {
+			return nil
+		}
+		return fn(filePath, name, info)
+	})
+}
@@ -0,0 +1,217 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Helper to write a zip with the given entries
+func writeTestZip(t *testing.T, archivePath string, files map[string]string) {
+	f, err := os.Create(archivePath)
+	assert.NoError(t, err)
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		entry, err := w.Create(name)
+		assert.NoError(t, err)
+		_, err = entry.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+	assert.NoError(t, f.Close())
+}
+
+// Helper to write a tar.gz with the given entries
+func writeTestTarGz(t *testing.T, archivePath string, files map[string]string) {
+	f, err := os.Create(archivePath)
+	assert.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	assert.NoError(t, f.Close())
+}
+
+func TestDetectFormat(t *testing.T) {
+	assert.Equal(t, FormatZip, DetectFormat("mod_1.0.0.zip"))
+	assert.Equal(t, FormatZip, DetectFormat("Factorio.tcplugin"))
+	assert.Equal(t, FormatTarGz, DetectFormat("mod.tar.gz"))
+	assert.Equal(t, FormatTarGz, DetectFormat("mod.TGZ"))
+	assert.Equal(t, FormatTar, DetectFormat("mod.tar"))
+	assert.Equal(t, FormatUnknown, DetectFormat("mod.7z"))
+}
+
+func TestMatchGlob(t *testing.T) {
+	assert.True(t, MatchGlob("*.lua", "mod/scripts/control.lua"))
+	assert.True(t, MatchGlob("mod/*.json", "mod/info.json"))
+	assert.False(t, MatchGlob("mod/*.json", "mod/locale/en.json"))
+	assert.True(t, MatchGlob("mod/**/*.json", "mod/locale/en.json"))
+	assert.True(t, MatchGlob("**/info.json", "info.json"))
+	assert.False(t, MatchGlob("*.png", "thumbnail.jpg"))
+}
+
+func TestExtractZip_StripComponentsAndFilters(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "mod_1.0.0.zip")
+	writeTestZip(t, archivePath, map[string]string{
+		"mod_1.0.0/info.json":        `{"name":"mod"}`,
+		"mod_1.0.0/control.lua":      "-- control",
+		"mod_1.0.0/graphics/a.png":   "png",
+		"mod_1.0.0/locale/en/en.cfg": "[mod]",
+	})
+
+	destination := filepath.Join(dir, "out")
+	files, err := Extract(archivePath, destination, ExtractOptions{
+		StripComponents: 1,
+		Exclude:         []string{"*.png"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+
+	content, err := os.ReadFile(filepath.Join(destination, "info.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"mod"}`, string(content))
+	assert.FileExists(t, filepath.Join(destination, "locale", "en", "en.cfg"))
+	assert.NoFileExists(t, filepath.Join(destination, "graphics", "a.png"))
+
+	only := filepath.Join(dir, "only")
+	files, err = Extract(archivePath, only, ExtractOptions{Include: []string{"mod_1.0.0/*.json"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(only, "mod_1.0.0", "info.json")}, files)
+}
+
+func TestExtract_RejectsZipSlip(t *testing.T) {
+	for _, name := range []string{"../evil.txt", "mod/../../evil.txt", "/etc/evil.txt", "C:/evil.txt", "..\\evil.txt"} {
+		dir := t.TempDir()
+		archivePath := filepath.Join(dir, "evil.zip")
+		writeTestZip(t, archivePath, map[string]string{name: "evil"})
+
+		_, err := Extract(archivePath, filepath.Join(dir, "out"), ExtractOptions{})
+		assert.True(t, errors.Is(err, ErrUnsafePath), "expected ErrUnsafePath for %s, got %v", name, err)
+		assert.NoFileExists(t, filepath.Join(dir, "evil.txt"))
+	}
+
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "evil.tar.gz")
+	writeTestTarGz(t, archivePath, map[string]string{"../evil.txt": "evil"})
+	_, err := Extract(archivePath, filepath.Join(dir, "out"), ExtractOptions{})
+	assert.True(t, errors.Is(err, ErrUnsafePath))
+	assert.NoFileExists(t, filepath.Join(dir, "evil.txt"))
+}
+
+// writeTestTarLinks writes a tar of directories, named with a trailing slash, and symbolic links.
+func writeTestTarLinks(t *testing.T, archivePath string, entries [][2]string) {
+	f, err := os.Create(archivePath)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry[0], Linkname: entry[1], Typeflag: tar.TypeSymlink}
+		if strings.HasSuffix(entry[0], "/") {
+			header = &tar.Header{Name: entry[0], Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		assert.NoError(t, tw.WriteHeader(header))
+	}
+	assert.NoError(t, tw.Close())
+}
+
+func TestExtract_RejectsSymlinkChain(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "evil.tar")
+	f, err := os.Create(archivePath)
+	assert.NoError(t, err)
+	tw := tar.NewWriter(f)
+	// Every link points inside the destination on its own, together they lead out of it
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "a", Linkname: ".", Typeflag: tar.TypeSymlink}))
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "a/x", Linkname: "..", Typeflag: tar.TypeSymlink}))
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "a/x/pwned", Mode: 0644, Size: 4, Typeflag: tar.TypeReg}))
+	_, err = tw.Write([]byte("evil"))
+	assert.NoError(t, err)
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, f.Close())
+
+	destination := filepath.Join(dir, "out")
+	_, err = Extract(archivePath, destination, ExtractOptions{})
+	assert.True(t, errors.Is(err, ErrUnsafePath), "expected ErrUnsafePath, got %v", err)
+	assert.NoFileExists(t, filepath.Join(dir, "pwned"))
+	assert.NoFileExists(t, filepath.Join(destination, "x"))
+
+	// Going up out of a link that is already extracted leaves the destination
+	archivePath = filepath.Join(dir, "up.tar")
+	writeTestTarLinks(t, archivePath, [][2]string{{"d/", ""}, {"d/x", ".."}, {"y", "d/x/.."}})
+	destination = filepath.Join(dir, "up")
+	_, err = Extract(archivePath, destination, ExtractOptions{})
+	assert.True(t, errors.Is(err, ErrUnsafePath), "expected ErrUnsafePath, got %v", err)
+	assert.NoFileExists(t, filepath.Join(destination, "y"))
+
+	// The same links in the other order are created with the cleaned target
+	archivePath = filepath.Join(dir, "later.tar")
+	writeTestTarLinks(t, archivePath, [][2]string{{"d/", ""}, {"y", "d/x/.."}, {"d/x", ".."}})
+	destination = filepath.Join(dir, "later")
+	_, err = Extract(archivePath, destination, ExtractOptions{})
+	assert.NoError(t, err)
+	resolved, err := filepath.EvalSymlinks(filepath.Join(destination, "y"))
+	if assert.NoError(t, err) {
+		root, _ := filepath.EvalSymlinks(destination)
+		assert.Equal(t, filepath.Join(root, "d"), resolved)
+	}
+
+	// A link already in the destination is not written through either
+	outside := filepath.Join(dir, "outside.txt")
+	assert.NoError(t, os.WriteFile(outside, []byte("safe"), 0644))
+	destination = filepath.Join(dir, "existing")
+	assert.NoError(t, os.MkdirAll(destination, os.ModePerm))
+	assert.NoError(t, os.Symlink(outside, filepath.Join(destination, "mod.txt")))
+	zipPath := filepath.Join(dir, "mod.zip")
+	writeTestZip(t, zipPath, map[string]string{"mod.txt": "evil"})
+	_, err = Extract(zipPath, destination, ExtractOptions{})
+	assert.True(t, errors.Is(err, ErrUnsafePath), "expected ErrUnsafePath, got %v", err)
+	content, _ := os.ReadFile(outside)
+	assert.Equal(t, "safe", string(content))
+}
+
+func TestCreateAndList_RoundTrip(t *testing.T) {
+	source := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(source, "sub"), os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(source, "a.txt"), []byte("a"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(source, "sub", "b.txt"), []byte("bb"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(source, "sub", "skip.log"), []byte("log"), 0644))
+
+	for _, name := range []string{"pack.zip", "pack.tar", "pack.tar.gz"} {
+		dir := t.TempDir()
+		archivePath := filepath.Join(dir, name)
+		assert.NoError(t, Create(archivePath, source, CreateOptions{Exclude: []string{"*.log"}}))
+
+		entries, err := List(archivePath)
+		assert.NoError(t, err)
+		var files []string
+		for _, entry := range entries {
+			if !entry.IsDir {
+				files = append(files, entry.Name)
+			}
+		}
+		sort.Strings(files)
+		assert.Equal(t, []string{"a.txt", "sub/b.txt"}, files, name)
+
+		destination := filepath.Join(dir, "out")
+		_, err = Extract(archivePath, destination, ExtractOptions{})
+		assert.NoError(t, err)
+		content, err := os.ReadFile(filepath.Join(destination, "sub", "b.txt"))
+		assert.NoError(t, err)
+		assert.Equal(t, "bb", string(content))
+	}
+}
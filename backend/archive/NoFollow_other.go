@@ -0,0 +1,6 @@
+//go:build !unix
+
+package archive
+
+// openNoFollow is not available, checkNoSymlinks still refuses to write through symbolic links.
+const openNoFollow = 0
@@ -0,0 +1,247 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// openTar opens a tar stream, transparently decompressing it when compressed is set.
+// The returned closer closes both the gzip reader and the underlying file.
+func openTar(archivePath string, compressed bool) (*tar.Reader, func(), error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closeFile := func() {
+		if err := file.Close(); err != nil {
+			log.Errorf("Failed to close tar archive %s: %v", archivePath, err)
+		}
+	}
+
+	if !compressed {
+		return tar.NewReader(file), closeFile, nil
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		closeFile()
+		return nil, nil, fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	return tar.NewReader(gz), func() {
+		if err := gz.Close(); err != nil {
+			log.Errorf("Failed to close gzip stream %s: %v", archivePath, err)
+		}
+		closeFile()
+	}, nil
+}
+
+func listTar(archivePath string, compressed bool) ([]Entry, error) {
+	tr, closeFn, err := openTar(archivePath, compressed)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	var entries []Entry
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{
+			Name:    header.Name,
+			Size:    header.Size,
+			Mode:    header.FileInfo().Mode(),
+			ModTime: header.ModTime,
+			IsDir:   header.Typeflag == tar.TypeDir,
+		})
+	}
+	return entries, nil
+}
+
+func extractTar(archivePath string, destination string, options ExtractOptions, compressed bool) ([]string, error) {
+	tr, closeFn, err := openTar(archivePath, compressed)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	var extracted []string
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return extracted, err
+		}
+
+		name, target, err := resolveTarget(destination, header.Name, options)
+		if err != nil {
+			return extracted, err
+		}
+		if target == "" {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.ModePerm); err != nil {
+				return extracted, err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+				return extracted, err
+			}
+			mode := header.FileInfo().Mode().Perm()
+			if mode == 0 {
+				mode = 0644
+			}
+			if err := writeFile(target, tr, mode); err != nil {
+				return extracted, err
+			}
+			extracted = append(extracted, target)
+		case tar.TypeSymlink, tar.TypeLink:
+			if err := extractTarLink(destination, name, target, header, options); err != nil {
+				return extracted, err
+			}
+			extracted = append(extracted, target)
+		default:
+			log.Warnf("Skipping unsupported tar entry %s (type %c)", header.Name, header.Typeflag)
+		}
+	}
+	return extracted, nil
+}
+
+// extractTarLink recreates symbolic and hard links, but only if they point inside the destination.
+func extractTarLink(destination string, name string, target string, header *tar.Header, options ExtractOptions) error {
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+
+	if header.Typeflag == tar.TypeLink {
+		linkName, err := sanitizeEntryName(header.Linkname)
+		if err != nil {
+			return err
+		}
+		linkName = stripComponents(linkName, options.StripComponents)
+		source := filepath.Join(destination, filepath.FromSlash(linkName))
+		if linkName == "" || !isWithin(destination, source) {
+			return fmt.Errorf("%w: %s -> %s", ErrUnsafePath, header.Name, header.Linkname)
+		}
+		if err := checkNoSymlinks(destination, source); err != nil {
+			return fmt.Errorf("%w: %s -> %s", err, header.Name, header.Linkname)
+		}
+		return os.Link(source, target)
+	}
+
+	linkTarget := strings.ReplaceAll(header.Linkname, "\\", "/")
+	if path.IsAbs(linkTarget) || filepath.IsAbs(linkTarget) {
+		return fmt.Errorf("%w: %s -> %s", ErrUnsafePath, header.Name, header.Linkname)
+	}
+	linkDir := filepath.Dir(filepath.Join(destination, filepath.FromSlash(name)))
+	if !isWithin(destination, filepath.Join(linkDir, filepath.FromSlash(linkTarget))) {
+		return fmt.Errorf("%w: %s -> %s", ErrUnsafePath, header.Name, header.Linkname)
+	}
+	if err := checkLinkTarget(destination, linkDir, linkTarget); err != nil {
+		return fmt.Errorf("%w: %s -> %s", err, header.Name, header.Linkname)
+	}
+	// The cleaned target only has ".." at its start, which go up through real directories. A link that is
+	// extracted later cannot turn "d/x/.." into a way out.
+	return os.Symlink(filepath.FromSlash(path.Clean(linkTarget)), target)
+}
+
+// checkLinkTarget follows a relative link target from linkDir the way the file system would. Going up out of a
+// symbolic link that is already on disk leads wherever the link points, not back to its parent.
+func checkLinkTarget(destination string, linkDir string, linkTarget string) error {
+	current := linkDir
+	for _, part := range strings.Split(linkTarget, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if info, err := os.Lstat(current); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("%w: %s is a symbolic link", ErrUnsafePath, current)
+			}
+			current = filepath.Dir(current)
+		default:
+			current = filepath.Join(current, part)
+		}
+		if !isWithin(destination, current) {
+			return ErrUnsafePath
+		}
+	}
+	return nil
+}
+
+func createTar(archivePath string, sourceDir string, options CreateOptions, compressed bool) error {
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Failed to close tar archive %s: %v", archivePath, err)
+		}
+	}(file)
+
+	var out io.Writer = file
+	var gz *gzip.Writer
+	if compressed {
+		gz = gzip.NewWriter(file)
+		out = gz
+	}
+	tw := tar.NewWriter(out)
+
+	err = walkSource(sourceDir, options, func(filePath string, name string, info os.FileInfo) error {
+		if info.IsDir() && len(options.Include) > 0 {
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(filePath); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFileTo(tw, filePath)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package archive
+
+import (
+	"archive/zip"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+func listZip(archivePath string) ([]Entry, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(r *zip.ReadCloser) {
+		err := r.Close()
+		if err != nil {
+			log.Errorf("Failed to close zip archive %s: %v", archivePath, err)
+		}
+	}(r)
+
+	entries := make([]Entry, 0, len(r.File))
+	for _, f := range r.File {
+		entries = append(entries, Entry{
+			Name:    f.Name,
+			Size:    int64(f.UncompressedSize64),
+			Mode:    f.Mode(),
+			ModTime: f.Modified,
+			IsDir:   f.FileInfo().IsDir(),
+		})
+	}
+	return entries, nil
+}
+
+func extractZip(archivePath string, destination string, options ExtractOptions) ([]string, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(r *zip.ReadCloser) {
+		err := r.Close()
+		if err != nil {
+			log.Errorf("Failed to close zip archive %s: %v", archivePath, err)
+		}
+	}(r)
+
+	var extracted []string
+	for _, f := range r.File {
+		_, target, err := resolveTarget(destination, f.Name, options)
+		if err != nil {
+			return extracted, err
+		}
+		if target == "" {
+			continue
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, os.ModePerm); err != nil {
+				return extracted, err
+			}
+			continue
+		}
+
+		if err := extractZipFile(f, target); err != nil {
+			return extracted, err
+		}
+		extracted = append(extracted, target)
+	}
+	return extracted, nil
+}
+
+func extractZipFile(f *zip.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer func(rc io.ReadCloser) {
+		err := rc.Close()
+		if err != nil {
+			log.Errorf("Failed to close zip entry %s: %v", f.Name, err)
+		}
+	}(rc)
+
+	mode := f.Mode().Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	return writeFile(target, rc, mode)
+}
+
+func createZip(archivePath string, sourceDir string, options CreateOptions) error {
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Failed to close zip archive %s: %v", archivePath, err)
+		}
+	}(file)
+
+	w := zip.NewWriter(file)
+	err = walkSource(sourceDir, options, func(filePath string, name string, info os.FileInfo) error {
+		if info.IsDir() {
+			if len(options.Include) > 0 {
+				return nil // Only directories that contain included files are created implicitly
+			}
+			_, err := w.CreateHeader(&zip.FileHeader{Name: name + "/", Modified: info.ModTime()})
+			return err
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		header.Method = zip.Deflate
+		writer, err := w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			// Zip stores symbolic links as entries whose content is the link target
+			link, err := os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+			_, err = writer.Write([]byte(filepath.ToSlash(link)))
+			return err
+		}
+		return copyFileTo(writer, filePath)
+	})
+	if err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// writeFile streams r into target, replacing any existing file. It never writes through a symbolic link.
+func writeFile(target string, r io.Reader, mode os.FileMode) error {
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|openNoFollow, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func copyFileTo(w io.Writer, filePath string) error {
+	in, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func(in *os.File) {
+		err := in.Close()
+		if err != nil {
+			log.Errorf("Failed to close file %s: %v", filePath, err)
+		}
+	}(in)
+
+	_, err = io.Copy(w, in)
+	return err
+}
@@ -0,0 +1,64 @@
+package catalogue
+
+import (
+	"TotalControl/backend/mods"
+	"context"
+	"time"
+)
+
+// Entry is a mod of a game's remote catalogue, with the details the catalogue can be filtered and sorted by.
+type Entry struct {
+	mods.Mod
+	Category  string    `json:"category,omitempty"`
+	Downloads int       `json:"downloads,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// Batch is what a Source returns for a refresh.
+type Batch struct {
+	Entries []Entry
+	// Complete means Entries is the whole catalogue, so mods that are not in it were deleted.
+	Complete bool
+}
+
+// Source is implemented by providers that can list their remote catalogue. With a zero since, the whole
+// catalogue is listed, otherwise the mods changed since then, though a source may always list everything.
+type Source interface {
+	FetchCatalogue(ctx context.Context, since time.Time) (*Batch, error)
+}
+
+// Sort is the order of query results.
+type Sort string
+
+const (
+	SortName      Sort = "name"
+	SortUpdated   Sort = "updated"
+	SortDownloads Sort = "downloads"
+)
+
+type Query struct {
+	// Text is matched against the ID, name and description.
+	Text     string `json:"text,omitempty"`
+	Category string `json:"category,omitempty"`
+	// GameVersion only returns mods compatible with this game version.
+	GameVersion string `json:"game_version,omitempty"`
+	// Author is compared ignoring case.
+	Author       string    `json:"author,omitempty"`
+	UpdatedSince time.Time `json:"updated_since,omitempty"`
+	// Sort defaults to SortName. Names are sorted ascending, the others newest or most downloaded first, which
+	// Descending reverses.
+	Sort       Sort `json:"sort,omitempty"`
+	Descending bool `json:"descending,omitempty"`
+	Offset     int  `json:"offset,omitempty"`
+	// Limit is the page size, zero returns every match.
+	Limit int `json:"limit,omitempty"`
+}
+
+// Page is a slice of the results of a query.
+type Page struct {
+	Mods []Entry `json:"mods"`
+	// Total is the number of mods matching the query, across all pages.
+	Total int `json:"total"`
+	// RefreshedAt is when the catalogue was last refreshed, zero if it never was.
+	RefreshedAt time.Time `json:"refreshed_at"`
+}
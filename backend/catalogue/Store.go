@@ -0,0 +1,324 @@
+package catalogue
+
+import (
+	"TotalControl/backend/version"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDir holds one catalogue per game. It is a cache, deleting it only means fetching everything again.
+const DefaultDir = "data/.cache/catalogue"
+
+// FullRefreshInterval is how often the whole catalogue is fetched instead of only the changes, which is the only
+// way to learn about mods that were deleted.
+const FullRefreshInterval = 7 * 24 * time.Hour
+
+// refreshOverlap fetches changes from a little before the last refresh, so mods published while it ran and small
+// clock differences between the host and the catalogue are not missed.
+const refreshOverlap = time.Hour
+
+type catalogueFile struct {
+	RefreshedAt     time.Time `json:"refreshed_at"`
+	FullRefreshedAt time.Time `json:"full_refreshed_at"`
+	Mods            []Entry   `json:"mods"`
+}
+
+// index is a loaded catalogue with the lookups queries use, all keyed by lowercase values.
+type index struct {
+	catalogueFile
+	byID       map[string]int
+	byCategory map[string][]int
+	byAuthor   map[string][]int
+	// byGameVersion maps the game versions mods declare to the mods, mods that declare none are under "".
+	byGameVersion map[string][]int
+}
+
+func newIndex(file catalogueFile) *index {
+	slices.SortFunc(file.Mods, func(a, b Entry) int {
+		return strings.Compare(strings.ToLower(a.ID), strings.ToLower(b.ID))
+	})
+	idx := &index{
+		catalogueFile: file,
+		byID:          make(map[string]int, len(file.Mods)),
+		byCategory:    make(map[string][]int),
+		byAuthor:      make(map[string][]int),
+		byGameVersion: make(map[string][]int),
+	}
+	for i, entry := range file.Mods {
+		idx.byID[strings.ToLower(entry.ID)] = i
+		idx.byCategory[strings.ToLower(entry.Category)] = append(idx.byCategory[strings.ToLower(entry.Category)], i)
+		idx.byAuthor[strings.ToLower(entry.Author)] = append(idx.byAuthor[strings.ToLower(entry.Author)], i)
+		if len(entry.GameVersions) == 0 {
+			idx.byGameVersion[""] = append(idx.byGameVersion[""], i)
+		}
+		for _, gameVersion := range entry.GameVersions {
+			idx.byGameVersion[gameVersion.Version] = append(idx.byGameVersion[gameVersion.Version], i)
+		}
+	}
+	return idx
+}
+
+// Store keeps the catalogues of all games on disk and in memory.
+type Store struct {
+	dir   string
+	mu    sync.Mutex
+	games map[string]*index
+	// now is replaced by tests.
+	now func() time.Time
+}
+
+var defaultStore = NewStore(DefaultDir)
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir, games: make(map[string]*index), now: time.Now}
+}
+
+// DefaultStore returns the store in DefaultDir.
+func DefaultStore() *Store {
+	return defaultStore
+}
+
+func (s *Store) path(gameID string) (string, error) {
+	if gameID == "" || !filepath.IsLocal(gameID) || filepath.Base(gameID) != gameID {
+		return "", fmt.Errorf("invalid game ID %q", gameID)
+	}
+	return filepath.Join(s.dir, gameID+".json"), nil
+}
+
+// load must be called with the lock held. A game without a catalogue file has an empty catalogue.
+func (s *Store) load(gameID string) (*index, error) {
+	if idx, ok := s.games[gameID]; ok {
+		return idx, nil
+	}
+	path, err := s.path(gameID)
+	if err != nil {
+		return nil, err
+	}
+	var file catalogueFile
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("invalid catalogue %s: %w", path, err)
+		}
+	}
+	idx := newIndex(file)
+	s.games[gameID] = idx
+	return idx, nil
+}
+
+func (s *Store) save(gameID string, file catalogueFile) error {
+	path, err := s.path(gameID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&file)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Refresh updates the catalogue of a game from source. The whole catalogue is fetched the first time and every
+// FullRefreshInterval, otherwise only what changed since the last refresh. The network is used without holding
+// the lock, so queries keep working meanwhile.
+func (s *Store) Refresh(ctx context.Context, gameID string, source Source) error {
+	s.mu.Lock()
+	idx, err := s.load(gameID)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	started := s.now()
+	var since time.Time
+	if !idx.FullRefreshedAt.IsZero() && started.Sub(idx.FullRefreshedAt) < FullRefreshInterval {
+		since = idx.RefreshedAt.Add(-refreshOverlap)
+	}
+	batch, err := source.FetchCatalogue(ctx, since)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the catalogue of %s: %w", gameID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Another refresh may have finished meanwhile, apply the changes to the latest catalogue
+	if idx, err = s.load(gameID); err != nil {
+		return err
+	}
+	file := catalogueFile{RefreshedAt: started, FullRefreshedAt: idx.FullRefreshedAt}
+	if batch.Complete {
+		file.FullRefreshedAt = started
+		file.Mods = batch.Entries
+	} else {
+		file.Mods = slices.Clone(idx.Mods)
+		for _, entry := range batch.Entries {
+			if i, ok := idx.byID[strings.ToLower(entry.ID)]; ok {
+				file.Mods[i] = entry
+			} else {
+				file.Mods = append(file.Mods, entry)
+			}
+		}
+	}
+	updated := newIndex(file)
+	if err := s.save(gameID, updated.catalogueFile); err != nil {
+		return err
+	}
+	s.games[gameID] = updated
+	return nil
+}
+
+// Query returns a page of the mods in a game's catalogue that match the query.
+func (s *Store) Query(gameID string, query Query) (*Page, error) {
+	if !slices.Contains([]Sort{"", SortName, SortUpdated, SortDownloads}, query.Sort) {
+		return nil, fmt.Errorf("unknown sort order %q", query.Sort)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx, err := s.load(gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	var gameVersions map[string]bool
+	if query.GameVersion != "" {
+		if gameVersions, err = idx.compatibleVersions(query.GameVersion); err != nil {
+			return nil, err
+		}
+	}
+	text := strings.ToLower(strings.TrimSpace(query.Text))
+	var matches []Entry
+	for _, i := range idx.candidates(query, gameVersions) {
+		entry := idx.Mods[i]
+		if query.Category != "" && !strings.EqualFold(entry.Category, query.Category) ||
+			query.Author != "" && !strings.EqualFold(entry.Author, query.Author) ||
+			entry.UpdatedAt.Before(query.UpdatedSince) ||
+			gameVersions != nil && !declaresVersion(entry, gameVersions) {
+			continue
+		}
+		if text != "" && !strings.Contains(strings.ToLower(entry.ID), text) &&
+			!strings.Contains(strings.ToLower(entry.Name), text) &&
+			!strings.Contains(strings.ToLower(entry.Description), text) {
+			continue
+		}
+		matches = append(matches, entry)
+	}
+	sortEntries(matches, query.Sort, query.Descending)
+
+	page := &Page{Mods: []Entry{}, Total: len(matches), RefreshedAt: idx.RefreshedAt}
+	start := min(max(query.Offset, 0), len(matches))
+	end := len(matches)
+	if query.Limit > 0 {
+		end = min(start+query.Limit, end)
+	}
+	page.Mods = append(page.Mods, matches[start:end]...)
+	return page, nil
+}
+
+// compatibleVersions returns the declared game versions that accept gameVersion, including "" for mods that
+// declare none.
+func (idx *index) compatibleVersions(gameVersion string) (map[string]bool, error) {
+	game, err := version.Parse(gameVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid game version %q: %w", gameVersion, err)
+	}
+	compatible := map[string]bool{"": true}
+	for declared := range idx.byGameVersion {
+		if declared == "" {
+			continue
+		}
+		if constraint, err := version.ParseConstraint(declared); err == nil && constraint.Check(game) {
+			compatible[declared] = true
+		}
+	}
+	return compatible, nil
+}
+
+func declaresVersion(entry Entry, gameVersions map[string]bool) bool {
+	if len(entry.GameVersions) == 0 {
+		return gameVersions[""]
+	}
+	for _, declared := range entry.GameVersions {
+		if gameVersions[declared.Version] {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates returns the mods of the smallest index matching the query, the other criteria are checked on those.
+func (idx *index) candidates(query Query, gameVersions map[string]bool) []int {
+	var best []int
+	found := false
+	consider := func(list []int) {
+		if !found || len(list) < len(best) {
+			best, found = list, true
+		}
+	}
+	if query.Category != "" {
+		consider(idx.byCategory[strings.ToLower(query.Category)])
+	}
+	if query.Author != "" {
+		consider(idx.byAuthor[strings.ToLower(query.Author)])
+	}
+	if gameVersions != nil {
+		var compatible []int
+		for declared := range gameVersions {
+			compatible = append(compatible, idx.byGameVersion[declared]...)
+		}
+		// Mods declaring several compatible versions are listed once per version
+		slices.Sort(compatible)
+		consider(slices.Compact(compatible))
+	}
+	if found {
+		return best
+	}
+	all := make([]int, len(idx.Mods))
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+func sortEntries(entries []Entry, order Sort, descending bool) {
+	compare := func(a, b Entry) int {
+		return cmp.Or(strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)),
+			strings.Compare(strings.ToLower(a.ID), strings.ToLower(b.ID)))
+	}
+	switch order {
+	case SortUpdated:
+		byName := compare
+		compare = func(a, b Entry) int {
+			return cmp.Or(b.UpdatedAt.Compare(a.UpdatedAt), byName(a, b))
+		}
+	case SortDownloads:
+		byName := compare
+		compare = func(a, b Entry) int {
+			return cmp.Or(cmp.Compare(b.Downloads, a.Downloads), byName(a, b))
+		}
+	}
+	if descending {
+		ascending := compare
+		compare = func(a, b Entry) int {
+			return ascending(b, a)
+		}
+	}
+	slices.SortFunc(entries, compare)
+}
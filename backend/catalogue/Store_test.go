@@ -0,0 +1,125 @@
+package catalogue
+
+import (
+	"TotalControl/backend/mods"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// testSource returns its batches in turn and records what it was asked for.
+type testSource struct {
+	batches []*Batch
+	since   []time.Time
+}
+
+func (s *testSource) FetchCatalogue(ctx context.Context, since time.Time) (*Batch, error) {
+	s.since = append(s.since, since)
+	if len(s.batches) == 0 {
+		return nil, errors.New("portal unavailable")
+	}
+	batch := s.batches[0]
+	s.batches = s.batches[1:]
+	return batch, nil
+}
+
+var day = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func entry(id string, category string, author string, gameVersion string, downloads int, updated time.Time) Entry {
+	mod := mods.Mod{ID: id, Name: id, Author: author, Version: "1.0.0"}
+	if gameVersion != "" {
+		mod.GameVersions = []mods.GameVersion{{Version: gameVersion, ModVersion: "1.0.0"}}
+	}
+	return Entry{Mod: mod, Category: category, Downloads: downloads, UpdatedAt: updated}
+}
+
+func ids(page *Page) []string {
+	var result []string
+	for _, entry := range page.Mods {
+		result = append(result, entry.ID)
+	}
+	return result
+}
+
+func newTestStore(t *testing.T) *Store {
+	store := NewStore(t.TempDir())
+	store.now = func() time.Time { return day }
+	return store
+}
+
+func TestStore_Query(t *testing.T) {
+	store := newTestStore(t)
+	source := &testSource{batches: []*Batch{{Complete: true, Entries: []Entry{
+		entry("helmod", "tweaks", "Helfima", "2.0", 900, day.Add(-time.Hour)),
+		entry("Krastorio2", "overhaul", "raiguard", "1.1", 800, day.Add(-100*24*time.Hour)),
+		entry("flib", "internal", "raiguard", "2.0", 1000, day.Add(-24*time.Hour)),
+		entry("bobs", "overhaul", "Bobingabout", "", 10, day.Add(-48*time.Hour)),
+	}}}}
+	assert.NoError(t, store.Refresh(context.Background(), "factorio", source))
+
+	page, err := store.Query("factorio", Query{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bobs", "flib"}, ids(page))
+	assert.Equal(t, 4, page.Total)
+	assert.Equal(t, day, page.RefreshedAt)
+
+	page, _ = store.Query("factorio", Query{Offset: 2, Limit: 5})
+	assert.Equal(t, []string{"helmod", "Krastorio2"}, ids(page))
+	page, _ = store.Query("factorio", Query{Offset: 10})
+	assert.Empty(t, page.Mods)
+	assert.Equal(t, 4, page.Total)
+
+	page, _ = store.Query("factorio", Query{Category: "Overhaul", Sort: SortDownloads})
+	assert.Equal(t, []string{"Krastorio2", "bobs"}, ids(page))
+	page, _ = store.Query("factorio", Query{Author: "RAIGUARD", Sort: SortUpdated})
+	assert.Equal(t, []string{"flib", "Krastorio2"}, ids(page))
+	page, _ = store.Query("factorio", Query{Author: "raiguard", Sort: SortUpdated, Descending: true})
+	assert.Equal(t, []string{"Krastorio2", "flib"}, ids(page))
+	// Mods that declare no game version are assumed to work with every version
+	page, _ = store.Query("factorio", Query{GameVersion: "2.0.28", Sort: SortDownloads})
+	assert.Equal(t, []string{"flib", "helmod", "bobs"}, ids(page))
+	page, _ = store.Query("factorio", Query{UpdatedSince: day.Add(-36 * time.Hour), Text: "LIB"})
+	assert.Equal(t, []string{"flib"}, ids(page))
+
+	_, err = store.Query("factorio", Query{Sort: "popular"})
+	assert.Error(t, err)
+	_, err = store.Query("../factorio", Query{})
+	assert.Error(t, err)
+}
+
+func TestStore_Refresh(t *testing.T) {
+	store := newTestStore(t)
+	dir := store.dir
+	source := &testSource{batches: []*Batch{
+		{Complete: true, Entries: []Entry{entry("helmod", "", "", "", 1, day), entry("old", "", "", "", 1, day)}},
+		{Entries: []Entry{entry("helmod", "", "", "", 2, day), entry("new", "", "", "", 1, day)}},
+		{Complete: true, Entries: []Entry{entry("new", "", "", "", 5, day)}},
+	}}
+	assert.NoError(t, store.Refresh(context.Background(), "factorio", source))
+
+	// Later refreshes only fetch the changes since shortly before the last one
+	store.now = func() time.Time { return day.Add(24 * time.Hour) }
+	assert.NoError(t, store.Refresh(context.Background(), "factorio", source))
+	page, _ := store.Query("factorio", Query{Sort: SortDownloads})
+	assert.Equal(t, []string{"helmod", "new", "old"}, ids(page))
+
+	// The catalogue is kept on disk
+	reloaded := NewStore(dir)
+	page, err := reloaded.Query("factorio", Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, day.Add(24*time.Hour), page.RefreshedAt)
+
+	// A failed refresh keeps the catalogue
+	store.now = func() time.Time { return day.Add(FullRefreshInterval) }
+	assert.NoError(t, store.Refresh(context.Background(), "factorio", source))
+	page, _ = store.Query("factorio", Query{})
+	assert.Equal(t, []string{"new"}, ids(page))
+	assert.Error(t, store.Refresh(context.Background(), "factorio", source))
+	page, _ = store.Query("factorio", Query{})
+	assert.Equal(t, 1, page.Total)
+
+	assert.Equal(t, []time.Time{{}, day.Add(-refreshOverlap), {}, day.Add(FullRefreshInterval - refreshOverlap)}, source.since)
+}
@@ -0,0 +1,132 @@
+package dependencies
+
+import (
+	"TotalControl/backend/version"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidDependency = errors.New("invalid dependency")
+
+type Kind string
+
+const (
+	// Required dependencies are installed with the mod and loaded before it.
+	Required Kind = ""
+	// Optional dependencies are not installed, but constrain the version and load order if present.
+	Optional Kind = "?"
+	// HiddenOptional is an optional dependency the game does not show in its mod list.
+	HiddenOptional Kind = "(?)"
+	// Incompatible mods must not be installed together with the mod.
+	Incompatible Kind = "!"
+	// NoLoadOrder is a required dependency that does not affect the load order.
+	NoLoadOrder Kind = "~"
+)
+
+// prefixes are checked in this order, "(?)" has to come before "?".
+var prefixes = []Kind{HiddenOptional, Optional, Incompatible, NoLoadOrder}
+
+var constraintPattern = regexp.MustCompile(`^(.*?)\s*(<=|>=|<|>|=)\s*(\S+)$`)
+
+// Dependency is a parsed dependency expression in Factorio's format: "[prefix] name [operator version]",
+// e.g. "? space-exploration >= 0.6", "! bobs" or "~ base".
+type Dependency struct {
+	Kind  Kind   `json:"kind"`
+	ModID string `json:"mod_id"`
+	// Constraint is nil if any version is accepted.
+	Constraint *version.Constraint `json:"-"`
+	Raw        string              `json:"raw"`
+
+	// constraint is the comparison as written, "2" reads better than the padded "2.0.0" in messages
+	constraint string
+}
+
+func Parse(expression string) (*Dependency, error) {
+	dependency := &Dependency{Kind: Required, Raw: expression}
+	rest := strings.TrimSpace(expression)
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(rest, string(prefix)) {
+			dependency.Kind = prefix
+			rest = strings.TrimSpace(rest[len(prefix):])
+			break
+		}
+	}
+
+	// Mod names may contain spaces, only the trailing comparison is split off
+	if match := constraintPattern.FindStringSubmatch(rest); match != nil {
+		rest = match[1]
+		constraint, err := version.ParseConstraint(match[2] + " " + exactVersion(match[3]))
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidDependency, expression, err)
+		}
+		dependency.Constraint = constraint
+		dependency.constraint = match[2] + " " + match[3]
+	}
+	if rest == "" {
+		return nil, fmt.Errorf("%w %q: missing mod name", ErrInvalidDependency, expression)
+	}
+	dependency.ModID = rest
+	return dependency, nil
+}
+
+// ParseAll parses a mod's dependency list, skipping and reporting invalid entries.
+func ParseAll(expressions []string) ([]*Dependency, error) {
+	var result []*Dependency
+	var errs []error
+	for _, expression := range expressions {
+		dependency, err := Parse(expression)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		result = append(result, dependency)
+	}
+	return result, errors.Join(errs...)
+}
+
+// exactVersion pads a version to three parts. Factorio compares "> 1.1" as "> 1.1.0", while the version package
+// would read "1.1" as a wildcard for every 1.1.x.
+func exactVersion(value string) string {
+	for strings.Count(value, ".") < 2 {
+		value += ".0"
+	}
+	return value
+}
+
+// IsRequired reports whether the dependency has to be installed with the mod.
+func (d *Dependency) IsRequired() bool {
+	return d.Kind == Required || d.Kind == NoLoadOrder
+}
+
+// IsOptional reports whether the dependency only applies if the other mod is installed anyway.
+func (d *Dependency) IsOptional() bool {
+	return d.Kind == Optional || d.Kind == HiddenOptional
+}
+
+// AffectsLoadOrder reports whether the other mod has to be loaded first.
+func (d *Dependency) AffectsLoadOrder() bool {
+	return d.Kind == Required || d.IsOptional()
+}
+
+// Allows reports whether the dependency accepts the given version of the other mod. Versions that cannot be parsed
+// only satisfy dependencies without a constraint.
+func (d *Dependency) Allows(modVersion string) bool {
+	if d.Constraint == nil {
+		return true
+	}
+	parsed, err := version.Parse(modVersion)
+	if err != nil {
+		return false
+	}
+	return d.Constraint.Check(parsed)
+}
+
+// String describes the dependency target, e.g. "base >= 2.0".
+func (d *Dependency) String() string {
+	if d.Constraint == nil {
+		return d.ModID
+	}
+	return d.ModID + " " + d.constraint
+}
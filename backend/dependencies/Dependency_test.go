@@ -0,0 +1,44 @@
+package dependencies
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		expression string
+		kind       Kind
+		modID      string
+		text       string
+		allows     string
+		rejects    string
+	}{
+		{"base", Required, "base", "base", "1.0.0", ""},
+		{"base >= 1.1", Required, "base", "base >= 1.1", "1.1.0", "1.0.9"},
+		{"? space-exploration >= 0.6", Optional, "space-exploration", "space-exploration >= 0.6", "0.6.0", "0.5.9"},
+		{"(?) hidden-mod", HiddenOptional, "hidden-mod", "hidden-mod", "1.0.0", ""},
+		{"! bobs", Incompatible, "bobs", "bobs", "1.0.0", ""},
+		{"~ base", NoLoadOrder, "base", "base", "2.0.0", ""},
+		{"Bob's Mod Pack > 1.1", Required, "Bob's Mod Pack", "Bob's Mod Pack > 1.1", "1.1.1", "1.1.0"},
+		{"lib = 2.0.5", Required, "lib", "lib = 2.0.5", "2.0.5", "2.0.6"},
+	}
+	for _, c := range cases {
+		dependency, err := Parse(c.expression)
+		if !assert.NoError(t, err, c.expression) {
+			continue
+		}
+		assert.Equal(t, c.kind, dependency.Kind, c.expression)
+		assert.Equal(t, c.modID, dependency.ModID, c.expression)
+		assert.Equal(t, c.text, dependency.String(), c.expression)
+		assert.True(t, dependency.Allows(c.allows), c.expression)
+		if c.rejects != "" {
+			assert.False(t, dependency.Allows(c.rejects), c.expression)
+		}
+	}
+
+	for _, invalid := range []string{"", "?", ">= 1.0", "base >= beta"} {
+		_, err := Parse(invalid)
+		assert.ErrorIs(t, err, ErrInvalidDependency, invalid)
+	}
+}
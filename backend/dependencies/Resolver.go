@@ -0,0 +1,394 @@
+package dependencies
+
+import (
+	"TotalControl/backend/mods"
+	"TotalControl/backend/version"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// maxRounds bounds how often the resolver re-picks versions before it gives up on settling.
+const maxRounds = 100
+
+// Release is one version of a mod with its dependency expressions.
+type Release struct {
+	ModID        string   `json:"mod_id"`
+	Version      string   `json:"version"`
+	Dependencies []string `json:"dependencies,omitempty"`
+}
+
+// ReleaseFromMod returns the release described by an installed or catalogue mod.
+func ReleaseFromMod(mod mods.Mod) Release {
+	return Release{ModID: mod.ID, Version: mod.Version, Dependencies: mod.Dependencies}
+}
+
+// Source provides the releases the resolver may pick from.
+type Source interface {
+	// Releases returns every available release of a mod in any order, or nil if the mod is unknown.
+	Releases(modID string) []Release
+}
+
+// Catalogue is a Source backed by a map of mod IDs to releases.
+type Catalogue map[string][]Release
+
+func (c Catalogue) Releases(modID string) []Release {
+	if releases, ok := c[modID]; ok {
+		return releases
+	}
+	for id, releases := range c {
+		if strings.EqualFold(id, modID) {
+			return releases
+		}
+	}
+	return nil
+}
+
+// Request asks for a mod, optionally limited to versions matching Constraint (e.g. ">= 1.2").
+type Request struct {
+	ModID      string `json:"mod_id"`
+	Constraint string `json:"constraint,omitempty"`
+}
+
+// Resolution is the outcome of resolving a set of requests.
+type Resolution struct {
+	// Mods are the requested mods, their dependencies and the installed mods, dependencies first.
+	Mods []Release `json:"mods"`
+	// Install are the releases that are not installed yet, or installed in a different version.
+	Install []Release `json:"install"`
+}
+
+// ConflictError lists everything that prevents a resolution, in plain language.
+type ConflictError struct {
+	Conflicts []string
+}
+
+func (e *ConflictError) Error() string {
+	return "dependency conflict: " + strings.Join(e.Conflicts, "; ")
+}
+
+// Resolver computes which mod versions to install so every requirement holds.
+type Resolver struct {
+	Source Source
+	// Installed are the releases already present, including mods that come with the game like Factorio's base.
+	// They are kept unless a requirement forces a different version.
+	Installed []Release
+}
+
+// requirement is a dependency on a mod together with who declared it, "" for the user's request.
+type requirement struct {
+	from       string
+	dependency *Dependency
+}
+
+func (r requirement) describe() string {
+	if r.from == "" {
+		return "you requested " + r.dependency.String()
+	}
+	return r.from + " requires " + r.dependency.String()
+}
+
+func key(modID string) string {
+	return strings.ToLower(modID)
+}
+
+// Resolve computes the install closure of the requested mods. Versions already installed are preferred, otherwise
+// the newest release matching every requirement is picked. A *ConflictError explains why no resolution exists.
+func (r *Resolver) Resolve(requests ...Request) (*Resolution, error) {
+	installed := make(map[string]Release)
+	for _, release := range r.Installed {
+		installed[key(release.ModID)] = release
+	}
+	chosen := make(map[string]Release)
+	for id, release := range installed {
+		chosen[id] = release
+	}
+
+	wanted := make([]requirement, 0, len(requests))
+	for _, request := range requests {
+		dependency := &Dependency{Kind: Required, ModID: request.ModID, Raw: request.ModID}
+		if request.Constraint != "" {
+			constraint, err := version.ParseConstraint(request.Constraint)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", request.ModID, err)
+			}
+			dependency.Constraint = constraint
+			dependency.constraint = request.Constraint
+			dependency.Raw += " " + request.Constraint
+		}
+		wanted = append(wanted, requirement{dependency: dependency})
+	}
+
+	settled := false
+	for round := 0; round < maxRounds && !settled; round++ {
+		settled = true
+		requirements := r.requirements(wanted, chosen)
+		for _, id := range sortedKeys(requirements) {
+			reqs := requirements[id]
+			current, present := chosen[id]
+			if present && allow(reqs, current.Version) {
+				continue
+			}
+			if !present && !slices.ContainsFunc(reqs, func(req requirement) bool { return req.dependency.IsRequired() }) {
+				// Optional dependencies only count once something else brings the mod in
+				continue
+			}
+			release, err := r.pick(id, reqs, installed)
+			if err != nil {
+				return nil, err
+			}
+			chosen[id] = release
+			settled = false
+		}
+	}
+	if !settled {
+		return nil, errors.New("dependency resolution did not settle, the requirements keep changing each other")
+	}
+
+	// A replaced version may have pulled in dependencies nothing needs anymore
+	needed := r.closure(wanted, chosen)
+	for id := range chosen {
+		if _, ok := installed[id]; !ok && !needed[id] {
+			delete(chosen, id)
+		}
+	}
+
+	var conflicts []string
+	conflicts = append(conflicts, incompatibilities(chosen)...)
+	ordered, cycle := loadOrder(chosen)
+	if cycle != "" {
+		conflicts = append(conflicts, cycle)
+	}
+	if len(conflicts) > 0 {
+		return nil, &ConflictError{Conflicts: conflicts}
+	}
+
+	resolution := &Resolution{Mods: ordered}
+	for _, release := range ordered {
+		if _, err := ParseAll(release.Dependencies); err != nil {
+			log.Warnf("Mod %s %s has invalid dependencies: %v", release.ModID, release.Version, err)
+		}
+		if current, ok := installed[key(release.ModID)]; !ok || current.Version != release.Version {
+			resolution.Install = append(resolution.Install, release)
+		}
+	}
+	return resolution, nil
+}
+
+// requirements collects the dependencies of the requests and every chosen release by target mod.
+func (r *Resolver) requirements(wanted []requirement, chosen map[string]Release) map[string][]requirement {
+	result := make(map[string][]requirement)
+	for _, req := range wanted {
+		id := key(req.dependency.ModID)
+		result[id] = append(result[id], req)
+	}
+	for _, id := range sortedKeys(chosen) {
+		release := chosen[id]
+		for _, dependency := range parseRelease(release) {
+			if dependency.Kind == Incompatible {
+				continue
+			}
+			target := key(dependency.ModID)
+			result[target] = append(result[target], requirement{from: release.ModID, dependency: dependency})
+		}
+	}
+	return result
+}
+
+// pick returns the release of a mod that satisfies every requirement, preferring the installed one.
+func (r *Resolver) pick(id string, reqs []requirement, installed map[string]Release) (Release, error) {
+	if current, ok := installed[id]; ok && allow(reqs, current.Version) {
+		return current, nil
+	}
+	var releases []Release
+	if r.Source != nil {
+		releases = r.Source.Releases(id)
+	}
+	byVersion := make(map[string]Release)
+	var candidates []string
+	for _, release := range releases {
+		if allow(reqs, release.Version) {
+			byVersion[release.Version] = release
+			candidates = append(candidates, release.Version)
+		}
+	}
+	if best := version.Latest(candidates, nil); best != "" {
+		return byVersion[best], nil
+	}
+	if current, ok := installed[id]; ok {
+		releases = append(releases, current)
+	}
+	return Release{}, &ConflictError{Conflicts: []string{explain(reqs, releases)}}
+}
+
+// closure returns the mods needed by the requests, following required dependencies of the chosen releases.
+func (r *Resolver) closure(wanted []requirement, chosen map[string]Release) map[string]bool {
+	needed := make(map[string]bool)
+	var queue []string
+	for _, req := range wanted {
+		queue = append(queue, key(req.dependency.ModID))
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if needed[id] {
+			continue
+		}
+		needed[id] = true
+		for _, dependency := range parseRelease(chosen[id]) {
+			if dependency.IsRequired() {
+				queue = append(queue, key(dependency.ModID))
+			}
+		}
+	}
+	return needed
+}
+
+// explain describes why none of the releases satisfies the requirements, naming the two that contradict each other
+// where possible.
+func explain(reqs []requirement, releases []Release) string {
+	target := reqs[0].dependency.ModID
+	if len(releases) == 0 {
+		return fmt.Sprintf("%s, which is not available", reqs[0].describe())
+	}
+	satisfiable := func(subset ...requirement) bool {
+		return slices.ContainsFunc(releases, func(release Release) bool {
+			return allow(subset, release.Version)
+		})
+	}
+	for _, req := range reqs {
+		if !satisfiable(req) {
+			return fmt.Sprintf("%s, but only %s %s available", req.describe(), target, describeVersions(releases))
+		}
+	}
+	for i := range reqs {
+		for j := i + 1; j < len(reqs); j++ {
+			if !satisfiable(reqs[i], reqs[j]) {
+				return fmt.Sprintf("%s but %s", reqs[i].describe(), reqs[j].describe())
+			}
+		}
+	}
+	descriptions := make([]string, len(reqs))
+	for i, req := range reqs {
+		descriptions[i] = req.describe()
+	}
+	return fmt.Sprintf("no version of %s satisfies all of: %s", target, strings.Join(descriptions, ", "))
+}
+
+func describeVersions(releases []Release) string {
+	versions := make([]string, 0, len(releases))
+	for _, release := range releases {
+		if !slices.Contains(versions, release.Version) {
+			versions = append(versions, release.Version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		result, _ := version.Compare(versions[i], versions[j])
+		return result < 0
+	})
+	if len(versions) == 1 {
+		return versions[0] + " is"
+	}
+	return strings.Join(versions, ", ") + " are"
+}
+
+// incompatibilities reports chosen mods that declare another chosen mod incompatible.
+func incompatibilities(chosen map[string]Release) []string {
+	var conflicts []string
+	for _, id := range sortedKeys(chosen) {
+		release := chosen[id]
+		for _, dependency := range parseRelease(release) {
+			if dependency.Kind != Incompatible {
+				continue
+			}
+			other, ok := chosen[key(dependency.ModID)]
+			if ok && dependency.Allows(other.Version) {
+				conflicts = append(conflicts, fmt.Sprintf("%s is incompatible with %s %s", release.ModID, other.ModID, other.Version))
+			}
+		}
+	}
+	return conflicts
+}
+
+// loadOrder sorts the releases so dependencies come first, ties in alphabetical order. If the dependencies form a
+// cycle it is described instead.
+func loadOrder(chosen map[string]Release) ([]Release, string) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var ordered []Release
+	var path []string
+	var cycle string
+
+	var visit func(id string) bool
+	visit = func(id string) bool {
+		switch state[id] {
+		case done:
+			return true
+		case visiting:
+			start := slices.Index(path, id)
+			names := make([]string, 0, len(path)-start+1)
+			for _, step := range append(path[start:], id) {
+				names = append(names, chosen[step].ModID)
+			}
+			cycle = "dependency cycle: " + strings.Join(names, " -> ")
+			return false
+		}
+		state[id] = visiting
+		path = append(path, id)
+		var next []string
+		for _, dependency := range parseRelease(chosen[id]) {
+			if _, ok := chosen[key(dependency.ModID)]; ok && dependency.AffectsLoadOrder() {
+				next = append(next, key(dependency.ModID))
+			}
+		}
+		sort.Strings(next)
+		for _, dependency := range next {
+			if !visit(dependency) {
+				return false
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = done
+		ordered = append(ordered, chosen[id])
+		return true
+	}
+
+	for _, id := range sortedKeys(chosen) {
+		if !visit(id) {
+			return nil, cycle
+		}
+	}
+	return ordered, ""
+}
+
+// allow reports whether modVersion satisfies every requirement.
+func allow(reqs []requirement, modVersion string) bool {
+	for _, req := range reqs {
+		if !req.dependency.Allows(modVersion) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRelease returns the valid dependencies of a release, Resolve reports the invalid ones once at the end.
+func parseRelease(release Release) []*Dependency {
+	dependencies, _ := ParseAll(release.Dependencies)
+	return dependencies
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,93 @@
+package dependencies
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var testCatalogue = Catalogue{
+	"a": {
+		{ModID: "a", Version: "1.0.0", Dependencies: []string{"base", "b >= 1.0"}},
+		{ModID: "a", Version: "2.0.0", Dependencies: []string{"base >= 2.0", "b >= 2", "? c >= 1.5", "(?) hidden"}},
+	},
+	"b": {
+		{ModID: "b", Version: "1.0.0", Dependencies: []string{"base"}},
+		{ModID: "b", Version: "2.1.0", Dependencies: []string{"base", "~ lib"}},
+	},
+	"c":      {{ModID: "c", Version: "1.0.0"}, {ModID: "c", Version: "1.6.0", Dependencies: []string{"! bobs"}}},
+	"lib":    {{ModID: "lib", Version: "0.3.0"}},
+	"hidden": {{ModID: "hidden", Version: "1.0.0"}},
+	"bobs":   {{ModID: "bobs", Version: "1.0.0"}},
+	"old":    {{ModID: "old", Version: "1.0.0", Dependencies: []string{"b < 2"}}},
+	"loop-a": {{ModID: "loop-a", Version: "1.0.0", Dependencies: []string{"loop-b"}}},
+	"loop-b": {{ModID: "loop-b", Version: "1.0.0", Dependencies: []string{"loop-a"}}},
+	"soft-a": {{ModID: "soft-a", Version: "1.0.0", Dependencies: []string{"~ soft-b"}}},
+	"soft-b": {{ModID: "soft-b", Version: "1.0.0", Dependencies: []string{"soft-a"}}},
+}
+
+var base = Release{ModID: "base", Version: "2.0.28"}
+
+func versions(releases []Release) []string {
+	result := make([]string, len(releases))
+	for i, release := range releases {
+		result[i] = release.ModID + "@" + release.Version
+	}
+	return result
+}
+
+func conflicts(t *testing.T, err error) []string {
+	var conflictErr *ConflictError
+	if assert.ErrorAs(t, err, &conflictErr) {
+		return conflictErr.Conflicts
+	}
+	return nil
+}
+
+func TestResolver_Closure(t *testing.T) {
+	resolver := &Resolver{Source: testCatalogue, Installed: []Release{base, {ModID: "c", Version: "1.0.0"}}}
+	resolution, err := resolver.Resolve(Request{ModID: "a"})
+	assert.NoError(t, err)
+
+	// c is only optional but installed, so its version has to satisfy a; hidden is not pulled in.
+	// b's "~ lib" does not affect the load order, lib comes last alphabetically.
+	assert.Equal(t, []string{"base@2.0.28", "b@2.1.0", "c@1.6.0", "a@2.0.0", "lib@0.3.0"}, versions(resolution.Mods))
+	assert.Equal(t, []string{"b@2.1.0", "c@1.6.0", "a@2.0.0", "lib@0.3.0"}, versions(resolution.Install))
+}
+
+func TestResolver_PrefersInstalled(t *testing.T) {
+	resolver := &Resolver{Source: testCatalogue, Installed: []Release{base, {ModID: "b", Version: "1.0.0", Dependencies: []string{"base"}}}}
+	resolution, err := resolver.Resolve(Request{ModID: "a", Constraint: "< 2"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a@1.0.0"}, versions(resolution.Install))
+}
+
+func TestResolver_Conflicts(t *testing.T) {
+	installed := []Release{base, testCatalogue["old"][0], {ModID: "b", Version: "1.0.0"}}
+	resolver := &Resolver{Source: testCatalogue, Installed: installed}
+
+	_, err := resolver.Resolve(Request{ModID: "a", Constraint: ">= 2"})
+	assert.Equal(t, []string{"a requires b >= 2 but old requires b < 2"}, conflicts(t, err))
+
+	_, err = resolver.Resolve(Request{ModID: "b", Constraint: ">= 3"})
+	assert.Equal(t, []string{"you requested b >= 3, but only b 1.0.0, 2.1.0 are available"}, conflicts(t, err))
+
+	_, err = resolver.Resolve(Request{ModID: "missing"})
+	assert.Equal(t, []string{"you requested missing, which is not available"}, conflicts(t, err))
+}
+
+func TestResolver_Incompatible(t *testing.T) {
+	resolver := &Resolver{Source: testCatalogue, Installed: []Release{base, {ModID: "bobs", Version: "1.0.0"}}}
+	_, err := resolver.Resolve(Request{ModID: "c"})
+	assert.Equal(t, []string{"c is incompatible with bobs 1.0.0"}, conflicts(t, err))
+}
+
+func TestResolver_Cycles(t *testing.T) {
+	resolver := &Resolver{Source: testCatalogue}
+	_, err := resolver.Resolve(Request{ModID: "loop-a"})
+	assert.Equal(t, []string{"dependency cycle: loop-a -> loop-b -> loop-a"}, conflicts(t, err))
+
+	// "~" dependencies do not affect the load order, so they cannot form a cycle
+	resolution, err := resolver.Resolve(Request{ModID: "soft-a"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"soft-a@1.0.0", "soft-b@1.0.0"}, versions(resolution.Mods))
+}
@@ -0,0 +1,146 @@
+package downloads
+
+import (
+	"TotalControl/backend/httpclient"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// ErrCancelled is returned by Wait when the download was cancelled.
+var ErrCancelled = errors.New("download cancelled")
+
+type Checksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+type Request struct {
+	URL         string            `json:"url"`
+	Destination string            `json:"destination"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Checksum    *Checksum         `json:"checksum,omitempty"`
+	// Priority orders the queue, higher values start first. Equal priorities are first come, first served.
+	Priority int `json:"priority"`
+	// Retries is the number of times an interrupted transfer is resumed before giving up.
+	Retries int `json:"retries"`
+	// Client sends the request instead of the manager's client, e.g. a plugin's client with its rate limits.
+	Client *httpclient.Client `json:"-"`
+}
+
+// Progress is a snapshot of a download, sent to listeners and returned by Manager.List.
+type Progress struct {
+	ID          string `json:"id"`
+	URL         string `json:"url"`
+	Destination string `json:"destination"`
+	Status      Status `json:"status"`
+	BytesDone   int64  `json:"bytes_done"`
+	BytesTotal  int64  `json:"bytes_total"`
+	Error       string `json:"error,omitempty"`
+}
+
+type Download struct {
+	ID      string
+	Request Request
+
+	mu         sync.Mutex
+	status     Status
+	bytesDone  int64
+	bytesTotal int64
+	err        error
+	sequence   uint64
+	ctx        context.Context
+	cancel     context.CancelFunc
+	done       chan struct{}
+	lastEvent  time.Time
+}
+
+func (d *Download) Progress() Progress {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	progress := Progress{
+		ID:          d.ID,
+		URL:         d.Request.URL,
+		Destination: d.Request.Destination,
+		Status:      d.status,
+		BytesDone:   d.bytesDone,
+		BytesTotal:  d.bytesTotal,
+	}
+	if d.err != nil {
+		progress.Error = d.err.Error()
+	}
+	return progress
+}
+
+func (d *Download) Status() Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status
+}
+
+// Done is closed when the download has completed, failed or was cancelled.
+func (d *Download) Done() <-chan struct{} {
+	return d.done
+}
+
+// Wait blocks until the download finishes and returns its error, if any.
+func (d *Download) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-d.done:
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+func (d *Download) setStatus(status Status, err error) {
+	d.mu.Lock()
+	d.status = status
+	d.err = err
+	d.mu.Unlock()
+}
+
+// downloadQueue is a priority queue for container/heap.
+type downloadQueue []*Download
+
+func (q downloadQueue) Len() int {
+	return len(q)
+}
+
+func (q downloadQueue) Less(i, j int) bool {
+	if q[i].Request.Priority != q[j].Request.Priority {
+		return q[i].Request.Priority > q[j].Request.Priority
+	}
+	return q[i].sequence < q[j].sequence
+}
+
+func (q downloadQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *downloadQueue) Push(x any) {
+	*q = append(*q, x.(*Download))
+}
+
+func (q *downloadQueue) Pop() any {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return item
+}
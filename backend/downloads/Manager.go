@@ -0,0 +1,508 @@
+package downloads
+
+import (
+	"TotalControl/backend/httpclient"
+	"TotalControl/backend/utils"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultConcurrency = 3
+	// progressInterval throttles progress events so listeners are not flooded.
+	progressInterval = 250 * time.Millisecond
+	partSuffix       = ".part"
+	// validatorSuffix names the file holding the ETag or Last-Modified date the part file was downloaded with.
+	validatorSuffix = ".part.validator"
+	// DefaultIdleTimeout is how long an attempt may go without receiving data before it is given up and retried.
+	DefaultIdleTimeout = 30 * time.Second
+)
+
+var errStalled = errors.New("download stalled")
+
+// Manager runs downloads from a bounded priority queue. Files are streamed into
+// "<destination>.part" and renamed into place only after they were verified, so a
+// destination never holds a partial or corrupted file.
+type Manager struct {
+	client      *httpclient.Client
+	concurrency int
+	// IdleTimeout overrides DefaultIdleTimeout. A stalled server would otherwise hold a download slot forever.
+	IdleTimeout time.Duration
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	queue     downloadQueue
+	downloads map[string]*Download
+	listeners []func(Progress)
+	sequence  uint64
+	closed    bool
+	workers   sync.WaitGroup
+}
+
+var (
+	defaultManager     *Manager
+	defaultManagerOnce sync.Once
+)
+
+// Default returns the host download manager shared by plugins and the UI.
+func Default() *Manager {
+	defaultManagerOnce.Do(func() {
+		defaultManager = NewManager(httpclient.Default(), DefaultConcurrency)
+	})
+	return defaultManager
+}
+
+func NewManager(client *httpclient.Client, concurrency int) *Manager {
+	if client == nil {
+		client = httpclient.Default()
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	m := &Manager{
+		client:      client,
+		concurrency: concurrency,
+		downloads:   make(map[string]*Download),
+	}
+	m.cond = sync.NewCond(&m.mu)
+	for i := 0; i < concurrency; i++ {
+		m.workers.Add(1)
+		go m.worker()
+	}
+	return m
+}
+
+// OnProgress registers a listener that receives progress snapshots of every download.
+// Listeners are called from worker goroutines and must not block.
+func (m *Manager) OnProgress(listener func(Progress)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// Enqueue adds a download to the queue and returns immediately.
+func (m *Manager) Enqueue(request Request) (*Download, error) {
+	if request.URL == "" || request.Destination == "" {
+		return nil, errors.New("download requires a URL and a destination")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	download := &Download{
+		ID:      uuid.New().String(),
+		Request: request,
+		status:  StatusQueued,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		cancel()
+		return nil, errors.New("download manager is closed")
+	}
+	m.sequence++
+	download.sequence = m.sequence
+	m.downloads[download.ID] = download
+	heap.Push(&m.queue, download)
+	m.mu.Unlock()
+
+	m.cond.Signal()
+	m.emit(download, true)
+	return download, nil
+}
+
+// Download enqueues a request and waits for it to finish. Cancelling ctx cancels the download.
+func (m *Manager) Download(ctx context.Context, request Request) error {
+	download, err := m.Enqueue(request)
+	if err != nil {
+		return err
+	}
+	err = download.Wait(ctx)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		_ = m.Cancel(download.ID)
+	}
+	return err
+}
+
+func (m *Manager) Get(id string) (*Download, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	download, ok := m.downloads[id]
+	return download, ok
+}
+
+// List returns a snapshot of all known downloads.
+func (m *Manager) List() []Progress {
+	m.mu.Lock()
+	downloads := make([]*Download, 0, len(m.downloads))
+	for _, download := range m.downloads {
+		downloads = append(downloads, download)
+	}
+	m.mu.Unlock()
+
+	result := make([]Progress, 0, len(downloads))
+	for _, download := range downloads {
+		result = append(result, download.Progress())
+	}
+	return result
+}
+
+// Cancel stops a queued or running download and removes its partial file.
+func (m *Manager) Cancel(id string) error {
+	download, ok := m.Get(id)
+	if !ok {
+		return fmt.Errorf("download not found: %s", id)
+	}
+	download.cancel()
+
+	m.mu.Lock()
+	for i, queued := range m.queue {
+		if queued == download {
+			heap.Remove(&m.queue, i)
+			m.mu.Unlock()
+			m.finish(download, StatusCancelled, ErrCancelled)
+			return nil
+		}
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// Forget removes finished downloads from the list.
+func (m *Manager) Forget() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, download := range m.downloads {
+		select {
+		case <-download.done:
+			delete(m.downloads, id)
+		default:
+		}
+	}
+}
+
+// Close cancels every download and stops the workers.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	for _, download := range m.downloads {
+		download.cancel()
+	}
+	queued := m.queue
+	m.queue = nil
+	m.mu.Unlock()
+
+	for _, download := range queued {
+		m.finish(download, StatusCancelled, ErrCancelled)
+	}
+	m.cond.Broadcast()
+	m.workers.Wait()
+}
+
+func (m *Manager) worker() {
+	defer m.workers.Done()
+	for {
+		m.mu.Lock()
+		for len(m.queue) == 0 && !m.closed {
+			m.cond.Wait()
+		}
+		if m.closed {
+			m.mu.Unlock()
+			return
+		}
+		download := heap.Pop(&m.queue).(*Download)
+		m.mu.Unlock()
+
+		m.run(download)
+	}
+}
+
+func (m *Manager) run(download *Download) {
+	download.setStatus(StatusRunning, nil)
+	m.emit(download, true)
+
+	err := m.transferWithRetries(download)
+	switch {
+	case err == nil:
+		m.finish(download, StatusCompleted, nil)
+	case download.ctx.Err() != nil:
+		removePart(download.Request.Destination)
+		m.finish(download, StatusCancelled, ErrCancelled)
+	default:
+		log.Errorf("Download of %s failed: %v", download.Request.URL, err)
+		m.finish(download, StatusFailed, err)
+	}
+}
+
+func (m *Manager) finish(download *Download, status Status, err error) {
+	download.mu.Lock()
+	select {
+	case <-download.done:
+		download.mu.Unlock()
+		return // Already finished
+	default:
+	}
+	download.status = status
+	download.err = err
+	close(download.done)
+	download.mu.Unlock()
+
+	download.cancel()
+	m.emit(download, true)
+}
+
+func (m *Manager) emit(download *Download, force bool) {
+	download.mu.Lock()
+	if !force && time.Since(download.lastEvent) < progressInterval {
+		download.mu.Unlock()
+		return
+	}
+	download.lastEvent = time.Now()
+	download.mu.Unlock()
+
+	m.mu.Lock()
+	listeners := append([]func(Progress){}, m.listeners...)
+	m.mu.Unlock()
+
+	progress := download.Progress()
+	for _, listener := range listeners {
+		listener(progress)
+	}
+}
+
+func (m *Manager) transferWithRetries(download *Download) error {
+	var err error
+	for attempt := 0; attempt <= download.Request.Retries; attempt++ {
+		if attempt > 0 {
+			wait := min(time.Duration(1<<attempt)*time.Second, httpclient.MaxRetryBackoff)
+			log.Warnf("Download of %s interrupted (%v), resuming in %s", download.Request.URL, err, wait)
+			select {
+			case <-download.ctx.Done():
+				return download.ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		err = m.transfer(download)
+		if err == nil || download.ctx.Err() != nil || errors.Is(err, utils.ErrChecksumMismatch) || errors.Is(err, httpclient.ErrStatus) {
+			return err
+		}
+	}
+	return err
+}
+
+// transfer streams the body into the part file, resuming from its current size with a Range request. The
+// request carries the part's validator in If-Range, so a file that changed on the server is downloaded again.
+func (m *Manager) transfer(download *Download) error {
+	request := download.Request
+	partPath := request.Destination + partSuffix
+	validatorPath := request.Destination + validatorSuffix
+	if err := os.MkdirAll(filepath.Dir(request.Destination), os.ModePerm); err != nil {
+		return err
+	}
+
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+	var validator string
+	if data, err := os.ReadFile(validatorPath); err == nil {
+		validator = strings.TrimSpace(string(data))
+	}
+	if offset > 0 && validator == "" {
+		// Without a validator the rest could belong to a different file
+		log.Debugf("Cannot resume download of %s without a validator, starting over", request.URL)
+		offset = 0
+	}
+
+	headers := make(map[string]string, len(request.Headers)+1)
+	for key, value := range request.Headers {
+		headers[key] = value
+	}
+	if offset > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+		headers["If-Range"] = validator
+	}
+
+	// The deadline covers waiting for the response and every read, and is pushed back whenever data arrives
+	idleTimeout := m.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	ctx, cancel := context.WithCancelCause(download.ctx)
+	defer cancel(nil)
+	idle := time.AfterFunc(idleTimeout, func() {
+		cancel(fmt.Errorf("%w: no data for %s", errStalled, idleTimeout))
+	})
+	defer idle.Stop()
+	stalled := func(err error) error {
+		if cause := context.Cause(ctx); errors.Is(cause, errStalled) {
+			return cause
+		}
+		return err
+	}
+
+	client := m.client
+	if request.Client != nil {
+		client = request.Client
+	}
+	resp, err := client.Stream(ctx, &httpclient.Request{
+		Method:  http.MethodGet,
+		URL:     request.URL,
+		Headers: headers,
+		// Files are written to disk anyway, keeping a second copy in the response cache is wasteful
+		Cache: &httpclient.CacheOptions{NoStore: true},
+	})
+	if err != nil {
+		return stalled(err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Errorf("Failed to close response body: %v", err)
+		}
+	}(resp.Body)
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if current := responseValidator(resp); current != "" && current != validator {
+			log.Debugf("%s changed since the download started, starting over", request.URL)
+			removePart(request.Destination)
+			return m.transfer(download)
+		}
+		flags |= os.O_APPEND
+		log.Debugf("Resuming download of %s at byte %d", request.URL, offset)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The part file is already complete (or the server changed the file), verify it below
+		resp.Body = http.NoBody
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		flags |= os.O_TRUNC
+		offset = 0
+		if err := writeValidator(validatorPath, responseValidator(resp)); err != nil {
+			return err
+		}
+	default:
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return fmt.Errorf("server responded with %s", resp.Status)
+		}
+		return fmt.Errorf("%w: %s", httpclient.ErrStatus, resp.Status)
+	}
+
+	download.mu.Lock()
+	download.bytesDone = offset
+	download.bytesTotal = totalSize(resp, offset)
+	download.mu.Unlock()
+
+	file, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	idle.Reset(idleTimeout)
+	reader := &progressReader{reader: resp.Body, download: download, manager: m, idle: idle, idleTimeout: idleTimeout}
+	_, copyErr := io.Copy(file, reader)
+	closeErr := file.Close()
+	if copyErr != nil {
+		return stalled(copyErr)
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	if request.Checksum != nil && request.Checksum.Algorithm != "" {
+		if err := utils.VerifyFile(partPath, request.Checksum.Algorithm, request.Checksum.Value); err != nil {
+			// A bad part file cannot be resumed, start from scratch next time
+			removePart(request.Destination)
+			return err
+		}
+	} else if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		removePart(request.Destination)
+		return fmt.Errorf("server rejected resuming %s", request.URL)
+	}
+
+	if err := os.Rename(partPath, request.Destination); err != nil {
+		return err
+	}
+	_ = os.Remove(validatorPath)
+	return nil
+}
+
+// totalSize returns the size of the complete file, or -1 if the server did not tell us.
+// responseValidator returns the strong ETag of a response, or its Last-Modified date. Weak ETags are not allowed
+// in If-Range.
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+func writeValidator(path string, validator string) error {
+	if validator == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(path, []byte(validator), 0644)
+}
+
+// removePart deletes the part file of a download and its validator.
+func removePart(destination string) {
+	_ = os.Remove(destination + partSuffix)
+	_ = os.Remove(destination + validatorSuffix)
+}
+
+func totalSize(resp *http.Response, offset int64) int64 {
+	if contentRange := resp.Header.Get("Content-Range"); contentRange != "" {
+		if slash := strings.LastIndex(contentRange, "/"); slash != -1 {
+			if total, err := strconv.ParseInt(contentRange[slash+1:], 10, 64); err == nil {
+				return total
+			}
+		}
+	}
+	if resp.ContentLength >= 0 {
+		return offset + resp.ContentLength
+	}
+	return -1
+}
+
+type progressReader struct {
+	reader   io.Reader
+	download *Download
+	manager  *Manager
+	// idle is reset whenever data arrives.
+	idle        *time.Timer
+	idleTimeout time.Duration
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.idle.Reset(r.idleTimeout)
+		r.download.mu.Lock()
+		r.download.bytesDone += int64(n)
+		r.download.mu.Unlock()
+		r.manager.emit(r.download, false)
+	}
+	return n, err
+}
@@ -0,0 +1,208 @@
+package downloads
+
+import (
+	"TotalControl/backend/httpclient"
+	"TotalControl/backend/utils"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testPayload = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+const testETag = `"v1"`
+
+func newTestServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			// ServeContent answers Range requests with 206 Partial Content, unless If-Range names another version
+			w.Header().Set("ETag", testETag)
+			http.ServeContent(w, r, "mod.zip", time.Time{}, bytes.NewReader(testPayload))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestManager_DownloadWithChecksum(t *testing.T) {
+	server := newTestServer(t)
+	manager := NewManager(httpclient.NewClient(""), 2)
+	defer manager.Close()
+
+	var mu sync.Mutex
+	var events []Progress
+	manager.OnProgress(func(progress Progress) {
+		mu.Lock()
+		events = append(events, progress)
+		mu.Unlock()
+	})
+
+	sha1, err := utils.HashReader(bytes.NewReader(testPayload), utils.HashSHA1)
+	assert.NoError(t, err)
+
+	destination := filepath.Join(t.TempDir(), "mods", "mod.zip")
+	err = manager.Download(context.Background(), Request{
+		URL:         server.URL + "/mod.zip",
+		Destination: destination,
+		Checksum:    &Checksum{Algorithm: utils.HashSHA1, Value: sha1},
+	})
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(destination)
+	assert.NoError(t, err)
+	assert.Equal(t, testPayload, content)
+	assert.NoFileExists(t, destination+partSuffix)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.NotEmpty(t, events)
+	last := events[len(events)-1]
+	assert.Equal(t, StatusCompleted, last.Status)
+	assert.Equal(t, int64(len(testPayload)), last.BytesDone)
+	assert.Equal(t, int64(len(testPayload)), last.BytesTotal)
+}
+
+func TestManager_ResumesPartialDownload(t *testing.T) {
+	server := newTestServer(t)
+	manager := NewManager(httpclient.NewClient(""), 1)
+	defer manager.Close()
+
+	destination := filepath.Join(t.TempDir(), "mod.zip")
+	half := len(testPayload) / 2
+	assert.NoError(t, os.WriteFile(destination+partSuffix, testPayload[:half], 0644))
+	assert.NoError(t, os.WriteFile(destination+validatorSuffix, []byte(testETag), 0644))
+
+	err := manager.Download(context.Background(), Request{URL: server.URL + "/mod.zip", Destination: destination})
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(destination)
+	assert.NoError(t, err)
+	assert.Equal(t, testPayload, content)
+	assert.NoFileExists(t, destination+validatorSuffix)
+}
+
+func TestManager_RestartsChangedDownload(t *testing.T) {
+	server := newTestServer(t)
+	manager := NewManager(httpclient.NewClient(""), 1)
+	defer manager.Close()
+
+	stale := bytes.Repeat([]byte("x"), len(testPayload)/2)
+	for name, validator := range map[string]string{"changed": `"v0"`, "unvalidated": ""} {
+		t.Run(name, func(t *testing.T) {
+			destination := filepath.Join(t.TempDir(), "mod.zip")
+			assert.NoError(t, os.WriteFile(destination+partSuffix, stale, 0644))
+			if validator != "" {
+				assert.NoError(t, os.WriteFile(destination+validatorSuffix, []byte(validator), 0644))
+			}
+
+			err := manager.Download(context.Background(), Request{URL: server.URL + "/mod.zip", Destination: destination})
+			assert.NoError(t, err)
+			content, err := os.ReadFile(destination)
+			assert.NoError(t, err)
+			assert.Equal(t, testPayload, content)
+		})
+	}
+}
+
+func TestManager_ChecksumMismatchLeavesNothing(t *testing.T) {
+	server := newTestServer(t)
+	manager := NewManager(httpclient.NewClient(""), 1)
+	defer manager.Close()
+
+	destination := filepath.Join(t.TempDir(), "mod.zip")
+	err := manager.Download(context.Background(), Request{
+		URL:         server.URL + "/mod.zip",
+		Destination: destination,
+		Checksum:    &Checksum{Algorithm: utils.HashSHA1, Value: "0000000000000000000000000000000000000000"},
+		Retries:     2,
+	})
+	assert.True(t, errors.Is(err, utils.ErrChecksumMismatch))
+	assert.NoFileExists(t, destination)
+	assert.NoFileExists(t, destination+partSuffix)
+
+	err = manager.Download(context.Background(), Request{URL: server.URL + "/missing", Destination: destination})
+	assert.True(t, errors.Is(err, httpclient.ErrStatus))
+}
+
+func TestManager_CancelAndPriority(t *testing.T) {
+	var mu sync.Mutex
+	var served []string
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		mu.Lock()
+		served = append(served, r.URL.Path)
+		mu.Unlock()
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	manager := NewManager(httpclient.NewClient(""), 1)
+	defer manager.Close()
+
+	dir := t.TempDir()
+	// Occupies the only worker so the next downloads stay queued
+	slow, err := manager.Enqueue(Request{URL: server.URL + "/slow", Destination: filepath.Join(dir, "slow")})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return slow.Status() == StatusRunning }, time.Second, time.Millisecond)
+
+	low, err := manager.Enqueue(Request{URL: server.URL + "/low", Destination: filepath.Join(dir, "low")})
+	assert.NoError(t, err)
+	high, err := manager.Enqueue(Request{URL: server.URL + "/high", Destination: filepath.Join(dir, "high"), Priority: 10})
+	assert.NoError(t, err)
+
+	assert.NoError(t, manager.Cancel(slow.ID))
+	assert.True(t, errors.Is(slow.Wait(context.Background()), ErrCancelled))
+	assert.Equal(t, StatusCancelled, slow.Status())
+
+	assert.NoError(t, high.Wait(context.Background()))
+	assert.NoError(t, low.Wait(context.Background()))
+	mu.Lock()
+	assert.Equal(t, []string{"/high", "/low"}, served)
+	mu.Unlock()
+	assert.Len(t, manager.List(), 3)
+}
+
+func TestManager_GivesUpOnStalledServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1024")
+		_, _ = w.Write(testPayload[:512])
+		w.(http.Flusher).Flush()
+		// Never sends the rest
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	manager := NewManager(httpclient.NewClient(""), 1)
+	manager.IdleTimeout = 100 * time.Millisecond
+	defer manager.Close()
+
+	started := time.Now()
+	destination := filepath.Join(t.TempDir(), "mod.zip")
+	err := manager.Download(context.Background(), Request{URL: server.URL, Destination: destination})
+	assert.ErrorIs(t, err, errStalled)
+	assert.Less(t, time.Since(started), 5*time.Second)
+	assert.NoFileExists(t, destination)
+	// What arrived is kept for resuming
+	info, err := os.Stat(destination + partSuffix)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(512), info.Size())
+	}
+}
@@ -0,0 +1,280 @@
+package formats
+
+import (
+	"strings"
+)
+
+// IniFile is an INI document that keeps comments, blank lines, ordering and spacing, so writing an unmodified
+// file reproduces it byte for byte. Factorio's locale cfg files use the same syntax.
+//
+// Comments are lines starting with ";" or "#". Inline comments are not supported because values such as
+// Factorio locale strings may contain those characters. Keys before the first section belong to the section "".
+type IniFile struct {
+	sections []*iniSection
+	newline  string
+	// trailingNewline records whether the last line ended with a newline.
+	trailingNewline bool
+	bom             bool
+}
+
+// bom is the UTF-8 byte order mark some Windows editors write.
+const bom = "\ufeff"
+
+type iniSection struct {
+	name   string
+	header string
+	lines  []*iniLine
+}
+
+type iniLine struct {
+	// raw is the original text, cleared once the entry is modified.
+	raw       string
+	key       string
+	value     string
+	separator string
+}
+
+func (l *iniLine) isEntry() bool {
+	return l.key != ""
+}
+
+func (l *iniLine) String() string {
+	if l.raw != "" || !l.isEntry() {
+		return l.raw
+	}
+	return l.key + l.separator + l.value
+}
+
+func NewIniFile() *IniFile {
+	return &IniFile{sections: []*iniSection{{}}, newline: "\n", trailingNewline: true}
+}
+
+func ParseIni(data []byte) (*IniFile, error) {
+	text := string(data)
+	file := NewIniFile()
+	if strings.HasPrefix(text, bom) {
+		file.bom = true
+		text = text[len(bom):]
+	}
+	if strings.Contains(text, "\r\n") {
+		file.newline = "\r\n"
+	}
+	file.trailingNewline = text == "" || strings.HasSuffix(text, "\n")
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if text == "" {
+		return file, nil
+	}
+
+	current := file.sections[0]
+	for _, raw := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(raw)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, ";") || strings.HasPrefix(trimmed, "#"):
+			current.lines = append(current.lines, &iniLine{raw: raw})
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			current = &iniSection{name: strings.TrimSpace(trimmed[1 : len(trimmed)-1]), header: raw}
+			file.sections = append(file.sections, current)
+		default:
+			key, value, ok := strings.Cut(raw, "=")
+			if !ok || strings.TrimSpace(key) == "" {
+				// Not an assignment, e.g. a bare flag. Keep it untouched.
+				current.lines = append(current.lines, &iniLine{raw: raw})
+				continue
+			}
+			trimmedKey := strings.TrimSpace(key)
+			trimmedValue := strings.TrimLeft(value, " \t")
+			separator := key[len(strings.TrimRight(key, " \t")):] + "=" + value[:len(value)-len(trimmedValue)]
+			current.lines = append(current.lines, &iniLine{
+				raw:       raw,
+				key:       trimmedKey,
+				value:     strings.TrimRight(trimmedValue, " \t"),
+				separator: separator,
+			})
+		}
+	}
+	return file, nil
+}
+
+func (f *IniFile) section(name string) *iniSection {
+	for _, section := range f.sections {
+		if section.name == name {
+			return section
+		}
+	}
+	return nil
+}
+
+func (s *iniSection) entry(key string) *iniLine {
+	for _, line := range s.lines {
+		if line.key == key {
+			return line
+		}
+	}
+	return nil
+}
+
+// Sections returns the section names in file order. The section "" is only included if it has keys.
+func (f *IniFile) Sections() []string {
+	var names []string
+	for i, section := range f.sections {
+		if i == 0 && len(section.Keys()) == 0 {
+			continue
+		}
+		names = append(names, section.name)
+	}
+	return names
+}
+
+func (s *iniSection) Keys() []string {
+	var keys []string
+	for _, line := range s.lines {
+		if line.isEntry() {
+			keys = append(keys, line.key)
+		}
+	}
+	return keys
+}
+
+// Keys returns the keys of a section in file order.
+func (f *IniFile) Keys(section string) []string {
+	if s := f.section(section); s != nil {
+		return s.Keys()
+	}
+	return nil
+}
+
+// Get returns the value of the first matching key.
+func (f *IniFile) Get(section string, key string) (string, bool) {
+	s := f.section(section)
+	if s == nil {
+		return "", false
+	}
+	if line := s.entry(key); line != nil {
+		return line.value, true
+	}
+	return "", false
+}
+
+// Set changes a value in place or appends the key to its section, creating the section if needed.
+// New keys use the spacing around "=" of the file's existing keys.
+func (f *IniFile) Set(section string, key string, value string) {
+	s := f.section(section)
+	if s == nil {
+		s = &iniSection{name: section, header: "[" + section + "]"}
+		// Separate the new section from the previous one like most editors do
+		if last := f.sections[len(f.sections)-1]; len(last.lines) > 0 && strings.TrimSpace(last.lines[len(last.lines)-1].String()) != "" {
+			last.lines = append(last.lines, &iniLine{raw: ""})
+		}
+		f.sections = append(f.sections, s)
+	}
+
+	if line := s.entry(key); line != nil {
+		if line.value != value {
+			line.value = value
+			line.raw = ""
+		}
+		return
+	}
+
+	line := &iniLine{key: key, value: value, separator: f.separator()}
+	// Insert after the last entry so trailing blank lines and comments stay between sections
+	index := len(s.lines)
+	for index > 0 && !s.lines[index-1].isEntry() {
+		index--
+	}
+	if index == 0 {
+		index = len(s.lines)
+		for index > 0 && strings.TrimSpace(s.lines[index-1].raw) == "" {
+			index--
+		}
+	}
+	s.lines = append(s.lines[:index], append([]*iniLine{line}, s.lines[index:]...)...)
+}
+
+func (f *IniFile) separator() string {
+	for _, section := range f.sections {
+		for _, line := range section.lines {
+			if line.isEntry() {
+				return line.separator
+			}
+		}
+	}
+	return "="
+}
+
+// Delete removes every occurrence of a key. It reports whether the key existed.
+func (f *IniFile) Delete(section string, key string) bool {
+	s := f.section(section)
+	if s == nil {
+		return false
+	}
+	found := false
+	lines := s.lines[:0]
+	for _, line := range s.lines {
+		if line.key == key {
+			found = true
+			continue
+		}
+		lines = append(lines, line)
+	}
+	s.lines = lines
+	return found
+}
+
+// DeleteSection removes a section with all its keys and comments.
+func (f *IniFile) DeleteSection(name string) bool {
+	for i, section := range f.sections {
+		if i > 0 && section.name == name {
+			f.sections = append(f.sections[:i], f.sections[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Map returns the values as section -> key -> value. Later duplicates are ignored.
+func (f *IniFile) Map() map[string]map[string]string {
+	result := make(map[string]map[string]string)
+	for _, section := range f.sections {
+		values, ok := result[section.name]
+		if !ok {
+			values = make(map[string]string)
+		}
+		for _, line := range section.lines {
+			if _, exists := values[line.key]; line.isEntry() && !exists {
+				values[line.key] = line.value
+			}
+		}
+		if len(values) > 0 || section.header != "" {
+			result[section.name] = values
+		}
+	}
+	return result
+}
+
+func (f *IniFile) String() string {
+	var lines []string
+	for _, section := range f.sections {
+		if section.header != "" {
+			lines = append(lines, section.header)
+		}
+		for _, line := range section.lines {
+			lines = append(lines, line.String())
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	result := strings.Join(lines, f.newline)
+	if f.trailingNewline {
+		result += f.newline
+	}
+	if f.bom {
+		result = bom + result
+	}
+	return result
+}
+
+func (f *IniFile) Bytes() []byte {
+	return []byte(f.String())
+}
@@ -0,0 +1,72 @@
+package formats
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const skyrimIni = `; Skyrim.ini
+[General]
+sLanguage=ENGLISH
+uExterior Cell Buffer = 36
+
+[Archive]
+# Loaded in order
+sResourceArchiveList=Skyrim - Misc.bsa, Skyrim - Shaders.bsa
+bInvalidateOlderFiles=1
+`
+
+func TestParseIni_RoundTrip(t *testing.T) {
+	for _, input := range []string{skyrimIni, "a=1\r\n[b]\r\nc = 2", "\ufeff[x]\ny=z\n", ""} {
+		file, err := ParseIni([]byte(input))
+		assert.NoError(t, err)
+		assert.Equal(t, input, file.String())
+	}
+}
+
+func TestIniFile_Edit(t *testing.T) {
+	file, err := ParseIni([]byte(skyrimIni))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"General", "Archive"}, file.Sections())
+	assert.Equal(t, []string{"sLanguage", "uExterior Cell Buffer"}, file.Keys("General"))
+
+	value, ok := file.Get("General", "uExterior Cell Buffer")
+	assert.True(t, ok)
+	assert.Equal(t, "36", value)
+
+	file.Set("General", "sLanguage", "GERMAN")
+	file.Set("General", "bAlwaysActive", "1")
+	file.Set("Display", "iSize W", "1920")
+	assert.True(t, file.Delete("Archive", "bInvalidateOlderFiles"))
+	assert.False(t, file.Delete("Archive", "missing"))
+
+	assert.Equal(t, `; Skyrim.ini
+[General]
+sLanguage=GERMAN
+uExterior Cell Buffer = 36
+bAlwaysActive=1
+
+[Archive]
+# Loaded in order
+sResourceArchiveList=Skyrim - Misc.bsa, Skyrim - Shaders.bsa
+
+[Display]
+iSize W=1920
+`, file.String())
+	assert.Equal(t, "1920", file.Map()["Display"]["iSize W"])
+}
+
+func TestParseIni_FactorioLocale(t *testing.T) {
+	input := "mod-name=Helmod\n\n[mod-setting-name]\nhelmod_display_ratio=Display ratio; [color=red]slow[/color] # experimental\n"
+	file, err := ParseIni([]byte(input))
+	assert.NoError(t, err)
+
+	value, _ := file.Get("", "mod-name")
+	assert.Equal(t, "Helmod", value)
+	value, _ = file.Get("mod-setting-name", "helmod_display_ratio")
+	assert.Equal(t, "Display ratio; [color=red]slow[/color] # experimental", value)
+	assert.Equal(t, []string{"", "mod-setting-name"}, file.Sections())
+
+	file.Set("", "mod-description", "Production planner")
+	assert.Equal(t, "mod-name=Helmod\nmod-description=Production planner\n\n[mod-setting-name]\nhelmod_display_ratio=Display ratio; [color=red]slow[/color] # experimental\n", file.String())
+}
@@ -0,0 +1,307 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// The scopes of Factorio mod settings.
+const (
+	SettingStartup        = "startup"
+	SettingRuntimeGlobal  = "runtime-global"
+	SettingRuntimePerUser = "runtime-per-user"
+)
+
+// SettingScopes are the scopes in the order Factorio writes them.
+var SettingScopes = []string{SettingStartup, SettingRuntimeGlobal, SettingRuntimePerUser}
+
+// ModSettings is Factorio's mod-settings.dat: the version of the game that wrote it followed by a property tree
+// that maps each scope to the settings and each setting to a dictionary with its value. Saving an unchanged file
+// reproduces it byte for byte.
+type ModSettings struct {
+	// Version is main, major, minor and build of the game that wrote the file.
+	Version [4]uint16
+	Tree    *PropertyTree
+
+	// flag is the byte Factorio 0.17 and newer write after the version.
+	flag    byte
+	hasFlag bool
+}
+
+// Setting is a single mod setting. Value is a bool, float64, int64, string or, for colors, a map[string]any
+// with the keys r, g, b and a.
+type Setting struct {
+	Scope string `json:"scope"`
+	Name  string `json:"name"`
+	Value any    `json:"value"`
+}
+
+// NewModSettings returns empty settings as written by the given game version.
+func NewModSettings(version [4]uint16) *ModSettings {
+	settings := &ModSettings{Version: version, Tree: &PropertyTree{Type: PropertyDictionary}}
+	settings.hasFlag = settings.writesFlag()
+	return settings
+}
+
+func (m *ModSettings) writesFlag() bool {
+	return m.Version[0] > 0 || m.Version[1] >= 17
+}
+
+func ParseModSettings(data []byte) (*ModSettings, error) {
+	reader := bytes.NewReader(data)
+	settings := &ModSettings{}
+	if err := binary.Read(reader, binary.LittleEndian, &settings.Version); err != nil {
+		return nil, fmt.Errorf("invalid mod settings: %w", err)
+	}
+	if settings.writesFlag() {
+		flag, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("invalid mod settings: %w", err)
+		}
+		settings.flag, settings.hasFlag = flag, true
+	}
+	tree, err := ReadPropertyTree(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mod settings: %w", err)
+	}
+	if tree.Type != PropertyDictionary {
+		return nil, errors.New("invalid mod settings: the root is not a dictionary")
+	}
+	if reader.Len() > 0 {
+		return nil, fmt.Errorf("invalid mod settings: %d bytes after the settings", reader.Len())
+	}
+	settings.Tree = tree
+	return settings, nil
+}
+
+func LoadModSettings(path string) (*ModSettings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseModSettings(data)
+}
+
+func (m *ModSettings) Bytes() ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := binary.Write(&buffer, binary.LittleEndian, m.Version); err != nil {
+		return nil, err
+	}
+	if m.hasFlag {
+		buffer.WriteByte(m.flag)
+	}
+	if err := m.Tree.Write(&buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Save writes the settings atomically, the game must never read half a file.
+func (m *ModSettings) Save(path string) error {
+	data, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// GameVersion returns the version of the game that wrote the file, e.g. "2.0.28".
+func (m *ModSettings) GameVersion() string {
+	return fmt.Sprintf("%d.%d.%d", m.Version[0], m.Version[1], m.Version[2])
+}
+
+func (m *ModSettings) setting(scope string, name string) *PropertyTree {
+	return m.Tree.Get(scope).Get(name).Get("value")
+}
+
+// Get returns the value of a setting.
+func (m *ModSettings) Get(scope string, name string) (any, bool) {
+	node := m.setting(scope, name)
+	if node == nil {
+		return nil, false
+	}
+	return node.Value(), true
+}
+
+// Set changes a setting, keeping the type of an existing value, or adds it. Factorio drops settings that no mod
+// defines when it starts, and resets values of the wrong type to the default.
+func (m *ModSettings) Set(scope string, name string, value any) error {
+	if !isSettingScope(scope) {
+		return fmt.Errorf("unknown setting scope %q", scope)
+	}
+	if node := m.setting(scope, name); node != nil {
+		if err := node.SetValue(value); err != nil {
+			return fmt.Errorf("setting %s: %w", name, err)
+		}
+		return nil
+	}
+	node, err := m.newValue(value)
+	if err != nil {
+		return fmt.Errorf("setting %s: %w", name, err)
+	}
+	scopeTree := m.Tree.Get(scope)
+	if scopeTree == nil {
+		scopeTree = &PropertyTree{Type: PropertyDictionary}
+		m.Tree.Set(scope, scopeTree)
+	}
+	settingTree := scopeTree.Get(name)
+	if settingTree == nil || settingTree.Type != PropertyDictionary {
+		settingTree = &PropertyTree{Type: PropertyDictionary}
+		scopeTree.Set(name, settingTree)
+	}
+	settingTree.Set("value", node)
+	return nil
+}
+
+// newValue converts the value of a new setting. Games before 2.0 store every number as a double.
+func (m *ModSettings) newValue(value any) (*PropertyTree, error) {
+	node, err := NewPropertyTree(value)
+	if err != nil {
+		return nil, err
+	}
+	if node.Type == PropertySigned && m.Version[0] < 2 {
+		return &PropertyTree{Type: PropertyNumber, Number: float64(node.Signed)}, nil
+	}
+	return node, nil
+}
+
+// Delete removes a setting and reports whether it existed.
+func (m *ModSettings) Delete(scope string, name string) bool {
+	scopeTree := m.Tree.Get(scope)
+	return scopeTree != nil && scopeTree.Delete(name)
+}
+
+// Names returns the names of the settings in a scope in file order.
+func (m *ModSettings) Names(scope string) []string {
+	return m.Tree.Get(scope).Keys()
+}
+
+// Settings returns the settings whose names start with prefix in every scope, all of them if it is empty.
+// Factorio does not store which mod a setting belongs to, but mods prefix their settings with their name by
+// convention, so passing the mod name usually finds the settings of a mod.
+func (m *ModSettings) Settings(prefix string) []Setting {
+	var settings []Setting
+	for _, scope := range SettingScopes {
+		for _, name := range m.Names(scope) {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if value, ok := m.Get(scope, name); ok {
+				settings = append(settings, Setting{Scope: scope, Name: name, Value: value})
+			}
+		}
+	}
+	return settings
+}
+
+// settingsJSON is the JSON form of mod settings: the game version and, per scope, the setting names and values.
+type settingsJSON struct {
+	Version        string         `json:"version,omitempty"`
+	Startup        map[string]any `json:"startup,omitempty"`
+	RuntimeGlobal  map[string]any `json:"runtime-global,omitempty"`
+	RuntimePerUser map[string]any `json:"runtime-per-user,omitempty"`
+}
+
+func (s *settingsJSON) scope(scope string) *map[string]any {
+	switch scope {
+	case SettingStartup:
+		return &s.Startup
+	case SettingRuntimeGlobal:
+		return &s.RuntimeGlobal
+	case SettingRuntimePerUser:
+		return &s.RuntimePerUser
+	}
+	return nil
+}
+
+func isSettingScope(scope string) bool {
+	return scope == SettingStartup || scope == SettingRuntimeGlobal || scope == SettingRuntimePerUser
+}
+
+// ExportJSON writes the settings whose names start with prefix as JSON, e.g. to share the settings of a mod.
+func (m *ModSettings) ExportJSON(prefix string) ([]byte, error) {
+	export := settingsJSON{Version: m.GameVersion()}
+	for _, setting := range m.Settings(prefix) {
+		values := export.scope(setting.Scope)
+		if *values == nil {
+			*values = make(map[string]any)
+		}
+		(*values)[setting.Name] = setting.Value
+	}
+	return json.MarshalIndent(&export, "", "  ")
+}
+
+// ImportJSON applies settings exported with ExportJSON. Settings that are not in the JSON are left as they are.
+func (m *ModSettings) ImportJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var imported settingsJSON
+	if err := decoder.Decode(&imported); err != nil {
+		return fmt.Errorf("invalid mod settings JSON: %w", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid mod settings JSON: data after the settings")
+	}
+	for _, scope := range SettingScopes {
+		values := *imported.scope(scope)
+		// Sorted, so new settings are added in the same order every time
+		for _, name := range slices.Sorted(maps.Keys(values)) {
+			value, err := jsonSettingValue(values[name])
+			if err != nil {
+				return fmt.Errorf("setting %s: %w", name, err)
+			}
+			if err := m.Set(scope, name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonSettingValue turns json.Number into int64 or float64, depending on whether it has a fraction or exponent.
+func jsonSettingValue(value any) (any, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case map[string]any:
+		converted := make(map[string]any, len(v))
+		for key, entry := range v {
+			value, err := jsonSettingValue(entry)
+			if err != nil {
+				return nil, err
+			}
+			converted[key] = value
+		}
+		return converted, nil
+	case []any:
+		converted := make([]any, len(v))
+		for i, entry := range v {
+			value, err := jsonSettingValue(entry)
+			if err != nil {
+				return nil, err
+			}
+			converted[i] = value
+		}
+		return converted, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,213 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// treeBuilder writes property tree bytes by hand, including the encodings Factorio rarely uses.
+type treeBuilder struct {
+	bytes.Buffer
+}
+
+func (b *treeBuilder) node(propertyType PropertyType, anyType bool) {
+	b.WriteByte(byte(propertyType))
+	if anyType {
+		b.WriteByte(1)
+	} else {
+		b.WriteByte(0)
+	}
+}
+
+func (b *treeBuilder) uint32(value uint32) {
+	_ = binary.Write(b, binary.LittleEndian, value)
+}
+
+func (b *treeBuilder) string(value string) {
+	if value == "" {
+		b.WriteByte(1)
+		return
+	}
+	if len(value) >= 0xFF {
+		b.longString(value)
+		return
+	}
+	b.WriteByte(0)
+	b.WriteByte(byte(len(value)))
+	b.WriteString(value)
+}
+
+func (b *treeBuilder) longString(value string) {
+	b.WriteByte(0)
+	b.WriteByte(0xFF)
+	b.uint32(uint32(len(value)))
+	b.WriteString(value)
+}
+
+func (b *treeBuilder) dictionary(count uint32) {
+	b.node(PropertyDictionary, false)
+	b.uint32(count)
+}
+
+// setting writes the dictionary Factorio wraps around each setting value.
+func (b *treeBuilder) setting(name string) {
+	b.string(name)
+	b.dictionary(1)
+	b.string("value")
+}
+
+func testModSettings() []byte {
+	var b treeBuilder
+	for _, part := range []uint16{2, 0, 28, 0} {
+		_ = binary.Write(&b, binary.LittleEndian, part)
+	}
+	b.WriteByte(0)
+	b.dictionary(3)
+
+	b.string(SettingStartup)
+	b.dictionary(4)
+	b.setting("bobmods-plates-purewater")
+	b.node(PropertyBool, false)
+	b.WriteByte(1)
+	b.setting("bobmods-ores-unsortedgemore")
+	b.node(PropertyNumber, false)
+	_ = binary.Write(&b, binary.LittleEndian, math.Float64bits(0.5))
+	b.setting("bobmods-logistics-beltoverhaul")
+	b.node(PropertySigned, false)
+	_ = binary.Write(&b, binary.LittleEndian, int64(-3))
+	b.setting("helmod_display_size")
+	b.node(PropertyString, true)
+	b.longString("1920x1080")
+
+	b.string(SettingRuntimeGlobal)
+	b.dictionary(2)
+	b.setting("helmod_color")
+	b.dictionary(2)
+	b.string("r")
+	b.node(PropertyNumber, false)
+	_ = binary.Write(&b, binary.LittleEndian, math.Float64bits(1))
+	b.string("g")
+	b.node(PropertyNumber, false)
+	_ = binary.Write(&b, binary.LittleEndian, math.Float64bits(0))
+	b.setting("helmod_seed")
+	b.node(PropertyUnsigned, false)
+	_ = binary.Write(&b, binary.LittleEndian, uint64(1<<40))
+
+	b.string(SettingRuntimePerUser)
+	b.dictionary(2)
+	b.setting("helmod_filter")
+	b.node(PropertyString, false)
+	// An empty string written with a length instead of the empty flag
+	b.WriteByte(0)
+	b.WriteByte(0)
+	b.setting("helmod_list")
+	b.node(PropertyList, false)
+	b.uint32(2)
+	b.string("")
+	b.node(PropertyNone, false)
+	b.string("")
+	b.node(PropertyString, false)
+	b.string(strings.Repeat("x", 300))
+	return b.Bytes()
+}
+
+func TestParseModSettings_RoundTrip(t *testing.T) {
+	input := testModSettings()
+	settings, err := ParseModSettings(input)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "2.0.28", settings.GameVersion())
+	output, err := settings.Bytes()
+	assert.NoError(t, err)
+	assert.Equal(t, input, output)
+
+	for _, size := range []int{3, 9, len(input) - 1} {
+		_, err := ParseModSettings(input[:size])
+		assert.Error(t, err, size)
+	}
+	_, err = ParseModSettings(append(input, 0))
+	assert.Error(t, err)
+}
+
+func TestModSettings_Edit(t *testing.T) {
+	settings, err := ParseModSettings(testModSettings())
+	if !assert.NoError(t, err) {
+		return
+	}
+	value, ok := settings.Get(SettingStartup, "bobmods-logistics-beltoverhaul")
+	assert.True(t, ok)
+	assert.Equal(t, int64(-3), value)
+	assert.Equal(t, []Setting{{Scope: SettingRuntimeGlobal, Name: "helmod_color", Value: map[string]any{"r": 1.0, "g": 0.0}},
+		{Scope: SettingRuntimeGlobal, Name: "helmod_seed", Value: uint64(1 << 40)},
+	}, settings.Settings("helmod_")[1:3])
+
+	// Existing settings keep their type
+	assert.NoError(t, settings.Set(SettingStartup, "bobmods-logistics-beltoverhaul", 7.0))
+	assert.EqualError(t, settings.Set(SettingStartup, "bobmods-logistics-beltoverhaul", 7.5),
+		"setting bobmods-logistics-beltoverhaul: expected an integer, got 7.5")
+	assert.Error(t, settings.Set(SettingStartup, "bobmods-plates-purewater", "yes"))
+	assert.NoError(t, settings.Set(SettingRuntimeGlobal, "helmod_color", map[string]any{"g": 0.5, "a": 1.0}))
+	assert.NoError(t, settings.Set(SettingRuntimePerUser, "rso-mod-size", int64(4)))
+	assert.Error(t, settings.Set("runtime", "rso-mod-size", int64(4)))
+	assert.True(t, settings.Delete(SettingStartup, "bobmods-plates-purewater"))
+	assert.False(t, settings.Delete(SettingStartup, "bobmods-plates-purewater"))
+
+	saved := filepath.Join(t.TempDir(), "mod-settings.dat")
+	assert.NoError(t, settings.Save(saved))
+	loaded, err := LoadModSettings(saved)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"bobmods-ores-unsortedgemore", "bobmods-logistics-beltoverhaul", "helmod_display_size"},
+		loaded.Names(SettingStartup))
+	value, _ = loaded.Get(SettingStartup, "bobmods-logistics-beltoverhaul")
+	assert.Equal(t, int64(7), value)
+	value, _ = loaded.Get(SettingRuntimeGlobal, "helmod_color")
+	assert.Equal(t, map[string]any{"r": 1.0, "g": 0.5, "a": 1.0}, value)
+	value, _ = loaded.Get(SettingRuntimePerUser, "rso-mod-size")
+	assert.Equal(t, int64(4), value)
+
+	// Before 2.0 new integers are stored as doubles
+	old := NewModSettings([4]uint16{1, 1, 110, 0})
+	assert.NoError(t, old.Set(SettingStartup, "rso-mod-size", 4))
+	assert.Equal(t, PropertyNumber, old.setting(SettingStartup, "rso-mod-size").Type)
+}
+
+func TestModSettings_JSON(t *testing.T) {
+	settings, err := ParseModSettings(testModSettings())
+	if !assert.NoError(t, err) {
+		return
+	}
+	exported, err := settings.ExportJSON("bobmods-")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"version": "2.0.28",
+		"startup": {
+			"bobmods-plates-purewater": true,
+			"bobmods-ores-unsortedgemore": 0.5,
+			"bobmods-logistics-beltoverhaul": -3
+		}
+	}`, string(exported))
+
+	target := NewModSettings(settings.Version)
+	assert.NoError(t, target.Set(SettingStartup, "bobmods-ores-unsortedgemore", 2.0))
+	assert.NoError(t, target.ImportJSON(exported))
+	assert.Equal(t, settings.Settings("bobmods-"), []Setting{
+		{Scope: SettingStartup, Name: "bobmods-plates-purewater", Value: true},
+		{Scope: SettingStartup, Name: "bobmods-ores-unsortedgemore", Value: 0.5},
+		{Scope: SettingStartup, Name: "bobmods-logistics-beltoverhaul", Value: int64(-3)},
+	})
+	value, _ := target.Get(SettingStartup, "bobmods-logistics-beltoverhaul")
+	assert.Equal(t, int64(-3), value)
+	value, _ = target.Get(SettingStartup, "bobmods-ores-unsortedgemore")
+	assert.Equal(t, 0.5, value)
+
+	assert.Error(t, target.ImportJSON([]byte(`{"startup": {"a": 1}} {}`)))
+	assert.Error(t, target.ImportJSON([]byte(`{"startup": []}`)))
+}
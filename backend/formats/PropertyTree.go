@@ -0,0 +1,488 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+)
+
+// PropertyType is the type of a property tree node.
+type PropertyType uint8
+
+const (
+	PropertyNone       PropertyType = 0
+	PropertyBool       PropertyType = 1
+	PropertyNumber     PropertyType = 2
+	PropertyString     PropertyType = 3
+	PropertyList       PropertyType = 4
+	PropertyDictionary PropertyType = 5
+	// PropertySigned and PropertyUnsigned are 64-bit integers, written by Factorio 2.0 and newer.
+	PropertySigned   PropertyType = 6
+	PropertyUnsigned PropertyType = 7
+)
+
+// maxPropertyItems limits lists and dictionaries, so a corrupt count cannot exhaust memory.
+const maxPropertyItems = 1 << 24
+
+// PropertyTree is a node of Factorio's binary property tree, the format of mod-settings.dat and parts of save
+// files. See https://wiki.factorio.com/Property_tree. Nodes remember how their strings were encoded, so writing
+// an unchanged tree reproduces the input byte for byte.
+type PropertyTree struct {
+	Type PropertyType
+	// AnyType is a flag Factorio writes with every node. It has no meaning for mods but is kept as read.
+	AnyType bool
+
+	Bool     bool
+	Number   float64
+	Signed   int64
+	Unsigned uint64
+	String   string
+	// Items are the entries of lists and dictionaries in file order. List entries have empty keys.
+	Items []PropertyItem
+
+	encoding stringEncoding
+}
+
+type PropertyItem struct {
+	Key   string
+	Value *PropertyTree
+
+	encoding stringEncoding
+}
+
+// stringEncoding records the unusual ways Factorio can write a string, the zero value is how it writes them.
+type stringEncoding struct {
+	// zeroLength is set for an empty string written with a length of zero instead of the empty flag.
+	zeroLength bool
+	// longLength is set for a short string whose length was written in five bytes.
+	longLength bool
+}
+
+// ReadPropertyTree reads one node and everything below it. Readers that are io.ByteReaders, like bytes.Reader,
+// are not buffered, so they are left right after the tree.
+func ReadPropertyTree(r io.Reader) (*PropertyTree, error) {
+	if _, ok := r.(io.ByteReader); !ok {
+		r = bufio.NewReader(r)
+	}
+	return (&propertyReader{r: r}).tree(0)
+}
+
+type propertyReader struct {
+	r   io.Reader
+	buf [8]byte
+}
+
+func (p *propertyReader) bytes(n int) ([]byte, error) {
+	if _, err := io.ReadFull(p.r, p.buf[:n]); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return p.buf[:n], nil
+}
+
+func (p *propertyReader) byte() (byte, error) {
+	b, err := p.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (p *propertyReader) uint32() (uint32, error) {
+	b, err := p.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (p *propertyReader) uint64() (uint64, error) {
+	b, err := p.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func (p *propertyReader) string() (string, stringEncoding, error) {
+	var encoding stringEncoding
+	empty, err := p.byte()
+	if err != nil || empty != 0 {
+		return "", encoding, err
+	}
+	length, err := p.byte()
+	if err != nil {
+		return "", encoding, err
+	}
+	size := uint32(length)
+	if length == 0xFF {
+		if size, err = p.uint32(); err != nil {
+			return "", encoding, err
+		}
+		encoding.longLength = size < 0xFF
+	}
+	encoding.zeroLength = size == 0
+	// Read in chunks, a corrupt length must not allocate gigabytes up front
+	value, err := io.ReadAll(io.LimitReader(p.r, int64(size)))
+	if err != nil {
+		return "", encoding, err
+	}
+	if len(value) != int(size) {
+		return "", encoding, io.ErrUnexpectedEOF
+	}
+	return string(value), encoding, nil
+}
+
+func (p *propertyReader) tree(depth int) (*PropertyTree, error) {
+	if depth > 1000 {
+		return nil, errors.New("property tree is nested too deeply")
+	}
+	header, err := p.bytes(2)
+	if err != nil {
+		return nil, err
+	}
+	tree := &PropertyTree{Type: PropertyType(header[0]), AnyType: header[1] != 0}
+	switch tree.Type {
+	case PropertyNone:
+	case PropertyBool:
+		value, err := p.byte()
+		if err != nil {
+			return nil, err
+		}
+		tree.Bool = value != 0
+	case PropertyNumber:
+		bits, err := p.uint64()
+		if err != nil {
+			return nil, err
+		}
+		tree.Number = math.Float64frombits(bits)
+	case PropertyString:
+		if tree.String, tree.encoding, err = p.string(); err != nil {
+			return nil, err
+		}
+	case PropertyList, PropertyDictionary:
+		count, err := p.uint32()
+		if err != nil {
+			return nil, err
+		}
+		if count > maxPropertyItems {
+			return nil, fmt.Errorf("property tree has %d items", count)
+		}
+		for i := uint32(0); i < count; i++ {
+			var item PropertyItem
+			if item.Key, item.encoding, err = p.string(); err != nil {
+				return nil, err
+			}
+			if item.Value, err = p.tree(depth + 1); err != nil {
+				return nil, err
+			}
+			tree.Items = append(tree.Items, item)
+		}
+	case PropertySigned:
+		value, err := p.uint64()
+		if err != nil {
+			return nil, err
+		}
+		tree.Signed = int64(value)
+	case PropertyUnsigned:
+		if tree.Unsigned, err = p.uint64(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown property tree type %d", tree.Type)
+	}
+	return tree, nil
+}
+
+// Write writes the node and everything below it.
+func (t *PropertyTree) Write(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	if err := t.write(writer); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func writeBool(w *bufio.Writer, value bool) error {
+	if value {
+		return w.WriteByte(1)
+	}
+	return w.WriteByte(0)
+}
+
+func writePropertyString(w *bufio.Writer, value string, encoding stringEncoding) error {
+	if value == "" && !encoding.zeroLength {
+		return w.WriteByte(1)
+	}
+	if err := w.WriteByte(0); err != nil {
+		return err
+	}
+	if len(value) < 0xFF && !encoding.longLength {
+		if err := w.WriteByte(byte(len(value))); err != nil {
+			return err
+		}
+	} else {
+		if len(value) > math.MaxUint32 {
+			return errors.New("string is too long for a property tree")
+		}
+		if err := w.WriteByte(0xFF); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, uint32(len(value))); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteString(value)
+	return err
+}
+
+func (t *PropertyTree) write(w *bufio.Writer) error {
+	if err := w.WriteByte(byte(t.Type)); err != nil {
+		return err
+	}
+	if err := writeBool(w, t.AnyType); err != nil {
+		return err
+	}
+	switch t.Type {
+	case PropertyNone:
+		return nil
+	case PropertyBool:
+		return writeBool(w, t.Bool)
+	case PropertyNumber:
+		return binary.Write(w, binary.LittleEndian, math.Float64bits(t.Number))
+	case PropertyString:
+		return writePropertyString(w, t.String, t.encoding)
+	case PropertyList, PropertyDictionary:
+		if err := binary.Write(w, binary.LittleEndian, uint32(len(t.Items))); err != nil {
+			return err
+		}
+		for _, item := range t.Items {
+			if err := writePropertyString(w, item.Key, item.encoding); err != nil {
+				return err
+			}
+			if item.Value == nil {
+				return fmt.Errorf("property %q has no value", item.Key)
+			}
+			if err := item.Value.write(w); err != nil {
+				return err
+			}
+		}
+		return nil
+	case PropertySigned:
+		return binary.Write(w, binary.LittleEndian, t.Signed)
+	case PropertyUnsigned:
+		return binary.Write(w, binary.LittleEndian, t.Unsigned)
+	}
+	return fmt.Errorf("unknown property tree type %d", t.Type)
+}
+
+// Get returns the value of a dictionary key, or nil.
+func (t *PropertyTree) Get(key string) *PropertyTree {
+	if t == nil || t.Type != PropertyDictionary {
+		return nil
+	}
+	for _, item := range t.Items {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+// Set replaces the value of a dictionary key in place, or appends the key.
+func (t *PropertyTree) Set(key string, value *PropertyTree) {
+	for i := range t.Items {
+		if t.Items[i].Key == key {
+			t.Items[i].Value = value
+			return
+		}
+	}
+	t.Items = append(t.Items, PropertyItem{Key: key, Value: value})
+}
+
+// Delete removes a dictionary key and reports whether it existed.
+func (t *PropertyTree) Delete(key string) bool {
+	before := len(t.Items)
+	t.Items = slices.DeleteFunc(t.Items, func(item PropertyItem) bool { return item.Key == key })
+	return len(t.Items) != before
+}
+
+// Keys returns the keys of a dictionary in file order.
+func (t *PropertyTree) Keys() []string {
+	if t == nil || t.Type != PropertyDictionary {
+		return nil
+	}
+	keys := make([]string, 0, len(t.Items))
+	for _, item := range t.Items {
+		keys = append(keys, item.Key)
+	}
+	return keys
+}
+
+// Value converts the node to plain Go values: nil, bool, float64, int64, uint64, string, []any and
+// map[string]any.
+func (t *PropertyTree) Value() any {
+	switch t.Type {
+	case PropertyBool:
+		return t.Bool
+	case PropertyNumber:
+		return t.Number
+	case PropertyString:
+		return t.String
+	case PropertyList:
+		list := make([]any, 0, len(t.Items))
+		for _, item := range t.Items {
+			list = append(list, item.Value.Value())
+		}
+		return list
+	case PropertyDictionary:
+		dictionary := make(map[string]any, len(t.Items))
+		for _, item := range t.Items {
+			dictionary[item.Key] = item.Value.Value()
+		}
+		return dictionary
+	case PropertySigned:
+		return t.Signed
+	case PropertyUnsigned:
+		return t.Unsigned
+	}
+	return nil
+}
+
+// NewPropertyTree converts a Go value to a node. Integers become PropertySigned, use SetValue to keep the type of
+// an existing node instead. Map keys are sorted, as maps have no order.
+func NewPropertyTree(value any) (*PropertyTree, error) {
+	switch v := value.(type) {
+	case nil:
+		return &PropertyTree{Type: PropertyNone}, nil
+	case *PropertyTree:
+		return v, nil
+	case bool:
+		return &PropertyTree{Type: PropertyBool, Bool: v}, nil
+	case float64:
+		return &PropertyTree{Type: PropertyNumber, Number: v}, nil
+	case float32:
+		return &PropertyTree{Type: PropertyNumber, Number: float64(v)}, nil
+	case int:
+		return &PropertyTree{Type: PropertySigned, Signed: int64(v)}, nil
+	case int64:
+		return &PropertyTree{Type: PropertySigned, Signed: v}, nil
+	case uint64:
+		return &PropertyTree{Type: PropertyUnsigned, Unsigned: v}, nil
+	case string:
+		return &PropertyTree{Type: PropertyString, String: v}, nil
+	case []any:
+		tree := &PropertyTree{Type: PropertyList}
+		for _, entry := range v {
+			node, err := NewPropertyTree(entry)
+			if err != nil {
+				return nil, err
+			}
+			tree.Items = append(tree.Items, PropertyItem{Value: node})
+		}
+		return tree, nil
+	case map[string]any:
+		tree := &PropertyTree{Type: PropertyDictionary}
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			node, err := NewPropertyTree(v[key])
+			if err != nil {
+				return nil, err
+			}
+			tree.Items = append(tree.Items, PropertyItem{Key: key, Value: node})
+		}
+		return tree, nil
+	}
+	return nil, fmt.Errorf("cannot store %T in a property tree", value)
+}
+
+// SetValue changes the node to value, converting numbers to the node's current number type, so an integer
+// setting stays an integer when it is given as a float and the other way round.
+func (t *PropertyTree) SetValue(value any) error {
+	switch t.Type {
+	case PropertyNumber, PropertySigned, PropertyUnsigned:
+		var number float64
+		switch v := value.(type) {
+		case float64:
+			number = v
+		case int:
+			number = float64(v)
+		case int64:
+			number = float64(v)
+		case uint64:
+			number = float64(v)
+		default:
+			return fmt.Errorf("expected a number, got %T", value)
+		}
+		switch t.Type {
+		case PropertyNumber:
+			t.Number = number
+		case PropertySigned:
+			if number != math.Trunc(number) {
+				return fmt.Errorf("expected an integer, got %v", number)
+			}
+			if v, ok := value.(int64); ok {
+				t.Signed = v
+			} else {
+				t.Signed = int64(number)
+			}
+		case PropertyUnsigned:
+			if number != math.Trunc(number) || number < 0 {
+				return fmt.Errorf("expected an unsigned integer, got %v", number)
+			}
+			if v, ok := value.(uint64); ok {
+				t.Unsigned = v
+			} else {
+				t.Unsigned = uint64(number)
+			}
+		}
+		return nil
+	case PropertyString:
+		if v, ok := value.(string); ok {
+			t.String = v
+			return nil
+		}
+		return fmt.Errorf("expected a string, got %T", value)
+	case PropertyBool:
+		if v, ok := value.(bool); ok {
+			t.Bool = v
+			return nil
+		}
+		return fmt.Errorf("expected a boolean, got %T", value)
+	case PropertyDictionary:
+		v, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected a table, got %T", value)
+		}
+		// Colors: change the components that exist and add the others
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			entry := v[key]
+			if existing := t.Get(key); existing != nil {
+				if err := existing.SetValue(entry); err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				continue
+			}
+			node, err := NewPropertyTree(entry)
+			if err != nil {
+				return err
+			}
+			t.Set(key, node)
+		}
+		return nil
+	}
+	node, err := NewPropertyTree(value)
+	if err != nil {
+		return err
+	}
+	anyType := t.AnyType
+	*t = *node
+	t.AnyType = anyType
+	return nil
+}
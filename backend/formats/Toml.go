@@ -0,0 +1,648 @@
+package formats
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DecodeToml parses a TOML document into plain values like DecodeYaml.
+func DecodeToml(data []byte) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	if err := toml.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return normalize(result).(map[string]interface{}), nil
+}
+
+// EncodeToml writes a table. Nested maps become [tables] and lists of maps become [[arrays of tables]].
+func EncodeToml(value map[string]interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := toml.NewEncoder(&buffer)
+	encoder.Indent = ""
+	if err := encoder.Encode(denormalize(value)); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// UpdateToml encodes value on top of the original document. Lines whose value did not change are kept as they
+// are, including comments, blank lines and key order. Changed values are rewritten in place, keeping a trailing
+// comment and the float type that was read, new keys are added to their table and removed keys are dropped.
+func UpdateToml(original []byte, value map[string]interface{}) ([]byte, error) {
+	if len(bytes.TrimSpace(original)) == 0 {
+		return EncodeToml(value)
+	}
+	current, err := DecodeToml(original)
+	if err != nil {
+		return nil, err
+	}
+	document, err := parseTomlDocument(string(original))
+	if err != nil {
+		return nil, err
+	}
+	return document.update(current, value)
+}
+
+type tomlBlockKind int
+
+const (
+	tomlText tomlBlockKind = iota
+	tomlTable
+	tomlArrayTable
+	tomlEntry
+)
+
+// tomlBlock is a comment or blank line, a table header or a key/value pair, which can span several lines.
+type tomlBlock struct {
+	kind  tomlBlockKind
+	lines []string
+	// path is the full key, with the index of the element for arrays of tables.
+	path []interface{}
+	// prefix is everything up to the value, value is its text and suffix the spacing and comment after it.
+	prefix string
+	value  string
+	suffix string
+}
+
+type tomlDocument struct {
+	blocks          []*tomlBlock
+	newline         string
+	trailingNewline bool
+	// headers holds the path of every table header, defined every path that has a header or key below it.
+	headers map[string]bool
+	defined map[string]bool
+	// arrays counts the elements of each array of tables.
+	arrays map[string]int
+}
+
+type tomlUpdate struct {
+	document *tomlDocument
+	current  map[string]interface{}
+	value    map[string]interface{}
+	out      []string
+	written  map[string]bool
+}
+
+func tomlPathKey(path []interface{}) string {
+	parts := make([]string, len(path))
+	for i, part := range path {
+		parts[i] = fmt.Sprint(part)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+func appendPath(path []interface{}, parts ...interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(path)+len(parts)), path...), parts...)
+}
+
+func parseTomlDocument(text string) (*tomlDocument, error) {
+	document := &tomlDocument{
+		newline:         "\n",
+		trailingNewline: strings.HasSuffix(text, "\n"),
+		headers:         make(map[string]bool),
+		defined:         make(map[string]bool),
+		arrays:          make(map[string]int),
+	}
+	if strings.Contains(text, "\r\n") {
+		document.newline = "\r\n"
+	}
+	lines := strings.Split(strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), "\n")
+
+	var table []interface{}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			document.blocks = append(document.blocks, &tomlBlock{kind: tomlText, lines: []string{line}})
+		case strings.HasPrefix(trimmed, "["):
+			array := strings.HasPrefix(trimmed, "[[")
+			keys, _, err := parseTomlKey(strings.TrimLeft(trimmed, "["))
+			if err != nil {
+				return nil, fmt.Errorf("toml: line %d: %w", i+1, err)
+			}
+			table = document.resolveTable(keys, array)
+			kind := tomlTable
+			if array {
+				kind = tomlArrayTable
+			}
+			document.blocks = append(document.blocks, &tomlBlock{kind: kind, lines: []string{line}, path: table})
+			document.headers[tomlPathKey(table)] = true
+			document.define(table)
+		default:
+			keys, rest, err := parseTomlKey(line)
+			if err != nil || !strings.HasPrefix(rest, "=") {
+				return nil, fmt.Errorf("toml: line %d: expected a key and value", i+1)
+			}
+			prefix := line[:len(line)-len(rest)+1]
+			valueText := rest[1:]
+			block := &tomlBlock{kind: tomlEntry, lines: []string{line}}
+			// Values such as arrays and multi-line strings continue until they parse
+			for !isTomlValue(valueText) {
+				if i+1 >= len(lines) {
+					return nil, fmt.Errorf("toml: line %d: unterminated value", i+1)
+				}
+				i++
+				block.lines = append(block.lines, lines[i])
+				valueText += "\n" + lines[i]
+			}
+			trimmedValue := strings.TrimLeft(valueText, " \t")
+			block.prefix = prefix + valueText[:len(valueText)-len(trimmedValue)]
+			block.value, block.suffix = splitTomlComment(trimmedValue)
+			block.path = appendPath(table)
+			for _, key := range keys {
+				block.path = append(block.path, key)
+			}
+			document.blocks = append(document.blocks, block)
+			document.define(block.path)
+		}
+	}
+	return document, nil
+}
+
+// resolveTable turns a header's keys into a path, adding the index of the current element of every array of
+// tables on the way.
+func (d *tomlDocument) resolveTable(keys []string, array bool) []interface{} {
+	var path []interface{}
+	for i, key := range keys {
+		path = append(path, key)
+		count, isArray := d.arrays[tomlPathKey(path)]
+		if i == len(keys)-1 && array {
+			d.arrays[tomlPathKey(path)] = count + 1
+			path = append(path, count)
+		} else if isArray {
+			path = append(path, count-1)
+		}
+	}
+	return path
+}
+
+func (d *tomlDocument) define(path []interface{}) {
+	for i := 0; i <= len(path); i++ {
+		d.defined[tomlPathKey(path[:i])] = true
+	}
+}
+
+// parseTomlKey reads a dotted key of bare and quoted parts and returns the text after it.
+func parseTomlKey(text string) ([]string, string, error) {
+	var keys []string
+	rest := strings.TrimLeft(text, " \t")
+	for {
+		switch {
+		case strings.HasPrefix(rest, `"`):
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(rest) {
+				return nil, "", fmt.Errorf("unterminated key %s", rest)
+			}
+			key, err := strconv.Unquote(rest[:end+1])
+			if err != nil {
+				return nil, "", err
+			}
+			keys = append(keys, key)
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "'"):
+			end := strings.Index(rest[1:], "'")
+			if end < 0 {
+				return nil, "", fmt.Errorf("unterminated key %s", rest)
+			}
+			keys = append(keys, rest[1:end+1])
+			rest = rest[end+2:]
+		default:
+			end := strings.IndexFunc(rest, func(r rune) bool { return !isBareTomlKey(r) })
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, "", fmt.Errorf("expected a key at %q", rest)
+			}
+			keys = append(keys, rest[:end])
+			rest = rest[end:]
+		}
+		rest = strings.TrimLeft(rest, " \t")
+		if !strings.HasPrefix(rest, ".") {
+			return keys, rest, nil
+		}
+		rest = strings.TrimLeft(rest[1:], " \t")
+	}
+}
+
+func isBareTomlKey(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-'
+}
+
+func isTomlValue(text string) bool {
+	var value map[string]interface{}
+	_, err := toml.Decode("value ="+text, &value)
+	return err == nil
+}
+
+// splitTomlComment separates a value from the spacing and comment that follow it on its last line.
+func splitTomlComment(text string) (string, string) {
+	for i := 0; i < len(text); i++ {
+		if text[i] != '#' || strings.Contains(text[i:], "\n") || !isTomlValue(text[:i]) {
+			continue
+		}
+		value := strings.TrimRight(text[:i], " \t")
+		return value, text[len(value):]
+	}
+	value := strings.TrimRight(text, " \t")
+	return value, text[len(value):]
+}
+
+func (d *tomlDocument) update(current map[string]interface{}, value map[string]interface{}) ([]byte, error) {
+	u := &tomlUpdate{document: d, current: current, value: value, written: make(map[string]bool)}
+	open := [][]interface{}{{}}
+	var table []interface{}
+	insertAt, dropping := 0, false
+	for _, block := range d.blocks {
+		switch block.kind {
+		case tomlText:
+			if !dropping {
+				u.out = append(u.out, block.lines...)
+			}
+		case tomlEntry:
+			if dropping {
+				continue
+			}
+			item, ok := lookupToml(value, block.path)
+			if !ok {
+				continue
+			}
+			u.written[tomlPathKey(block.path)] = true
+			old, _ := lookupToml(current, block.path)
+			if sameValue(old, item) {
+				u.out = append(u.out, block.lines...)
+			} else {
+				text, err := encodeTomlValue(item, old, block.value)
+				if err != nil {
+					return nil, err
+				}
+				u.out = append(u.out, strings.Split(block.prefix+text+block.suffix, "\n")...)
+			}
+			insertAt = len(u.out)
+		case tomlTable, tomlArrayTable:
+			if !dropping {
+				if err := u.flush(table, insertAt); err != nil {
+					return nil, err
+				}
+			}
+			for len(open) > 0 && !hasTomlPrefix(block.path, open[len(open)-1]) {
+				if err := u.close(open[len(open)-1]); err != nil {
+					return nil, err
+				}
+				open = open[:len(open)-1]
+			}
+			item, ok := lookupToml(value, block.path)
+			if _, isTable := item.(map[string]interface{}); !ok || !isTable {
+				// The table was removed, together with its comments
+				dropping = true
+				continue
+			}
+			dropping = false
+			u.out = append(u.out, block.lines...)
+			table = block.path
+			insertAt = len(u.out)
+			open = append(open, block.path)
+		}
+	}
+	if !dropping {
+		if err := u.flush(table, insertAt); err != nil {
+			return nil, err
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		if err := u.close(open[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	text := strings.Join(u.out, d.newline)
+	if d.trailingNewline && len(u.out) > 0 {
+		text += d.newline
+	}
+	return []byte(text), nil
+}
+
+func hasTomlPrefix(path []interface{}, prefix []interface{}) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func lookupToml(value interface{}, path []interface{}) (interface{}, bool) {
+	for _, part := range path {
+		switch key := part.(type) {
+		case string:
+			table, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, ok = table[key]; !ok {
+				return nil, false
+			}
+		case int:
+			list, ok := value.([]interface{})
+			if !ok || key >= len(list) {
+				return nil, false
+			}
+			value = list[key]
+		}
+	}
+	return value, true
+}
+
+func sortedKeys(table map[string]interface{}) []string {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isTomlTableArray(value interface{}) bool {
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 {
+		return false
+	}
+	for _, item := range list {
+		if _, ok := item.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// flush inserts the new keys of a table after its last key. Tables that only exist through dotted keys get new
+// dotted keys, other new tables are written when the table is closed.
+func (u *tomlUpdate) flush(table []interface{}, at int) error {
+	var lines []string
+	var collect func(path []interface{}, prefix string) error
+	collect = func(path []interface{}, prefix string) error {
+		item, _ := lookupToml(u.value, path)
+		values, _ := item.(map[string]interface{})
+		for _, key := range sortedKeys(values) {
+			child := appendPath(path, key)
+			childKey := tomlPathKey(child)
+			if u.written[childKey] || u.document.headers[childKey] {
+				continue
+			}
+			name := prefix + quoteTomlKey(key)
+			switch value := values[key].(type) {
+			case map[string]interface{}:
+				if u.document.defined[childKey] && !u.hasHeaderBelow(child) {
+					if err := collect(child, name+"."); err != nil {
+						return err
+					}
+				}
+				continue
+			default:
+				if _, isArray := u.document.arrays[childKey]; isArray {
+					continue
+				}
+				if isTomlTableArray(value) && !u.document.defined[childKey] {
+					continue
+				}
+				text, err := encodeTomlValue(value, nil, "")
+				if err != nil {
+					return err
+				}
+				lines = append(lines, name+" = "+text)
+				u.written[childKey] = true
+			}
+		}
+		return nil
+	}
+	if err := collect(table, ""); err != nil {
+		return err
+	}
+	u.out = append(u.out[:at], append(lines, u.out[at:]...)...)
+	return nil
+}
+
+func (u *tomlUpdate) hasHeaderBelow(path []interface{}) bool {
+	for key := range u.document.headers {
+		if strings.HasPrefix(key, tomlPathKey(path)+"\x00") {
+			return true
+		}
+	}
+	return false
+}
+
+// close writes the tables below table that are not in the document yet, at its end. Elements added to an array
+// of tables follow its last element.
+func (u *tomlUpdate) close(table []interface{}) error {
+	item, _ := lookupToml(u.value, table)
+	values, _ := item.(map[string]interface{})
+	for _, key := range sortedKeys(values) {
+		child := appendPath(table, key)
+		childKey := tomlPathKey(child)
+		if u.written[childKey] || u.document.headers[childKey] {
+			continue
+		}
+		switch value := values[key].(type) {
+		case map[string]interface{}:
+			if !u.hasHeaderBelow(child) {
+				if !u.document.defined[childKey] {
+					u.writeTable(child, value, false)
+				}
+				continue
+			}
+			// Only defined by the headers of its subtables, so it can still get its own
+			start := len(u.out)
+			u.writeHeader("[" + tomlHeader(child) + "]")
+			header := len(u.out)
+			if err := u.flush(child, header); err != nil {
+				return err
+			}
+			if len(u.out) == header {
+				u.out = u.out[:start]
+			}
+			if err := u.close(child); err != nil {
+				return err
+			}
+		case []interface{}:
+			if count, isArray := u.document.arrays[childKey]; isArray {
+				for _, element := range value[min(count, len(value)):] {
+					u.writeTable(child, element.(map[string]interface{}), true)
+				}
+			} else if isTomlTableArray(value) && !u.document.defined[childKey] {
+				for _, element := range value {
+					u.writeTable(child, element.(map[string]interface{}), true)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeTable appends a table that is not in the document, like EncodeToml would write it.
+func (u *tomlUpdate) writeTable(path []interface{}, values map[string]interface{}, array bool) {
+	header := "[" + tomlHeader(path) + "]"
+	if array {
+		header = "[" + header + "]"
+	}
+	u.writeHeader(header)
+	var tables []string
+	for _, key := range sortedKeys(values) {
+		if _, isTable := values[key].(map[string]interface{}); isTable || isTomlTableArray(values[key]) {
+			tables = append(tables, key)
+			continue
+		}
+		if text, err := encodeTomlValue(values[key], nil, ""); err == nil {
+			u.out = append(u.out, quoteTomlKey(key)+" = "+text)
+		}
+	}
+	for _, key := range tables {
+		child := appendPath(path, key)
+		if table, ok := values[key].(map[string]interface{}); ok {
+			u.writeTable(child, table, false)
+			continue
+		}
+		for _, element := range values[key].([]interface{}) {
+			u.writeTable(child, element.(map[string]interface{}), true)
+		}
+	}
+}
+
+// writeHeader starts a table, separated from the previous one by a blank line.
+func (u *tomlUpdate) writeHeader(header string) {
+	if len(u.out) > 0 && strings.TrimSpace(u.out[len(u.out)-1]) != "" {
+		u.out = append(u.out, "")
+	}
+	u.out = append(u.out, header)
+}
+
+// tomlHeader is the name of a table in a header, without the indices of arrays of tables.
+func tomlHeader(path []interface{}) string {
+	var names []string
+	for _, part := range path {
+		if key, ok := part.(string); ok {
+			names = append(names, quoteTomlKey(key))
+		}
+	}
+	return strings.Join(names, ".")
+}
+
+func quoteTomlKey(key string) string {
+	if key != "" && strings.IndexFunc(key, func(r rune) bool { return !isBareTomlKey(r) }) < 0 {
+		return key
+	}
+	return quoteTomlString(key)
+}
+
+func quoteTomlString(value string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&sb, `\u%04X`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// encodeTomlValue writes a value inline. Whole numbers are written as integers unless the value they replace was
+// a float, and arrays that were spread over several lines stay that way.
+func encodeTomlValue(value interface{}, old interface{}, oldText string) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return quoteTomlString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		_, wasFloat := old.(float64)
+		switch {
+		case math.IsNaN(v):
+			return "nan", nil
+		case math.IsInf(v, 0):
+			if v < 0 {
+				return "-inf", nil
+			}
+			return "inf", nil
+		case v == math.Trunc(v) && wasFloat:
+			return strconv.FormatFloat(v, 'f', 1, 64), nil
+		case v == math.Trunc(v) && math.Abs(v) < 1<<53:
+			return strconv.FormatInt(int64(v), 10), nil
+		}
+		text := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".eEn") {
+			text += ".0"
+		}
+		return text, nil
+	case []interface{}:
+		oldList, _ := old.([]interface{})
+		items := make([]string, len(v))
+		for i, item := range v {
+			var oldItem interface{}
+			if i < len(oldList) {
+				oldItem = oldList[i]
+			}
+			text, err := encodeTomlValue(item, oldItem, "")
+			if err != nil {
+				return "", err
+			}
+			items[i] = text
+		}
+		lines := strings.Split(oldText, "\n")
+		if len(lines) < 3 || len(items) == 0 {
+			return "[" + strings.Join(items, ", ") + "]", nil
+		}
+		indent := lines[1][:len(lines[1])-len(strings.TrimLeft(lines[1], " \t"))]
+		last := lines[len(lines)-1]
+		closing := last[:len(last)-len(strings.TrimLeft(last, " \t"))]
+		return "[\n" + indent + strings.Join(items, ",\n"+indent) + ",\n" + closing + "]", nil
+	case map[string]interface{}:
+		oldTable, _ := old.(map[string]interface{})
+		items := make([]string, 0, len(v))
+		for _, key := range sortedKeys(v) {
+			text, err := encodeTomlValue(v[key], oldTable[key], "")
+			if err != nil {
+				return "", err
+			}
+			items = append(items, quoteTomlKey(key)+" = "+text)
+		}
+		if len(items) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	}
+	return "", fmt.Errorf("toml: cannot encode %T", value)
+}
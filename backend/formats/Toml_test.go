@@ -0,0 +1,103 @@
+package formats
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestToml_RoundTrip(t *testing.T) {
+	input := `[modengine]
+debug = false
+
+[[mods]]
+enabled = true
+path = "mod/SeamlessCoop"
+`
+	value, err := DecodeToml([]byte(input))
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"enabled": true, "path": "mod/SeamlessCoop"}}, value["mods"])
+
+	data, err := EncodeToml(value)
+	assert.NoError(t, err)
+	assert.Equal(t, input, string(data))
+}
+
+func TestUpdateToml_KeepsDocument(t *testing.T) {
+	input := `# ModEngine configuration
+[modengine]
+# Enables debug output
+debug = false   # true to log everything
+scale = 1.0
+
+[extension.mod_loader]
+enabled = true
+mods = [
+    { enabled = true, name = "default", path = "mod" },
+]
+
+[[profiles]]
+name = "first"
+
+[[profiles]]
+name = "second"
+`
+	value, err := DecodeToml([]byte(input))
+	assert.NoError(t, err)
+	value["modengine"].(map[string]interface{})["debug"] = true
+	value["modengine"].(map[string]interface{})["scale"] = float64(2)
+	value["modengine"].(map[string]interface{})["language"] = "en"
+	loader := value["extension"].(map[string]interface{})["mod_loader"].(map[string]interface{})
+	loader["mods"] = append(loader["mods"].([]interface{}), map[string]interface{}{"enabled": true, "name": "seamless", "path": "SeamlessCoop"})
+	value["profiles"] = append(value["profiles"].([]interface{}), map[string]interface{}{"name": "third"})
+	value["extension"].(map[string]interface{})["dll"] = map[string]interface{}{"paths": []interface{}{"a.dll"}}
+
+	data, err := UpdateToml([]byte(input), value)
+	assert.NoError(t, err)
+	assert.Equal(t, `# ModEngine configuration
+[modengine]
+# Enables debug output
+debug = true   # true to log everything
+scale = 2.0
+language = "en"
+
+[extension.mod_loader]
+enabled = true
+mods = [
+    { enabled = true, name = "default", path = "mod" },
+    { enabled = true, name = "seamless", path = "SeamlessCoop" },
+]
+
+[[profiles]]
+name = "first"
+
+[[profiles]]
+name = "second"
+
+[extension.dll]
+paths = ["a.dll"]
+
+[[profiles]]
+name = "third"
+`, string(data))
+
+	decoded, err := DecodeToml(data)
+	assert.NoError(t, err)
+	assert.True(t, sameValue(normalize(value), decoded))
+
+	unchanged, err := UpdateToml([]byte(input), mustDecodeToml(t, input))
+	assert.NoError(t, err)
+	assert.Equal(t, input, string(unchanged))
+}
+
+func TestUpdateToml_RemovesKeysAndTables(t *testing.T) {
+	input := "a = 1\nb = 2 # gone\n\n[old]\nx = 1\n\n[kept]\ny = \"z\"\n"
+	data, err := UpdateToml([]byte(input), map[string]interface{}{"a": float64(1), "kept": map[string]interface{}{"y": "z"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "a = 1\n\n[kept]\ny = \"z\"\n", string(data))
+}
+
+func mustDecodeToml(t *testing.T, input string) map[string]interface{} {
+	value, err := DecodeToml([]byte(input))
+	assert.NoError(t, err)
+	return value
+}
@@ -0,0 +1,298 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type XmlNodeType string
+
+const (
+	XmlDocument    XmlNodeType = "document"
+	XmlElement     XmlNodeType = "element"
+	XmlText        XmlNodeType = "text"
+	XmlComment     XmlNodeType = "comment"
+	XmlProcInst    XmlNodeType = "procinst"
+	XmlDirective   XmlNodeType = "directive"
+	xmlIndentation             = "  "
+)
+
+type XmlAttribute struct {
+	Name  string
+	Value string
+}
+
+// XmlNode is a node of an XML document. Names keep their namespace prefix ("xsi:nil") and attributes keep
+// their order, so a parsed document is written back with only insignificant differences such as quoting.
+type XmlNode struct {
+	Type       XmlNodeType
+	Name       string
+	Attributes []XmlAttribute
+	Children   []*XmlNode
+	// Text is the content of text, comment and directive nodes and the instruction of procinst nodes.
+	Text string
+}
+
+func NewXmlElement(name string) *XmlNode {
+	return &XmlNode{Type: XmlElement, Name: name}
+}
+
+// ParseXml reads a whole document. Whitespace between elements is kept as text nodes.
+func ParseXml(data []byte) (*XmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	document := &XmlNode{Type: XmlDocument}
+	stack := []*XmlNode{document}
+
+	for {
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			element := NewXmlElement(xmlName(t.Name))
+			for _, attr := range t.Attr {
+				element.Attributes = append(element.Attributes, XmlAttribute{Name: xmlName(attr.Name), Value: attr.Value})
+			}
+			parent.Children = append(parent.Children, element)
+			stack = append(stack, element)
+		case xml.EndElement:
+			if len(stack) == 1 || parent.Name != xmlName(t.Name) {
+				return nil, fmt.Errorf("unexpected closing tag </%s> at line %d", xmlName(t.Name), xmlLine(decoder))
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			parent.Children = append(parent.Children, &XmlNode{Type: XmlText, Text: string(t)})
+		case xml.Comment:
+			parent.Children = append(parent.Children, &XmlNode{Type: XmlComment, Text: string(t)})
+		case xml.ProcInst:
+			parent.Children = append(parent.Children, &XmlNode{Type: XmlProcInst, Name: t.Target, Text: string(t.Inst)})
+		case xml.Directive:
+			parent.Children = append(parent.Children, &XmlNode{Type: XmlDirective, Text: string(t)})
+		}
+	}
+	if len(stack) > 1 {
+		return nil, fmt.Errorf("unclosed tag <%s>", stack[len(stack)-1].Name)
+	}
+	return document, nil
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+func xmlLine(decoder *xml.Decoder) int {
+	line, _ := decoder.InputPos()
+	return line
+}
+
+// Root returns the first element of a document.
+func (n *XmlNode) Root() *XmlNode {
+	if n.Type != XmlDocument {
+		return n
+	}
+	for _, child := range n.Children {
+		if child.Type == XmlElement {
+			return child
+		}
+	}
+	return nil
+}
+
+// Elements returns the child elements, optionally only those with the given name.
+func (n *XmlNode) Elements(name string) []*XmlNode {
+	var result []*XmlNode
+	for _, child := range n.Children {
+		if child.Type == XmlElement && (name == "" || child.Name == name) {
+			result = append(result, child)
+		}
+	}
+	return result
+}
+
+// Find follows a path of element names separated by "/", e.g. "activeMods/li", and returns the first match.
+func (n *XmlNode) Find(path string) *XmlNode {
+	if matches := n.FindAll(path); len(matches) > 0 {
+		return matches[0]
+	}
+	return nil
+}
+
+// FindAll returns every element matching a path of element names separated by "/". "*" matches any name.
+func (n *XmlNode) FindAll(path string) []*XmlNode {
+	current := []*XmlNode{n}
+	if n.Type == XmlDocument {
+		current = n.Elements("")
+		first, rest, _ := strings.Cut(strings.Trim(path, "/"), "/")
+		var roots []*XmlNode
+		for _, root := range current {
+			if first == "*" || root.Name == first {
+				roots = append(roots, root)
+			}
+		}
+		if rest == "" {
+			return roots
+		}
+		current, path = roots, rest
+	}
+
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "*" {
+			name = ""
+		}
+		var next []*XmlNode
+		for _, node := range current {
+			next = append(next, node.Elements(name)...)
+		}
+		current = next
+	}
+	return current
+}
+
+func (n *XmlNode) Attr(name string) (string, bool) {
+	for _, attr := range n.Attributes {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetAttr changes an attribute in place or appends it.
+func (n *XmlNode) SetAttr(name string, value string) {
+	for i, attr := range n.Attributes {
+		if attr.Name == name {
+			n.Attributes[i].Value = value
+			return
+		}
+	}
+	n.Attributes = append(n.Attributes, XmlAttribute{Name: name, Value: value})
+}
+
+func (n *XmlNode) RemoveAttr(name string) {
+	for i, attr := range n.Attributes {
+		if attr.Name == name {
+			n.Attributes = append(n.Attributes[:i], n.Attributes[i+1:]...)
+			return
+		}
+	}
+}
+
+// InnerText returns the concatenated text of the node and its descendants.
+func (n *XmlNode) InnerText() string {
+	if n.Type == XmlText {
+		return n.Text
+	}
+	var sb strings.Builder
+	for _, child := range n.Children {
+		if child.Type == XmlText || child.Type == XmlElement {
+			sb.WriteString(child.InnerText())
+		}
+	}
+	return sb.String()
+}
+
+// SetText replaces all children with a single text node.
+func (n *XmlNode) SetText(text string) {
+	n.Children = []*XmlNode{{Type: XmlText, Text: text}}
+}
+
+// Append adds a child element. If the parent is indented, the whitespace of its existing children is copied so
+// the new element lines up with its siblings.
+func (n *XmlNode) Append(child *XmlNode) {
+	last := len(n.Children) - 1
+	if last >= 0 && n.Children[last].Type == XmlText && strings.TrimSpace(n.Children[last].Text) == "" {
+		closing := n.Children[last]
+		indent := closing.Text + xmlIndentation
+		for _, sibling := range n.Children[:last] {
+			if sibling.Type == XmlText && strings.TrimSpace(sibling.Text) == "" {
+				indent = sibling.Text
+			}
+		}
+		n.Children = append(n.Children[:last], &XmlNode{Type: XmlText, Text: indent}, child, closing)
+		return
+	}
+	n.Children = append(n.Children, child)
+}
+
+// Remove deletes a child node together with the whitespace in front of it.
+func (n *XmlNode) Remove(child *XmlNode) bool {
+	for i, candidate := range n.Children {
+		if candidate != child {
+			continue
+		}
+		start := i
+		if i > 0 && n.Children[i-1].Type == XmlText && strings.TrimSpace(n.Children[i-1].Text) == "" {
+			start--
+		}
+		n.Children = append(n.Children[:start], n.Children[i+1:]...)
+		return true
+	}
+	return false
+}
+
+func (n *XmlNode) String() string {
+	var sb strings.Builder
+	n.write(&sb)
+	return sb.String()
+}
+
+func (n *XmlNode) Bytes() []byte {
+	return []byte(n.String())
+}
+
+func (n *XmlNode) write(sb *strings.Builder) {
+	switch n.Type {
+	case XmlDocument:
+		for _, child := range n.Children {
+			child.write(sb)
+		}
+	case XmlText:
+		sb.WriteString(escapeXml(n.Text, false))
+	case XmlComment:
+		sb.WriteString("<!--" + n.Text + "-->")
+	case XmlProcInst:
+		sb.WriteString("<?" + n.Name)
+		if n.Text != "" {
+			sb.WriteString(" " + n.Text)
+		}
+		sb.WriteString("?>")
+	case XmlDirective:
+		sb.WriteString("<!" + n.Text + ">")
+	case XmlElement:
+		sb.WriteString("<" + n.Name)
+		for _, attr := range n.Attributes {
+			sb.WriteString(" " + attr.Name + `="` + escapeXml(attr.Value, true) + `"`)
+		}
+		if len(n.Children) == 0 {
+			sb.WriteString(" />")
+			return
+		}
+		sb.WriteString(">")
+		for _, child := range n.Children {
+			child.write(sb)
+		}
+		sb.WriteString("</" + n.Name + ">")
+	}
+}
+
+// escapeXml only escapes what is required, unlike xml.EscapeText which also escapes newlines and tabs.
+func escapeXml(value string, attribute bool) string {
+	replacements := []string{"&", "&amp;", "<", "&lt;", ">", "&gt;"}
+	if attribute {
+		replacements = append(replacements, `"`, "&quot;", "\n", "&#xA;", "\t", "&#x9;")
+	}
+	return strings.NewReplacer(replacements...).Replace(value)
+}
@@ -0,0 +1,59 @@
+package formats
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const modsConfig = `<?xml version="1.0" encoding="utf-8"?>
+<!-- RimWorld -->
+<ModsConfigData>
+  <version>1.5.4104 rev435</version>
+  <activeMods>
+    <li>ludeon.rimworld</li>
+    <li>brrainz.harmony</li>
+  </activeMods>
+  <knownExpansions xsi:nil="true" />
+  <note>Tom &amp; Jerry &lt;3</note>
+</ModsConfigData>
+`
+
+func TestParseXml_RoundTrip(t *testing.T) {
+	document, err := ParseXml([]byte(modsConfig))
+	assert.NoError(t, err)
+	assert.Equal(t, modsConfig, document.String())
+
+	root := document.Root()
+	assert.Equal(t, "ModsConfigData", root.Name)
+	assert.Equal(t, "1.5.4104 rev435", document.Find("ModsConfigData/version").InnerText())
+	assert.Equal(t, "Tom & Jerry <3", root.Find("note").InnerText())
+	value, ok := root.Find("knownExpansions").Attr("xsi:nil")
+	assert.True(t, ok)
+	assert.Equal(t, "true", value)
+}
+
+func TestXmlNode_Edit(t *testing.T) {
+	document, err := ParseXml([]byte(modsConfig))
+	assert.NoError(t, err)
+	activeMods := document.Find("ModsConfigData/activeMods")
+
+	mods := activeMods.FindAll("li")
+	assert.Len(t, mods, 2)
+	assert.True(t, activeMods.Remove(mods[0]))
+
+	mod := NewXmlElement("li")
+	mod.SetText("unlimitedhugs.hugslib")
+	activeMods.Append(mod)
+
+	assert.Contains(t, document.String(), `  <activeMods>
+    <li>brrainz.harmony</li>
+    <li>unlimitedhugs.hugslib</li>
+  </activeMods>`)
+}
+
+func TestParseXml_Invalid(t *testing.T) {
+	for _, invalid := range []string{"<a><b></a>", "<a>", "</a>"} {
+		_, err := ParseXml([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
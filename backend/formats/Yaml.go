@@ -0,0 +1,270 @@
+package formats
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DecodeYaml parses a YAML document into plain values: maps with string keys, []interface{}, strings, numbers,
+// booleans and nil. Timestamps become RFC 3339 strings.
+func DecodeYaml(data []byte) (interface{}, error) {
+	var result interface{}
+	if err := yaml.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return normalize(result), nil
+}
+
+// EncodeYaml writes a value with sorted keys and two-space indentation.
+func EncodeYaml(value interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(denormalize(value)); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// UpdateYaml encodes value like EncodeYaml, but on top of the original document: comments, key order and scalar
+// styles are kept for everything that did not change, and a float that is written back stays a float.
+func UpdateYaml(original []byte, value interface{}) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(original, &document); err != nil {
+		return nil, err
+	}
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 {
+		return EncodeYaml(value)
+	}
+	root, err := mergeYaml(document.Content[0], value)
+	if err != nil {
+		return nil, err
+	}
+	document.Content[0] = root
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// mergeYaml returns node updated to hold value. Unchanged nodes are returned as they are.
+func mergeYaml(node *yaml.Node, value interface{}) (*yaml.Node, error) {
+	var current interface{}
+	if err := node.Decode(&current); err == nil && sameValue(normalize(current), value) {
+		return node, nil
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if node.Kind == yaml.MappingNode {
+			return node, mergeYamlMapping(node, v, normalize(current))
+		}
+	case []interface{}:
+		if node.Kind == yaml.SequenceNode {
+			content := make([]*yaml.Node, 0, len(v))
+			for i, item := range v {
+				if i < len(node.Content) {
+					merged, err := mergeYaml(node.Content[i], item)
+					if err != nil {
+						return nil, err
+					}
+					content = append(content, merged)
+					continue
+				}
+				added, err := newYamlNode(item, nil)
+				if err != nil {
+					return nil, err
+				}
+				content = append(content, added)
+			}
+			node.Content = content
+			return node, nil
+		}
+	}
+	return newYamlNode(value, node)
+}
+
+func mergeYamlMapping(node *yaml.Node, value map[string]interface{}, current interface{}) error {
+	seen := make(map[string]bool, len(value))
+	content := make([]*yaml.Node, 0, len(node.Content))
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, item := node.Content[i], node.Content[i+1]
+		if key.Tag == "!!merge" {
+			content = append(content, key, item)
+			continue
+		}
+		newItem, ok := value[key.Value]
+		if !ok {
+			continue
+		}
+		seen[key.Value] = true
+		merged, err := mergeYaml(item, newItem)
+		if err != nil {
+			return err
+		}
+		content = append(content, key, merged)
+	}
+
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	inherited, _ := current.(map[string]interface{})
+	for _, key := range keys {
+		if item, ok := inherited[key]; ok && sameValue(item, value[key]) {
+			// Comes from a merge key ("<<: *defaults") and did not change
+			continue
+		}
+		item, err := newYamlNode(value[key], nil)
+		if err != nil {
+			return err
+		}
+		content = append(content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, item)
+	}
+	node.Content = content
+	return nil
+}
+
+// newYamlNode encodes value, taking over the comments, quoting style and float type of the node it replaces.
+func newYamlNode(value interface{}, replaces *yaml.Node) (*yaml.Node, error) {
+	node := &yaml.Node{}
+	if err := node.Encode(denormalize(value)); err != nil {
+		return nil, err
+	}
+	if replaces == nil {
+		return node, nil
+	}
+	if number, ok := value.(float64); ok && replaces.Tag == "!!float" && node.Tag == "!!int" {
+		node.Tag = "!!float"
+		node.Value = strconv.FormatFloat(number, 'f', 1, 64)
+	}
+	if replaces.Kind == yaml.ScalarNode && node.Kind == yaml.ScalarNode && replaces.Tag == node.Tag {
+		node.Style = replaces.Style &^ yaml.TaggedStyle
+	}
+	node.HeadComment = replaces.HeadComment
+	node.LineComment = replaces.LineComment
+	node.FootComment = replaces.FootComment
+	return node, nil
+}
+
+// sameValue compares plain values, treating numbers as equal regardless of their type.
+func sameValue(a interface{}, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, item := range x {
+			other, ok := y[key]
+			if !ok || !sameValue(item, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !sameValue(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case string, bool, nil:
+		return a == b
+	}
+	return false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// normalize converts decoder output into the plain values used by the Lua bindings.
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalize(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = normalize(item)
+		}
+		return result
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+		return v
+	case []map[string]interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = normalize(item)
+		}
+		return result
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return value
+}
+
+// denormalize turns whole floats into integers, since Lua numbers are always floats but "3.0" in a config file
+// usually means something different than "3".
+func denormalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = denormalize(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = denormalize(item)
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,70 @@
+package formats
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeYaml(t *testing.T) {
+	value, err := DecodeYaml([]byte("name: Example\nenabled: true\nload_order:\n  - a\n  - b\n1: one\nupdated: 2024-05-01T10:00:00Z\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":       "Example",
+		"enabled":    true,
+		"load_order": []interface{}{"a", "b"},
+		"1":          "one",
+		"updated":    "2024-05-01T10:00:00Z",
+	}, value)
+
+	_, err = DecodeYaml([]byte("a: [b"))
+	assert.Error(t, err)
+}
+
+func TestEncodeYaml(t *testing.T) {
+	data, err := EncodeYaml(map[string]interface{}{"count": float64(3), "ratio": 1.5, "mods": []interface{}{"a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "count: 3\nmods:\n  - a\nratio: 1.5\n", string(data))
+}
+
+func TestUpdateYaml_KeepsDocument(t *testing.T) {
+	input := `# Game settings
+name: Example
+scale: 1.0 # render scale
+title: "quoted"
+load_order:
+  - b
+  - a
+removed: true
+`
+	value, err := DecodeYaml([]byte(input))
+	assert.NoError(t, err)
+	settings := value.(map[string]interface{})
+	settings["scale"] = float64(2)
+	settings["title"] = "changed"
+	settings["load_order"] = append(settings["load_order"].([]interface{}), "c")
+	settings["added"] = float64(3)
+	delete(settings, "removed")
+
+	data, err := UpdateYaml([]byte(input), settings)
+	assert.NoError(t, err)
+	assert.Equal(t, `# Game settings
+name: Example
+scale: 2.0 # render scale
+title: "changed"
+load_order:
+  - b
+  - a
+  - c
+added: 3
+`, string(data))
+
+	unchanged, err := UpdateYaml([]byte(input), mustDecodeYaml(t, input))
+	assert.NoError(t, err)
+	assert.Equal(t, input, string(unchanged))
+}
+
+func mustDecodeYaml(t *testing.T, input string) interface{} {
+	value, err := DecodeYaml([]byte(input))
+	assert.NoError(t, err)
+	return value
+}
@@ -0,0 +1,349 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultCacheDir = "data/.cache/http"
+
+// CacheStatusHeader is added to responses served by the cache so callers and logs can tell them apart.
+const CacheStatusHeader = "X-TotalControl-Cache"
+
+const (
+	CacheHit         = "HIT"
+	CacheRevalidated = "REVALIDATED"
+	CacheStale       = "STALE"
+	CacheMiss        = "MISS"
+)
+
+// CacheOptions override the caching behaviour for a single request.
+type CacheOptions struct {
+	// MaxStale accepts a cached response up to this long after it became stale, without revalidating.
+	// Any non-zero value also allows falling back to the cached response when the server is unreachable.
+	MaxStale time.Duration
+	// NoCache always revalidates with the server, even if the cached response is fresh.
+	NoCache bool
+	// NoStore bypasses the cache completely, neither reading nor writing it.
+	NoStore bool
+}
+
+type cacheOptionsKey struct{}
+
+// WithCacheOptions attaches cache options to a request context.
+func WithCacheOptions(ctx context.Context, options CacheOptions) context.Context {
+	return context.WithValue(ctx, cacheOptionsKey{}, options)
+}
+
+func cacheOptionsFromContext(ctx context.Context) CacheOptions {
+	if options, ok := ctx.Value(cacheOptionsKey{}).(CacheOptions); ok {
+		return options
+	}
+	return CacheOptions{}
+}
+
+type cacheEntry struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	StoredAt   time.Time   `json:"stored_at"`
+	// Vary holds the request headers named by the response's Vary header, the entry only answers requests with
+	// the same values.
+	Vary map[string]string `json:"vary,omitempty"`
+}
+
+// varyHeaders returns the names listed in the Vary header of a response.
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// matches reports whether the entry was stored for a request with the same values of the Vary headers.
+func (e *cacheEntry) matches(req *http.Request) bool {
+	for _, name := range varyHeaders(e.Header) {
+		if req.Header.Get(name) != e.Vary[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// Cache stores GET responses on disk, one file per URL.
+type Cache struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+func (c *Cache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *Cache) load(key string) (*cacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (c *Cache) store(key string, entry *cacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.MkdirAll(c.dir, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash never leaves a truncated entry behind
+	tmp := c.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path(key))
+}
+
+func (c *Cache) Delete(rawURL string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := os.Remove(c.path(rawURL))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (c *Cache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := os.RemoveAll(c.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// CachingTransport is an http.RoundTripper that serves fresh responses from a Cache and
+// revalidates stale ones with If-None-Match / If-Modified-Since.
+type CachingTransport struct {
+	Transport http.RoundTripper
+	Cache     *Cache
+}
+
+func NewCachingTransport(transport http.RoundTripper, cache *Cache) *CachingTransport {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &CachingTransport{Transport: transport, Cache: cache}
+}
+
+func (t *CachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	options := cacheOptionsFromContext(req.Context())
+	// Authenticated responses are private to the caller and are never shared through the cache
+	if req.Method != http.MethodGet || options.NoStore || t.Cache == nil ||
+		req.Header.Get("Range") != "" || req.Header.Get("Authorization") != "" {
+		return t.Transport.RoundTrip(req)
+	}
+
+	key := req.URL.String()
+	entry, err := t.Cache.load(key)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warnf("Ignoring unreadable HTTP cache entry for %s: %v", key, err)
+		entry = nil
+	}
+	if entry != nil && !entry.matches(req) {
+		log.Debugf("HTTP cache entry for %s varies from this request", key)
+		entry = nil
+	}
+
+	if entry != nil && !options.NoCache {
+		age := time.Since(entry.StoredAt)
+		freshness := freshnessLifetime(entry.Header)
+		if age < freshness || age < freshness+options.MaxStale {
+			log.Debugf("HTTP cache hit for %s (age %s)", key, age.Round(time.Second))
+			return entry.response(req, CacheHit), nil
+		}
+	}
+
+	outgoing := req
+	if entry != nil {
+		outgoing = req.Clone(req.Context())
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			outgoing.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			outgoing.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := t.Transport.RoundTrip(outgoing)
+	if err != nil {
+		if entry != nil && options.MaxStale > 0 {
+			log.Warnf("Serving stale HTTP cache entry for %s after error: %v", key, err)
+			return entry.response(req, CacheStale), nil
+		}
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		_ = resp.Body.Close()
+		for _, name := range []string{"Cache-Control", "Expires", "ETag", "Last-Modified", "Date"} {
+			if value := resp.Header.Get(name); value != "" {
+				entry.Header.Set(name, value)
+			}
+		}
+		entry.StoredAt = time.Now()
+		if err := t.Cache.store(key, entry); err != nil {
+			log.Warnf("Failed to update HTTP cache entry for %s: %v", key, err)
+		}
+		log.Debugf("HTTP cache revalidated %s", key)
+		return entry.response(req, CacheRevalidated), nil
+	}
+
+	if resp.StatusCode != http.StatusOK || !isStorable(resp.Header, options) {
+		resp.Header.Set(CacheStatusHeader, CacheMiss)
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	entry = &cacheEntry{
+		URL:        key,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       body,
+		StoredAt:   time.Now(),
+	}
+	// Cookies belong to the session that received them, not to every later caller
+	entry.Header.Del("Set-Cookie")
+	for _, name := range varyHeaders(resp.Header) {
+		if entry.Vary == nil {
+			entry.Vary = make(map[string]string)
+		}
+		entry.Vary[name] = req.Header.Get(name)
+	}
+	if err := t.Cache.store(key, entry); err != nil {
+		log.Warnf("Failed to store HTTP cache entry for %s: %v", key, err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.Header.Set(CacheStatusHeader, CacheMiss)
+	return resp, nil
+}
+
+// response rebuilds an *http.Response from the cached entry.
+func (e *cacheEntry) response(req *http.Request, status string) *http.Response {
+	header := e.Header.Clone()
+	header.Del("Set-Cookie")
+	header.Set(CacheStatusHeader, status)
+	header.Set("Age", strconv.Itoa(int(time.Since(e.StoredAt).Seconds())))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// parseCacheControl splits a Cache-Control header into directives, lowercased.
+func parseCacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, argument, _ := strings.Cut(part, "=")
+			directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(argument), `"`)
+		}
+	}
+	return directives
+}
+
+// freshnessLifetime returns how long a response may be served without revalidation.
+func freshnessLifetime(header http.Header) time.Duration {
+	directives := parseCacheControl(header)
+	if _, ok := directives["no-cache"]; ok {
+		return 0
+	}
+	if maxAge, ok := directives["max-age"]; ok {
+		if seconds, err := strconv.Atoi(maxAge); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		return 0
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		if lifetime := expiresAt.Sub(date); lifetime > 0 {
+			return lifetime
+		}
+	}
+	return 0
+}
+
+func isStorable(header http.Header, options CacheOptions) bool {
+	directives := parseCacheControl(header)
+	if _, ok := directives["no-store"]; ok {
+		return false
+	}
+	// Vary: * means the response depends on more than the request headers
+	if slices.Contains(varyHeaders(header), "*") {
+		return false
+	}
+	if header.Get("ETag") != "" || header.Get("Last-Modified") != "" {
+		return true
+	}
+	return freshnessLifetime(header) > 0 || options.MaxStale > 0
+}
@@ -0,0 +1,156 @@
+package httpclient
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newCachingClient(t *testing.T) *Client {
+	client := NewClient("")
+	client.Transport = NewCachingTransport(http.DefaultTransport, NewCache(t.TempDir()))
+	return client
+}
+
+func TestCachingTransport_RevalidatesWithETag(t *testing.T) {
+	var calls, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"mods":1}`))
+	}))
+	defer server.Close()
+
+	client := newCachingClient(t)
+	first, err := client.Get(context.Background(), server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, CacheMiss, first.Headers.Get(CacheStatusHeader))
+
+	second, err := client.Get(context.Background(), server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, CacheRevalidated, second.Headers.Get(CacheStatusHeader))
+	assert.Equal(t, `{"mods":1}`, string(second.Body))
+	assert.Equal(t, map[string]interface{}{"mods": float64(1)}, second.JSON)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&notModified))
+}
+
+func TestCachingTransport_FreshnessAndOverrides(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/private" {
+			w.Header().Set("Cache-Control", "no-store")
+		} else if r.URL.Path == "/stale" {
+			w.Header().Set("Cache-Control", "max-age=0")
+		} else {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := newCachingClient(t)
+	get := func(path string, options *CacheOptions) *Response {
+		resp, err := client.Do(context.Background(), &Request{URL: server.URL + path, Cache: options})
+		assert.NoError(t, err)
+		return resp
+	}
+
+	get("/fresh", nil)
+	assert.Equal(t, CacheHit, get("/fresh", nil).Headers.Get(CacheStatusHeader))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// no_cache forces a round trip even though the entry is fresh
+	get("/fresh", &CacheOptions{NoCache: true})
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	get("/private", nil)
+	get("/private", nil)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	// Without validators, an already stale response is only kept when the caller accepts stale data
+	get("/stale", &CacheOptions{MaxStale: time.Hour})
+	assert.Equal(t, CacheHit, get("/stale", &CacheOptions{MaxStale: time.Hour}).Headers.Get(CacheStatusHeader))
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls))
+	get("/stale", nil)
+	assert.Equal(t, int32(6), atomic.LoadInt32(&calls))
+}
+
+func TestCachingTransport_ServesStaleOnNetworkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		_, _ = w.Write([]byte("cached"))
+	}))
+	client := newCachingClient(t)
+
+	_, err := client.Get(context.Background(), server.URL)
+	assert.NoError(t, err)
+	server.Close()
+
+	_, err = client.Get(context.Background(), server.URL)
+	assert.Error(t, err)
+
+	resp, err := client.Do(context.Background(), &Request{URL: server.URL, Cache: &CacheOptions{MaxStale: time.Nanosecond}})
+	assert.NoError(t, err)
+	assert.Equal(t, CacheStale, resp.Headers.Get(CacheStatusHeader))
+	assert.Equal(t, "cached", string(resp.Body))
+}
+
+func TestCachingTransport_VaryAndCookies(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Set-Cookie", "session=secret")
+		if r.URL.Path == "/any" {
+			w.Header().Set("Vary", "*")
+		} else {
+			w.Header().Set("Vary", "Accept-Language")
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := NewClient("")
+	client.Transport = NewCachingTransport(http.DefaultTransport, NewCache(dir))
+	get := func(path string, language string) *Response {
+		resp, err := client.Do(context.Background(), &Request{URL: server.URL + path, Headers: map[string]string{"Accept-Language": language}})
+		assert.NoError(t, err)
+		return resp
+	}
+
+	get("/mods", "en")
+	hit := get("/mods", "en")
+	assert.Equal(t, CacheHit, hit.Headers.Get(CacheStatusHeader))
+	assert.Empty(t, hit.Headers.Get("Set-Cookie"))
+	// Another language is not answered with the cached English response
+	assert.Equal(t, "de", string(get("/mods", "de").Body))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	get("/any", "en")
+	get("/any", "en")
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, entries)
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		assert.NoError(t, err)
+		assert.NotContains(t, string(data), "session=secret")
+	}
+}
@@ -0,0 +1,286 @@
+package httpclient
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// CassetteMode selects whether HTTP traffic goes to the network, is recorded, or is replayed from a cassette.
+type CassetteMode string
+
+const (
+	ModeLive   CassetteMode = ""
+	ModeRecord CassetteMode = "record"
+	ModeReplay CassetteMode = "replay"
+)
+
+const (
+	// EnvHttpMode selects the CassetteMode of the default client, e.g. TOTALCONTROL_HTTP_MODE=replay.
+	EnvHttpMode = "TOTALCONTROL_HTTP_MODE"
+	// EnvHttpCassette is the cassette file used by the default client when EnvHttpMode is set.
+	EnvHttpCassette = "TOTALCONTROL_HTTP_CASSETTE"
+)
+
+// ErrUnmatchedRequest is returned in replay mode for requests that are not on the cassette.
+var ErrUnmatchedRequest = errors.New("request not found on cassette")
+
+// recordedHeaders are the only response headers written to cassettes, everything else is noise or private.
+var recordedHeaders = []string{"Content-Type", "Cache-Control", "ETag", "Last-Modified", "Expires", "Location", "Retry-After"}
+
+// DefaultRedactedParams are the query parameters whose values are replaced by RedactedValue on cassettes.
+var DefaultRedactedParams = []string{"token", "key", "password", "username", "secret"}
+
+// RedactedValue stands in for the values of redacted query parameters.
+const RedactedValue = "REDACTED"
+
+type RecordedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int               `json:"status_code"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body"`
+	// BodyEncoding is "base64" for binary bodies and empty for text.
+	BodyEncoding string `json:"body_encoding,omitempty"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// Cassette is a file of recorded interactions.
+type Cassette struct {
+	Path         string        `json:"-"`
+	Interactions []Interaction `json:"interactions"`
+
+	mu     sync.Mutex
+	played []bool
+}
+
+// CassetteModeFromEnv returns the mode set in EnvHttpMode, or fallback if it is not set.
+func CassetteModeFromEnv(fallback CassetteMode) CassetteMode {
+	if mode := CassetteMode(strings.ToLower(os.Getenv(EnvHttpMode))); mode != ModeLive {
+		return mode
+	}
+	return fallback
+}
+
+// LoadCassette reads a cassette. In record mode a missing file starts an empty cassette.
+func LoadCassette(path string, mode CassetteMode) (*Cassette, error) {
+	cassette := &Cassette{Path: path}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && mode == ModeRecord:
+		return cassette, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read cassette %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, cassette); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %w", path, err)
+	}
+	cassette.played = make([]bool, len(cassette.Interactions))
+	if mode == ModeRecord {
+		// Re-recording replaces the old interactions
+		cassette.Interactions = nil
+		cassette.played = nil
+	}
+	return cassette, nil
+}
+
+// Save writes the cassette to its path.
+func (c *Cassette) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.save()
+}
+
+func (c *Cassette) save() error {
+	if err := os.MkdirAll(filepath.Dir(c.Path), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.Path, append(data, '\n'), 0644)
+}
+
+func (c *Cassette) record(interaction Interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, interaction)
+	c.played = append(c.played, true)
+	return c.save()
+}
+
+// find returns the first unplayed interaction matching the request. Once all matching interactions were
+// played the last one is repeated, so polling the same URL keeps working.
+func (c *Cassette) find(request RecordedRequest) (*Interaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last := -1
+	for i := range c.Interactions {
+		if c.Interactions[i].Request != request {
+			continue
+		}
+		if !c.played[i] {
+			c.played[i] = true
+			return &c.Interactions[i], true
+		}
+		last = i
+	}
+	if last == -1 {
+		return nil, false
+	}
+	return &c.Interactions[last], true
+}
+
+// CassetteTransport records the traffic of Transport to a cassette, or replays it without touching the network.
+type CassetteTransport struct {
+	Transport http.RoundTripper
+	Cassette  *Cassette
+	Mode      CassetteMode
+	// RedactedParams are matched case-insensitively, DefaultRedactedParams if nil. Requests are matched and
+	// recorded with the values redacted.
+	RedactedParams []string
+}
+
+func (t *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	params := t.RedactedParams
+	if params == nil {
+		params = DefaultRedactedParams
+	}
+	recorded := RecordedRequest{Method: req.Method, URL: redactQuery(req.URL, params)}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		recorded.Body = string(body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if t.Mode == ModeReplay {
+		interaction, ok := t.Cassette.find(recorded)
+		if !ok {
+			log.Errorf("Unmatched %s %s in replay mode (cassette %s)", recorded.Method, recorded.URL, t.Cassette.Path)
+			return nil, fmt.Errorf("%w: %s %s (cassette %s)", ErrUnmatchedRequest, recorded.Method, recorded.URL, t.Cassette.Path)
+		}
+		return interaction.Response.toResponse(req)
+	}
+
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil || t.Mode != ModeRecord {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	response := RecordedResponse{StatusCode: resp.StatusCode, Headers: map[string]string{}}
+	for _, name := range recordedHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			response.Headers[name] = value
+		}
+	}
+	if utf8.Valid(body) {
+		response.Body = string(body)
+	} else {
+		response.Body = base64.StdEncoding.EncodeToString(body)
+		response.BodyEncoding = "base64"
+	}
+	if err := t.Cassette.record(Interaction{Request: recorded, Response: response}); err != nil {
+		log.Errorf("Failed to write cassette %s: %v", t.Cassette.Path, err)
+	}
+	return resp, nil
+}
+
+// redactQuery returns u with the values of the given query parameters replaced. The order of the parameters is
+// kept, so the URL stays as close to the real request as possible.
+func redactQuery(u *url.URL, params []string) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	parts := strings.Split(u.RawQuery, "&")
+	redacted := false
+	for i, part := range parts {
+		name, _, _ := strings.Cut(part, "=")
+		key, err := url.QueryUnescape(name)
+		if err != nil {
+			key = name
+		}
+		if slices.ContainsFunc(params, func(param string) bool { return strings.EqualFold(param, key) }) {
+			parts[i] = name + "=" + RedactedValue
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.String()
+	}
+	clone := *u
+	clone.RawQuery = strings.Join(parts, "&")
+	return clone.String()
+}
+
+func (r *RecordedResponse) toResponse(req *http.Request) (*http.Response, error) {
+	body := []byte(r.Body)
+	if r.BodyEncoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body on cassette: %w", err)
+		}
+		body = decoded
+	}
+	header := http.Header{}
+	for name, value := range r.Headers {
+		header.Set(name, value)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// NewCassetteClient returns a client that records to or replays from the cassette at path.
+// Responses are neither cached nor rate limited in replay mode, so replays are deterministic.
+func NewCassetteClient(path string, mode CassetteMode) (*Client, error) {
+	cassette, err := LoadCassette(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(DefaultUserAgent)
+	client.Transport = &CassetteTransport{
+		Transport: NewRateLimitedTransport(http.DefaultTransport, DefaultLimiter()),
+		Cassette:  cassette,
+		Mode:      mode,
+	}
+	return client, nil
+}
@@ -0,0 +1,86 @@
+package httpclient
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCassette_RecordThenReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/binary" {
+			_, _ = w.Write([]byte{0xff, 0x00, 0xfe})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret")
+		_, _ = w.Write([]byte(`{"name":"mod-a"}`))
+	}))
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	recorder, err := NewCassetteClient(path, ModeRecord)
+	assert.NoError(t, err)
+	_, err = recorder.Get(context.Background(), server.URL+"/mods")
+	assert.NoError(t, err)
+	_, err = recorder.Get(context.Background(), server.URL+"/binary")
+	assert.NoError(t, err)
+	server.Close()
+
+	cassette, err := LoadCassette(path, ModeReplay)
+	assert.NoError(t, err)
+	assert.Len(t, cassette.Interactions, 2)
+	assert.NotContains(t, cassette.Interactions[0].Response.Headers, "Set-Cookie")
+	assert.Equal(t, "base64", cassette.Interactions[1].Response.BodyEncoding)
+
+	player, err := NewCassetteClient(path, ModeReplay)
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		resp, err := player.Get(context.Background(), server.URL+"/mods")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"name": "mod-a"}, resp.JSON)
+	}
+	resp, err := player.Get(context.Background(), server.URL+"/binary")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0x00, 0xfe}, resp.Body)
+
+	_, err = player.Do(context.Background(), &Request{URL: server.URL + "/unknown", Retries: 3})
+	assert.ErrorIs(t, err, ErrUnmatchedRequest)
+
+	_, err = NewCassetteClient(filepath.Join(t.TempDir(), "missing.json"), ModeReplay)
+	assert.Error(t, err)
+}
+
+func TestCassette_RedactsQueryParams(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.URL.Query().Get("Token")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	recorder, err := NewCassetteClient(path, ModeRecord)
+	assert.NoError(t, err)
+	_, err = recorder.Get(context.Background(), server.URL+"/download?page=2&Token=s3cret&api_key=k&username=me")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", received)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "s3cret")
+	cassette, err := LoadCassette(path, ModeReplay)
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/download?page=2&Token=REDACTED&api_key=k&username=REDACTED", cassette.Interactions[0].Request.URL)
+
+	// Replays match whatever credentials the request carries
+	player, err := NewCassetteClient(path, ModeReplay)
+	assert.NoError(t, err)
+	_, err = player.Get(context.Background(), server.URL+"/download?page=2&Token=other&api_key=k&username=you")
+	assert.NoError(t, err)
+	_, err = player.Do(context.Background(), &Request{URL: server.URL + "/download?page=3&Token=s3cret&api_key=k&username=me"})
+	assert.ErrorIs(t, err, ErrUnmatchedRequest)
+}
@@ -0,0 +1,373 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultUserAgent    = "TotalControl/1.0.0 (+https://github.com/Subtixx/total-control)"
+	DefaultTimeout      = 30 * time.Second
+	DefaultRetryBackoff = 500 * time.Millisecond
+	MaxRetryBackoff     = 30 * time.Second
+)
+
+type Request struct {
+	Method  string
+	URL     string
+	Query   url.Values
+	Headers map[string]string
+	Body    []byte
+	// Timeout applies to every attempt. Zero uses the client's timeout.
+	Timeout time.Duration
+	// Retries is the number of additional attempts on network errors, 5xx and 429 responses.
+	Retries          int
+	DisableRedirects bool
+	// Cache overrides how the response cache treats this request, if the client has one.
+	Cache *CacheOptions
+}
+
+type Response struct {
+	StatusCode  int
+	Status      string
+	Headers     http.Header
+	Body        []byte
+	ContentType string
+	// JSON holds the decoded body (map[string]interface{} or []interface{}) if the response is JSON. It is nil if
+	// the body does not parse, e.g. an HTML error page sent as JSON, Body still has it.
+	JSON interface{}
+	// URL is the final URL after redirects.
+	URL string
+}
+
+// Client is the HTTP client used by the host and by every plugin. It identifies
+// itself with UserAgent and sends all traffic through Transport.
+type Client struct {
+	UserAgent    string
+	Timeout      time.Duration
+	RetryBackoff time.Duration
+	Transport    http.RoundTripper
+}
+
+func NewClient(userAgent string) *Client {
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	return &Client{
+		UserAgent:    userAgent,
+		Timeout:      DefaultTimeout,
+		RetryBackoff: DefaultRetryBackoff,
+		Transport:    http.DefaultTransport,
+	}
+}
+
+var (
+	defaultClient     *Client
+	defaultClientOnce sync.Once
+)
+
+func newDefaultClient() *Client {
+	if mode := CassetteModeFromEnv(ModeLive); mode != ModeLive {
+		path := os.Getenv(EnvHttpCassette)
+		client, err := NewCassetteClient(path, mode)
+		if err != nil {
+			// Silently going to the network would defeat the point of replay mode
+			log.Fatalf("Failed to use cassette %q in %s mode: %v", path, mode, err)
+		}
+		log.Infof("HTTP traffic is in %s mode using cassette %s", mode, path)
+		return client
+	}
+
+	client := NewClient(DefaultUserAgent)
+	// Cache hits never reach the network, so they are not rate limited
+	client.Transport = NewCachingTransport(
+		NewRateLimitedTransport(http.DefaultTransport, DefaultLimiter()),
+		NewCache(DefaultCacheDir),
+	)
+	return client
+}
+
+// Default returns the shared host client. Its responses are cached on disk in DefaultCacheDir and
+// requests are rate limited per host by DefaultLimiter. Setting EnvHttpMode and EnvHttpCassette
+// records or replays its traffic instead.
+func Default() *Client {
+	defaultClientOnce.Do(func() {
+		defaultClient = newDefaultClient()
+	})
+	return defaultClient
+}
+
+// WithUserAgent returns a copy of the client that shares its transport but identifies differently.
+func (c *Client) WithUserAgent(userAgent string) *Client {
+	clone := *c
+	clone.UserAgent = userAgent
+	return &clone
+}
+
+// WithRateLimits returns a copy of the client that applies limits to the given hosts. The limits are merged into
+// the rate limiter of the client's transport, keeping the stricter value, so they can only make the client more
+// polite. A transport without a rate limiter gets one below its cache, so cache hits stay free.
+func (c *Client) WithRateLimits(limits map[string]HostLimit) *Client {
+	if len(limits) == 0 {
+		return c
+	}
+	clone := *c
+	clone.Transport = withRateLimits(c.Transport, limits)
+	return &clone
+}
+
+func withRateLimits(transport http.RoundTripper, limits map[string]HostLimit) http.RoundTripper {
+	switch t := transport.(type) {
+	case *RateLimitedTransport:
+		t.Limiter.Restrict(limits)
+		return t
+	case *CachingTransport:
+		clone := *t
+		clone.Transport = withRateLimits(t.Transport, limits)
+		return &clone
+	case *CassetteTransport:
+		clone := *t
+		clone.Transport = withRateLimits(t.Transport, limits)
+		return &clone
+	default:
+		limiter := NewRateLimiter(RateLimitConfig{})
+		limiter.Restrict(limits)
+		return NewRateLimitedTransport(transport, limiter)
+	}
+}
+
+// IsSuccess reports whether the status code is 2xx.
+func (r *Response) IsSuccess() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// IsJSON reports whether a media type is application/json or a +json suffix type.
+func IsJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func (c *Client) Get(ctx context.Context, rawURL string) (*Response, error) {
+	return c.Do(ctx, &Request{Method: http.MethodGet, URL: rawURL})
+}
+
+// NewHTTPRequest builds the net/http request for req, including query parameters and headers.
+func (c *Client) NewHTTPRequest(ctx context.Context, req *Request) (*http.Request, error) {
+	method := strings.ToUpper(req.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete,
+		http.MethodPatch, http.MethodHead, http.MethodOptions:
+	default:
+		return nil, fmt.Errorf("unsupported HTTP method: %s", req.Method)
+	}
+
+	target, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %s: %w", req.URL, err)
+	}
+	if len(req.Query) > 0 {
+		query := target.Query()
+		for key, values := range req.Query {
+			for _, value := range values {
+				query.Add(key, value)
+			}
+		}
+		target.RawQuery = query.Encode()
+	}
+
+	if req.Cache != nil {
+		ctx = WithCacheOptions(ctx, *req.Cache)
+	}
+
+	var body io.Reader = http.NoBody
+	if len(req.Body) > 0 {
+		body = bytes.NewReader(req.Body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	httpReq.Header.Set("User-Agent", c.UserAgent)
+	for key, value := range req.Headers {
+		httpReq.Header.Set(key, value)
+	}
+	return httpReq, nil
+}
+
+func (c *Client) httpClient(req *Request) *http.Client {
+	client := &http.Client{Transport: c.Transport}
+	if req.DisableRedirects {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	return client
+}
+
+// Do performs the request, retrying with exponential backoff on network errors, 5xx and 429.
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = c.Timeout
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= req.Retries; attempt++ {
+		response, retryAfter, err := c.attempt(ctx, req, timeout)
+		if err == nil && !shouldRetry(response.StatusCode) {
+			return response, nil
+		}
+
+		if errors.Is(err, ErrUnmatchedRequest) {
+			// Retrying cannot help, the cassette will not change
+			return nil, err
+		}
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("HTTP request to %s failed with status %s", req.URL, response.Status)
+		}
+		if attempt == req.Retries {
+			if err == nil {
+				// Out of retries, hand the last response to the caller instead of an error
+				return response, nil
+			}
+			break
+		}
+
+		wait := c.backoff(attempt, retryAfter)
+		log.Warnf("HTTP %s %s attempt %d/%d failed (%v), retrying in %s",
+			req.Method, req.URL, attempt+1, req.Retries+1, lastErr, wait)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	return nil, lastErr
+}
+
+func (c *Client) attempt(ctx context.Context, req *Request, timeout time.Duration) (*Response, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	httpReq, err := c.NewHTTPRequest(ctx, req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := c.httpClient(req).Do(httpReq)
+	if err != nil {
+		return nil, 0, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Errorf("Failed to close response body: %v", err)
+		}
+	}(resp.Body)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	response := &Response{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Headers:    resp.Header,
+		Body:       body,
+		URL:        resp.Request.URL.String(),
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		response.ContentType = mediaType
+	}
+	if IsJSON(response.ContentType) && len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &response.JSON); err != nil {
+			// The status and body still tell the caller what went wrong, retrying would not change the body
+			log.Warnf("HTTP %s %s returned invalid JSON: %v", req.Method, req.URL, err)
+			response.JSON = nil
+		}
+	}
+
+	return response, ParseRetryAfter(resp.Header.Get("Retry-After")), nil
+}
+
+func shouldRetry(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+func (c *Client) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, MaxRetryBackoff)
+	}
+	base := c.RetryBackoff
+	if base <= 0 {
+		base = DefaultRetryBackoff
+	}
+	return min(base<<attempt, MaxRetryBackoff)
+}
+
+// ParseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date.
+func ParseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	var seconds int
+	if _, err := fmt.Sscanf(value, "%d", &seconds); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// ErrStatus can be used by callers that treat non-2xx responses as errors.
+var ErrStatus = errors.New("unexpected HTTP status")
+
+// CheckStatus returns an error wrapping ErrStatus if the response is not 2xx.
+func CheckStatus(response *Response) error {
+	if response.IsSuccess() {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrStatus, response.Status)
+}
+
+// Stream sends a single request and returns the raw response with its body still open, for callers
+// that need to stream large bodies. No retries are performed and the caller must close the body.
+func (c *Client) Stream(ctx context.Context, req *Request) (*http.Response, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	httpReq, err := c.NewHTTPRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient(req).Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,122 @@
+package httpclient
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_Do_HeadersQueryAndJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "token", r.Header.Get("Authorization"))
+		assert.Equal(t, "test-agent", r.Header.Get("User-Agent"))
+		assert.Equal(t, "2", r.URL.Query().Get("page"))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write([]byte(`[{"name":"a"},{"name":"b"}]`))
+	}))
+	defer server.Close()
+
+	client := NewClient("test-agent")
+	resp, err := client.Do(context.Background(), &Request{
+		Method:  http.MethodGet,
+		URL:     server.URL,
+		Query:   url.Values{"page": {"2"}},
+		Headers: map[string]string{"Authorization": "token"},
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.IsSuccess())
+	assert.Equal(t, "application/json", resp.ContentType)
+	list, ok := resp.JSON.([]interface{})
+	assert.True(t, ok)
+	assert.Len(t, list, 2)
+}
+
+func TestClient_Do_InvalidJSON(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("<html>Bad gateway</html>"))
+	}))
+	defer server.Close()
+
+	client := NewClient("")
+	client.RetryBackoff = time.Millisecond
+	resp, err := client.Do(context.Background(), &Request{URL: server.URL, Retries: 1})
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		assert.Equal(t, "<html>Bad gateway</html>", string(resp.Body))
+		assert.Nil(t, resp.JSON)
+	}
+	// Retried for the status, not for the body
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestClient_Do_RetriesOnServerErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			_, _ = w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient("")
+	client.RetryBackoff = time.Millisecond
+	resp, err := client.Do(context.Background(), &Request{URL: server.URL, Retries: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(resp.Body))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, 0)
+	resp, err = client.Do(context.Background(), &Request{URL: server.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Error(t, CheckStatus(resp))
+}
+
+func TestClient_Do_RedirectsAndTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			_, _ = w.Write([]byte("new"))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient("")
+	resp, err := client.Do(context.Background(), &Request{URL: server.URL + "/old"})
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(resp.Body))
+	assert.Equal(t, server.URL+"/new", resp.URL)
+
+	resp, err = client.Do(context.Background(), &Request{URL: server.URL + "/old", DisableRedirects: true})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+	_, err = client.Do(context.Background(), &Request{URL: server.URL + "/slow", Timeout: 20 * time.Millisecond})
+	assert.Error(t, err)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 120*time.Second, ParseRetryAfter("120"))
+	assert.Equal(t, time.Duration(0), ParseRetryAfter(""))
+	future := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	assert.InDelta(t, float64(10*time.Second), float64(ParseRetryAfter(future)), float64(2*time.Second))
+}
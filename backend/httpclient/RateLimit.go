@@ -0,0 +1,361 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultRateLimitsFile = "data/network.json"
+
+// HostLimit configures the politeness towards a single host. Zero values mean unlimited.
+type HostLimit struct {
+	// RequestsPerSecond is the rate at which the token bucket refills.
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	// Burst is the size of the token bucket, at least 1 if RequestsPerSecond is set.
+	Burst int `json:"burst"`
+	// MaxConcurrent caps the number of requests in flight, including streaming downloads.
+	MaxConcurrent int `json:"max_concurrent"`
+}
+
+// RateLimitConfig is the format of DefaultRateLimitsFile.
+type RateLimitConfig struct {
+	Default HostLimit            `json:"default"`
+	Hosts   map[string]HostLimit `json:"hosts"`
+}
+
+// DefaultRateLimits are used unless DefaultRateLimitsFile says otherwise.
+var DefaultRateLimits = RateLimitConfig{
+	Default: HostLimit{RequestsPerSecond: 10, Burst: 20, MaxConcurrent: 6},
+	Hosts: map[string]HostLimit{
+		// The appdetails endpoint allows roughly 200 requests per 5 minutes
+		"store.steampowered.com": {RequestsPerSecond: 0.5, Burst: 10, MaxConcurrent: 2},
+		"mods.factorio.com":      {RequestsPerSecond: 5, Burst: 10, MaxConcurrent: 4},
+	},
+}
+
+// HostStats are the counters of a host, exposed as metrics.
+type HostStats struct {
+	Requests int64 `json:"requests"`
+	// Throttled counts requests that had to wait for a token, a free slot or a Retry-After.
+	Throttled int64 `json:"throttled"`
+	WaitedMs  int64 `json:"waited_ms"`
+	// RetryAfter counts responses that asked us to back off.
+	RetryAfter int64 `json:"retry_after"`
+	InFlight   int   `json:"in_flight"`
+}
+
+type hostState struct {
+	limit        HostLimit
+	tokens       float64
+	refilledAt   time.Time
+	blockedUntil time.Time
+	slots        chan struct{}
+	stats        HostStats
+}
+
+// RateLimiter limits requests per hostname with a token bucket, a concurrency cap and
+// the Retry-After periods servers ask for.
+type RateLimiter struct {
+	mu     sync.Mutex
+	config RateLimitConfig
+	hosts  map[string]*hostState
+}
+
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	return &RateLimiter{config: config, hosts: make(map[string]*hostState)}
+}
+
+var defaultLimiter = NewRateLimiter(DefaultRateLimits)
+
+// DefaultLimiter returns the limiter shared by all host and plugin traffic.
+func DefaultLimiter() *RateLimiter {
+	return defaultLimiter
+}
+
+// Configure replaces the limits and resets the counters.
+func (l *RateLimiter) Configure(config RateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = config
+	l.hosts = make(map[string]*hostState)
+}
+
+// LoadFile merges the limits of a RateLimitConfig file over the current configuration.
+// A missing file is not an error.
+func (l *RateLimiter) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var file RateLimitConfig
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid rate limit file %s: %w", path, err)
+	}
+
+	l.mu.Lock()
+	config := RateLimitConfig{Default: l.config.Default, Hosts: make(map[string]HostLimit)}
+	for host, limit := range l.config.Hosts {
+		config.Hosts[host] = limit
+	}
+	l.mu.Unlock()
+
+	if file.Default != (HostLimit{}) {
+		config.Default = file.Default
+	}
+	for host, limit := range file.Hosts {
+		config.Hosts[strings.ToLower(host)] = limit
+	}
+	l.Configure(config)
+	log.Infof("Loaded rate limits for %d hosts from %s", len(file.Hosts), path)
+	return nil
+}
+
+// Restrict merges limits into the configuration, keeping the stricter value of every field, so it can only make
+// the limiter more polite. Hosts that were contacted before keep their counters.
+func (l *RateLimiter) Restrict(limits map[string]HostLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hosts := make(map[string]HostLimit, len(l.config.Hosts)+len(limits))
+	for host, limit := range l.config.Hosts {
+		hosts[host] = limit
+	}
+	for host, limit := range limits {
+		host = strings.ToLower(host)
+		current, ok := hosts[host]
+		if !ok {
+			current = l.config.Default
+		}
+		hosts[host] = stricter(current, limit)
+	}
+	l.config.Hosts = hosts
+
+	for host := range limits {
+		state, ok := l.hosts[strings.ToLower(host)]
+		if !ok {
+			continue
+		}
+		limit := l.limit(host)
+		if limit.MaxConcurrent != state.limit.MaxConcurrent {
+			// Requests in flight release the slot of the channel they acquired it from
+			state.slots = nil
+			if limit.MaxConcurrent > 0 {
+				state.slots = make(chan struct{}, limit.MaxConcurrent)
+			}
+		}
+		state.limit = limit
+		state.tokens = min(state.tokens, float64(limit.Burst))
+	}
+}
+
+// stricter returns the lower value of every field, zero meaning unlimited.
+func stricter(a HostLimit, b HostLimit) HostLimit {
+	lower := func(x float64, y float64) float64 {
+		if x <= 0 {
+			return y
+		}
+		if y <= 0 {
+			return x
+		}
+		return min(x, y)
+	}
+	return HostLimit{
+		RequestsPerSecond: lower(a.RequestsPerSecond, b.RequestsPerSecond),
+		Burst:             int(lower(float64(a.Burst), float64(b.Burst))),
+		MaxConcurrent:     int(lower(float64(a.MaxConcurrent), float64(b.MaxConcurrent))),
+	}
+}
+
+// limit returns the configured limit of a host. Must be called with l.mu held.
+func (l *RateLimiter) limit(host string) HostLimit {
+	limit, ok := l.config.Hosts[strings.ToLower(host)]
+	if !ok {
+		limit = l.config.Default
+	}
+	if limit.RequestsPerSecond > 0 && limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return limit
+}
+
+func (l *RateLimiter) state(host string) *hostState {
+	host = strings.ToLower(host)
+	state, ok := l.hosts[host]
+	if ok {
+		return state
+	}
+	limit := l.limit(host)
+	state = &hostState{limit: limit, tokens: float64(limit.Burst), refilledAt: time.Now()}
+	if limit.MaxConcurrent > 0 {
+		state.slots = make(chan struct{}, limit.MaxConcurrent)
+	}
+	l.hosts[host] = state
+	return state
+}
+
+// Acquire waits until a request to host may be sent. The returned function must be called
+// once the request (including its body) is finished.
+func (l *RateLimiter) Acquire(ctx context.Context, host string) (func(), error) {
+	l.mu.Lock()
+	state := l.state(host)
+	slots := state.slots
+	state.stats.Requests++
+	l.mu.Unlock()
+
+	started := time.Now()
+	throttled := false
+	if slots != nil {
+		select {
+		case slots <- struct{}{}:
+		default:
+			throttled = true
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	release := func() {
+		l.mu.Lock()
+		state.stats.InFlight--
+		l.mu.Unlock()
+		if slots != nil {
+			<-slots
+		}
+	}
+
+	l.mu.Lock()
+	state.stats.InFlight++
+	wait := l.reserve(state)
+	l.mu.Unlock()
+
+	if wait > 0 {
+		throttled = true
+		log.Infof("Throttling request to %s for %s", host, wait.Round(time.Millisecond))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	if throttled {
+		l.mu.Lock()
+		state.stats.Throttled++
+		state.stats.WaitedMs += time.Since(started).Milliseconds()
+		l.mu.Unlock()
+	}
+
+	var once sync.Once
+	return func() { once.Do(release) }, nil
+}
+
+// reserve takes a token and returns how long the caller has to wait for it. Must be called with l.mu held.
+func (l *RateLimiter) reserve(state *hostState) time.Duration {
+	now := time.Now()
+	var wait time.Duration
+	if state.blockedUntil.After(now) {
+		wait = state.blockedUntil.Sub(now)
+	}
+	if state.limit.RequestsPerSecond <= 0 {
+		return wait
+	}
+
+	elapsed := now.Sub(state.refilledAt).Seconds()
+	state.tokens = min(state.tokens+elapsed*state.limit.RequestsPerSecond, float64(state.limit.Burst))
+	state.refilledAt = now
+	// Tokens may go negative, which queues callers behind each other
+	state.tokens--
+	if state.tokens < 0 {
+		wait = max(wait, time.Duration(-state.tokens/state.limit.RequestsPerSecond*float64(time.Second)))
+	}
+	return wait
+}
+
+// Backoff blocks all requests to host for the given duration, e.g. after a Retry-After header.
+func (l *RateLimiter) Backoff(host string, duration time.Duration) {
+	if duration <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state := l.state(host)
+	state.stats.RetryAfter++
+	if until := time.Now().Add(min(duration, MaxRetryBackoff)); until.After(state.blockedUntil) {
+		state.blockedUntil = until
+	}
+	log.Warnf("%s asked us to back off, pausing requests for %s", host, duration)
+}
+
+// Stats returns the counters of every host that was contacted.
+func (l *RateLimiter) Stats() map[string]HostStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := make(map[string]HostStats, len(l.hosts))
+	for host, state := range l.hosts {
+		stats[host] = state.stats
+	}
+	return stats
+}
+
+// RateLimitedTransport sends requests through a RateLimiter.
+type RateLimitedTransport struct {
+	Transport http.RoundTripper
+	Limiter   *RateLimiter
+}
+
+func NewRateLimitedTransport(transport http.RoundTripper, limiter *RateLimiter) *RateLimitedTransport {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &RateLimitedTransport{Transport: transport, Limiter: limiter}
+}
+
+func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	release, err := t.Limiter.Acquire(req.Context(), host)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		retryAfter := ParseRetryAfter(resp.Header.Get("Retry-After"))
+		if resp.Header.Get("Retry-After") == "" && resp.StatusCode == http.StatusTooManyRequests {
+			retryAfter = time.Second
+		}
+		t.Limiter.Backoff(host, retryAfter)
+	}
+	// Keep the slot until the body is consumed, so streaming downloads count towards MaxConcurrent
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,125 @@
+package httpclient
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_TokenBucket(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{
+		Hosts: map[string]HostLimit{"example.com": {RequestsPerSecond: 20, Burst: 2}},
+	})
+
+	started := time.Now()
+	for i := 0; i < 4; i++ {
+		release, err := limiter.Acquire(context.Background(), "EXAMPLE.com")
+		assert.NoError(t, err)
+		release()
+	}
+	// Two requests fit in the burst, the other two wait 50ms each
+	assert.GreaterOrEqual(t, time.Since(started), 90*time.Millisecond)
+
+	stats := limiter.Stats()["example.com"]
+	assert.Equal(t, int64(4), stats.Requests)
+	assert.Equal(t, int64(2), stats.Throttled)
+	assert.Equal(t, 0, stats.InFlight)
+
+	// Hosts without an entry use the (unlimited) default
+	release, err := limiter.Acquire(context.Background(), "other.com")
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, int64(0), limiter.Stats()["other.com"].Throttled)
+}
+
+func TestRateLimiter_MaxConcurrentAndCancel(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{Default: HostLimit{MaxConcurrent: 1}})
+
+	release, err := limiter.Acquire(context.Background(), "example.com")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = limiter.Acquire(ctx, "example.com")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+	release() // Releasing twice must not free a second slot
+	release2, err := limiter.Acquire(context.Background(), "example.com")
+	assert.NoError(t, err)
+	release2()
+}
+
+func TestRateLimitedTransport_HonoursRetryAfter(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	limiter := NewRateLimiter(RateLimitConfig{Default: HostLimit{MaxConcurrent: 4}})
+	client := NewClient("")
+	client.Transport = NewRateLimitedTransport(http.DefaultTransport, limiter)
+
+	resp, err := client.Get(context.Background(), server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// Every request to the host now waits for the Retry-After period, not only the one that was rejected
+	started := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(context.Background(), server.URL)
+			assert.NoError(t, err)
+			assert.Equal(t, "ok", string(resp.Body))
+		}()
+	}
+	wg.Wait()
+	assert.GreaterOrEqual(t, time.Since(started), 900*time.Millisecond)
+
+	stats := limiter.Stats()["127.0.0.1"]
+	assert.Equal(t, int64(1), stats.RetryAfter)
+	assert.Equal(t, int64(2), stats.Throttled)
+	assert.Equal(t, 0, stats.InFlight)
+}
+
+func TestClient_WithRateLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	limiter := NewRateLimiter(RateLimitConfig{Default: HostLimit{RequestsPerSecond: 100, Burst: 10, MaxConcurrent: 4}})
+	client := NewClient("")
+	client.Transport = NewCachingTransport(NewRateLimitedTransport(http.DefaultTransport, limiter), NewCache(t.TempDir()))
+
+	limited := client.WithRateLimits(map[string]HostLimit{"127.0.0.1": {RequestsPerSecond: 0.1, Burst: 1}})
+	// Limits can only be made stricter
+	client.WithRateLimits(map[string]HostLimit{"127.0.0.1": {RequestsPerSecond: 50, Burst: 50, MaxConcurrent: 2}})
+	limiter.mu.Lock()
+	assert.Equal(t, HostLimit{RequestsPerSecond: 0.1, Burst: 1, MaxConcurrent: 2}, limiter.limit("127.0.0.1"))
+	limiter.mu.Unlock()
+
+	// Cache hits neither wait for nor use up tokens
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := limited.Get(context.Background(), server.URL+"/mods")
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", string(resp.Body))
+	}
+	assert.Less(t, time.Since(started), time.Second)
+	assert.Equal(t, int64(1), limiter.Stats()["127.0.0.1"].Requests)
+}
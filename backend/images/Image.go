@@ -0,0 +1,237 @@
+package images
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatGIF  = "gif"
+
+	jpegQuality = 85
+
+	// MaxPixels limits the size of decoded images. A few bytes of PNG can claim a huge canvas, and decoding
+	// allocates all of it up front.
+	MaxPixels = 8192 * 8192
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooLarge          = errors.New("image too large")
+)
+
+// Decode reads a PNG, JPEG or GIF image. For animated GIFs only the first frame is used. Images with more than
+// MaxPixels pixels are refused before they are decoded.
+func Decode(data []byte) (image.Image, string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, "", ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, "", fmt.Errorf("%w: %dx%d", ErrTooLarge, config.Width, config.Height)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, "", ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return img, format, nil
+}
+
+// Encode writes an image in the given format. JPEG has no transparency, transparent pixels become black.
+func Encode(img image.Image, format string) ([]byte, error) {
+	var buffer bytes.Buffer
+	var err error
+	switch format {
+	case FormatPNG:
+		err = png.Encode(&buffer, img)
+	case FormatJPEG, "jpg":
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: jpegQuality})
+	case FormatGIF:
+		err = gif.Encode(&buffer, img, nil)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// toRGBA returns the pixels of an image as RGBA starting at 0,0. draw.Draw has fast paths for the common types.
+func toRGBA(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok && bounds.Min == (image.Point{}) {
+		return rgba
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// CheckSize rejects an output size that is empty or has more than MaxPixels pixels, as the result is allocated
+// up front.
+func CheckSize(width int, height int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid size %dx%d", width, height)
+	}
+	if int64(width)*int64(height) > MaxPixels {
+		return fmt.Errorf("%w: %dx%d", ErrTooLarge, width, height)
+	}
+	return nil
+}
+
+// Resize scales an image to exactly width x height. Every target pixel is the average of the source pixels it
+// covers, which gives clean results when shrinking large artwork.
+func Resize(img image.Image, width int, height int) (*image.RGBA, error) {
+	if err := CheckSize(width, height); err != nil {
+		return nil, err
+	}
+	return resize(img, width, height), nil
+}
+
+func resize(img image.Image, width int, height int) *image.RGBA {
+	source := toRGBA(img)
+	sourceWidth, sourceHeight := source.Bounds().Dx(), source.Bounds().Dy()
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	if sourceWidth == 0 || sourceHeight == 0 {
+		return result
+	}
+
+	for y := 0; y < height; y++ {
+		y0 := y * sourceHeight / height
+		y1 := max((y+1)*sourceHeight/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := x * sourceWidth / width
+			x1 := max((x+1)*sourceWidth/width, x0+1)
+
+			var r, g, b, a, count int
+			for sy := y0; sy < y1; sy++ {
+				offset := source.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(source.Pix[offset])
+					g += int(source.Pix[offset+1])
+					b += int(source.Pix[offset+2])
+					a += int(source.Pix[offset+3])
+					offset += 4
+					count++
+				}
+			}
+			offset := result.PixOffset(x, y)
+			result.Pix[offset] = uint8(r / count)
+			result.Pix[offset+1] = uint8(g / count)
+			result.Pix[offset+2] = uint8(b / count)
+			result.Pix[offset+3] = uint8(a / count)
+		}
+	}
+	return result
+}
+
+// Crop returns the part of the image inside rect, relative to the image's top left corner.
+func Crop(img image.Image, rect image.Rectangle) (*image.RGBA, error) {
+	bounds := img.Bounds()
+	rect = rect.Add(bounds.Min)
+	if rect.Empty() || !rect.In(bounds) {
+		return nil, fmt.Errorf("crop %v is outside of the image %v", rect.Sub(bounds.Min), bounds.Sub(bounds.Min))
+	}
+	result := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(result, result.Bounds(), img, rect.Min, draw.Src)
+	return result, nil
+}
+
+// Fill scales and crops the image to cover exactly width x height, keeping the centre, like CSS object-fit: cover.
+func Fill(img image.Image, width int, height int) (*image.RGBA, error) {
+	if err := CheckSize(width, height); err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	cropWidth, cropHeight := bounds.Dx(), bounds.Dy()
+	if cropWidth*height > cropHeight*width {
+		cropWidth = max(cropHeight*width/height, 1)
+	} else {
+		cropHeight = max(cropWidth*height/width, 1)
+	}
+	left := (bounds.Dx() - cropWidth) / 2
+	top := (bounds.Dy() - cropHeight) / 2
+	cropped, err := Crop(img, image.Rect(left, top, left+cropWidth, top+cropHeight))
+	if err != nil {
+		return nil, err
+	}
+	return resize(cropped, width, height), nil
+}
+
+// DominantColor returns the most common colour of the opaque pixels, ignoring small differences in shade.
+func DominantColor(img image.Image) color.NRGBA {
+	// A small copy is enough and keeps this fast for large artwork
+	bounds := img.Bounds()
+	sample := toRGBA(img)
+	if bounds.Dx() > 64 || bounds.Dy() > 64 {
+		sample = resize(img, min(bounds.Dx(), 64), min(bounds.Dy(), 64))
+	}
+
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := make(map[int]*bucket)
+	var best *bucket
+	for offset := 0; offset+3 < len(sample.Pix); offset += 4 {
+		a := int(sample.Pix[offset+3])
+		if a < 128 {
+			continue
+		}
+		// Undo the alpha premultiplication
+		r := int(sample.Pix[offset]) * 255 / a
+		g := int(sample.Pix[offset+1]) * 255 / a
+		b := int(sample.Pix[offset+2]) * 255 / a
+		key := r>>4<<8 | g>>4<<4 | b>>4
+		entry, ok := buckets[key]
+		if !ok {
+			entry = &bucket{}
+			buckets[key] = entry
+		}
+		entry.count++
+		entry.r += r
+		entry.g += g
+		entry.b += b
+		if best == nil || entry.count > best.count {
+			best = entry
+		}
+	}
+	if best == nil {
+		return color.NRGBA{}
+	}
+	return color.NRGBA{
+		R: uint8(best.r / best.count),
+		G: uint8(best.g / best.count),
+		B: uint8(best.b / best.count),
+		A: 255,
+	}
+}
+
+// HexColor formats a colour as #rrggbb.
+func HexColor(c color.Color) string {
+	nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x", nrgba.R, nrgba.G, nrgba.B)
+}
+
+// DataURL embeds image data in a data: URL, which the frontend can use as an img src.
+func DataURL(data []byte) string {
+	return "data:" + http.DetectContentType(data) + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
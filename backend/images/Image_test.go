@@ -0,0 +1,134 @@
+package images
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+)
+
+// testImage is red on the left half and blue on the right, with a small green square in the red part.
+func testImage(width int, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			switch {
+			case x < 4 && y < 4:
+				img.Set(x, y, color.NRGBA{G: 255, A: 255})
+			case x < width/2:
+				img.Set(x, y, color.NRGBA{R: 255, A: 255})
+			default:
+				img.Set(x, y, color.NRGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, format := range []string{FormatPNG, FormatJPEG, FormatGIF} {
+		data, err := Encode(testImage(20, 10), format)
+		assert.NoError(t, err)
+		img, decodedFormat, err := Decode(data)
+		assert.NoError(t, err)
+		assert.Equal(t, format, decodedFormat)
+		assert.Equal(t, image.Rect(0, 0, 20, 10), img.Bounds())
+	}
+
+	_, _, err := Decode([]byte("not an image"))
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+	_, err = Encode(testImage(1, 1), "bmp")
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+func TestResizeAndCrop(t *testing.T) {
+	resized, err := Resize(testImage(200, 100), 20, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 20, 10), resized.Bounds())
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, resized.At(5, 5))
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, resized.At(15, 5))
+
+	// The result is allocated up front, so its size is limited like decoded images
+	_, err = Resize(testImage(10, 10), 100000, 100000)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = Resize(testImage(10, 10), -1, 10)
+	assert.Error(t, err)
+
+	cropped, err := Crop(testImage(200, 100), image.Rect(150, 0, 200, 50))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 50, 50), cropped.Bounds())
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, cropped.At(0, 0))
+
+	_, err = Crop(testImage(10, 10), image.Rect(5, 5, 20, 20))
+	assert.Error(t, err)
+}
+
+func TestFill(t *testing.T) {
+	// Covering a square crops the sides of the wide image and keeps the centre
+	filled, err := Fill(testImage(300, 100), 10, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 10, 10), filled.Bounds())
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, filled.At(0, 5))
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, filled.At(9, 5))
+
+	_, err = Fill(testImage(10, 10), 0, 10)
+	assert.Error(t, err)
+	_, err = Fill(testImage(10, 10), MaxPixels, 2)
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestDominantColor(t *testing.T) {
+	img := testImage(100, 100)
+	for x := 50; x < 60; x++ {
+		for y := 0; y < 100; y++ {
+			img.Set(x, y, color.NRGBA{})
+		}
+	}
+	// Red covers more pixels than the blue that is left
+	assert.Equal(t, "#ff0000", HexColor(DominantColor(img)))
+	assert.Equal(t, color.NRGBA{}, DominantColor(image.NewNRGBA(image.Rect(0, 0, 5, 5))))
+}
+
+func TestThumbnailCache(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewThumbnailCache(dir)
+	source, err := Encode(testImage(288, 144), FormatPNG)
+	assert.NoError(t, err)
+
+	thumbnail, err := cache.Get(source, ModThumbnailSize, ModThumbnailSize)
+	assert.NoError(t, err)
+	img, format, err := Decode(thumbnail.Data)
+	assert.NoError(t, err)
+	assert.Equal(t, FormatPNG, format)
+	assert.Equal(t, image.Rect(0, 0, ModThumbnailSize, ModThumbnailSize), img.Bounds())
+	assert.Contains(t, thumbnail.DataURL, "data:image/png;base64,")
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	cached, err := cache.Get(source, ModThumbnailSize, ModThumbnailSize)
+	assert.NoError(t, err)
+	assert.Equal(t, thumbnail.Data, cached.Data)
+
+	_, err = cache.Get([]byte("broken"), 10, 10)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+	assert.NoError(t, cache.Clear())
+}
+
+func TestDecode_RefusesHugeImages(t *testing.T) {
+	data, err := Encode(testImage(8, 8), FormatPNG)
+	assert.NoError(t, err)
+	// Claim a 100000 x 100000 canvas in the IHDR chunk, which follows the 8 byte signature and chunk header
+	binary.BigEndian.PutUint32(data[16:], 100000)
+	binary.BigEndian.PutUint32(data[20:], 100000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	_, _, err = Decode(data)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = NewThumbnailCache(t.TempDir()).Get(data, ModThumbnailSize, ModThumbnailSize)
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
@@ -0,0 +1,131 @@
+package images
+
+import (
+	"TotalControl/backend/utils"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	DefaultThumbnailDir = "data/.cache/thumbnails"
+
+	// ModThumbnailSize is the size of Factorio's thumbnail.png, which other games' mod images are scaled to as well.
+	ModThumbnailSize = 144
+	// MaxThumbnailSize limits the width and height plugins can ask for, thumbnails are cached on disk.
+	MaxThumbnailSize = 1024
+	// GameThumbnailWidth and GameThumbnailHeight match Steam's header images.
+	GameThumbnailWidth  = 460
+	GameThumbnailHeight = 215
+)
+
+// Thumbnail is a scaled PNG together with its dominant colour, which the UI uses as a placeholder background.
+type Thumbnail struct {
+	Data          []byte `json:"-"`
+	DataURL       string `json:"data_url"`
+	DominantColor string `json:"dominant_color"`
+}
+
+// ThumbnailCache stores generated thumbnails on disk. Files are named after the hash of the source image and the
+// size, so a changed image never returns a stale thumbnail and nothing ever needs to be invalidated.
+type ThumbnailCache struct {
+	dir string
+	mu  sync.Mutex
+}
+
+var (
+	defaultThumbnails     *ThumbnailCache
+	defaultThumbnailsOnce sync.Once
+)
+
+func NewThumbnailCache(dir string) *ThumbnailCache {
+	return &ThumbnailCache{dir: dir}
+}
+
+// DefaultThumbnails returns the cache shared by the app and all plugins.
+func DefaultThumbnails() *ThumbnailCache {
+	defaultThumbnailsOnce.Do(func() {
+		defaultThumbnails = NewThumbnailCache(DefaultThumbnailDir)
+	})
+	return defaultThumbnails
+}
+
+func (c *ThumbnailCache) path(data []byte, width int, height int) (string, error) {
+	hash, err := utils.HashString(string(data), utils.HashSHA256)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(c.dir, fmt.Sprintf("%s_%dx%d.png", hash, width, height)), nil
+}
+
+// Get returns a width x height PNG of the image, generating and storing it on the first request.
+func (c *ThumbnailCache) Get(data []byte, width int, height int) (*Thumbnail, error) {
+	path, err := c.path(data, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	thumbnail, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warnf("Failed to read cached thumbnail %s: %v", path, err)
+		}
+		if thumbnail, err = c.generate(path, data, width, height); err != nil {
+			return nil, err
+		}
+	}
+
+	img, _, err := Decode(thumbnail)
+	if err != nil {
+		return nil, err
+	}
+	return &Thumbnail{
+		Data:          thumbnail,
+		DataURL:       DataURL(thumbnail),
+		DominantColor: HexColor(DominantColor(img)),
+	}, nil
+}
+
+func (c *ThumbnailCache) generate(path string, data []byte, width int, height int) ([]byte, error) {
+	img, _, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	filled, err := Fill(img, width, height)
+	if err != nil {
+		return nil, err
+	}
+	thumbnail, err := Encode(filled, FormatPNG)
+	if err != nil {
+		return nil, err
+	}
+
+	// The thumbnail is still usable if it cannot be cached
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		log.Warnf("Failed to create thumbnail cache %s: %v", c.dir, err)
+		return thumbnail, nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, thumbnail, 0644); err != nil {
+		log.Warnf("Failed to cache thumbnail %s: %v", path, err)
+		return thumbnail, nil
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Warnf("Failed to cache thumbnail %s: %v", path, err)
+		_ = os.Remove(tmp)
+	}
+	return thumbnail, nil
+}
+
+// Clear removes all cached thumbnails.
+func (c *ThumbnailCache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return os.RemoveAll(c.dir)
+}
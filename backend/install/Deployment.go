@@ -0,0 +1,465 @@
+package install
+
+import (
+	"TotalControl/backend/archive"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDeploymentDir holds the staged mods and the deployment record of each game.
+const DefaultDeploymentDir = "data/deployments"
+
+const deploymentFile = "deployment.json"
+
+// probeFile is created in the game directory for a moment to find out which links its file system supports.
+const probeFile = ".totalcontrol-probe"
+
+// Method is how staged files are put into the game directory.
+type Method string
+
+const (
+	MethodHardlink Method = "hardlink"
+	MethodSymlink  Method = "symlink"
+	MethodCopy     Method = "copy"
+)
+
+// DeployedFile is a file a deployment put into the game directory.
+type DeployedFile struct {
+	// Path is relative to the game directory.
+	Path  string `json:"path"`
+	ModID string `json:"mod_id"`
+	// Replaced is set if a file of the game was moved aside for it, purging puts the file back.
+	Replaced bool `json:"replaced,omitempty"`
+}
+
+// Conflict is a file that several mods contain. The mod deployed last wins.
+type Conflict struct {
+	Path   string   `json:"path"`
+	ModIDs []string `json:"mod_ids"`
+}
+
+// DeploymentRecord is everything a deployment changed in the game directory, which is all a purge needs.
+type DeploymentRecord struct {
+	Method Method `json:"method"`
+	// Mods are the deployed mods, in the order they were deployed.
+	Mods  []string       `json:"mods"`
+	Files []DeployedFile `json:"files"`
+	// Dirs are the directories the deployment created, parents first.
+	Dirs       []string   `json:"dirs,omitempty"`
+	Conflicts  []Conflict `json:"conflicts,omitempty"`
+	DeployedAt time.Time  `json:"deployed_at"`
+}
+
+// Deployment keeps every mod of a game extracted in a staging directory and links the enabled ones into the game
+// directory. Because it records each file it deploys and moves aside every game file it replaces, a purge leaves
+// the game directory exactly as it was before, which copying mods into loose-file games cannot.
+type Deployment struct {
+	gameDir string
+	dir     string
+	// Method forces how files are deployed. Empty picks the best one the file systems support.
+	Method Method
+
+	mu sync.Mutex
+}
+
+var (
+	deploymentsMu sync.Mutex
+	deployments   = make(map[string]*Deployment)
+)
+
+func NewDeployment(dir string, gameDir string) *Deployment {
+	return &Deployment{dir: dir, gameDir: gameDir}
+}
+
+// ForGame returns the deployment of a game in DefaultDeploymentDir. There is only one per game, so two callers
+// cannot deploy over each other.
+func ForGame(gameID string, gameDir string) (*Deployment, error) {
+	if gameID == "" || !filepath.IsLocal(gameID) || filepath.Base(gameID) != gameID {
+		return nil, fmt.Errorf("invalid game ID %q", gameID)
+	}
+	deploymentsMu.Lock()
+	defer deploymentsMu.Unlock()
+	if deployment, ok := deployments[gameID]; ok {
+		if filepath.Clean(deployment.gameDir) != filepath.Clean(gameDir) {
+			return nil, fmt.Errorf("%s is deployed to %s, not %s", gameID, deployment.gameDir, gameDir)
+		}
+		return deployment, nil
+	}
+	deployment := NewDeployment(filepath.Join(DefaultDeploymentDir, gameID), gameDir)
+	deployments[gameID] = deployment
+	return deployment, nil
+}
+
+func (d *Deployment) stagingDir(modID string) (string, error) {
+	if modID == "" || !filepath.IsLocal(modID) || filepath.Base(modID) != modID || strings.HasPrefix(modID, ".") {
+		return "", fmt.Errorf("invalid mod ID %q", modID)
+	}
+	return filepath.Join(d.dir, "staging", modID), nil
+}
+
+// Stage extracts a mod into the staging directory, replacing what was staged for it before. source is an archive
+// or a directory, which is copied. Like all changes to the staging directory, it reaches the game directory with
+// the next Deploy.
+func (d *Deployment) Stage(modID string, source string, options archive.ExtractOptions) error {
+	staging, err := d.stagingDir(modID)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Extract next to the final directory, so a failed extraction leaves the staged mod as it was
+	tmp := filepath.Join(filepath.Dir(staging), "."+modID+".tmp")
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(tmp), 0755); err != nil {
+		return err
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		err = copyTree(source, tmp)
+	} else {
+		_, err = archive.Extract(source, tmp, options)
+	}
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+		return fmt.Errorf("failed to stage %s: %w", modID, err)
+	}
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	return os.Rename(tmp, staging)
+}
+
+// Unstage deletes a staged mod. It stays in the game directory until the next Deploy or Purge.
+func (d *Deployment) Unstage(modID string) error {
+	staging, err := d.stagingDir(modID)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return os.RemoveAll(staging)
+}
+
+// Staged lists the staged mods, sorted by ID.
+func (d *Deployment) Staged() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(d.dir, "staging"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var staged []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			staged = append(staged, entry.Name())
+		}
+	}
+	return staged, nil
+}
+
+// Record returns the current deployment, nil if nothing is deployed.
+func (d *Deployment) Record() (*DeploymentRecord, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.loadRecord()
+}
+
+func (d *Deployment) loadRecord() (*DeploymentRecord, error) {
+	data, err := os.ReadFile(filepath.Join(d.dir, deploymentFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record DeploymentRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("corrupt deployment record in %s: %w", d.dir, err)
+	}
+	return &record, nil
+}
+
+// saveRecord writes the record atomically, without it the deployed files could not be purged anymore.
+func (d *Deployment) saveRecord(record *DeploymentRecord) error {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(d.dir, deploymentFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(d.dir, deploymentFile))
+}
+
+// Deploy purges the current deployment and deploys the staged mods in modIDs, in order, so a file in several mods
+// comes from the last one. If it fails, the game directory is purged.
+func (d *Deployment) Deploy(modIDs []string) (*DeploymentRecord, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if info, err := os.Stat(d.gameDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("the game directory %s does not exist", d.gameDir)
+	}
+	files, conflicts, err := d.collect(modIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.purge(); err != nil {
+		return nil, fmt.Errorf("failed to purge the previous deployment: %w", err)
+	}
+	record, err := d.plan(modIDs, files)
+	if err != nil {
+		return nil, err
+	}
+	record.Conflicts = conflicts
+	if err := d.saveRecord(record); err != nil {
+		return nil, err
+	}
+	if err := d.deploy(record); err != nil {
+		if purgeErr := d.purge(); purgeErr != nil {
+			log.Errorf("Failed to purge the incomplete deployment in %s: %v", d.gameDir, purgeErr)
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+// collect maps the relative path of every file the mods deploy to the mod it comes from.
+func (d *Deployment) collect(modIDs []string) (map[string]string, []Conflict, error) {
+	files := make(map[string]string)
+	sources := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, modID := range modIDs {
+		if seen[modID] {
+			return nil, nil, fmt.Errorf("%s is deployed twice", modID)
+		}
+		seen[modID] = true
+		staging, err := d.stagingDir(modID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := os.Stat(staging); err != nil {
+			return nil, nil, fmt.Errorf("%s is not staged: %w", modID, err)
+		}
+		err = filepath.WalkDir(staging, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			relative, err := filepath.Rel(staging, path)
+			if err != nil {
+				return err
+			}
+			files[relative] = modID
+			sources[relative] = append(sources[relative], modID)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var conflicts []Conflict
+	for _, path := range slices.Sorted(maps.Keys(files)) {
+		// One mod's file would have to be another mod's directory
+		for parent := filepath.Dir(path); parent != "."; parent = filepath.Dir(parent) {
+			if modID, ok := files[parent]; ok {
+				return nil, nil, fmt.Errorf("%s has a file %s where %s has a directory", modID, parent, files[path])
+			}
+		}
+		if len(sources[path]) > 1 {
+			conflicts = append(conflicts, Conflict{Path: path, ModIDs: sources[path]})
+		}
+	}
+	return files, conflicts, nil
+}
+
+// plan decides which directories to create and which game files to move aside, before anything is changed.
+func (d *Deployment) plan(modIDs []string, files map[string]string) (*DeploymentRecord, error) {
+	method, err := d.method()
+	if err != nil {
+		return nil, err
+	}
+	record := &DeploymentRecord{Method: method, Mods: slices.Clone(modIDs), DeployedAt: time.Now()}
+	created := make(map[string]bool)
+	var createDir func(dir string) error
+	createDir = func(dir string) error {
+		if dir == "." || created[dir] {
+			return nil
+		}
+		info, err := os.Stat(filepath.Join(d.gameDir, dir))
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is a file in the game directory, but a mod has a directory there", dir)
+			}
+			return nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := createDir(filepath.Dir(dir)); err != nil {
+			return err
+		}
+		created[dir] = true
+		record.Dirs = append(record.Dirs, dir)
+		return nil
+	}
+
+	for _, path := range slices.Sorted(maps.Keys(files)) {
+		if err := createDir(filepath.Dir(path)); err != nil {
+			return nil, err
+		}
+		file := DeployedFile{Path: path, ModID: files[path]}
+		info, err := os.Lstat(filepath.Join(d.gameDir, path))
+		if err == nil {
+			if info.IsDir() {
+				return nil, fmt.Errorf("%s is a directory in the game directory, but %s has a file there", path, file.ModID)
+			}
+			file.Replaced = true
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		record.Files = append(record.Files, file)
+	}
+	return record, nil
+}
+
+// method returns the forced method or detects the best one: hardlinks look like ordinary files to the game and
+// need no privileges, symlinks also work across drives, and copies work everywhere but take space.
+func (d *Deployment) method() (Method, error) {
+	if d.Method != "" {
+		return d.Method, nil
+	}
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return "", err
+	}
+	source, err := filepath.Abs(filepath.Join(d.dir, probeFile))
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(source, nil, 0644); err != nil {
+		return "", err
+	}
+	defer os.Remove(source)
+	target := filepath.Join(d.gameDir, probeFile)
+	// A probe left behind by a crash would make both links fail and detection fall back to copies
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if err := os.Link(source, target); err == nil {
+		return MethodHardlink, os.Remove(target)
+	}
+	if err := os.Symlink(source, target); err == nil {
+		return MethodSymlink, os.Remove(target)
+	}
+	return MethodCopy, nil
+}
+
+func (d *Deployment) deploy(record *DeploymentRecord) error {
+	for _, dir := range record.Dirs {
+		if err := os.Mkdir(filepath.Join(d.gameDir, dir), 0755); err != nil {
+			return err
+		}
+	}
+	for _, file := range record.Files {
+		target := filepath.Join(d.gameDir, file.Path)
+		if file.Replaced {
+			backup := filepath.Join(d.dir, "backup", file.Path)
+			if err := os.MkdirAll(filepath.Dir(backup), 0755); err != nil {
+				return err
+			}
+			if err := moveFile(target, backup); err != nil {
+				return err
+			}
+		}
+		staging, _ := d.stagingDir(file.ModID)
+		source := filepath.Join(staging, file.Path)
+		var err error
+		switch record.Method {
+		case MethodHardlink:
+			err = os.Link(source, target)
+		case MethodSymlink:
+			if source, err = filepath.Abs(source); err == nil {
+				err = os.Symlink(source, target)
+			}
+		case MethodCopy:
+			err = copyFile(source, target)
+		default:
+			err = fmt.Errorf("unknown deployment method %q", record.Method)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.ModID, err)
+		}
+	}
+	return nil
+}
+
+// Purge removes every deployed file and restores the game files they replaced.
+func (d *Deployment) Purge() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.purge()
+}
+
+// purge only relies on the record, so it also cleans up a deployment interrupted by a crash. A replaced file is
+// only removed once its original is in the backup, otherwise the original was never moved.
+func (d *Deployment) purge() error {
+	record, err := d.loadRecord()
+	if record == nil || err != nil {
+		return err
+	}
+	var errs []error
+	for i := len(record.Files) - 1; i >= 0; i-- {
+		file := record.Files[i]
+		target := filepath.Join(d.gameDir, file.Path)
+		if !file.Replaced {
+			if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		backup := filepath.Join(d.dir, "backup", file.Path)
+		if _, err := os.Lstat(backup); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, moveFile(backup, target))
+	}
+	for i := len(record.Dirs) - 1; i >= 0; i-- {
+		dir := filepath.Join(d.gameDir, record.Dirs[i])
+		if err := os.Remove(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			// The game or the user put files there, they are not ours to delete
+			log.Warnf("Keeping %s after purging the deployment: %v", dir, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(d.dir, deploymentFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(d.dir, "backup"))
+}
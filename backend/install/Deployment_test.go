@@ -0,0 +1,145 @@
+package install
+
+import (
+	"TotalControl/backend/archive"
+	"archive/zip"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func newTestDeployment(t *testing.T) (*Deployment, string) {
+	gameDir := t.TempDir()
+	writeFiles(t, gameDir, map[string]string{
+		"bin/game.exe":            "vanilla executable",
+		"Content/Maps/farm.xnb":   "vanilla farm",
+		"Content/Data/Crops.xnb":  "vanilla crops",
+		"archive/pc/content/a.xl": "vanilla archive",
+	})
+	deployment := NewDeployment(t.TempDir(), gameDir)
+
+	source := t.TempDir()
+	writeFiles(t, filepath.Join(source, "better-farm"), map[string]string{
+		"Content/Maps/farm.xnb":  "better farm",
+		"Mods/BetterFarm/a.json": "{}",
+	})
+	assert.NoError(t, deployment.Stage("better-farm", filepath.Join(source, "better-farm"), archive.ExtractOptions{}))
+
+	zipPath := filepath.Join(source, "crops.zip")
+	file, err := os.Create(zipPath)
+	if assert.NoError(t, err) {
+		writer := zip.NewWriter(file)
+		for name, content := range map[string]string{
+			"crops-1.0/Content/Maps/farm.xnb":  "crops farm",
+			"crops-1.0/Content/Data/Crops.xnb": "more crops",
+			"crops-1.0/Mods/Crops/crops.json":  "[]",
+		} {
+			entry, _ := writer.Create(name)
+			_, _ = entry.Write([]byte(content))
+		}
+		assert.NoError(t, writer.Close())
+		assert.NoError(t, file.Close())
+	}
+	assert.NoError(t, deployment.Stage("crops", zipPath, archive.ExtractOptions{StripComponents: 1}))
+	return deployment, gameDir
+}
+
+func TestDeployment_DeployAndPurge(t *testing.T) {
+	for _, method := range []Method{MethodHardlink, MethodSymlink, MethodCopy} {
+		t.Run(string(method), func(t *testing.T) {
+			deployment, gameDir := newTestDeployment(t)
+			deployment.Method = method
+			vanilla := snapshot(t, gameDir)
+
+			record, err := deployment.Deploy([]string{"better-farm", "crops"})
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, method, record.Method)
+			assert.Equal(t, []Conflict{
+				{Path: filepath.FromSlash("Content/Maps/farm.xnb"), ModIDs: []string{"better-farm", "crops"}},
+			}, record.Conflicts)
+			assert.Equal(t, []string{"Mods", filepath.FromSlash("Mods/BetterFarm"), filepath.FromSlash("Mods/Crops")}, record.Dirs)
+			deployed := snapshot(t, gameDir)
+			assert.Equal(t, "crops farm", deployed[filepath.FromSlash("Content/Maps/farm.xnb")])
+			assert.Equal(t, "more crops", deployed[filepath.FromSlash("Content/Data/Crops.xnb")])
+			assert.Equal(t, "{}", deployed[filepath.FromSlash("Mods/BetterFarm/a.json")])
+
+			// Redeploying in another order lets the other mod win
+			record, err = deployment.Deploy([]string{"crops", "better-farm"})
+			if assert.NoError(t, err) {
+				assert.Equal(t, "better farm", snapshot(t, gameDir)[filepath.FromSlash("Content/Maps/farm.xnb")])
+			}
+
+			assert.NoError(t, deployment.Purge())
+			assert.Equal(t, vanilla, snapshot(t, gameDir))
+			record, err = deployment.Record()
+			assert.NoError(t, err)
+			assert.Nil(t, record)
+		})
+	}
+}
+
+func TestDeployment_Detect(t *testing.T) {
+	deployment, gameDir := newTestDeployment(t)
+	record, err := deployment.Deploy([]string{"crops"})
+	if assert.NoError(t, err) {
+		// Both temporary directories are on the same file system
+		assert.Equal(t, MethodHardlink, record.Method)
+	}
+	_, err = os.Lstat(filepath.Join(gameDir, probeFile))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	staged, err := deployment.Staged()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"better-farm", "crops"}, staged)
+	assert.NoError(t, deployment.Unstage("crops"))
+	_, err = deployment.Deploy([]string{"crops"})
+	assert.Error(t, err)
+	// The failed deployment did not touch the previous one
+	assert.Equal(t, "crops farm", snapshot(t, gameDir)[filepath.FromSlash("Content/Maps/farm.xnb")])
+}
+
+func TestDeployment_DetectStaleProbe(t *testing.T) {
+	deployment, gameDir := newTestDeployment(t)
+	// Left behind by a crash during an earlier detection
+	writeFiles(t, gameDir, map[string]string{probeFile: ""})
+	record, err := deployment.Deploy([]string{"crops"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, MethodHardlink, record.Method)
+	}
+	_, err = os.Lstat(filepath.Join(gameDir, probeFile))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestDeployment_PurgeInterrupted(t *testing.T) {
+	deployment, gameDir := newTestDeployment(t)
+	vanilla := snapshot(t, gameDir)
+	_, err := deployment.Deploy([]string{"better-farm"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// A crash after the game's file was moved aside, but before the mod's file replaced it
+	assert.NoError(t, os.Remove(filepath.Join(gameDir, "Content", "Maps", "farm.xnb")))
+	// The game wrote a log into a directory the deployment created
+	writeFiles(t, gameDir, map[string]string{"Mods/BetterFarm/log.txt": "played"})
+
+	assert.NoError(t, deployment.Purge())
+	expected := maps.Clone(vanilla)
+	expected[filepath.FromSlash("Mods")+"/"] = ""
+	expected[filepath.FromSlash("Mods/BetterFarm")+"/"] = ""
+	expected[filepath.FromSlash("Mods/BetterFarm/log.txt")] = "played"
+	assert.Equal(t, expected, snapshot(t, gameDir))
+}
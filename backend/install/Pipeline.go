@@ -0,0 +1,294 @@
+package install
+
+import (
+	"TotalControl/backend/archive"
+	"TotalControl/backend/downloads"
+	"TotalControl/backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultWorkDir holds downloads, staged files and backups while an install runs.
+const DefaultWorkDir = "data/.install"
+
+// Pipeline installs, updates and removes mods transactionally: resolve, download, verify, stage, back up, deploy,
+// update the enabled list and commit. If any step fails, the mod directory and the enabled list are restored
+// exactly as they were.
+type Pipeline struct {
+	downloads *downloads.Manager
+	workDir   string
+
+	// mu runs one transaction at a time, two installs touching the same files would corrupt each other's backups.
+	mu        sync.Mutex
+	listeners []func(plan *Plan, step Step)
+}
+
+var (
+	defaultPipeline     *Pipeline
+	defaultPipelineOnce sync.Once
+)
+
+func NewPipeline(manager *downloads.Manager, workDir string) *Pipeline {
+	if manager == nil {
+		manager = downloads.Default()
+	}
+	return &Pipeline{downloads: manager, workDir: workDir}
+}
+
+// Default returns the pipeline shared by all providers, using the default download manager.
+func Default() *Pipeline {
+	defaultPipelineOnce.Do(func() {
+		defaultPipeline = NewPipeline(downloads.Default(), DefaultWorkDir)
+	})
+	return defaultPipeline
+}
+
+// OnStep registers a listener that is called when a plan reaches the next step.
+func (p *Pipeline) OnStep(listener func(plan *Plan, step Step)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listeners = append(p.listeners, listener)
+}
+
+func (p *Pipeline) emit(plan *Plan, step Step) {
+	for _, listener := range p.listeners {
+		listener(plan, step)
+	}
+}
+
+// resolvedArtifact is an artifact with absolute paths for each step.
+type resolvedArtifact struct {
+	Artifact
+	file   string
+	staged string
+	target string
+}
+
+// Run carries out a plan. Cancelling ctx stops the download and aborts the install, but not while files are
+// being deployed, which only takes a moment.
+func (p *Pipeline) Run(ctx context.Context, plan *Plan) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.emit(plan, StepResolve)
+	artifacts, removals, err := resolvePlan(plan)
+	if err != nil {
+		return &StepError{Step: StepResolve, Err: err}
+	}
+
+	tx, err := newTransaction(p.workDir, uuid.New().String())
+	if err != nil {
+		return &StepError{Step: StepResolve, Err: err}
+	}
+	if step, err := p.run(ctx, tx, plan, artifacts, removals); err != nil {
+		p.emit(plan, StepRollback)
+		stepErr := &StepError{Step: step, Err: err}
+		if rollbackErr := tx.rollback(); rollbackErr != nil {
+			log.Errorf("Failed to roll back install of %s, it is retried on the next start: %v", plan.GameID, rollbackErr)
+			stepErr.Rollback = rollbackErr
+		}
+		return stepErr
+	}
+
+	p.emit(plan, StepCommit)
+	if err := tx.discard(); err != nil {
+		return &StepError{Step: StepCommit, Err: err}
+	}
+	return nil
+}
+
+func (p *Pipeline) run(ctx context.Context, tx *transaction, plan *Plan, artifacts []*resolvedArtifact, removals []string) (Step, error) {
+	p.emit(plan, StepDownload)
+	for i, artifact := range artifacts {
+		if artifact.Source != "" {
+			artifact.file = artifact.Source
+			continue
+		}
+		artifact.file = tx.path("downloads", fmt.Sprintf("%d-%s", i, downloadName(artifact.URL)))
+		err := p.downloads.Download(ctx, downloads.Request{
+			URL:         artifact.URL,
+			Destination: artifact.file,
+			Headers:     artifact.Headers,
+			Retries:     2,
+			Client:      plan.Client,
+		})
+		if err != nil {
+			return StepDownload, fmt.Errorf("%s: %w", artifact.ModID, err)
+		}
+	}
+
+	p.emit(plan, StepVerify)
+	for _, artifact := range artifacts {
+		if artifact.Checksum == nil || artifact.Checksum.Algorithm == "" {
+			continue
+		}
+		if err := utils.VerifyFile(artifact.file, artifact.Checksum.Algorithm, artifact.Checksum.Value); err != nil {
+			return StepVerify, fmt.Errorf("%s: %w", artifact.ModID, err)
+		}
+	}
+
+	p.emit(plan, StepStage)
+	for i, artifact := range artifacts {
+		if err := ctx.Err(); err != nil {
+			return StepStage, err
+		}
+		artifact.staged = tx.path("staged", fmt.Sprint(i))
+		if err := stage(artifact); err != nil {
+			return StepStage, fmt.Errorf("%s: %w", artifact.ModID, err)
+		}
+	}
+
+	// Nothing outside the work directory has been touched yet, this is the last chance to give up cheaply
+	if err := ctx.Err(); err != nil {
+		return StepBackup, err
+	}
+	p.emit(plan, StepBackup)
+	for _, artifact := range artifacts {
+		if err := tx.backup(artifact.target); err != nil {
+			return StepBackup, err
+		}
+	}
+	for _, removal := range removals {
+		if err := tx.backup(removal); err != nil {
+			return StepBackup, err
+		}
+	}
+	if plan.EnabledList != nil {
+		if err := tx.backupList(plan.EnabledList.Path()); err != nil {
+			return StepBackup, err
+		}
+	}
+
+	p.emit(plan, StepDeploy)
+	for _, artifact := range artifacts {
+		if err := tx.deploy(artifact.staged, artifact.target); err != nil {
+			return StepDeploy, fmt.Errorf("%s: %w", artifact.ModID, err)
+		}
+	}
+
+	if plan.EnabledList != nil && (len(plan.Enable) > 0 || len(plan.Forget) > 0) {
+		p.emit(plan, StepEnable)
+		// The files are in place, a cancelled context must not leave the list behind
+		if err := plan.EnabledList.Update(context.WithoutCancel(ctx), plan.Enable, plan.Forget); err != nil {
+			return StepEnable, err
+		}
+	}
+	return "", nil
+}
+
+// resolvePlan checks the plan and turns its relative paths into absolute ones inside the root.
+func resolvePlan(plan *Plan) ([]*resolvedArtifact, []string, error) {
+	if plan.Root == "" {
+		return nil, nil, errors.New("the plan has no mod directory")
+	}
+	root, err := filepath.Abs(plan.Root)
+	if err != nil {
+		return nil, nil, err
+	}
+	resolve := func(relative string) (string, error) {
+		if relative == "" || !filepath.IsLocal(relative) {
+			return "", fmt.Errorf("%q is not a path inside the mod directory", relative)
+		}
+		return filepath.Join(root, relative), nil
+	}
+
+	targets := make(map[string]bool)
+	var artifacts []*resolvedArtifact
+	for _, artifact := range plan.Artifacts {
+		if (artifact.URL == "") == (artifact.Source == "") {
+			return nil, nil, fmt.Errorf("%s: an artifact needs either a URL or a source file", artifact.ModID)
+		}
+		target, err := resolve(artifact.Target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", artifact.ModID, err)
+		}
+		if targets[target] {
+			return nil, nil, fmt.Errorf("%s: %s is deployed twice", artifact.ModID, artifact.Target)
+		}
+		targets[target] = true
+		artifacts = append(artifacts, &resolvedArtifact{Artifact: artifact, target: target})
+	}
+
+	var removals []string
+	for _, relative := range plan.Remove {
+		removal, err := resolve(relative)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Replacing a file already backs it up
+		if !targets[removal] {
+			removals = append(removals, removal)
+		}
+	}
+	return artifacts, removals, nil
+}
+
+// stage puts the artifact into its final form inside the work directory.
+func stage(artifact *resolvedArtifact) error {
+	if err := os.MkdirAll(filepath.Dir(artifact.staged), 0755); err != nil {
+		return err
+	}
+	if artifact.Extract {
+		_, err := archive.Extract(artifact.file, artifact.staged, archive.ExtractOptions{StripComponents: artifact.StripComponents})
+		return err
+	}
+	if artifact.Source != "" {
+		return copyFile(artifact.file, artifact.staged)
+	}
+	return os.Rename(artifact.file, artifact.staged)
+}
+
+// downloadName keeps the file name of the URL, archive.Extract detects the format by extension.
+func downloadName(rawURL string) string {
+	if parsed, err := url.Parse(rawURL); err == nil {
+		if name := path.Base(parsed.Path); name != "." && name != "/" {
+			return name
+		}
+	}
+	return "download"
+}
+
+// Recover rolls back installs that were interrupted, e.g. by a crash or power loss. Call it once at startup
+// before anything is installed.
+func (p *Pipeline) Recover() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entries, err := os.ReadDir(p.workDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(p.workDir, entry.Name())
+		tx, err := loadTransaction(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			// Committed, only the cleanup was interrupted
+			_ = os.RemoveAll(dir)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Warnf("Rolling back interrupted install %s", tx.ID)
+		if err := tx.rollback(); err != nil {
+			errs = append(errs, fmt.Errorf("install %s: %w", tx.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,245 @@
+package install
+
+import (
+	"TotalControl/backend/downloads"
+	"TotalControl/backend/httpclient"
+	"TotalControl/backend/utils"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMod = []byte("new mod version")
+
+// testEnabledList stores a JSON object of mod names to enabled flags.
+type testEnabledList struct {
+	path string
+	err  error
+}
+
+func (l *testEnabledList) Path() string {
+	return l.path
+}
+
+func (l *testEnabledList) Update(ctx context.Context, enable map[string]bool, forget []string) error {
+	list := make(map[string]bool)
+	if data, err := os.ReadFile(l.path); err == nil {
+		_ = json.Unmarshal(data, &list)
+	}
+	for name, enabled := range enable {
+		list[name] = enabled
+	}
+	for _, name := range forget {
+		delete(list, name)
+	}
+	data, _ := json.Marshal(list)
+	if err := os.WriteFile(l.path, data, 0644); err != nil {
+		return err
+	}
+	// Fail after writing, the pipeline has to restore the list
+	return l.err
+}
+
+func newTestPipeline(t *testing.T) (*Pipeline, *httptest.Server) {
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	file, _ := writer.Create("mod-1.0.0/data.lua")
+	_, _ = file.Write([]byte("data:extend({})"))
+	assert.NoError(t, writer.Close())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		content := testMod
+		if strings.HasSuffix(r.URL.Path, ".zip") {
+			content = archive.Bytes()
+		}
+		http.ServeContent(w, r, "mod", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+
+	manager := downloads.NewManager(httpclient.NewClient(""), 1)
+	t.Cleanup(manager.Close)
+	return NewPipeline(manager, filepath.Join(t.TempDir(), "work")), server
+}
+
+// snapshot returns every file below dir with its content, to compare the state before and after a failed install.
+func snapshot(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, _ := filepath.Rel(dir, path)
+		if entry.IsDir() {
+			files[relative+"/"] = ""
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		files[relative] = string(content)
+		return err
+	})
+	assert.NoError(t, err)
+	return files
+}
+
+func setupModDirectory(t *testing.T) (string, *testEnabledList) {
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "mod_0.9.0.zip"), []byte("old mod version"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "list.json"), []byte(`{"mod":false,"other":true}`), 0644))
+	return root, &testEnabledList{path: filepath.Join(root, "list.json")}
+}
+
+func TestPipeline_Install(t *testing.T) {
+	pipeline, server := newTestPipeline(t)
+	root, list := setupModDirectory(t)
+	sha1, _ := utils.HashString(string(testMod), utils.HashSHA1)
+
+	var steps []Step
+	pipeline.OnStep(func(plan *Plan, step Step) {
+		steps = append(steps, step)
+	})
+
+	err := pipeline.Run(context.Background(), &Plan{
+		Root: root,
+		Artifacts: []Artifact{
+			{ModID: "mod", URL: server.URL + "/download/mod", Target: "mod_1.0.0.zip",
+				Checksum: &downloads.Checksum{Algorithm: utils.HashSHA1, Value: sha1}},
+			{ModID: "extracted", URL: server.URL + "/extracted.zip", Target: "nested/extracted", Extract: true, StripComponents: 1},
+		},
+		Remove:      []string{"mod_0.9.0.zip"},
+		EnabledList: list,
+		Enable:      map[string]bool{"mod": true},
+		Forget:      []string{"other"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Step{StepResolve, StepDownload, StepVerify, StepStage, StepBackup, StepDeploy, StepEnable, StepCommit}, steps)
+
+	assert.Equal(t, map[string]string{
+		"./":                        "",
+		"list.json":                 `{"mod":true}`,
+		"mod_1.0.0.zip":             string(testMod),
+		"nested/":                   "",
+		"nested/extracted/":         "",
+		"nested/extracted/data.lua": "data:extend({})",
+	}, snapshot(t, root))
+	entries, _ := os.ReadDir(pipeline.workDir)
+	assert.Empty(t, entries)
+}
+
+func TestPipeline_FailureRestoresEverything(t *testing.T) {
+	pipeline, server := newTestPipeline(t)
+	cases := map[Step]func(plan *Plan){
+		StepDownload: func(plan *Plan) {
+			plan.Artifacts[0].URL = server.URL + "/missing"
+		},
+		StepVerify: func(plan *Plan) {
+			plan.Artifacts[0].Checksum = &downloads.Checksum{Algorithm: utils.HashSHA1, Value: "0000"}
+		},
+		StepEnable: func(plan *Plan) {
+			plan.EnabledList.(*testEnabledList).err = errors.New("disk full")
+		},
+	}
+
+	for step, breakPlan := range cases {
+		t.Run(string(step), func(t *testing.T) {
+			root, list := setupModDirectory(t)
+			before := snapshot(t, root)
+			plan := &Plan{
+				Root: root,
+				Artifacts: []Artifact{
+					{ModID: "mod", URL: server.URL + "/download/mod", Target: "new/dir/mod_1.0.0.zip"},
+				},
+				Remove:      []string{"mod_0.9.0.zip"},
+				EnabledList: list,
+				Enable:      map[string]bool{"mod": true},
+			}
+			breakPlan(plan)
+
+			err := pipeline.Run(context.Background(), plan)
+			var stepErr *StepError
+			if assert.ErrorAs(t, err, &stepErr) {
+				assert.Equal(t, step, stepErr.Step)
+				assert.NoError(t, stepErr.Rollback)
+			}
+			assert.Equal(t, before, snapshot(t, root))
+		})
+	}
+}
+
+func TestPipeline_RejectsPathsOutsideRoot(t *testing.T) {
+	pipeline, _ := newTestPipeline(t)
+	root, _ := setupModDirectory(t)
+	for _, target := range []string{"../escape.zip", "/etc/escape.zip", ""} {
+		err := pipeline.Run(context.Background(), &Plan{
+			Root:      root,
+			Artifacts: []Artifact{{ModID: "mod", Source: filepath.Join(root, "mod_0.9.0.zip"), Target: target}},
+		})
+		var stepErr *StepError
+		if assert.ErrorAs(t, err, &stepErr, target) {
+			assert.Equal(t, StepResolve, stepErr.Step)
+		}
+	}
+}
+
+func TestPipeline_Recover(t *testing.T) {
+	pipeline, _ := newTestPipeline(t)
+	root, list := setupModDirectory(t)
+	before := snapshot(t, root)
+
+	// An install that crashed after deploying its file
+	tx, err := newTransaction(pipeline.workDir, "interrupted")
+	assert.NoError(t, err)
+	assert.NoError(t, tx.backup(filepath.Join(root, "mod_0.9.0.zip")))
+	assert.NoError(t, tx.backupList(list.path))
+	staged := tx.path("staged")
+	assert.NoError(t, os.WriteFile(staged, testMod, 0644))
+	assert.NoError(t, tx.deploy(staged, filepath.Join(root, "mods", "mod_1.0.0.zip")))
+	assert.NoError(t, list.Update(context.Background(), map[string]bool{"mod": true}, nil))
+
+	assert.NoError(t, pipeline.Recover())
+	assert.Equal(t, before, snapshot(t, root))
+	entries, _ := os.ReadDir(pipeline.workDir)
+	assert.Empty(t, entries)
+}
+
+func TestMerge(t *testing.T) {
+	pipeline, server := newTestPipeline(t)
+	root, list := setupModDirectory(t)
+	before := snapshot(t, root)
+	update := &Plan{
+		Root:        root,
+		Artifacts:   []Artifact{{ModID: "mod", URL: server.URL + "/download/mod", Target: "mod_1.0.0.zip"}},
+		Remove:      []string{"mod_0.9.0.zip"},
+		EnabledList: list,
+		Enable:      map[string]bool{"mod": true},
+	}
+	failing := &Plan{
+		Root:      root,
+		Artifacts: []Artifact{{ModID: "other", URL: server.URL + "/missing", Target: "other_2.0.0.zip"}},
+	}
+
+	merged, err := Merge(update, failing)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, merged.Artifacts, 2)
+	assert.Equal(t, list, merged.EnabledList)
+	assert.Error(t, pipeline.Run(context.Background(), merged))
+	assert.Equal(t, before, snapshot(t, root))
+
+	_, err = Merge(update, &Plan{Root: t.TempDir()})
+	assert.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package install
+
+import (
+	"TotalControl/backend/downloads"
+	"TotalControl/backend/httpclient"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// Artifact is a file the pipeline fetches and deploys into the game's mod directory.
+type Artifact struct {
+	ModID string `json:"mod_id"`
+	URL   string `json:"url,omitempty"`
+	// Source is a local file used instead of URL, e.g. a mod the user dropped into the app. It is copied, never moved.
+	Source   string              `json:"source,omitempty"`
+	Headers  map[string]string   `json:"headers,omitempty"`
+	Checksum *downloads.Checksum `json:"checksum,omitempty"`
+	// Target is the deployed file, or directory if Extract is set, relative to Plan.Root.
+	Target string `json:"target"`
+	// Extract unpacks the archive into Target instead of deploying the file itself.
+	Extract         bool `json:"extract,omitempty"`
+	StripComponents int  `json:"strip_components,omitempty"`
+}
+
+// EnabledList is the game's record of enabled mods, such as Factorio's mod-list.json.
+type EnabledList interface {
+	// Path is the file the list is stored in. The pipeline backs it up before Update is called and restores it
+	// if the install fails.
+	Path() string
+	// Update enables or disables the mods in enable and drops the mods in forget from the list.
+	Update(ctx context.Context, enable map[string]bool, forget []string) error
+}
+
+// Plan describes a change to a game's mods. Providers only describe what to install, the pipeline carries it
+// out and either applies all of it or nothing.
+type Plan struct {
+	GameID string `json:"game_id"`
+	// Root is the game's mod directory, every target must be inside it.
+	Root      string     `json:"root"`
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+	// Remove lists files and directories to delete, relative to Root, e.g. the previous version of an updated mod.
+	Remove []string `json:"remove,omitempty"`
+
+	// EnabledList is updated with Enable and Forget after the files were deployed. It may be nil.
+	EnabledList EnabledList     `json:"-"`
+	Enable      map[string]bool `json:"enable,omitempty"`
+	Forget      []string        `json:"forget,omitempty"`
+
+	// Client downloads the artifacts instead of the download manager's client, e.g. the client of the plugin that
+	// made the plan.
+	Client *httpclient.Client `json:"-"`
+}
+
+// Step is a stage of the pipeline, in the order they run.
+type Step string
+
+const (
+	StepResolve  Step = "resolve"
+	StepDownload Step = "download"
+	StepVerify   Step = "verify"
+	StepStage    Step = "stage"
+	StepBackup   Step = "backup"
+	StepDeploy   Step = "deploy"
+	StepEnable   Step = "enable"
+	StepCommit   Step = "commit"
+	StepRollback Step = "rollback"
+)
+
+// StepError says at which step an install failed. Rollback is set if restoring the previous state failed as
+// well, in which case the transaction is kept and Recover tries again.
+type StepError struct {
+	Step     Step
+	Err      error
+	Rollback error
+}
+
+func (e *StepError) Error() string {
+	if e.Rollback != nil {
+		return fmt.Sprintf("install failed at %s: %v (rollback failed: %v)", e.Step, e.Err, e.Rollback)
+	}
+	return fmt.Sprintf("install failed at %s: %v", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// Merge combines plans for the same mod directory into one, so they are applied together or not at all, e.g. a
+// batch of updates. The plans must share the enabled list, if they have one.
+func Merge(plans ...*Plan) (*Plan, error) {
+	if len(plans) == 0 {
+		return nil, errors.New("nothing to merge")
+	}
+	merged := &Plan{GameID: plans[0].GameID, Root: plans[0].Root, Enable: make(map[string]bool), Client: plans[0].Client}
+	for _, plan := range plans {
+		if plan.GameID != merged.GameID || filepath.Clean(plan.Root) != filepath.Clean(merged.Root) {
+			return nil, fmt.Errorf("cannot merge plans for %s and %s", merged.Root, plan.Root)
+		}
+		if plan.EnabledList != nil {
+			if merged.EnabledList != nil && merged.EnabledList.Path() != plan.EnabledList.Path() {
+				return nil, fmt.Errorf("cannot merge plans for the enabled lists %s and %s", merged.EnabledList.Path(), plan.EnabledList.Path())
+			}
+			merged.EnabledList = plan.EnabledList
+		}
+		merged.Artifacts = append(merged.Artifacts, plan.Artifacts...)
+		merged.Remove = append(merged.Remove, plan.Remove...)
+		for name, enabled := range plan.Enable {
+			merged.Enable[name] = enabled
+		}
+		merged.Forget = append(merged.Forget, plan.Forget...)
+	}
+	return merged, nil
+}
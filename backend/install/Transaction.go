@@ -0,0 +1,228 @@
+package install
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const journalFile = "journal.json"
+
+type backupEntry struct {
+	Original string `json:"original"`
+	Backup   string `json:"backup"`
+}
+
+// transaction is the journal of an install. It is written to disk before every change to the mod directory, so
+// an install interrupted by a crash can still be rolled back by Recover.
+type transaction struct {
+	ID string `json:"id"`
+	// Deployed are the files and directories created in the mod directory, in the order they were created.
+	Deployed []string      `json:"deployed,omitempty"`
+	Backups  []backupEntry `json:"backups,omitempty"`
+	// ListPath is the enabled list, ListBackup its copy if it existed before the install.
+	ListPath    string `json:"list_path,omitempty"`
+	ListBackup  string `json:"list_backup,omitempty"`
+	ListExisted bool   `json:"list_existed,omitempty"`
+
+	dir string
+}
+
+func newTransaction(workDir string, id string) (*transaction, error) {
+	tx := &transaction{ID: id, dir: filepath.Join(workDir, id)}
+	if err := os.MkdirAll(tx.dir, 0755); err != nil {
+		return nil, err
+	}
+	return tx, tx.save()
+}
+
+func loadTransaction(dir string) (*transaction, error) {
+	data, err := os.ReadFile(filepath.Join(dir, journalFile))
+	if err != nil {
+		return nil, err
+	}
+	tx := &transaction{dir: dir}
+	if err := json.Unmarshal(data, tx); err != nil {
+		return nil, fmt.Errorf("corrupt install journal %s: %w", dir, err)
+	}
+	return tx, nil
+}
+
+func (tx *transaction) path(elem ...string) string {
+	return filepath.Join(append([]string{tx.dir}, elem...)...)
+}
+
+// save writes the journal atomically, a torn journal would make the transaction impossible to roll back.
+func (tx *transaction) save() error {
+	data, err := json.MarshalIndent(tx, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := tx.path(journalFile + ".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, tx.path(journalFile))
+}
+
+// backup moves original out of the way. Nothing happens if it does not exist.
+func (tx *transaction) backup(original string) error {
+	if _, err := os.Lstat(original); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	entry := backupEntry{Original: original, Backup: tx.path("backup", fmt.Sprint(len(tx.Backups)))}
+	tx.Backups = append(tx.Backups, entry)
+	if err := tx.save(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(entry.Backup), 0755); err != nil {
+		return err
+	}
+	return moveFile(original, entry.Backup)
+}
+
+// deploy moves a staged file or directory to target, creating missing parent directories.
+func (tx *transaction) deploy(staged string, target string) error {
+	if err := tx.mkdirAll(filepath.Dir(target)); err != nil {
+		return err
+	}
+	tx.Deployed = append(tx.Deployed, target)
+	if err := tx.save(); err != nil {
+		return err
+	}
+	return moveFile(staged, target)
+}
+
+// mkdirAll creates dir and records every directory it had to create, so the rollback removes them again.
+func (tx *transaction) mkdirAll(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if err := tx.mkdirAll(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	tx.Deployed = append(tx.Deployed, dir)
+	if err := tx.save(); err != nil {
+		return err
+	}
+	return os.Mkdir(dir, 0755)
+}
+
+// backupList copies the enabled list, it is changed in place by the EnabledList.
+func (tx *transaction) backupList(path string) error {
+	tx.ListPath = path
+	tx.ListBackup = tx.path("enabled-list")
+	_, err := os.Stat(path)
+	tx.ListExisted = err == nil
+	if tx.ListExisted {
+		if err := copyFile(path, tx.ListBackup); err != nil {
+			return err
+		}
+	}
+	return tx.save()
+}
+
+// rollback undoes every recorded change in reverse order. It keeps going after errors so as much as possible
+// is restored, and reports all of them.
+func (tx *transaction) rollback() error {
+	var errs []error
+	if tx.ListBackup != "" {
+		if tx.ListExisted {
+			errs = append(errs, replaceFile(tx.ListBackup, tx.ListPath))
+		} else if err := os.Remove(tx.ListPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	for i := len(tx.Deployed) - 1; i >= 0; i-- {
+		errs = append(errs, os.RemoveAll(tx.Deployed[i]))
+	}
+	for i := len(tx.Backups) - 1; i >= 0; i-- {
+		entry := tx.Backups[i]
+		if _, err := os.Lstat(entry.Backup); errors.Is(err, fs.ErrNotExist) {
+			// The journal was written but the file never moved
+			continue
+		}
+		errs = append(errs, moveFile(entry.Backup, entry.Original))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return tx.discard()
+}
+
+// discard removes the transaction. The journal goes first, once it is gone the install cannot be rolled back.
+func (tx *transaction) discard() error {
+	if err := os.Remove(tx.path(journalFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.RemoveAll(tx.dir); err != nil {
+		log.Warnf("Failed to clean up install transaction %s: %v", tx.dir, err)
+	}
+	return nil
+}
+
+// moveFile renames a file or directory. Renames fail across file systems, e.g. from the app's data directory to a
+// game on another drive, then it is copied and removed instead.
+func moveFile(source string, target string) error {
+	if err := os.Rename(source, target); err == nil {
+		return nil
+	}
+	if err := copyTree(source, target); err != nil {
+		_ = os.RemoveAll(target)
+		return err
+	}
+	return os.RemoveAll(source)
+}
+
+func copyTree(source string, target string) error {
+	return filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		destination := filepath.Join(target, relative)
+		if entry.IsDir() {
+			return os.MkdirAll(destination, 0755)
+		}
+		return copyFile(path, destination)
+	})
+}
+
+func copyFile(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// replaceFile copies source over target through a temporary file, so target is never left half written.
+func replaceFile(source string, target string) error {
+	tmp := target + ".tmp"
+	if err := copyFile(source, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, target)
+}
@@ -0,0 +1,198 @@
+package loadorder
+
+import (
+	"TotalControl/backend/dependencies"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+type Relation string
+
+const (
+	Before Relation = "before"
+	After  Relation = "after"
+)
+
+// Rule pins the position of a mod relative to another one, e.g. "ui-overhaul before better-icons".
+type Rule struct {
+	ModID    string   `json:"mod_id"`
+	Relation Relation `json:"relation"`
+	Other    string   `json:"other"`
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf("%s %s %s", r.ModID, r.Relation, r.Other)
+}
+
+func (r Rule) validate() error {
+	if r.ModID == "" || r.Other == "" {
+		return fmt.Errorf("rule %q needs two mods", r.String())
+	}
+	if strings.EqualFold(r.ModID, r.Other) {
+		return fmt.Errorf("rule %q places a mod relative to itself", r.String())
+	}
+	if r.Relation != Before && r.Relation != After {
+		return fmt.Errorf("rule %q has unknown relation %q", r.String(), r.Relation)
+	}
+	return nil
+}
+
+// Writer is implemented by providers of games that read the load order from their own file, e.g. RimWorld's
+// ModsConfig.xml or Fallout 4's plugins.txt.
+type Writer interface {
+	// WriteLoadOrder stores the enabled mods in the game's format, first loaded first.
+	WriteLoadOrder(ctx context.Context, order []string) error
+}
+
+// UnsatisfiableError is returned when dependencies and rules contradict each other.
+type UnsatisfiableError struct {
+	// Reasons are the constraints that form the cycle, e.g. "b requires a", "you placed b before a".
+	Reasons []string
+}
+
+func (e *UnsatisfiableError) Error() string {
+	return "unsatisfiable load order: " + strings.Join(e.Reasons, ", but ")
+}
+
+// edge says that from has to load before to, and why.
+type edge struct {
+	from   string
+	to     string
+	reason string
+}
+
+// Sort orders the enabled mods so every mod loads after its dependencies and every rule holds. Otherwise the
+// previous order is kept as far as possible, new mods go to the end in alphabetical order. Dependencies and rules
+// that mention mods which are not enabled are ignored.
+func Sort(enabled []dependencies.Release, rules []Rule, previous []string) ([]string, error) {
+	ids := make(map[string]string, len(enabled))
+	for _, release := range enabled {
+		ids[strings.ToLower(release.ModID)] = release.ModID
+	}
+	position := make(map[string]int)
+	for i, id := range previous {
+		if _, ok := position[strings.ToLower(id)]; !ok {
+			position[strings.ToLower(id)] = i
+		}
+	}
+
+	var edges []edge
+	for _, release := range enabled {
+		parsed, _ := dependencies.ParseAll(release.Dependencies)
+		for _, dependency := range parsed {
+			if _, ok := ids[strings.ToLower(dependency.ModID)]; !ok || !dependency.AffectsLoadOrder() {
+				continue
+			}
+			reason := release.ModID + " requires " + ids[strings.ToLower(dependency.ModID)]
+			if dependency.IsOptional() {
+				reason = release.ModID + " optionally depends on " + ids[strings.ToLower(dependency.ModID)]
+			}
+			edges = append(edges, edge{from: strings.ToLower(dependency.ModID), to: strings.ToLower(release.ModID), reason: reason})
+		}
+	}
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		modID, other := strings.ToLower(rule.ModID), strings.ToLower(rule.Other)
+		if _, ok := ids[modID]; !ok {
+			continue
+		}
+		if _, ok := ids[other]; !ok {
+			continue
+		}
+		reason := "you placed " + rule.String()
+		if rule.Relation == Before {
+			edges = append(edges, edge{from: modID, to: other, reason: reason})
+		} else {
+			edges = append(edges, edge{from: other, to: modID, reason: reason})
+		}
+	}
+
+	incoming := make(map[string]int)
+	outgoing := make(map[string][]edge)
+	for _, e := range edges {
+		incoming[e.to]++
+		outgoing[e.from] = append(outgoing[e.from], e)
+	}
+	// less prefers the previous position, then the name
+	less := func(a string, b string) bool {
+		positionA, knownA := position[a]
+		positionB, knownB := position[b]
+		if knownA != knownB {
+			return knownA
+		}
+		if knownA && positionA != positionB {
+			return positionA < positionB
+		}
+		return a < b
+	}
+
+	var ready []string
+	for id := range ids {
+		if incoming[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+	order := make([]string, 0, len(ids))
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return less(ready[i], ready[j]) })
+		id := ready[0]
+		ready = ready[1:]
+		order = append(order, ids[id])
+		for _, e := range outgoing[id] {
+			incoming[e.to]--
+			if incoming[e.to] == 0 {
+				ready = append(ready, e.to)
+			}
+		}
+	}
+	if len(order) < len(ids) {
+		return nil, &UnsatisfiableError{Reasons: findCycle(incoming, outgoing)}
+	}
+	return order, nil
+}
+
+// findCycle returns the reasons along one cycle among the mods that could not be sorted.
+func findCycle(incoming map[string]int, outgoing map[string][]edge) []string {
+	var remaining []string
+	for id, count := range incoming {
+		if count > 0 {
+			remaining = append(remaining, id)
+		}
+	}
+	sort.Strings(remaining)
+
+	// Every unsorted mod has an unsorted predecessor, so walking backwards from any of them has to repeat
+	predecessor := make(map[string]edge)
+	for _, edges := range outgoing {
+		for _, e := range edges {
+			if incoming[e.from] > 0 && incoming[e.to] > 0 {
+				if current, ok := predecessor[e.to]; !ok || e.from < current.from {
+					predecessor[e.to] = e
+				}
+			}
+		}
+	}
+	var path []string
+	id := remaining[0]
+	for !slices.Contains(path, id) {
+		path = append(path, id)
+		id = predecessor[id].from
+	}
+
+	var reasons []string
+	for current := id; ; {
+		e := predecessor[current]
+		reasons = append(reasons, e.reason)
+		current = e.from
+		if current == id {
+			break
+		}
+	}
+	slices.Reverse(reasons)
+	return reasons
+}
@@ -0,0 +1,89 @@
+package loadorder
+
+import (
+	"TotalControl/backend/dependencies"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var enabled = []dependencies.Release{
+	{ModID: "ui-overhaul", Dependencies: []string{"core"}},
+	{ModID: "core"},
+	{ModID: "better-icons", Dependencies: []string{"? ui-overhaul", "~ core", "missing"}},
+	{ModID: "textures"},
+}
+
+func TestSort(t *testing.T) {
+	order, err := Sort(enabled, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"core", "textures", "ui-overhaul", "better-icons"}, order)
+
+	// The previous order is kept where the dependencies allow it
+	order, err = Sort(enabled, nil, []string{"textures", "better-icons", "ui-overhaul", "core"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"textures", "core", "ui-overhaul", "better-icons"}, order)
+
+	order, err = Sort(enabled, []Rule{{ModID: "better-icons", Relation: Before, Other: "textures"}, {ModID: "core", Relation: After, Other: "gone"}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"core", "ui-overhaul", "better-icons", "textures"}, order)
+}
+
+func TestSort_Unsatisfiable(t *testing.T) {
+	_, err := Sort(enabled, []Rule{{ModID: "better-icons", Relation: Before, Other: "core"}, {ModID: "core", Relation: After, Other: "better-icons"}}, nil)
+	var unsatisfiable *UnsatisfiableError
+	if assert.ErrorAs(t, err, &unsatisfiable) {
+		assert.Equal(t, []string{
+			"you placed better-icons before core",
+			"ui-overhaul requires core",
+			"better-icons optionally depends on ui-overhaul",
+		}, unsatisfiable.Reasons)
+	}
+
+	_, err = Sort(enabled, []Rule{{ModID: "core", Relation: Before, Other: "core"}}, nil)
+	assert.Error(t, err)
+}
+
+type testWriter struct {
+	order []string
+	err   error
+}
+
+func (w *testWriter) WriteLoadOrder(ctx context.Context, order []string) error {
+	w.order = order
+	return w.err
+}
+
+func TestStore(t *testing.T) {
+	store := NewStore(t.TempDir())
+	writer := &testWriter{}
+	ctx := context.Background()
+
+	order, err := store.AddRule(ctx, "rimworld", Rule{ModID: "textures", Relation: Before, Other: "core"}, enabled, writer)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"textures", "core", "ui-overhaul", "better-icons"}, order)
+	assert.Equal(t, order, writer.order)
+
+	// A contradicting rule is not saved
+	_, err = store.AddRule(ctx, "rimworld", Rule{ModID: "core", Relation: After, Other: "better-icons"}, enabled, writer)
+	assert.Error(t, err)
+	state, err := store.Load("rimworld")
+	assert.NoError(t, err)
+	assert.Equal(t, &State{Order: order, Rules: []Rule{{ModID: "textures", Relation: Before, Other: "core"}}}, state)
+
+	// The previous order is kept after removing the rule, and saved even if the game's file cannot be written
+	writer.err = errors.New("read-only file")
+	_, err = store.RemoveRule(ctx, "rimworld", Rule{ModID: "textures", Relation: Before, Other: "core"}, enabled, writer)
+	assert.ErrorIs(t, err, writer.err)
+	state, _ = store.Load("rimworld")
+	assert.Equal(t, order, state.Order)
+	assert.Empty(t, state.Rules)
+
+	_, err = store.Load("../escape")
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(store.dir, "rimworld.json"))
+	assert.NoError(t, err)
+}
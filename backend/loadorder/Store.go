@@ -0,0 +1,164 @@
+package loadorder
+
+import (
+	"TotalControl/backend/dependencies"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// DefaultDir holds one load order file per game.
+const DefaultDir = "data/loadorder"
+
+// State is the saved load order of a game.
+type State struct {
+	Order []string `json:"order"`
+	Rules []Rule   `json:"rules,omitempty"`
+}
+
+// Store persists the load order and rules of every game as JSON files.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+var defaultStore = NewStore(DefaultDir)
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// DefaultStore returns the store in DefaultDir.
+func DefaultStore() *Store {
+	return defaultStore
+}
+
+func (s *Store) path(gameID string) (string, error) {
+	if gameID == "" || !filepath.IsLocal(gameID) || filepath.Base(gameID) != gameID {
+		return "", fmt.Errorf("invalid game ID %q", gameID)
+	}
+	return filepath.Join(s.dir, gameID+".json"), nil
+}
+
+// Load returns the saved state of a game, an empty one if nothing was saved yet.
+func (s *Store) Load(gameID string) (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(gameID)
+}
+
+func (s *Store) load(gameID string) (*State, error) {
+	path, err := s.path(gameID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid load order file %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// save writes the state atomically, so a crash never loses the user's rules.
+func (s *Store) save(gameID string, state *State) error {
+	path, err := s.path(gameID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Update sorts the enabled mods of a game with its saved rules and previous order, saves the result and hands it to
+// writer if it is not nil. The saved order is only changed if sorting succeeds.
+func (s *Store) Update(ctx context.Context, gameID string, enabled []dependencies.Release, writer Writer) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := s.load(gameID)
+	if err != nil {
+		return nil, err
+	}
+	return s.apply(ctx, gameID, state, enabled, writer)
+}
+
+// Restore is like Update but starts from the given order instead of the saved one, e.g. the order of a profile
+// that is switched to.
+func (s *Store) Restore(ctx context.Context, gameID string, order []string, enabled []dependencies.Release, writer Writer) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := s.load(gameID)
+	if err != nil {
+		return nil, err
+	}
+	state.Order = order
+	return s.apply(ctx, gameID, state, enabled, writer)
+}
+
+// AddRule adds a rule and re-sorts the enabled mods. A rule that contradicts the dependencies or other rules is
+// rejected with an *UnsatisfiableError and not saved.
+func (s *Store) AddRule(ctx context.Context, gameID string, rule Rule, enabled []dependencies.Release, writer Writer) ([]string, error) {
+	if err := rule.validate(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := s.load(gameID)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(state.Rules, rule) {
+		state.Rules = append(state.Rules, rule)
+	}
+	return s.apply(ctx, gameID, state, enabled, writer)
+}
+
+// RemoveRule removes a rule and re-sorts the enabled mods.
+func (s *Store) RemoveRule(ctx context.Context, gameID string, rule Rule, enabled []dependencies.Release, writer Writer) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := s.load(gameID)
+	if err != nil {
+		return nil, err
+	}
+	state.Rules = slices.DeleteFunc(state.Rules, func(existing Rule) bool { return existing == rule })
+	return s.apply(ctx, gameID, state, enabled, writer)
+}
+
+func (s *Store) apply(ctx context.Context, gameID string, state *State, enabled []dependencies.Release, writer Writer) ([]string, error) {
+	order, err := Sort(enabled, state.Rules, state.Order)
+	if err != nil {
+		return nil, err
+	}
+	state.Order = order
+	if err := s.save(gameID, state); err != nil {
+		return nil, err
+	}
+	if writer != nil {
+		if err := writer.WriteLoadOrder(ctx, order); err != nil {
+			return nil, fmt.Errorf("failed to write load order of %s: %w", gameID, err)
+		}
+	}
+	return order, nil
+}
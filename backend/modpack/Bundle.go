@@ -0,0 +1,144 @@
+package modpack
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ManifestFile is the name of the manifest inside a bundle.
+const ManifestFile = "manifest.json"
+
+// Extension is the file extension of modpack bundles.
+const Extension = ".tcmodpack"
+
+// WriteBundle writes the manifest together with the mod archives into a single zip file. Mods whose file the
+// provider cannot name, or that are directories, are listed without an archive.
+func WriteBundle(target string, manifest *Manifest, files ModFiles) error {
+	bundled := *manifest
+	bundled.Mods = make([]ModEntry, len(manifest.Mods))
+	copy(bundled.Mods, manifest.Mods)
+
+	tmp := target + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := zip.NewWriter(file)
+	err = func() error {
+		for i := range bundled.Mods {
+			entry := &bundled.Mods[i]
+			source, err := files.ModFile(entry.ID)
+			if err != nil {
+				continue
+			}
+			if info, err := os.Stat(source); err != nil || info.IsDir() {
+				continue
+			}
+			entry.Bundled = path.Join("mods", filepath.Base(source))
+			if err := addFile(writer, entry.Bundled, source); err != nil {
+				return fmt.Errorf("failed to bundle %s: %w", entry.ID, err)
+			}
+		}
+		data, err := json.MarshalIndent(&bundled, "", "  ")
+		if err != nil {
+			return err
+		}
+		manifestWriter, err := writer.Create(ManifestFile)
+		if err != nil {
+			return err
+		}
+		if _, err := manifestWriter.Write(data); err != nil {
+			return err
+		}
+		return writer.Close()
+	}()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+func addFile(writer *zip.Writer, name string, source string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// Mod archives are compressed already
+	out, err := writer.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// Bundle is an opened bundle file.
+type Bundle struct {
+	Manifest *Manifest
+	reader   *zip.ReadCloser
+}
+
+func OpenBundle(path string) (*Bundle, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	bundle := &Bundle{reader: reader}
+	manifest, err := bundle.readManifest()
+	if err != nil {
+		_ = reader.Close()
+		return nil, fmt.Errorf("invalid modpack %s: %w", path, err)
+	}
+	bundle.Manifest = manifest
+	return bundle, nil
+}
+
+func (b *Bundle) readManifest() (*Manifest, error) {
+	file, err := b.reader.Open(ManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	manifest := &Manifest{}
+	if err := json.NewDecoder(file).Decode(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, manifest.Validate()
+}
+
+// extract copies a bundled archive to dir and returns its path.
+func (b *Bundle) extract(entry ModEntry, dir string) (string, error) {
+	file, err := b.reader.Open(entry.Bundled)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%s is missing from the bundle", entry.Bundled)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	target := filepath.Join(dir, path.Base(entry.Bundled))
+	out, err := os.Create(target)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, file); err != nil {
+		_ = out.Close()
+		return "", err
+	}
+	return target, out.Close()
+}
+
+func (b *Bundle) Close() error {
+	return b.reader.Close()
+}
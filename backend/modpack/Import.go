@@ -0,0 +1,207 @@
+package modpack
+
+import (
+	"TotalControl/backend/dependencies"
+	"TotalControl/backend/loadorder"
+	"TotalControl/backend/mods"
+	"TotalControl/backend/profiles"
+	"TotalControl/backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Action string
+
+const (
+	// ActionInstall installs a mod that is missing.
+	ActionInstall Action = "install"
+	// ActionChangeVersion replaces the installed version with the one of the modpack.
+	ActionChangeVersion Action = "change_version"
+	// ActionEnable enables an installed mod.
+	ActionEnable Action = "enable"
+	// ActionNotInPack marks an enabled mod the modpack does not have. Import leaves it as it is.
+	ActionNotInPack Action = "not_in_pack"
+)
+
+// Change is a difference between a modpack and the installed mods.
+type Change struct {
+	ModID     string `json:"mod_id"`
+	Action    Action `json:"action"`
+	Installed string `json:"installed,omitempty"`
+	Wanted    string `json:"wanted,omitempty"`
+}
+
+// Diff lists what importing the manifest would change, in the order of the manifest followed by the enabled mods
+// that are not in it.
+func Diff(manifest *Manifest, installed []mods.Mod) []Change {
+	var changes []Change
+	for _, entry := range manifest.Mods {
+		mod, ok := mods.FindMod(installed, entry.ID)
+		switch {
+		case !ok:
+			changes = append(changes, Change{ModID: entry.ID, Action: ActionInstall, Wanted: entry.Version})
+		case entry.Version != "" && mod.Version != entry.Version:
+			changes = append(changes, Change{ModID: entry.ID, Action: ActionChangeVersion, Installed: mod.Version, Wanted: entry.Version})
+		case !mod.Enabled:
+			changes = append(changes, Change{ModID: entry.ID, Action: ActionEnable, Installed: mod.Version, Wanted: entry.Version})
+		}
+	}
+	for _, mod := range installed {
+		if mod.Enabled && manifest.find(mod.ID) == nil {
+			changes = append(changes, Change{ModID: mod.ID, Action: ActionNotInPack, Installed: mod.Version})
+		}
+	}
+	return changes
+}
+
+type ImportOptions struct {
+	// Bundle provides the archives of bundled mods, which are installed from it instead of being downloaded.
+	Bundle *Bundle
+	// Settings overwrites the game's mod settings with those of the modpack.
+	Settings bool
+	// LoadOrders is the store the load order is saved to, the default one if nil.
+	LoadOrders *loadorder.Store
+}
+
+// Import installs the missing mods and versions of the manifest through the provider, enables them and applies the
+// load order. Mods that are not in the modpack are left alone, see Diff. Like switching profiles, importing is
+// refused while the game is running.
+func Import(ctx context.Context, provider mods.ModProvider, manifest *Manifest, options ImportOptions) error {
+	if err := manifest.Validate(); err != nil {
+		return err
+	}
+	if !strings.EqualFold(manifest.GameID, provider.GameID()) {
+		return fmt.Errorf("the modpack is for %s, not %s", manifest.GameID, provider.GameID())
+	}
+	if process, ok := provider.(mods.GameProcess); ok {
+		running, err := process.IsGameRunning(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to check whether %s is running: %w", manifest.GameID, err)
+		}
+		if running {
+			return mods.ErrGameRunning
+		}
+	}
+
+	installed, err := provider.InstalledMods(ctx)
+	if err != nil {
+		return err
+	}
+	changes := Diff(manifest, installed)
+	workDir, err := os.MkdirTemp("", "modpack-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	for _, change := range changes {
+		entry := manifest.find(change.ModID)
+		switch change.Action {
+		case ActionInstall, ActionChangeVersion:
+			if err := installEntry(ctx, provider, change, *entry, options.Bundle, workDir); err != nil {
+				return fmt.Errorf("failed to install %s %s: %w", entry.ID, entry.Version, err)
+			}
+			verifyInstalled(provider, *entry)
+		case ActionEnable:
+			if err := provider.SetModEnabled(ctx, entry.ID, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	if options.Settings && len(manifest.Settings) > 0 {
+		if err := writeSettings(provider, manifest); err != nil {
+			return err
+		}
+	}
+
+	installed, err = provider.InstalledMods(ctx)
+	if err != nil {
+		return err
+	}
+	var enabled []dependencies.Release
+	for _, mod := range installed {
+		if mod.Enabled {
+			enabled = append(enabled, dependencies.ReleaseFromMod(mod))
+		}
+	}
+	var writer loadorder.Writer
+	if w, ok := provider.(loadorder.Writer); ok && provider.Capabilities().LoadOrder {
+		writer = w
+	}
+	loadOrders := options.LoadOrders
+	if loadOrders == nil {
+		loadOrders = loadorder.DefaultStore()
+	}
+	_, err = loadOrders.Restore(ctx, manifest.GameID, manifest.LoadOrder, enabled, writer)
+	return err
+}
+
+func installEntry(ctx context.Context, provider mods.ModProvider, change Change, entry ModEntry, bundle *Bundle, workDir string) error {
+	if bundle != nil && entry.Bundled != "" {
+		fileInstaller, ok := provider.(FileInstaller)
+		if !ok {
+			return fmt.Errorf("%w: installing bundled mods", mods.ErrNotSupported)
+		}
+		archive, err := bundle.extract(entry, workDir)
+		if err != nil {
+			return err
+		}
+		if entry.Checksum != nil && entry.Checksum.Algorithm != "" {
+			if err := utils.VerifyFile(archive, entry.Checksum.Algorithm, entry.Checksum.Value); err != nil {
+				return err
+			}
+		}
+		return fileInstaller.InstallModFile(ctx, entry.ID, entry.Version, archive)
+	}
+	var err error
+	if change.Action == ActionInstall {
+		_, err = provider.InstallMod(ctx, entry.ID, entry.Version)
+	} else {
+		_, err = provider.UpdateMod(ctx, entry.ID, entry.Version)
+	}
+	return err
+}
+
+// verifyInstalled warns if a downloaded mod differs from the one the modpack was made with, e.g. because the
+// author re-uploaded the same version.
+func verifyInstalled(provider mods.ModProvider, entry ModEntry) {
+	files, ok := provider.(ModFiles)
+	if !ok || entry.Checksum == nil || entry.Checksum.Algorithm == "" {
+		return
+	}
+	path, err := files.ModFile(entry.ID)
+	if err != nil {
+		return
+	}
+	if err := utils.VerifyFile(path, entry.Checksum.Algorithm, entry.Checksum.Value); errors.Is(err, utils.ErrChecksumMismatch) {
+		log.Warnf("Mod %s %s differs from the one in the modpack: %v", entry.ID, entry.Version, err)
+	}
+}
+
+func writeSettings(provider mods.ModProvider, manifest *Manifest) error {
+	settingsFiles, ok := provider.(profiles.SettingsFiles)
+	if !ok {
+		return nil
+	}
+	for _, path := range settingsFiles.ModSettingsFiles() {
+		data, ok := manifest.Settings[filepath.Base(path)]
+		if !ok {
+			continue
+		}
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+	}
+	return nil
+}
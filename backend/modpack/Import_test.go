@@ -0,0 +1,70 @@
+package modpack
+
+import (
+	"TotalControl/backend/loadorder"
+	"TotalControl/backend/mods"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	manifest := &Manifest{Mods: []ModEntry{
+		{ID: "core", Version: "1.0.0"},
+		{ID: "rockets", Version: "1.1.0"},
+		{ID: "cheats", Version: "0.1.0"},
+		{ID: "planets", Version: "0.6.0"},
+	}}
+	installed := []mods.Mod{
+		{ID: "core", Version: "1.0.0", Enabled: true},
+		{ID: "rockets", Version: "1.0.0", Enabled: true},
+		{ID: "cheats", Version: "0.1.0"},
+		{ID: "extra", Version: "3.0.0", Enabled: true},
+	}
+	assert.Equal(t, []Change{
+		{ModID: "rockets", Action: ActionChangeVersion, Installed: "1.0.0", Wanted: "1.1.0"},
+		{ModID: "cheats", Action: ActionEnable, Installed: "0.1.0", Wanted: "0.1.0"},
+		{ModID: "planets", Action: ActionInstall, Wanted: "0.6.0"},
+		{ModID: "extra", Action: ActionNotInPack, Installed: "3.0.0"},
+	}, Diff(manifest, installed))
+}
+
+func TestImport(t *testing.T) {
+	source := newTestProvider(t)
+	_ = source.SetModEnabled(context.Background(), "cheats", true)
+	_, _ = source.add("planets", "0.6.0", []byte("planets archive"))
+	manifest, err := Export(context.Background(), source, ExportOptions{Settings: true, LoadOrders: loadorder.NewStore(t.TempDir())})
+	assert.NoError(t, err)
+	manifest.Mods[1].Version = "1.1.0" // rockets, from the catalogue
+	manifest.LoadOrder = []string{"planets", "rockets", "core", "cheats"}
+	path := filepath.Join(t.TempDir(), "pack"+Extension)
+	assert.NoError(t, WriteBundle(path, manifest, source))
+	bundle, err := OpenBundle(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer bundle.Close()
+
+	target := newTestProvider(t)
+	assert.NoError(t, os.WriteFile(target.ModSettingsFiles()[0], []byte("my settings"), 0644))
+	loadOrders := loadorder.NewStore(t.TempDir())
+	target.running = true
+	assert.ErrorIs(t, Import(context.Background(), target, bundle.Manifest, ImportOptions{Bundle: bundle}), mods.ErrGameRunning)
+	target.running = false
+
+	err = Import(context.Background(), target, bundle.Manifest, ImportOptions{Bundle: bundle, Settings: true, LoadOrders: loadOrders})
+	assert.NoError(t, err)
+	installed, _ := target.InstalledMods(context.Background())
+	assert.Empty(t, Diff(bundle.Manifest, installed))
+	content, _ := os.ReadFile(filepath.Join(target.dir, "planets.zip"))
+	assert.Equal(t, "planets archive", string(content))
+	content, _ = os.ReadFile(target.ModSettingsFiles()[0])
+	assert.Equal(t, "settings", string(content))
+	state, _ := loadOrders.Load("game")
+	assert.Equal(t, []string{"planets", "rockets", "core", "cheats"}, state.Order)
+
+	manifest.GameID = "other"
+	assert.Error(t, Import(context.Background(), target, manifest, ImportOptions{}))
+}
@@ -0,0 +1,163 @@
+package modpack
+
+import (
+	"TotalControl/backend/downloads"
+	"TotalControl/backend/loadorder"
+	"TotalControl/backend/mods"
+	"TotalControl/backend/profiles"
+	"TotalControl/backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FormatVersion is the manifest format written by this version of TotalControl.
+const FormatVersion = 1
+
+// Manifest describes a modpack: the enabled mods of a game with their exact versions, the load order and
+// optionally the mod settings.
+type Manifest struct {
+	Format      int        `json:"format"`
+	Name        string     `json:"name,omitempty"`
+	GameID      string     `json:"game_id"`
+	GameVersion string     `json:"game_version,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Mods        []ModEntry `json:"mods"`
+	LoadOrder   []string   `json:"load_order,omitempty"`
+	// Settings are the game's mod settings files by file name.
+	Settings map[string][]byte `json:"settings,omitempty"`
+}
+
+type ModEntry struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	// Source is where the mod can be downloaded, empty for the game's own catalogue.
+	Source   string              `json:"source,omitempty"`
+	Checksum *downloads.Checksum `json:"checksum,omitempty"`
+	// Bundled is the path of the mod's archive inside a bundle.
+	Bundled string `json:"bundled,omitempty"`
+}
+
+// ModFiles is implemented by providers that know which file holds an installed mod. Exports then carry checksums,
+// and bundles the archives themselves.
+type ModFiles interface {
+	ModFile(id string) (string, error)
+}
+
+// FileInstaller is implemented by providers that can install a mod from a local archive, which importing a bundle
+// needs.
+type FileInstaller interface {
+	InstallModFile(ctx context.Context, id string, version string, path string) error
+}
+
+type ExportOptions struct {
+	Name        string
+	GameVersion string
+	// Settings includes the mod settings files if the provider has any.
+	Settings bool
+	// LoadOrders is the store the load order is taken from, the default one if nil.
+	LoadOrders *loadorder.Store
+}
+
+// Export describes the enabled mods of a game as a modpack.
+func Export(ctx context.Context, provider mods.ModProvider, options ExportOptions) (*Manifest, error) {
+	installed, err := provider.InstalledMods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{
+		Format:      FormatVersion,
+		Name:        options.Name,
+		GameID:      provider.GameID(),
+		GameVersion: options.GameVersion,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+		Mods:        []ModEntry{},
+	}
+	files, hasFiles := provider.(ModFiles)
+	for _, mod := range installed {
+		if !mod.Enabled {
+			continue
+		}
+		entry := ModEntry{ID: mod.ID, Version: mod.Version, Source: mod.DownloadURL}
+		if hasFiles {
+			if path, err := files.ModFile(mod.ID); err == nil {
+				if info, err := os.Stat(path); err == nil && !info.IsDir() {
+					hash, err := utils.HashFile(path, utils.HashSHA1)
+					if err != nil {
+						return nil, fmt.Errorf("failed to hash %s: %w", mod.ID, err)
+					}
+					entry.Checksum = &downloads.Checksum{Algorithm: utils.HashSHA1, Value: hash}
+				}
+			}
+		}
+		manifest.Mods = append(manifest.Mods, entry)
+	}
+
+	loadOrders := options.LoadOrders
+	if loadOrders == nil {
+		loadOrders = loadorder.DefaultStore()
+	}
+	state, err := loadOrders.Load(manifest.GameID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range state.Order {
+		if manifest.find(id) != nil {
+			manifest.LoadOrder = append(manifest.LoadOrder, id)
+		}
+	}
+
+	if settingsFiles, ok := provider.(profiles.SettingsFiles); ok && options.Settings {
+		for _, path := range settingsFiles.ModSettingsFiles() {
+			data, err := os.ReadFile(path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if manifest.Settings == nil {
+				manifest.Settings = make(map[string][]byte)
+			}
+			manifest.Settings[filepath.Base(path)] = data
+		}
+	}
+	return manifest, nil
+}
+
+func (m *Manifest) find(id string) *ModEntry {
+	for i := range m.Mods {
+		if strings.EqualFold(m.Mods[i].ID, id) {
+			return &m.Mods[i]
+		}
+	}
+	return nil
+}
+
+// Validate checks a manifest read from a file or share code.
+func (m *Manifest) Validate() error {
+	if m.Format < 1 || m.Format > FormatVersion {
+		return fmt.Errorf("unsupported modpack format %d, update TotalControl to import it", m.Format)
+	}
+	if m.GameID == "" {
+		return errors.New("the modpack does not say which game it is for")
+	}
+	for _, entry := range m.Mods {
+		if entry.ID == "" {
+			return errors.New("the modpack contains a mod without ID")
+		}
+		if entry.Bundled != "" && !filepath.IsLocal(entry.Bundled) {
+			return fmt.Errorf("mod %s has an invalid bundle path %q", entry.ID, entry.Bundled)
+		}
+	}
+	for name := range m.Settings {
+		if filepath.Base(name) != name || !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid settings file name %q", name)
+		}
+	}
+	return nil
+}
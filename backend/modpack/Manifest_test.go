@@ -0,0 +1,161 @@
+package modpack
+
+import (
+	"TotalControl/backend/dependencies"
+	"TotalControl/backend/loadorder"
+	"TotalControl/backend/mods"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testProvider keeps the installed mods in memory and their archives in a directory.
+type testProvider struct {
+	dir       string
+	installed []mods.Mod
+	catalogue map[string]string
+	running   bool
+}
+
+func (p *testProvider) GameID() string { return "game" }
+func (p *testProvider) Capabilities() mods.Capabilities {
+	return mods.Capabilities{Install: true, Toggle: true}
+}
+func (p *testProvider) InstalledMods(ctx context.Context) ([]mods.Mod, error) {
+	return append([]mods.Mod(nil), p.installed...), nil
+}
+func (p *testProvider) SearchMods(ctx context.Context, query mods.SearchQuery) ([]mods.Mod, error) {
+	return nil, mods.ErrNotSupported
+}
+func (p *testProvider) GetMod(ctx context.Context, id string) (*mods.Mod, error) {
+	return nil, mods.ErrNotSupported
+}
+func (p *testProvider) InstallMod(ctx context.Context, id string, version string) (*mods.Mod, error) {
+	if p.catalogue[id] != version {
+		return nil, mods.ErrModNotFound
+	}
+	return p.add(id, version, []byte(id+" from the catalogue"))
+}
+func (p *testProvider) UninstallMod(ctx context.Context, id string) error {
+	return mods.ErrNotSupported
+}
+func (p *testProvider) SetModEnabled(ctx context.Context, id string, enabled bool) error {
+	mod, ok := mods.FindMod(p.installed, id)
+	if !ok {
+		return mods.ErrModNotFound
+	}
+	mod.Enabled = enabled
+	return nil
+}
+func (p *testProvider) UpdateMod(ctx context.Context, id string, version string) (*mods.Mod, error) {
+	return p.InstallMod(ctx, id, version)
+}
+func (p *testProvider) IsGameRunning(ctx context.Context) (bool, error) { return p.running, nil }
+func (p *testProvider) ModSettingsFiles() []string {
+	return []string{filepath.Join(p.dir, "mod-settings.dat")}
+}
+func (p *testProvider) ModFile(id string) (string, error) {
+	if _, ok := mods.FindMod(p.installed, id); !ok {
+		return "", mods.ErrModNotFound
+	}
+	return filepath.Join(p.dir, id+".zip"), nil
+}
+func (p *testProvider) InstallModFile(ctx context.Context, id string, version string, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = p.add(id, version, content)
+	return err
+}
+
+func (p *testProvider) add(id string, version string, content []byte) (*mods.Mod, error) {
+	if err := os.WriteFile(filepath.Join(p.dir, id+".zip"), content, 0644); err != nil {
+		return nil, err
+	}
+	p.installed = append(slicesDeleteMod(p.installed, id), mods.Mod{ID: id, Version: version, Enabled: true})
+	return &p.installed[len(p.installed)-1], nil
+}
+
+func slicesDeleteMod(list []mods.Mod, id string) []mods.Mod {
+	var result []mods.Mod
+	for _, mod := range list {
+		if mod.ID != id {
+			result = append(result, mod)
+		}
+	}
+	return result
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	provider := &testProvider{dir: t.TempDir(), catalogue: map[string]string{"rockets": "1.1.0"}}
+	_, _ = provider.add("core", "1.0.0", []byte("core archive"))
+	_, _ = provider.add("rockets", "1.0.0", []byte("rockets archive"))
+	_, _ = provider.add("cheats", "0.1.0", []byte("cheats archive"))
+	_ = provider.SetModEnabled(context.Background(), "cheats", false)
+	assert.NoError(t, os.WriteFile(provider.ModSettingsFiles()[0], []byte("settings"), 0644))
+	return provider
+}
+
+func TestExport(t *testing.T) {
+	provider := newTestProvider(t)
+	loadOrders := loadorder.NewStore(t.TempDir())
+	enabled := []dependencies.Release{{ModID: "core", Version: "1.0.0"}, {ModID: "rockets", Version: "1.0.0"}}
+	_, err := loadOrders.Restore(context.Background(), "game", []string{"rockets", "cheats", "core"}, enabled, nil)
+	assert.NoError(t, err)
+
+	manifest, err := Export(context.Background(), provider, ExportOptions{Name: "Pack", GameVersion: "2.0.28", Settings: true, LoadOrders: loadOrders})
+	assert.NoError(t, err)
+	assert.Equal(t, "game", manifest.GameID)
+	assert.Equal(t, []string{"rockets", "core"}, manifest.LoadOrder)
+	assert.Equal(t, map[string][]byte{"mod-settings.dat": []byte("settings")}, manifest.Settings)
+	if assert.Len(t, manifest.Mods, 2) {
+		assert.Equal(t, "core", manifest.Mods[0].ID)
+		assert.Equal(t, "1.0.0", manifest.Mods[0].Version)
+		assert.Equal(t, "sha1", manifest.Mods[0].Checksum.Algorithm)
+	}
+}
+
+func TestShareCode(t *testing.T) {
+	manifest, err := Export(context.Background(), newTestProvider(t), ExportOptions{LoadOrders: loadorder.NewStore(t.TempDir())})
+	assert.NoError(t, err)
+
+	code, err := EncodeShareCode(manifest)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(code, "0"))
+	decoded, err := DecodeShareCode(" " + code[:20] + "\n" + code[20:] + "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, manifest, decoded)
+
+	for _, invalid := range []string{"", "1abc", "0not base64!", "0" + "aGVsbG8="} {
+		_, err := DecodeShareCode(invalid)
+		assert.ErrorIs(t, err, ErrInvalidShareCode, invalid)
+	}
+	manifest.Mods[0].Bundled = "mods/core.zip"
+	_, err = EncodeShareCode(manifest)
+	assert.Error(t, err)
+}
+
+func TestBundle(t *testing.T) {
+	provider := newTestProvider(t)
+	manifest, err := Export(context.Background(), provider, ExportOptions{LoadOrders: loadorder.NewStore(t.TempDir())})
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "pack"+Extension)
+	assert.NoError(t, WriteBundle(path, manifest, provider))
+	assert.Empty(t, manifest.Mods[0].Bundled, "the original manifest is not changed")
+
+	bundle, err := OpenBundle(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer bundle.Close()
+	assert.Equal(t, "mods/core.zip", bundle.Manifest.Mods[0].Bundled)
+	archive, err := bundle.extract(bundle.Manifest.Mods[0], t.TempDir())
+	assert.NoError(t, err)
+	content, _ := os.ReadFile(archive)
+	assert.Equal(t, "core archive", string(content))
+}
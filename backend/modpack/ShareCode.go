@@ -0,0 +1,75 @@
+package modpack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// shareCodeVersion prefixes every share code, like the version byte of Factorio blueprint strings.
+const shareCodeVersion = "0"
+
+// maxShareCodeSize limits the decompressed manifest, a pasted code must not exhaust memory.
+const maxShareCodeSize = 16 << 20
+
+var ErrInvalidShareCode = errors.New("invalid share code")
+
+// EncodeShareCode turns a manifest into a string that fits in a chat message: a version character followed by the
+// zlib compressed JSON in base64. Bundled archives cannot be shared this way.
+func EncodeShareCode(manifest *Manifest) (string, error) {
+	for _, entry := range manifest.Mods {
+		if entry.Bundled != "" {
+			return "", fmt.Errorf("mod %s is bundled, share the bundle file instead", entry.ID)
+		}
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	var compressed bytes.Buffer
+	writer, err := zlib.NewWriterLevel(&compressed, zlib.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return shareCodeVersion + base64.StdEncoding.EncodeToString(compressed.Bytes()), nil
+}
+
+// DecodeShareCode reads a manifest from a share code. Whitespace, e.g. from line breaks in a chat, is ignored.
+func DecodeShareCode(code string) (*Manifest, error) {
+	code = strings.Join(strings.Fields(code), "")
+	if !strings.HasPrefix(code, shareCodeVersion) {
+		return nil, fmt.Errorf("%w: unknown version", ErrInvalidShareCode)
+	}
+	compressed, err := base64.StdEncoding.DecodeString(code[len(shareCodeVersion):])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidShareCode, err)
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidShareCode, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, maxShareCodeSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidShareCode, err)
+	}
+	if len(data) > maxShareCodeSize {
+		return nil, fmt.Errorf("%w: too large", ErrInvalidShareCode)
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidShareCode, err)
+	}
+	return manifest, manifest.Validate()
+}
@@ -0,0 +1,51 @@
+package mods
+
+import (
+	"TotalControl/backend/version"
+	log "github.com/sirupsen/logrus"
+)
+
+// IsCompatibleWith reports whether the mod supports gameVersion. Each GameVersion.Version is a version
+// constraint, a plain "2.0" matches every 2.0.x. Mods that declare no game versions are assumed compatible.
+func (m *Mod) IsCompatibleWith(gameVersion string) bool {
+	if len(m.GameVersions) == 0 {
+		return true
+	}
+	game, err := version.Parse(gameVersion)
+	if err != nil {
+		log.Warnf("Cannot check compatibility of %s with invalid game version %q: %v", m.ID, gameVersion, err)
+		return false
+	}
+	for _, supported := range m.GameVersions {
+		constraint, err := version.ParseConstraint(supported.Version)
+		if err != nil {
+			log.Warnf("Mod %s declares invalid game version %q: %v", m.ID, supported.Version, err)
+			continue
+		}
+		if constraint.Check(game) {
+			return true
+		}
+	}
+	return false
+}
+
+// ModVersionFor returns the newest mod version that supports gameVersion, or "" if there is none.
+func (m *Mod) ModVersionFor(gameVersion string) string {
+	game, err := version.Parse(gameVersion)
+	if err != nil {
+		return ""
+	}
+	var candidates []string
+	for _, supported := range m.GameVersions {
+		constraint, err := version.ParseConstraint(supported.Version)
+		if err == nil && constraint.Check(game) {
+			candidates = append(candidates, supported.ModVersion)
+		}
+	}
+	return version.Latest(candidates, nil)
+}
+
+// IsUpdateAvailable reports whether latestVersion is newer than the installed version.
+func (m *Mod) IsUpdateAvailable(latestVersion string) bool {
+	return version.IsNewer(latestVersion, m.Version)
+}
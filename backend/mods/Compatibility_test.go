@@ -0,0 +1,28 @@
+package mods
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMod_IsCompatibleWith(t *testing.T) {
+	mod := &Mod{
+		ID:      "helmod",
+		Version: "1.1.9",
+		GameVersions: []GameVersion{
+			{Version: "1.1", ModVersion: "1.1.9"},
+			{Version: "2.0", ModVersion: "2.2.12"},
+		},
+	}
+	assert.True(t, mod.IsCompatibleWith("1.1.110"))
+	assert.True(t, mod.IsCompatibleWith("2.0.28"))
+	assert.False(t, mod.IsCompatibleWith("0.18.47"))
+	assert.False(t, mod.IsCompatibleWith("invalid"))
+	assert.True(t, (&Mod{ID: "any"}).IsCompatibleWith("2.0"))
+
+	assert.Equal(t, "2.2.12", mod.ModVersionFor("2.0.28"))
+	assert.Equal(t, "", mod.ModVersionFor("0.17"))
+
+	assert.True(t, mod.IsUpdateAvailable("1.1.10"))
+	assert.False(t, mod.IsUpdateAvailable("1.1.9"))
+}
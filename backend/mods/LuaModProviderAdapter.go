@@ -0,0 +1,162 @@
+package mods
+
+import (
+	"context"
+	lua "github.com/yuin/gopher-lua"
+	"sync"
+)
+
+// LuaModProviderAdapter makes a LuaModProvider usable as a ModProvider. The script's plugin functions take no
+// self argument and have no catalogue search, ListGameMods is filtered locally instead.
+type LuaModProviderAdapter struct {
+	provider *LuaModProvider
+	gameID   string
+	// mu serializes access to the provider's Lua state, which is not safe for concurrent use.
+	mu sync.Mutex
+}
+
+var _ ModProvider = (*LuaModProviderAdapter)(nil)
+
+func NewLuaModProviderAdapter(provider *LuaModProvider) *LuaModProviderAdapter {
+	adapter := &LuaModProviderAdapter{provider: provider}
+	if adapter.hasMethod("GetGameID") {
+		adapter.gameID, _ = provider.GetGameID()
+	}
+	return adapter
+}
+
+func (a *LuaModProviderAdapter) hasMethod(method string) bool {
+	return a.provider.L.GetField(a.provider.plugin, method).Type() == lua.LTFunction
+}
+
+func (a *LuaModProviderAdapter) wrap(op string, id string, err error) error {
+	return NewProviderError("lua:"+a.gameID, op, id, err)
+}
+
+func (a *LuaModProviderAdapter) GameID() string {
+	return a.gameID
+}
+
+func (a *LuaModProviderAdapter) Capabilities() Capabilities {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return Capabilities{
+		Search:    a.hasMethod("ListGameMods"),
+		Install:   a.hasMethod("AddMod"),
+		Uninstall: a.hasMethod("RemoveMod"),
+		Toggle:    a.hasMethod("UpdateMod"),
+		Update:    a.hasMethod("UpdateMod"),
+	}
+}
+
+func (a *LuaModProviderAdapter) InstalledMods(ctx context.Context) ([]Mod, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list, err := a.provider.GetMods()
+	if err != nil {
+		return nil, a.wrap("list installed mods", "", err)
+	}
+	return list, nil
+}
+
+func (a *LuaModProviderAdapter) SearchMods(ctx context.Context, query SearchQuery) ([]Mod, error) {
+	if !a.Capabilities().Search {
+		return nil, a.wrap("search mods", "", ErrNotSupported)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list, err := a.provider.ListGameMods()
+	if err != nil {
+		return nil, a.wrap("search mods", "", err)
+	}
+	return FilterMods(list, query), nil
+}
+
+func (a *LuaModProviderAdapter) GetMod(ctx context.Context, id string) (*Mod, error) {
+	installed, err := a.InstalledMods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mod, ok := FindMod(installed, id); ok {
+		return mod, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.hasMethod("GetModByID") {
+		return nil, a.wrap("get mod", id, ErrModNotFound)
+	}
+	mod, err := a.provider.GetModByID(id)
+	if err != nil {
+		return nil, a.wrap("get mod", id, err)
+	}
+	if mod == nil {
+		return nil, a.wrap("get mod", id, ErrModNotFound)
+	}
+	return mod, nil
+}
+
+func (a *LuaModProviderAdapter) InstallMod(ctx context.Context, id string, version string) (*Mod, error) {
+	if !a.Capabilities().Install {
+		return nil, a.wrap("install mod", id, ErrNotSupported)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	err := a.provider.AddMod(Mod{ID: id, Version: version, Enabled: true, GameID: a.gameID})
+	a.mu.Unlock()
+	if err != nil {
+		return nil, a.wrap("install mod", id, err)
+	}
+	return a.GetMod(ctx, id)
+}
+
+func (a *LuaModProviderAdapter) UninstallMod(ctx context.Context, id string) error {
+	if !a.Capabilities().Uninstall {
+		return a.wrap("uninstall mod", id, ErrNotSupported)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.wrap("uninstall mod", id, a.provider.RemoveMod(id))
+}
+
+func (a *LuaModProviderAdapter) SetModEnabled(ctx context.Context, id string, enabled bool) error {
+	if !a.Capabilities().Toggle {
+		return a.wrap("enable mod", id, ErrNotSupported)
+	}
+	mod, err := a.GetMod(ctx, id)
+	if err != nil {
+		return err
+	}
+	mod.Enabled = enabled
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.wrap("enable mod", id, a.provider.UpdateMod(*mod))
+}
+
+func (a *LuaModProviderAdapter) UpdateMod(ctx context.Context, id string, version string) (*Mod, error) {
+	if !a.Capabilities().Update {
+		return nil, a.wrap("update mod", id, ErrNotSupported)
+	}
+	mod, err := a.GetMod(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	mod.Version = version
+	a.mu.Lock()
+	err = a.provider.UpdateMod(*mod)
+	a.mu.Unlock()
+	if err != nil {
+		return nil, a.wrap("update mod", id, err)
+	}
+	return a.GetMod(ctx, id)
+}
@@ -1,10 +1,126 @@
 package mods
 
 import (
+	"TotalControl/backend/images"
+	"context"
 	"errors"
+	"fmt"
 	lua "github.com/yuin/gopher-lua"
+	"strings"
 )
 
+var (
+	ErrModNotFound      = errors.New("mod not found")
+	ErrNotSupported     = errors.New("not supported by this provider")
+	ErrAlreadyInstalled = errors.New("mod is already installed")
+	ErrGameRunning      = errors.New("the game is running")
+	// ErrNoImage is returned by Mod.Thumbnail for mods without an image.
+	ErrNoImage = errors.New("mod has no image")
+)
+
+// ModProvider manages the mods of one game, whether it is implemented in Go or by a Lua plugin. Methods return
+// errors wrapped in a ProviderError, use errors.Is to check for ErrModNotFound or ErrNotSupported.
+type ModProvider interface {
+	GameID() string
+	// Capabilities tells the UI which operations the provider supports. The others return ErrNotSupported.
+	Capabilities() Capabilities
+	InstalledMods(ctx context.Context) ([]Mod, error)
+	// SearchMods searches the game's remote mod catalogue.
+	SearchMods(ctx context.Context, query SearchQuery) ([]Mod, error)
+	// GetMod returns an installed mod, or the catalogue entry if the mod is not installed.
+	GetMod(ctx context.Context, id string) (*Mod, error)
+	// InstallMod installs a mod from the catalogue. An empty version installs the latest one.
+	InstallMod(ctx context.Context, id string, version string) (*Mod, error)
+	UninstallMod(ctx context.Context, id string) error
+	SetModEnabled(ctx context.Context, id string, enabled bool) error
+	// UpdateMod replaces an installed mod with another version, the latest one if version is empty.
+	UpdateMod(ctx context.Context, id string, version string) (*Mod, error)
+}
+
+// GameProcess is implemented by providers that can tell whether their game is running. Changes that the game
+// would overwrite on exit, like switching profiles, are refused with ErrGameRunning while it runs.
+type GameProcess interface {
+	IsGameRunning(ctx context.Context) (bool, error)
+}
+
+type Capabilities struct {
+	Search    bool `json:"search"`
+	Install   bool `json:"install"`
+	Uninstall bool `json:"uninstall"`
+	Toggle    bool `json:"toggle"`
+	Update    bool `json:"update"`
+	// LoadOrder means the provider writes the load order to the game's own format.
+	LoadOrder bool `json:"load_order"`
+}
+
+type SearchQuery struct {
+	// Text is matched against the ID, name and description, everything matches if it is empty.
+	Text string `json:"text,omitempty"`
+	// GameVersion only returns mods compatible with this game version.
+	GameVersion string `json:"game_version,omitempty"`
+	// Limit caps the number of results, zero means no limit.
+	Limit int `json:"limit,omitempty"`
+}
+
+// ProviderError is returned by ModProvider implementations and says which provider and operation failed.
+type ProviderError struct {
+	Provider string
+	Op       string
+	ModID    string
+	Err      error
+}
+
+func (e *ProviderError) Error() string {
+	if e.ModID != "" {
+		return fmt.Sprintf("%s: %s %s: %v", e.Provider, e.Op, e.ModID, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Provider, e.Op, e.Err)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// NewProviderError wraps err in a ProviderError, unless it is nil or already one.
+func NewProviderError(provider string, op string, modID string, err error) error {
+	var providerErr *ProviderError
+	if err == nil || errors.As(err, &providerErr) {
+		return err
+	}
+	return &ProviderError{Provider: provider, Op: op, ModID: modID, Err: err}
+}
+
+// FilterMods applies a query to a list of mods, for providers whose catalogue cannot be searched remotely.
+func FilterMods(list []Mod, query SearchQuery) []Mod {
+	text := strings.ToLower(strings.TrimSpace(query.Text))
+	var result []Mod
+	for _, mod := range list {
+		if query.Limit > 0 && len(result) >= query.Limit {
+			break
+		}
+		if text != "" && !strings.Contains(strings.ToLower(mod.ID), text) &&
+			!strings.Contains(strings.ToLower(mod.Name), text) &&
+			!strings.Contains(strings.ToLower(mod.Description), text) {
+			continue
+		}
+		if query.GameVersion != "" && !mod.IsCompatibleWith(query.GameVersion) {
+			continue
+		}
+		result = append(result, mod)
+	}
+	return result
+}
+
+// FindMod returns the mod with the given ID, ignoring case.
+func FindMod(list []Mod, id string) (*Mod, bool) {
+	for i := range list {
+		if strings.EqualFold(list[i].ID, id) {
+			return &list[i], true
+		}
+	}
+	return nil, false
+}
+
 type GameVersion struct {
 	Version    string `json:"version"`
 	ModVersion string `json:"mod_version"`
@@ -89,6 +205,20 @@ func NewModFromLuaTable(modTable *lua.LTable) (*Mod, error) {
 		enabled = bool(modTable.RawGetString("enabled").(lua.LBool))
 	}
 
+	var dependencies []string
+	if dependencyTable, ok := modTable.RawGetString("dependencies").(*lua.LTable); ok {
+		dependencyTable.ForEach(func(_ lua.LValue, value lua.LValue) {
+			if value.Type() == lua.LTString {
+				dependencies = append(dependencies, value.String())
+			}
+		})
+	}
+
+	var image []byte
+	if imageValue, ok := modTable.RawGetString("image").(lua.LString); ok {
+		image = []byte(imageValue)
+	}
+
 	mod := &Mod{
 		ID:           modTable.RawGetString("id").String(),
 		Name:         modTable.RawGetString("name").String(),
@@ -96,14 +226,23 @@ func NewModFromLuaTable(modTable *lua.LTable) (*Mod, error) {
 		Author:       modTable.RawGetString("author").String(),
 		Version:      modTable.RawGetString("version").String(),
 		Enabled:      enabled,
-		Dependencies: nil,
+		Dependencies: dependencies,
 		DownloadURL:  "",
 		IconURL:      "",
 		HeaderImage:  "",
 		GameVersions: gameVersions,
-		Image:        nil,
+		Image:        image,
 		GameID:       modTable.RawGetString("game_id").String(),
 	}
 
 	return mod, nil
 }
+
+// Thumbnail returns Image scaled to ModThumbnailSize, so mods of every game look the same in the UI. It is
+// generated when first asked for, listing mods never touches the cache.
+func (m *Mod) Thumbnail(cache *images.ThumbnailCache) (*images.Thumbnail, error) {
+	if len(m.Image) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoImage, m.ID)
+	}
+	return cache.Get(m.Image, images.ModThumbnailSize, images.ModThumbnailSize)
+}
@@ -0,0 +1,115 @@
+package mods
+
+import (
+	"TotalControl/backend/images"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"image"
+	"os"
+	"testing"
+)
+
+func TestNewModFromLuaTable_Dependencies(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	assert.NoError(t, L.DoString(`mod = { id = "a", name = "A", version = "1.0.0", game_id = "factorio",
+		dependencies = { "base >= 2.0", "! bobs" } }`))
+
+	mod, err := NewModFromLuaTable(L.GetGlobal("mod").(*lua.LTable))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"base >= 2.0", "! bobs"}, mod.Dependencies)
+}
+
+func TestMod_Thumbnail(t *testing.T) {
+	source, err := images.Encode(image.NewNRGBA(image.Rect(0, 0, 288, 144)), images.FormatPNG)
+	assert.NoError(t, err)
+	L := lua.NewState()
+	defer L.Close()
+	L.SetGlobal("image", lua.LString(source))
+	assert.NoError(t, L.DoString(`mod = { id = "a", name = "A", version = "1.0.0", game_id = "factorio", image = image }`))
+
+	mod, err := NewModFromLuaTable(L.GetGlobal("mod").(*lua.LTable))
+	assert.NoError(t, err)
+	assert.Equal(t, source, mod.Image)
+
+	dir := t.TempDir()
+	thumbnail, err := mod.Thumbnail(images.NewThumbnailCache(dir))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, thumbnail.DataURL)
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	_, err = (&Mod{ID: "b"}).Thumbnail(images.NewThumbnailCache(dir))
+	assert.ErrorIs(t, err, ErrNoImage)
+}
+
+func TestFilterMods(t *testing.T) {
+	list := []Mod{
+		{ID: "helmod", Name: "Helmod", GameVersions: []GameVersion{{Version: "2.0", ModVersion: "2.2.12"}}},
+		{ID: "krastorio2", Name: "Krastorio 2", Description: "Overhaul", GameVersions: []GameVersion{{Version: "1.1"}}},
+		{ID: "even-distribution", Name: "Even Distribution"},
+	}
+
+	assert.Len(t, FilterMods(list, SearchQuery{}), 3)
+	assert.Len(t, FilterMods(list, SearchQuery{Limit: 2}), 2)
+
+	found := FilterMods(list, SearchQuery{Text: "OVERHAUL"})
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "krastorio2", found[0].ID)
+	}
+
+	// Mods without game versions are compatible with everything
+	found = FilterMods(list, SearchQuery{GameVersion: "2.0.28"})
+	if assert.Len(t, found, 2) {
+		assert.Equal(t, "helmod", found[0].ID)
+		assert.Equal(t, "even-distribution", found[1].ID)
+	}
+}
+
+func TestNewProviderError(t *testing.T) {
+	assert.NoError(t, NewProviderError("factorio", "get mod", "helmod", nil))
+
+	err := NewProviderError("factorio", "get mod", "helmod", ErrModNotFound)
+	assert.True(t, errors.Is(err, ErrModNotFound))
+	assert.EqualError(t, err, "factorio: get mod helmod: mod not found")
+
+	// Errors that already say where they come from are not wrapped twice
+	assert.Same(t, err, NewProviderError("other", "install mod", "helmod", err))
+}
+
+func TestLuaModProviderAdapter(t *testing.T) {
+	provider := setupLuaModProvider(t, `
+		local enabled = { mod1 = true }
+		plugin = {
+			GetGameID = function() return "game1" end,
+			GetInstalledMods = function()
+				return {
+					{ id = "mod1", name = "Test Mod", enabled = tostring(enabled.mod1), game_id = "game1" },
+				}
+			end,
+			UpdateMod = function(mod)
+				enabled[mod.id] = mod.enabled == "true"
+			end,
+		}
+	`)
+	defer provider.Close()
+	adapter := NewLuaModProviderAdapter(provider)
+	ctx := context.Background()
+
+	assert.Equal(t, "game1", adapter.GameID())
+	assert.Equal(t, Capabilities{Toggle: true, Update: true}, adapter.Capabilities())
+
+	assert.NoError(t, adapter.SetModEnabled(ctx, "mod1", false))
+	mod, err := adapter.GetMod(ctx, "mod1")
+	if assert.NoError(t, err) {
+		assert.False(t, mod.Enabled)
+	}
+
+	_, err = adapter.GetMod(ctx, "missing")
+	assert.ErrorIs(t, err, ErrModNotFound)
+	_, err = adapter.SearchMods(ctx, SearchQuery{})
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
@@ -0,0 +1,128 @@
+package factorio
+
+import (
+	"TotalControl/backend/install"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ModListFile is the file in the mods directory in which Factorio stores which mods are enabled.
+const ModListFile = "mod-list.json"
+
+type ModListEntry struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// Version pins the mod to a version if several are installed.
+	Version string `json:"version,omitempty"`
+}
+
+type ModList struct {
+	Mods []ModListEntry `json:"mods"`
+}
+
+// ReadModList reads a mod-list.json. A missing file is an empty list, Factorio creates it on the next start.
+func ReadModList(path string) (*ModList, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &ModList{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list ModList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Save writes the list the way Factorio does and replaces the file atomically, so the game never reads half of it.
+func (l *ModList) Save(path string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Find returns the entry of a mod, mod names are compared ignoring case.
+func (l *ModList) Find(name string) *ModListEntry {
+	for i := range l.Mods {
+		if strings.EqualFold(l.Mods[i].Name, name) {
+			return &l.Mods[i]
+		}
+	}
+	return nil
+}
+
+// SetEnabled enables or disables a mod, adding it to the list if necessary.
+func (l *ModList) SetEnabled(name string, enabled bool) {
+	if entry := l.Find(name); entry != nil {
+		entry.Enabled = enabled
+		return
+	}
+	l.Mods = append(l.Mods, ModListEntry{Name: name, Enabled: enabled})
+}
+
+// Remove deletes a mod from the list and reports whether it was listed.
+func (l *ModList) Remove(name string) bool {
+	for i := range l.Mods {
+		if strings.EqualFold(l.Mods[i].Name, name) {
+			l.Mods = append(l.Mods[:i], l.Mods[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// EnabledList lets the install pipeline update mod-list.json.
+type EnabledList struct {
+	path string
+	// versions changes the version pins of mods that are enabled or disabled.
+	versions map[string]string
+}
+
+var _ install.EnabledList = (*EnabledList)(nil)
+
+func NewEnabledList(path string) *EnabledList {
+	return &EnabledList{path: path}
+}
+
+func (l *EnabledList) Path() string {
+	return l.path
+}
+
+func (l *EnabledList) Update(ctx context.Context, enable map[string]bool, forget []string) error {
+	list, err := ReadModList(l.path)
+	if err != nil {
+		return err
+	}
+	// Sorted, so newly added mods end up in the same order every time
+	names := make([]string, 0, len(enable))
+	for name := range enable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		list.SetEnabled(name, enable[name])
+		if pinned, ok := l.versions[name]; ok {
+			list.Find(name).Version = pinned
+		}
+	}
+	for _, name := range forget {
+		list.Remove(name)
+	}
+	return list.Save(l.path)
+}
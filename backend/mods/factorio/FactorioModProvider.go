@@ -1,48 +1,130 @@
 package factorio
 
 import (
+	"TotalControl/backend/install"
 	"TotalControl/backend/mods"
 	"TotalControl/backend/utils"
+	"TotalControl/backend/version"
+	"archive/zip"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"io"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
 type FactorioModProvider struct {
+	// Installer carries out installs, the default pipeline if nil.
+	Installer *install.Pipeline
 }
 
+// ReadModInfo reads info.json and thumbnail.png of a mod zip or unpacked mod folder.
 func (modProvider *FactorioModProvider) ReadModInfo(modPath string) (*ModInfo, error) {
-	if filepath.Ext(modPath) != ".zip" {
-		return nil, errors.New("mod file must be a .zip file")
-	}
-	// Factorio mods typically have a control.lua or info.json file inside the zip.
-	modZip, err := utils.NewFile(modPath)
+	stat, err := os.Stat(modPath)
 	if err != nil {
 		return nil, err
 	}
-	files, err := modZip.GetZipFileContents([]string{"info.json", "thumbnail.png"})
+	var files map[string][]byte
+	if stat.IsDir() {
+		files, err = readModFolder(modPath)
+	} else if filepath.Ext(modPath) == ".zip" {
+		files, err = readModZip(modPath)
+	} else {
+		return nil, errors.New("mod file must be a .zip file or a folder")
+	}
 	if err != nil {
 		return nil, err
 	}
-	var infoFile *utils.File
-	var ok bool
-	if infoFile, ok = files["info.json"]; !ok {
+	infoFile, ok := files["info.json"]
+	if !ok {
 		return nil, errors.New("info.json not found in " + modPath + ", is this a valid Factorio mod?")
 	}
 
 	var mod ModInfo
-	if err := json.Unmarshal(infoFile.Contents, &mod); err != nil {
+	if err := json.Unmarshal(infoFile, &mod); err != nil {
 		return nil, err
 	}
+	if mod.Name == "" || mod.Version == "" {
+		return nil, errors.New("info.json in " + modPath + " has no name or version")
+	}
+	mod.Image = files["thumbnail.png"]
+	return &mod, nil
+}
 
-	if thumbnail, ok := files["thumbnail.png"]; ok {
-		mod.Image = thumbnail.Contents
+// modMetadataFiles are the files ReadModInfo reads from a mod.
+var modMetadataFiles = []string{"info.json", "thumbnail.png"}
+
+// maxZipEntry limits the size of the metadata files and previews read from mods and saves, which are rarely
+// larger than a few hundred KB.
+const maxZipEntry = 16 << 20
+
+// readModZip reads the metadata files of a zip. Factorio expects them in a single top-level folder, which is
+// usually but not always named like the zip.
+func readModZip(zipPath string) (map[string][]byte, error) {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	files := make(map[string][]byte)
+	for _, file := range reader.File {
+		folder, name := path.Split(file.Name)
+		if strings.Count(folder, "/") > 1 || !slices.Contains(modMetadataFiles, name) {
+			continue
+		}
+		if _, ok := files[name]; ok {
+			continue
+		}
+		content, err := readZipFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+		files[name] = content
 	}
+	return files, nil
+}
 
-	return &mod, nil
+// readZipFile reads a file of at most maxZipEntry bytes from a zip.
+func readZipFile(file *zip.File) ([]byte, error) {
+	if file.UncompressedSize64 > maxZipEntry {
+		return nil, fmt.Errorf("%s is larger than %d bytes", file.Name, maxZipEntry)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	// The size in the zip header is not to be trusted
+	content, err := io.ReadAll(io.LimitReader(reader, maxZipEntry+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxZipEntry {
+		return nil, fmt.Errorf("%s is larger than %d bytes", file.Name, maxZipEntry)
+	}
+	return content, nil
+}
+
+func readModFolder(folder string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for _, name := range modMetadataFiles {
+		content, err := os.ReadFile(filepath.Join(folder, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[name] = content
+	}
+	return files, nil
 }
 
 func (modProvider *FactorioModProvider) GetGameModDirectory() string {
@@ -65,124 +147,399 @@ func (modProvider *FactorioModProvider) GetGameModDirectory() string {
 	panic("Unsupported OS: " + utils.GetOperatingSystem())
 }
 
-func (modProvider *FactorioModProvider) GetModFile(modID string) (string, error) {
-	// The gamemods contain versions.... Shortcuts-ick_2.0.7.zip so we need to remove the version part.
+// modFile is a mod in the mods directory, either <name>_<version>.zip or an unpacked folder named <name> or
+// <name>_<version>.
+type modFile struct {
+	Name string
+	// Version is empty for unversioned folders, it is only known from their info.json.
+	Version string
+	Path    string
+}
+
+// parseModFileName splits a file name from the mods directory into the mod name and version. Mod names may
+// contain underscores themselves, so only a valid version after the last one counts.
+func parseModFileName(fileName string, isDir bool) (name string, modVersion string, ok bool) {
+	base := fileName
+	if !isDir {
+		if !strings.EqualFold(filepath.Ext(fileName), ".zip") {
+			return "", "", false
+		}
+		base = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	}
+	if i := strings.LastIndex(base, "_"); i > 0 {
+		if _, err := version.Parse(base[i+1:]); err == nil {
+			return base[:i], base[i+1:], true
+		}
+	}
+	// Zips must carry the version, Factorio refuses to load them otherwise
+	if !isDir || strings.HasPrefix(base, ".") {
+		return "", "", false
+	}
+	return base, "", true
+}
+
+// modFiles lists the mods in the mods directory by their file names, grouped by lowercase mod name with the
+// newest version first.
+func (modProvider *FactorioModProvider) modFiles() (map[string][]modFile, error) {
 	modDirectory := modProvider.GetGameModDirectory()
-	filesInDir, err := os.ReadDir(modDirectory)
+	entries, err := os.ReadDir(modDirectory)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	for _, file := range filesInDir {
-		if file.IsDir() {
-			continue // Skip directories
+	found := make(map[string][]modFile)
+	for _, entry := range entries {
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 {
+			// Symlinked folders are how mod developers usually work on their mods
+			if stat, err := os.Stat(filepath.Join(modDirectory, entry.Name())); err == nil {
+				isDir = stat.IsDir()
+			}
 		}
-		if file.Name() == modID || file.Name() == modID+".zip" {
-			return filepath.Join(modDirectory, file.Name()), nil
+		name, modVersion, ok := parseModFileName(entry.Name(), isDir)
+		if !ok {
+			continue
 		}
-		// Check if the file name starts with the mod ID and ends with .zip
-		if len(file.Name()) > len(modID)+4 && file.Name()[:len(modID)] == modID && file.Name()[len(file.Name())-4:] == ".zip" {
-			return filepath.Join(modDirectory, file.Name()), nil
+		key := strings.ToLower(name)
+		found[key] = append(found[key], modFile{Name: name, Version: modVersion, Path: filepath.Join(modDirectory, entry.Name())})
+	}
+	for _, files := range found {
+		slices.SortStableFunc(files, func(a, b modFile) int {
+			order, err := version.Compare(b.Version, a.Version)
+			if err != nil {
+				// Unversioned folders sort last
+				return strings.Compare(b.Version, a.Version)
+			}
+			return order
+		})
+	}
+	return found, nil
+}
+
+// unlistedMods returns the sorted names of the installed mods without a mod-list.json entry. list may be nil.
+func unlistedMods(found map[string][]modFile, list *ModList) []string {
+	var names []string
+	for _, files := range found {
+		if list == nil || list.Find(files[0].Name) == nil {
+			names = append(names, files[0].Name)
 		}
 	}
-	return "", errors.New("mod file not found: " + modID)
+	slices.SortFunc(names, func(a, b string) int { return strings.Compare(strings.ToLower(a), strings.ToLower(b)) })
+	return names
+}
+
+// activeFile picks the version Factorio loads: the one pinned in mod-list.json if it is installed, otherwise
+// the newest.
+func activeFile(files []modFile, entry *ModListEntry) modFile {
+	if entry != nil && entry.Version != "" {
+		for _, file := range files {
+			if file.Version == entry.Version {
+				return file
+			}
+		}
+	}
+	return files[0]
+}
+
+// GetModFile returns the zip or folder of the version of a mod that Factorio loads. IDs are lowercased in
+// GetMods, so the case is ignored.
+func (modProvider *FactorioModProvider) GetModFile(modID string) (string, error) {
+	found, err := modProvider.modFiles()
+	if err != nil {
+		return "", err
+	}
+	files, ok := found[strings.ToLower(modID)]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", mods.ErrModNotFound, modID)
+	}
+	var entry *ModListEntry
+	if list, err := ReadModList(modProvider.enabledList().Path()); err == nil {
+		entry = list.Find(modID)
+	}
+	return activeFile(files, entry).Path, nil
 }
 
+// GetMods returns every installed mod, those listed in mod-list.json first and in its order. Mods that are not
+// listed are enabled, Factorio adds them to the list the next time it starts. Mods that cannot be read, e.g. a
+// broken download, are skipped with a warning.
 func (modProvider *FactorioModProvider) GetMods() ([]mods.Mod, error) {
-	modDirectory := modProvider.GetGameModDirectory()
-	files, err := os.ReadDir(modDirectory)
+	found, err := modProvider.modFiles()
 	if err != nil {
 		return nil, err
 	}
+	listPath := modProvider.enabledList().Path()
+	var list *ModList
+	if _, err := os.Stat(listPath); err == nil {
+		if list, err = ReadModList(listPath); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", listPath, err)
+		}
+	}
 
-	// If we have a mod-list.json file, we should read it and return the mods from there.
-	modListFile := filepath.Join(modDirectory, "mod-list.json")
-	if _, err := os.Stat(modListFile); err == nil {
-		modListData, err := os.ReadFile(modListFile)
+	var names []string
+	if list != nil {
+		for _, entry := range list.Mods {
+			names = append(names, entry.Name)
+		}
+	}
+	names = append(names, unlistedMods(found, list)...)
+
+	var foundMods []mods.Mod
+	for _, name := range names {
+		files, ok := found[strings.ToLower(name)]
+		if !ok {
+			// base, the DLCs and mods that were deleted by hand
+			log.Debugf("Mod %s not found in %s", name, modProvider.GetGameModDirectory())
+			continue
+		}
+		var entry *ModListEntry
+		if list != nil {
+			entry = list.Find(name)
+		}
+		file := activeFile(files, entry)
+		factorioMod, err := modProvider.ReadModInfo(file.Path)
 		if err != nil {
-			return nil, err
+			log.Warnf("Skipping invalid Factorio mod %s: %v", file.Path, err)
+			continue
 		}
-		var modList struct {
-			Mods []struct {
-				Name    string `json:"name"`
-				Enabled bool   `json:"enabled"`
-			} `json:"mods"`
+		foundMod := mods.Mod{
+			ID:           strings.ToLower(factorioMod.Name),
+			GameID:       modProvider.GetGameID(),
+			Name:         factorioMod.Title,
+			Description:  factorioMod.Description,
+			Version:      factorioMod.Version,
+			Author:       factorioMod.Author,
+			Image:        factorioMod.Image,
+			GameVersions: []mods.GameVersion{{Version: factorioMod.FactorioVersion, ModVersion: factorioMod.Version}},
+			Enabled:      entry == nil || entry.Enabled,
+			Dependencies: factorioMod.Dependencies,
 		}
-		if err := json.Unmarshal(modListData, &modList); err != nil {
-			return nil, err
+		if foundMod.Name == "" {
+			foundMod.Name = factorioMod.Name
 		}
-		var foundMods []mods.Mod
-		for _, mod := range modList.Mods {
-			// Check if the zip file exists
-			modPath, err := modProvider.GetModFile(mod.Name)
-			if _, err := os.Stat(modPath); os.IsNotExist(err) {
-				log.Debugf("Mod %s not found in %s", mod.Name, modDirectory)
-				continue
-			}
-			factorioMod, err := modProvider.ReadModInfo(modPath)
-			if err != nil {
-				return nil, err
-			}
-			versions := []mods.GameVersion{
-				{
-					Version: factorioMod.FactorioVersion,
-				},
-			}
-			foundMods = append(foundMods, mods.Mod{
-				ID:           strings.ToLower(factorioMod.Name),
-				GameID:       modProvider.GetGameID(),
-				Name:         factorioMod.Title,
-				Description:  factorioMod.Description,
-				Version:      factorioMod.Version,
-				Author:       factorioMod.Author,
-				Image:        factorioMod.Image,
-				GameVersions: versions,
-				Enabled:      mod.Enabled,
-			})
+		foundMods = append(foundMods, foundMod)
+	}
+	return foundMods, nil
+}
+
+// GetModByID returns an installed mod, ignoring case.
+func (modProvider *FactorioModProvider) GetModByID(id string) (*mods.Mod, error) {
+	installed, err := modProvider.GetMods()
+	if err != nil {
+		return nil, err
+	}
+	if mod, ok := mods.FindMod(installed, id); ok {
+		return mod, nil
+	}
+	return nil, fmt.Errorf("%w: %s", mods.ErrModNotFound, id)
+}
+
+func (modProvider *FactorioModProvider) pipeline() *install.Pipeline {
+	if modProvider.Installer != nil {
+		return modProvider.Installer
+	}
+	return install.Default()
+}
+
+func (modProvider *FactorioModProvider) enabledList() *EnabledList {
+	return NewEnabledList(filepath.Join(modProvider.GetGameModDirectory(), ModListFile))
+}
+
+// PlanInstall describes installing mod from its DownloadURL as <name>_<version>.zip, replacing every installed
+// version. DownloadURL may also be the path of a zip on disk. The mod is enabled unless it is marked as disabled
+// in an existing mod-list.json entry.
+func (modProvider *FactorioModProvider) PlanInstall(mod mods.Mod) (*install.Plan, error) {
+	if mod.DownloadURL == "" {
+		return nil, errors.New("mod " + mod.ID + " has no download URL")
+	}
+	if mod.Version == "" {
+		return nil, errors.New("mod " + mod.ID + " has no version")
+	}
+	artifact := install.Artifact{URL: mod.DownloadURL}
+	if parsed, err := url.Parse(mod.DownloadURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		artifact = install.Artifact{Source: mod.DownloadURL}
+	}
+	name := mod.ID
+	if existing, err := modProvider.GetModFile(mod.ID); err == nil {
+		// Factorio compares names case-sensitively, the ID may have been lowercased
+		if info, err := modProvider.ReadModInfo(existing); err == nil {
+			name = info.Name
 		}
-		return foundMods, nil
 	}
+	return modProvider.planInstall(name, mod.Version, artifact), nil
+}
 
-	var foundMods []mods.Mod
-	for _, file := range files {
-		fileExtension := filepath.Ext(file.Name())
-		if file.IsDir() || fileExtension != ".zip" {
-			continue // Skip directories and non-zip files
+// PlanInstallFile is like PlanInstall for a mod archive on disk. The name and version are read from its info.json.
+func (modProvider *FactorioModProvider) PlanInstallFile(path string) (*install.Plan, error) {
+	info, err := modProvider.ReadModInfo(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mod archive %s: %w", path, err)
+	}
+	return modProvider.planInstall(info.Name, info.Version, install.Artifact{Source: path}), nil
+}
+
+func (modProvider *FactorioModProvider) planInstall(name string, version string, artifact install.Artifact) *install.Plan {
+	artifact.ModID = name
+	artifact.Target = name + "_" + version + ".zip"
+	enabledList := modProvider.enabledList()
+	plan := &install.Plan{
+		GameID:      modProvider.GetGameID(),
+		Root:        modProvider.GetGameModDirectory(),
+		Artifacts:   []install.Artifact{artifact},
+		EnabledList: enabledList,
+		Enable:      map[string]bool{name: true},
+	}
+	plan.Remove = modProvider.installedFiles(name)
+	if list, err := ReadModList(enabledList.Path()); err == nil {
+		if entry := list.Find(name); entry != nil {
+			plan.Enable[name] = entry.Enabled
+			// The other versions are removed, a pin to one of them would keep the game from loading the mod
+			if entry.Version != "" {
+				enabledList.versions = map[string]string{name: version}
+			}
 		}
-		mod := mods.Mod{
-			ID:      file.Name(),
-			GameID:  modProvider.GetGameID(),
-			Enabled: true,
+	}
+	return plan
+}
+
+// installedFiles returns the zips and folders of every installed version of a mod, relative to the mods directory.
+func (modProvider *FactorioModProvider) installedFiles(name string) []string {
+	found, err := modProvider.modFiles()
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, file := range found[strings.ToLower(name)] {
+		files = append(files, filepath.Base(file.Path))
+	}
+	return files
+}
+
+// HasModVersion reports whether a version of a mod is in the mods directory, whether or not it is the one
+// Factorio loads.
+func (modProvider *FactorioModProvider) HasModVersion(id string, modVersion string) bool {
+	found, err := modProvider.modFiles()
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(found[strings.ToLower(id)], func(file modFile) bool { return file.Version == modVersion })
+}
+
+// PlanPin describes pinning a version of a mod that is already installed in mod-list.json, which makes Factorio
+// load it instead of the newest one. Nothing is downloaded or removed.
+func (modProvider *FactorioModProvider) PlanPin(id string, modVersion string) (*install.Plan, error) {
+	if !modProvider.HasModVersion(id, modVersion) {
+		return nil, fmt.Errorf("%w: %s %s", mods.ErrModNotFound, id, modVersion)
+	}
+	modFile, err := modProvider.GetModFile(id)
+	if err != nil {
+		return nil, err
+	}
+	info, err := modProvider.ReadModInfo(modFile)
+	if err != nil {
+		return nil, err
+	}
+	enabledList := modProvider.enabledList()
+	enabledList.versions = map[string]string{info.Name: modVersion}
+	enabled := true
+	if list, err := ReadModList(enabledList.Path()); err == nil {
+		if entry := list.Find(info.Name); entry != nil {
+			enabled = entry.Enabled
 		}
-		// Here we could potentially read the mod's metadata from its contents,
-		// but for now, we just use the file name as the mod ID.
-		foundMods = append(foundMods, mod)
 	}
-	return foundMods, nil
+	return &install.Plan{
+		GameID:      modProvider.GetGameID(),
+		Root:        modProvider.GetGameModDirectory(),
+		EnabledList: enabledList,
+		Enable:      map[string]bool{info.Name: enabled},
+	}, nil
 }
 
-func (modProvider *FactorioModProvider) GetModByID(id string) (*mods.Mod, error) {
-	//TODO implement me
-	panic("implement me")
+// PlanRemove describes deleting every version of a mod and its mod-list.json entry.
+func (modProvider *FactorioModProvider) PlanRemove(id string) (*install.Plan, error) {
+	modFile, err := modProvider.GetModFile(id)
+	if err != nil {
+		return nil, err
+	}
+	name, _, _ := parseModFileName(filepath.Base(modFile), filepath.Ext(modFile) != ".zip")
+	if info, err := modProvider.ReadModInfo(modFile); err == nil {
+		name = info.Name
+	}
+	return &install.Plan{
+		GameID:      modProvider.GetGameID(),
+		Root:        modProvider.GetGameModDirectory(),
+		Remove:      modProvider.installedFiles(name),
+		EnabledList: modProvider.enabledList(),
+		Forget:      []string{name},
+	}, nil
 }
 
 func (modProvider *FactorioModProvider) AddMod(mod mods.Mod) error {
-	//TODO implement me
-	panic("implement me")
+	return modProvider.addMod(context.Background(), mod)
+}
+
+func (modProvider *FactorioModProvider) addMod(ctx context.Context, mod mods.Mod) error {
+	if _, err := modProvider.GetModFile(mod.ID); err == nil {
+		return fmt.Errorf("%w: %s", mods.ErrAlreadyInstalled, mod.ID)
+	}
+	plan, err := modProvider.PlanInstall(mod)
+	if err != nil {
+		return err
+	}
+	return modProvider.pipeline().Run(ctx, plan)
 }
 
 func (modProvider *FactorioModProvider) RemoveMod(id string) error {
-	//TODO implement me
-	panic("implement me")
+	plan, err := modProvider.PlanRemove(id)
+	if err != nil {
+		return err
+	}
+	return modProvider.pipeline().Run(context.Background(), plan)
 }
 
+// UpdateMod installs another version of an installed mod.
 func (modProvider *FactorioModProvider) UpdateMod(mod mods.Mod) error {
-	//TODO implement me
-	panic("implement me")
+	return modProvider.updateMod(context.Background(), mod)
+}
+
+func (modProvider *FactorioModProvider) updateMod(ctx context.Context, mod mods.Mod) error {
+	if _, err := modProvider.GetModFile(mod.ID); err != nil {
+		return fmt.Errorf("%w: %s", mods.ErrModNotFound, mod.ID)
+	}
+	plan, err := modProvider.PlanInstall(mod)
+	if err != nil {
+		return err
+	}
+	return modProvider.pipeline().Run(ctx, plan)
 }
 
+// ListGameMods lists every mod the game knows of: the installed mods and the mod-list.json entries without a mod
+// file, like base and the DLCs, which ship with the game.
 func (modProvider *FactorioModProvider) ListGameMods() ([]mods.Mod, error) {
-	//TODO implement me
-	panic("implement me")
+	installed, err := modProvider.GetMods()
+	if err != nil {
+		return nil, err
+	}
+	list, err := ReadModList(modProvider.enabledList().Path())
+	if err != nil {
+		return nil, err
+	}
+	gameMods := installed
+	for _, entry := range list.Mods {
+		if _, ok := mods.FindMod(installed, entry.Name); ok {
+			continue
+		}
+		if _, err := modProvider.GetModFile(entry.Name); err == nil {
+			// Installed, but unreadable
+			continue
+		}
+		gameMods = append(gameMods, mods.Mod{
+			ID:      strings.ToLower(entry.Name),
+			Name:    entry.Name,
+			Version: entry.Version,
+			Enabled: entry.Enabled,
+			GameID:  modProvider.GetGameID(),
+		})
+	}
+	return gameMods, nil
 }
 
 func (modProvider *FactorioModProvider) GetGameID() string {
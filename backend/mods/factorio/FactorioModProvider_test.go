@@ -0,0 +1,185 @@
+package factorio
+
+import (
+	"TotalControl/backend/install"
+	"TotalControl/backend/mods"
+	"archive/zip"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useFixture copies testdata/mods to a temporary mods directory, so tests can change it.
+func useFixture(t *testing.T) string {
+	dir := t.TempDir()
+	assert.NoError(t, os.CopyFS(dir, os.DirFS("testdata/mods")))
+	t.Setenv("FACTORIO_MODS_DIR", dir)
+	return dir
+}
+
+func TestParseModFileName(t *testing.T) {
+	cases := []struct {
+		fileName string
+		isDir    bool
+		name     string
+		version  string
+		ok       bool
+	}{
+		{"helmod_2.2.12.zip", false, "helmod", "2.2.12", true},
+		{"even_more_1.0_2.0.1.zip", false, "even_more_1.0", "2.0.1", true},
+		{"RateCalculator_3.3.2", true, "RateCalculator", "3.3.2", true},
+		{"unpacked-dev", true, "unpacked-dev", "", true},
+		{"helmod.zip", false, "", "", false},
+		{"mod-list.json", false, "", "", false},
+		{".git", true, "", "", false},
+	}
+	for _, c := range cases {
+		name, version, ok := parseModFileName(c.fileName, c.isDir)
+		assert.Equal(t, c.ok, ok, c.fileName)
+		assert.Equal(t, c.name, name, c.fileName)
+		assert.Equal(t, c.version, version, c.fileName)
+	}
+}
+
+func TestReadZipFile_RejectsOversizedEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeZip := func(name string, files map[string][]byte) string {
+		zipPath := filepath.Join(dir, name)
+		file, err := os.Create(zipPath)
+		assert.NoError(t, err)
+		defer file.Close()
+		archive := zip.NewWriter(file)
+		for fileName, content := range files {
+			writer, _ := archive.Create(fileName)
+			_, _ = writer.Write(content)
+		}
+		assert.NoError(t, archive.Close())
+		return zipPath
+	}
+	huge := make([]byte, maxZipEntry+1)
+
+	_, err := readModZip(writeZip("big_1.0.0.zip", map[string][]byte{
+		"big/info.json":     []byte(`{"name": "big", "version": "1.0.0"}`),
+		"big/thumbnail.png": huge,
+	}))
+	assert.ErrorContains(t, err, "larger than")
+
+	_, err = ReadSave(writeZip("Big.zip", map[string][]byte{
+		"Big/level-init.dat": saveHeader([4]uint16{2, 0, 28, 0}, testSaveMods),
+		"Big/preview.png":    huge,
+	}))
+	assert.ErrorContains(t, err, "larger than")
+}
+
+func TestFactorioModProvider_GetMods(t *testing.T) {
+	useFixture(t)
+	provider := &FactorioModProvider{}
+
+	installed, err := provider.GetMods()
+	assert.NoError(t, err)
+	type summary struct {
+		ID      string
+		Version string
+		Enabled bool
+	}
+	var got []summary
+	for _, mod := range installed {
+		got = append(got, summary{mod.ID, mod.Version, mod.Enabled})
+	}
+	// In the order of mod-list.json, then the unlisted mods, which are enabled. The broken zip, base, the DLC and
+	// the deleted mod are left out.
+	assert.Equal(t, []summary{
+		{"helmod", "2.2.12", true},
+		{"krastorio2", "1.3.23", true},
+		{"flib", "0.16.2", false},
+		{"unpacked-dev", "0.1.0", true},
+		{"ratecalculator", "3.3.2", true},
+		{"even_more_1.0", "2.0.1", true},
+		{"newmod", "1.0.0", true},
+	}, got)
+	assert.Equal(t, []string{"base >= 2.0"}, installed[0].Dependencies)
+	assert.Equal(t, "Rate Calculator", installed[4].Name)
+
+	mod, err := provider.GetModByID("Krastorio2")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1.3.23", mod.Version)
+	}
+	mod, err = provider.GetModByID("newmod")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "New Mod", mod.Name)
+	}
+	_, err = provider.GetModByID("ghost-mod")
+	assert.ErrorIs(t, err, mods.ErrModNotFound)
+
+	path, err := provider.GetModFile("flib")
+	assert.NoError(t, err)
+	assert.Equal(t, "flib_0.16.2.zip", filepath.Base(path))
+}
+
+func TestFactorioModProvider_GetModsWithoutModList(t *testing.T) {
+	dir := useFixture(t)
+	assert.NoError(t, os.Remove(filepath.Join(dir, ModListFile)))
+
+	installed, err := (&FactorioModProvider{}).GetMods()
+	assert.NoError(t, err)
+	assert.Len(t, installed, 7)
+	for _, mod := range installed {
+		assert.True(t, mod.Enabled, mod.ID)
+	}
+}
+
+func TestFactorioModProvider_ListGameMods(t *testing.T) {
+	useFixture(t)
+	gameMods, err := (&FactorioModProvider{}).ListGameMods()
+	assert.NoError(t, err)
+	var ids []string
+	for _, mod := range gameMods {
+		ids = append(ids, mod.ID)
+	}
+	assert.Equal(t, []string{"helmod", "krastorio2", "flib", "unpacked-dev", "ratecalculator", "even_more_1.0",
+		"newmod", "base", "space-age", "ghost-mod"}, ids)
+}
+
+func TestFactorioModProvider_AddUpdateRemove(t *testing.T) {
+	dir := useFixture(t)
+	provider := &FactorioModProvider{Installer: install.NewPipeline(nil, t.TempDir())}
+	source := t.TempDir()
+	writeTestMod(t, source, "rso-mod", "6.2.23")
+	writeTestMod(t, source, "Krastorio2", "1.3.25")
+
+	rso := mods.Mod{ID: "rso-mod", Version: "6.2.23", DownloadURL: filepath.Join(source, "rso-mod_6.2.23.zip")}
+	assert.NoError(t, provider.AddMod(rso))
+	assert.FileExists(t, filepath.Join(dir, "rso-mod_6.2.23.zip"))
+	assert.ErrorIs(t, provider.AddMod(rso), mods.ErrAlreadyInstalled)
+
+	// Updating replaces both installed versions and moves the pin along
+	err := provider.UpdateMod(mods.Mod{ID: "krastorio2", Version: "1.3.25", DownloadURL: filepath.Join(source, "Krastorio2_1.3.25.zip")})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "Krastorio2_1.3.23.zip"))
+	assert.NoFileExists(t, filepath.Join(dir, "Krastorio2_1.3.24.zip"))
+	assert.FileExists(t, filepath.Join(dir, "Krastorio2_1.3.25.zip"))
+	assert.ErrorIs(t, provider.UpdateMod(mods.Mod{ID: "missing", Version: "1.0.0", DownloadURL: source}), mods.ErrModNotFound)
+
+	assert.NoError(t, provider.RemoveMod("flib"))
+	assert.NoFileExists(t, filepath.Join(dir, "flib_0.15.0.zip"))
+	assert.NoFileExists(t, filepath.Join(dir, "flib_0.16.2.zip"))
+	assert.NoError(t, provider.RemoveMod("unpacked-dev"))
+	assert.NoDirExists(t, filepath.Join(dir, "unpacked-dev"))
+	assert.ErrorIs(t, provider.RemoveMod("flib"), mods.ErrModNotFound)
+
+	// base, the DLC and entries of mods that are not installed are kept
+	list, err := ReadModList(filepath.Join(dir, ModListFile))
+	assert.NoError(t, err)
+	assert.Equal(t, []ModListEntry{
+		{Name: "base", Enabled: true},
+		{Name: "space-age", Enabled: true},
+		{Name: "helmod", Enabled: true},
+		{Name: "Krastorio2", Enabled: true, Version: "1.3.25"},
+		{Name: "broken", Enabled: true},
+		{Name: "ghost-mod", Enabled: true},
+		{Name: "RateCalculator", Enabled: true},
+		{Name: "even_more_1.0", Enabled: true},
+		{Name: "rso-mod", Enabled: true},
+	}, list.Mods)
+}
@@ -0,0 +1,457 @@
+package factorio
+
+import (
+	"TotalControl/backend/version"
+	"archive/zip"
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// builtinMods ship with the game, so they never have a mod file and their version is the game's.
+var builtinMods = []string{"base", "elevated-rails", "quality", "space-age"}
+
+// maxSaveHeader limits how much of the level data is read for the header, the mod list is usually a few KB.
+const maxSaveHeader = 4 << 20
+
+// SaveMod is a mod a save was made with.
+type SaveMod struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// CRC is Factorio's checksum of the mod, it is 0 for mods without scripts.
+	CRC uint32 `json:"crc"`
+}
+
+// SaveInfo is what a save file tells about itself without loading the map.
+type SaveInfo struct {
+	// Name is the name of the save, which is also the file name.
+	Name string `json:"name"`
+	// GameVersion is the version of the game that wrote the save, e.g. "2.0.28".
+	GameVersion string `json:"gameVersion"`
+	Campaign    string `json:"campaign"`
+	// Scenario is the level the map was started from, e.g. "freeplay".
+	Scenario string `json:"scenario"`
+	BaseMod  string `json:"baseMod"`
+	// Mods are the mods the save was made with, in load order.
+	Mods []SaveMod `json:"mods"`
+	// Preview is the save's preview.png, nil if it has none.
+	Preview []byte `json:"preview"`
+}
+
+// GetSavesDirectory returns the saves directory, which is next to the mods directory.
+func (modProvider *FactorioModProvider) GetSavesDirectory() string {
+	return filepath.Join(filepath.Dir(filepath.Clean(modProvider.GetGameModDirectory())), "saves")
+}
+
+// ReadSave reads the header and preview of a save zip. The header is at the start of level-init.dat, or of the
+// level data itself in saves older than 1.1. Both may be zlib compressed.
+func ReadSave(savePath string) (*SaveInfo, error) {
+	reader, err := zip.OpenReader(savePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	files := make(map[string]*zip.File)
+	for _, file := range reader.File {
+		folder, name := path.Split(file.Name)
+		if strings.Count(folder, "/") > 1 {
+			continue
+		}
+		if _, ok := files[name]; !ok {
+			files[name] = file
+		}
+	}
+	var levelFile *zip.File
+	for _, name := range []string{"level-init.dat", "level.dat0", "level.dat"} {
+		if file, ok := files[name]; ok {
+			levelFile = file
+			break
+		}
+	}
+	if levelFile == nil {
+		return nil, fmt.Errorf("%s is not a Factorio save, it has no level data", savePath)
+	}
+	data, err := readLevelData(levelFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", levelFile.Name, err)
+	}
+	info, err := parseSaveHeader(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid save %s: %w", savePath, err)
+	}
+	info.Name = strings.TrimSuffix(filepath.Base(savePath), filepath.Ext(savePath))
+	if preview, ok := files["preview.png"]; ok {
+		if info.Preview, err = readZipFile(preview); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// readLevelData reads the start of a level file, decompressing it if it starts with a zlib header.
+func readLevelData(file *zip.File) ([]byte, error) {
+	zipReader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer zipReader.Close()
+	reader := bufio.NewReader(zipReader)
+	var data io.Reader = reader
+	if header, err := reader.Peek(2); err == nil && header[0] == 0x78 && binary.BigEndian.Uint16(header)%31 == 0 {
+		zlibReader, err := zlib.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer zlibReader.Close()
+		data = zlibReader
+	}
+	content, err := io.ReadAll(io.LimitReader(data, maxSaveHeader))
+	// Only the header is needed, a truncated or damaged map after it does not matter
+	if errors.Is(err, io.ErrUnexpectedEOF) && len(content) > 0 {
+		err = nil
+	}
+	return content, err
+}
+
+// saveReader reads the space optimized values of Factorio's save format: numbers below 255 take one byte,
+// larger ones a 255 byte and the full number.
+type saveReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *saveReader) bytes(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *saveReader) uint8() (uint8, error) {
+	b, err := r.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *saveReader) uint16() (uint16, error) {
+	b, err := r.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (r *saveReader) uint32() (uint32, error) {
+	b, err := r.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (r *saveReader) optimizedUint16() (uint16, error) {
+	small, err := r.uint8()
+	if err != nil || small != 0xFF {
+		return uint16(small), err
+	}
+	return r.uint16()
+}
+
+func (r *saveReader) optimizedUint32() (uint32, error) {
+	small, err := r.uint8()
+	if err != nil || small != 0xFF {
+		return uint32(small), err
+	}
+	return r.uint32()
+}
+
+func (r *saveReader) string() (string, error) {
+	length, err := r.optimizedUint32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(int(length))
+	return string(b), err
+}
+
+// skip reads over fields that are not needed.
+func (r *saveReader) skip(n int) error {
+	_, err := r.bytes(n)
+	return err
+}
+
+// parseSaveHeader reads the header at the start of the level data. Its fields have the same layout in every
+// version from 0.17 to 2.0, strings and the mod count are space optimized:
+//
+//	game version                   4 x uint16, major, minor, patch and build
+//	(undocumented)                 uint8
+//	campaign, level, base mod      3 x string
+//	difficulty, finished, won      3 x uint8
+//	next level                     string
+//	can continue, finished but continuing, saving replay, allow non-admin debug options
+//	                               4 x bool
+//	loaded from                    3 x uint8, major, minor and patch
+//	loaded from build              uint16
+//	allowed commands               uint8
+//	mods                           optimized uint32 count, then name, 3 x optimized uint16 version and uint32 CRC
+func parseSaveHeader(data []byte) (*SaveInfo, error) {
+	r := &saveReader{data: data}
+	var gameVersion [4]uint16
+	for i := range gameVersion {
+		part, err := r.uint16()
+		if err != nil {
+			return nil, err
+		}
+		gameVersion[i] = part
+	}
+	if gameVersion[0] == 0 && gameVersion[1] < 17 {
+		return nil, fmt.Errorf("saves of Factorio %d.%d are not supported", gameVersion[0], gameVersion[1])
+	}
+	info := &SaveInfo{GameVersion: fmt.Sprintf("%d.%d.%d", gameVersion[0], gameVersion[1], gameVersion[2])}
+	if err := r.skip(1); err != nil {
+		return nil, err
+	}
+	var err error
+	if info.Campaign, err = r.string(); err != nil {
+		return nil, err
+	}
+	if info.Scenario, err = r.string(); err != nil {
+		return nil, err
+	}
+	if info.BaseMod, err = r.string(); err != nil {
+		return nil, err
+	}
+	// Difficulty, finished and player won
+	if err := r.skip(3); err != nil {
+		return nil, err
+	}
+	// Next level
+	if _, err := r.string(); err != nil {
+		return nil, err
+	}
+	// Can continue, finished but continuing, saving replay, allow non-admin debug options, loaded from version
+	// and build and allowed commands
+	if err := r.skip(4 + 3 + 2 + 1); err != nil {
+		return nil, err
+	}
+	if info.Mods, err = parseSaveMods(r); err != nil {
+		return nil, fmt.Errorf("invalid mod list of a Factorio %s save: %w", info.GameVersion, err)
+	}
+	return info, nil
+}
+
+// parseSaveMods reads the mod list, which always has base.
+func parseSaveMods(r *saveReader) ([]SaveMod, error) {
+	count, err := r.optimizedUint32()
+	if err != nil {
+		return nil, err
+	}
+	// A mod takes at least 8 bytes, a larger count is a damaged header rather than a reason to allocate
+	if int(count) > (len(r.data)-r.pos)/8 {
+		return nil, fmt.Errorf("%d mods do not fit in the header", count)
+	}
+	saveMods := make([]SaveMod, 0, count)
+	hasBase := false
+	for i := uint32(0); i < count; i++ {
+		name, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+		if !validSaveModName(name) {
+			return nil, fmt.Errorf("invalid mod name %q", name)
+		}
+		var parts [3]uint16
+		for j := range parts {
+			if parts[j], err = r.optimizedUint16(); err != nil {
+				return nil, err
+			}
+		}
+		crc, err := r.uint32()
+		if err != nil {
+			return nil, err
+		}
+		hasBase = hasBase || name == "base"
+		saveMods = append(saveMods, SaveMod{
+			Name:    name,
+			Version: fmt.Sprintf("%d.%d.%d", parts[0], parts[1], parts[2]),
+			CRC:     crc,
+		})
+	}
+	if !hasBase {
+		return nil, errors.New("base is missing")
+	}
+	return saveMods, nil
+}
+
+// validSaveModName checks the characters the mod portal allows in mod names.
+func validSaveModName(name string) bool {
+	if name == "" || len(name) > 100 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == ' ' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// MissingMod is a mod of a save whose version is not installed.
+type MissingMod struct {
+	SaveMod
+	// Installed is the version that is installed instead, empty if the mod is not installed at all.
+	Installed string `json:"installed"`
+}
+
+// SaveSync is the result of syncing the mods to a save.
+type SaveSync struct {
+	// Enabled are the mods of the save that are enabled, pinned to the save's version if it is installed.
+	Enabled []SaveMod `json:"enabled"`
+	// Disabled are the mods that were enabled but are not used by the save.
+	Disabled []string `json:"disabled"`
+	// Missing are the mods whose version is not installed. Mods installed in another version are enabled with
+	// that version, Factorio can usually migrate the save, mods that are not installed must be downloaded.
+	Missing []MissingMod `json:"missing"`
+}
+
+// SyncToSave rewrites mod-list.json so exactly the mods of a save are enabled, pinned to the versions the save was
+// made with where they are installed.
+func (modProvider *FactorioModProvider) SyncToSave(info *SaveInfo) (*SaveSync, error) {
+	found, err := modProvider.modFiles()
+	if err != nil {
+		return nil, err
+	}
+	listPath := modProvider.enabledList().Path()
+	list, err := ReadModList(listPath)
+	if err != nil {
+		return nil, err
+	}
+
+	sync := &SaveSync{}
+	unlisted := unlistedMods(found, list)
+	wanted := make(map[string]bool, len(info.Mods))
+	for _, saveMod := range info.Mods {
+		key := strings.ToLower(saveMod.Name)
+		wanted[key] = true
+		if slices.Contains(builtinMods, key) {
+			list.SetEnabled(saveMod.Name, true)
+			list.Find(saveMod.Name).Version = ""
+			sync.Enabled = append(sync.Enabled, saveMod)
+			continue
+		}
+		files, ok := found[key]
+		if !ok {
+			sync.Missing = append(sync.Missing, MissingMod{SaveMod: saveMod})
+			continue
+		}
+		list.SetEnabled(files[0].Name, true)
+		entry := list.Find(files[0].Name)
+		file, ok := modProvider.saveModFile(files, saveMod.Version)
+		if !ok {
+			// Factorio loads the newest version and migrates the save if it can
+			entry.Version = ""
+			installed := modProvider.modFileVersion(files[0])
+			sync.Missing = append(sync.Missing, MissingMod{SaveMod: saveMod, Installed: installed})
+			sync.Enabled = append(sync.Enabled, SaveMod{Name: saveMod.Name, Version: installed})
+			continue
+		}
+		// Unversioned folders cannot be pinned, they are loaded whatever their version
+		entry.Version = file.Version
+		sync.Enabled = append(sync.Enabled, saveMod)
+	}
+	for i := range list.Mods {
+		entry := &list.Mods[i]
+		if entry.Enabled && !wanted[strings.ToLower(entry.Name)] {
+			entry.Enabled = false
+			sync.Disabled = append(sync.Disabled, entry.Name)
+		}
+	}
+	// Factorio would enable the mods it finds without an entry
+	for _, name := range unlisted {
+		if !wanted[strings.ToLower(name)] {
+			list.Mods = append(list.Mods, ModListEntry{Name: name, Enabled: false})
+			sync.Disabled = append(sync.Disabled, name)
+		}
+	}
+	if err := list.Save(listPath); err != nil {
+		return nil, err
+	}
+	return sync, nil
+}
+
+// saveModFile finds the installed version of a mod a save needs.
+func (modProvider *FactorioModProvider) saveModFile(files []modFile, modVersion string) (modFile, bool) {
+	for _, file := range files {
+		if order, err := version.Compare(modProvider.modFileVersion(file), modVersion); err == nil && order == 0 {
+			return file, true
+		}
+	}
+	return modFile{}, false
+}
+
+// modFileVersion returns the version of a mod file, reading info.json of unversioned folders.
+func (modProvider *FactorioModProvider) modFileVersion(file modFile) string {
+	if file.Version != "" {
+		return file.Version
+	}
+	info, err := modProvider.ReadModInfo(file.Path)
+	if err != nil {
+		return ""
+	}
+	return info.Version
+}
+
+// GetSaves reads the saves in the saves directory, newest first. Files that are not readable saves are skipped
+// with a warning.
+func (modProvider *FactorioModProvider) GetSaves() ([]SaveInfo, error) {
+	entries, err := os.ReadDir(modProvider.GetSavesDirectory())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	type save struct {
+		info     *SaveInfo
+		modified time.Time
+	}
+	var saves []save
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".zip") {
+			continue
+		}
+		stat, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		info, err := ReadSave(filepath.Join(modProvider.GetSavesDirectory(), entry.Name()))
+		if err != nil {
+			log.Warnf("Skipping Factorio save %s: %v", entry.Name(), err)
+			continue
+		}
+		saves = append(saves, save{info, stat.ModTime()})
+	}
+	slices.SortStableFunc(saves, func(a, b save) int {
+		return b.modified.Compare(a.modified)
+	})
+	infos := make([]SaveInfo, 0, len(saves))
+	for _, s := range saves {
+		infos = append(infos, *s.info)
+	}
+	return infos, nil
+}
@@ -0,0 +1,189 @@
+package factorio
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// saveHeader builds the header of a save's level data.
+func saveHeader(gameVersion [4]uint16, saveMods []SaveMod) []byte {
+	var b bytes.Buffer
+	writeString := func(value string) {
+		b.WriteByte(byte(len(value)))
+		b.WriteString(value)
+	}
+	_ = binary.Write(&b, binary.LittleEndian, gameVersion)
+	b.WriteByte(0)
+	writeString("")
+	writeString("freeplay")
+	writeString("base")
+	// Difficulty, finished, player won
+	b.Write([]byte{0, 0, 0})
+	writeString("")
+	// Can continue, finished but continuing, saving replay, allow non-admin debug options
+	b.Write([]byte{0, 0, 0, 1})
+	// Loaded from 2.0.28 build 79000, allowed commands
+	b.Write([]byte{2, 0, 28, 0x98, 0x34, 1})
+	b.WriteByte(byte(len(saveMods)))
+	for _, saveMod := range saveMods {
+		writeString(saveMod.Name)
+		var parts [3]uint16
+		_, _ = fmt.Sscanf(saveMod.Version, "%d.%d.%d", &parts[0], &parts[1], &parts[2])
+		for _, part := range parts {
+			if part < 0xFF {
+				b.WriteByte(byte(part))
+			} else {
+				b.WriteByte(0xFF)
+				_ = binary.Write(&b, binary.LittleEndian, part)
+			}
+		}
+		_ = binary.Write(&b, binary.LittleEndian, saveMod.CRC)
+	}
+	// The map follows
+	b.Write(bytes.Repeat([]byte{0xAB}, 64))
+	return b.Bytes()
+}
+
+func writeTestSave(t *testing.T, savePath string, levelFile string, level []byte) {
+	file, err := os.Create(savePath)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+	name := filepath.Base(savePath[:len(savePath)-len(filepath.Ext(savePath))])
+	levelWriter, _ := archive.Create(name + "/" + levelFile)
+	_, _ = levelWriter.Write(level)
+	preview, _ := archive.Create(name + "/preview.png")
+	_, _ = preview.Write([]byte("\x89PNG preview"))
+	assert.NoError(t, archive.Close())
+}
+
+var testSaveMods = []SaveMod{
+	{Name: "base", Version: "2.0.28"},
+	{Name: "quality", Version: "2.0.28"},
+	{Name: "helmod", Version: "2.2.12", CRC: 0x1234abcd},
+	{Name: "Krastorio2", Version: "1.3.24"},
+	{Name: "flib", Version: "0.15.0"},
+	{Name: "unpacked-dev", Version: "0.1.0"},
+	{Name: "RateCalculator", Version: "3.4.0"},
+	{Name: "rso-mod", Version: "6.2.300"},
+}
+
+func TestReadSave(t *testing.T) {
+	dir := t.TempDir()
+	// The level-init.dat of a 1.1 save is stored as is, the one of a 2.0 save is compressed
+	cases := []struct {
+		fixture     string
+		gameVersion string
+		mods        []SaveMod
+	}{
+		{"testdata/saves/level-init-1.1.dat", "1.1.110", []SaveMod{
+			{Name: "base", Version: "1.1.110", CRC: 0x41b2c7f3},
+			{Name: "flib", Version: "0.12.9", CRC: 0x2c6e1d84},
+			{Name: "helmod", Version: "1.0.23", CRC: 0x9f0a3b51},
+			{Name: "Krastorio2", Version: "1.3.24", CRC: 0x7d15e0a2},
+			{Name: "even_more_1.0", Version: "2.0.1"},
+		}},
+		{"testdata/saves/level-init-2.0.dat", "2.0.28", []SaveMod{
+			{Name: "base", Version: "2.0.28", CRC: 0x5a9e31c2},
+			{Name: "elevated-rails", Version: "2.0.28", CRC: 0x0b4f7d6e},
+			{Name: "quality", Version: "2.0.28", CRC: 0xe3c81a09},
+			{Name: "space-age", Version: "2.0.28", CRC: 0x66d2f4b7},
+			{Name: "flib", Version: "0.15.0", CRC: 0x18aa40c3},
+			{Name: "helmod", Version: "2.2.12", CRC: 0x1234abcd},
+			{Name: "RateCalculator", Version: "3.3.2", CRC: 0xc0ffee11},
+			{Name: "rso-mod", Version: "6.2.300"},
+		}},
+	}
+	for _, c := range cases {
+		level, err := os.ReadFile(c.fixture)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		writeTestSave(t, filepath.Join(dir, "Megabase.zip"), "level-init.dat", level)
+		info, err := ReadSave(filepath.Join(dir, "Megabase.zip"))
+		if assert.NoError(t, err, c.fixture) {
+			assert.Equal(t, "Megabase", info.Name)
+			assert.Equal(t, c.gameVersion, info.GameVersion)
+			assert.Equal(t, "freeplay", info.Scenario)
+			assert.Equal(t, "base", info.BaseMod)
+			assert.Equal(t, c.mods, info.Mods)
+			assert.Equal(t, []byte("\x89PNG preview"), info.Preview)
+		}
+	}
+
+	// Older saves keep the header in the compressed level data
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	_, _ = writer.Write(saveHeader([4]uint16{1, 0, 0, 0}, testSaveMods[:1]))
+	assert.NoError(t, writer.Close())
+	writeTestSave(t, filepath.Join(dir, "Old.zip"), "level.dat0", compressed.Bytes())
+	info, err := ReadSave(filepath.Join(dir, "Old.zip"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1.0.0", info.GameVersion)
+		assert.Equal(t, testSaveMods[:1], info.Mods)
+	}
+
+	header := saveHeader([4]uint16{2, 0, 28, 0}, testSaveMods)
+	writeTestSave(t, filepath.Join(dir, "Broken.zip"), "level-init.dat", header[:40])
+	_, err = ReadSave(filepath.Join(dir, "Broken.zip"))
+	assert.Error(t, err)
+
+	// The mod list is read where the header says it is, a field too many is not skipped over
+	shifted := slices.Insert(slices.Clone(header), 33, 0)
+	writeTestSave(t, filepath.Join(dir, "Shifted.zip"), "level-init.dat", shifted)
+	_, err = ReadSave(filepath.Join(dir, "Shifted.zip"))
+	assert.Error(t, err)
+}
+
+func TestFactorioModProvider_SyncToSave(t *testing.T) {
+	dir := useFixture(t)
+	provider := &FactorioModProvider{}
+	savesDir := filepath.Join(filepath.Dir(dir), "saves")
+	assert.Equal(t, savesDir, provider.GetSavesDirectory())
+	assert.NoError(t, os.Mkdir(savesDir, 0755))
+	writeTestSave(t, filepath.Join(savesDir, "Megabase.zip"), "level-init.dat",
+		saveHeader([4]uint16{2, 0, 28, 0}, testSaveMods))
+	assert.NoError(t, os.WriteFile(filepath.Join(savesDir, "notes.zip"), []byte("not a zip"), 0644))
+
+	saves, err := provider.GetSaves()
+	assert.NoError(t, err)
+	if !assert.Len(t, saves, 1) {
+		return
+	}
+
+	sync, err := provider.SyncToSave(&saves[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"space-age", "broken", "ghost-mod", "even_more_1.0", "newmod"}, sync.Disabled)
+	assert.Equal(t, []MissingMod{
+		{SaveMod: SaveMod{Name: "RateCalculator", Version: "3.4.0"}, Installed: "3.3.2"},
+		{SaveMod: SaveMod{Name: "rso-mod", Version: "6.2.300"}},
+	}, sync.Missing)
+	assert.Len(t, sync.Enabled, 7)
+
+	list, err := ReadModList(filepath.Join(dir, ModListFile))
+	assert.NoError(t, err)
+	assert.Equal(t, []ModListEntry{
+		{Name: "base", Enabled: true},
+		{Name: "space-age", Enabled: false},
+		{Name: "helmod", Enabled: true, Version: "2.2.12"},
+		{Name: "Krastorio2", Enabled: true, Version: "1.3.24"},
+		{Name: "flib", Enabled: true, Version: "0.15.0"},
+		{Name: "broken", Enabled: false},
+		{Name: "ghost-mod", Enabled: false},
+		{Name: "unpacked-dev", Enabled: true},
+		{Name: "RateCalculator", Enabled: true},
+		{Name: "even_more_1.0", Enabled: false},
+		{Name: "quality", Enabled: true},
+		{Name: "newmod", Enabled: false},
+	}, list.Mods)
+}
@@ -0,0 +1,338 @@
+package factorio
+
+import (
+	"TotalControl/backend/install"
+	"TotalControl/backend/modpack"
+	"TotalControl/backend/mods"
+	"TotalControl/backend/process"
+	"TotalControl/backend/profiles"
+	"TotalControl/backend/updates"
+	"context"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ModSettingsFile stores the startup and map settings of all mods, next to mod-list.json.
+const ModSettingsFile = "mod-settings.dat"
+
+// ModSource looks up a release of a mod on the mod portal, the latest one if version is empty. The mod it returns
+// has the name from the portal as its ID, a Version and a DownloadURL.
+type ModSource func(ctx context.Context, id string, version string) (*mods.Mod, error)
+
+// ProviderAdapter exposes FactorioModProvider as a mods.ModProvider. Installing and downloading updates needs a
+// Source, without one only the versions already in the mods directory can be used.
+type ProviderAdapter struct {
+	Source   ModSource
+	provider *FactorioModProvider
+	// mu keeps concurrent calls from overwriting each other's changes to mod-list.json.
+	mu sync.Mutex
+}
+
+var (
+	_ mods.ModProvider           = (*ProviderAdapter)(nil)
+	_ mods.GameProcess           = (*ProviderAdapter)(nil)
+	_ profiles.SettingsFiles     = (*ProviderAdapter)(nil)
+	_ profiles.EnabledApplier    = (*ProviderAdapter)(nil)
+	_ profiles.InstalledVersions = (*ProviderAdapter)(nil)
+	_ modpack.ModFiles           = (*ProviderAdapter)(nil)
+	_ modpack.FileInstaller      = (*ProviderAdapter)(nil)
+	_ updates.BatchUpdater       = (*ProviderAdapter)(nil)
+)
+
+func NewProviderAdapter(provider *FactorioModProvider) *ProviderAdapter {
+	return &ProviderAdapter{provider: provider}
+}
+
+func (a *ProviderAdapter) wrap(op string, id string, err error) error {
+	return mods.NewProviderError(a.GameID(), op, id, err)
+}
+
+func (a *ProviderAdapter) GameID() string {
+	return a.provider.GetGameID()
+}
+
+func (a *ProviderAdapter) Capabilities() mods.Capabilities {
+	download := a.Source != nil
+	return mods.Capabilities{Install: download, Uninstall: true, Toggle: true, Update: download}
+}
+
+func (a *ProviderAdapter) InstalledMods(ctx context.Context) ([]mods.Mod, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	installed, err := a.provider.GetMods()
+	if err != nil {
+		return nil, a.wrap("list installed mods", "", err)
+	}
+	return installed, nil
+}
+
+func (a *ProviderAdapter) SearchMods(ctx context.Context, query mods.SearchQuery) ([]mods.Mod, error) {
+	return nil, a.wrap("search mods", "", mods.ErrNotSupported)
+}
+
+func (a *ProviderAdapter) GetMod(ctx context.Context, id string) (*mods.Mod, error) {
+	installed, err := a.InstalledMods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mod, ok := mods.FindMod(installed, id); ok {
+		return mod, nil
+	}
+	return nil, a.wrap("get mod", id, mods.ErrModNotFound)
+}
+
+// InstallMod downloads a mod from the Source through the install pipeline and enables it.
+func (a *ProviderAdapter) InstallMod(ctx context.Context, id string, version string) (*mods.Mod, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.provider.GetModFile(id); err == nil {
+		return nil, a.wrap("install mod", id, mods.ErrAlreadyInstalled)
+	}
+	if a.Source == nil {
+		return nil, a.wrap("install mod", id, mods.ErrNotSupported)
+	}
+	release, err := a.Source(ctx, id, version)
+	if err != nil {
+		return nil, a.wrap("install mod", id, err)
+	}
+	if err := a.provider.addMod(ctx, *release); err != nil {
+		return nil, a.wrap("install mod", id, err)
+	}
+	return a.installed("install mod", id)
+}
+
+// UninstallMod deletes the mod's zip file and its mod-list.json entry through the install pipeline.
+func (a *ProviderAdapter) UninstallMod(ctx context.Context, id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	plan, err := a.provider.PlanRemove(id)
+	if err != nil {
+		return a.wrap("uninstall mod", id, err)
+	}
+	return a.wrap("uninstall mod", id, a.provider.pipeline().Run(ctx, plan))
+}
+
+// SetModEnabled changes the mod's entry in mod-list.json, the game picks it up on the next start.
+func (a *ProviderAdapter) SetModEnabled(ctx context.Context, id string, enabled bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	listPath := filepath.Join(a.provider.GetGameModDirectory(), ModListFile)
+	list, err := ReadModList(listPath)
+	if err != nil {
+		return a.wrap("enable mod", id, err)
+	}
+	entry := list.Find(id)
+	if entry == nil {
+		// Mods that are not listed yet must at least be installed. Factorio compares names case-sensitively, so
+		// the name is taken from info.json rather than the lowercased ID.
+		modFile, err := a.provider.GetModFile(id)
+		if err != nil {
+			return a.wrap("enable mod", id, mods.ErrModNotFound)
+		}
+		info, err := a.provider.ReadModInfo(modFile)
+		if err != nil {
+			return a.wrap("enable mod", id, err)
+		}
+		list.SetEnabled(info.Name, enabled)
+	} else {
+		entry.Enabled = enabled
+	}
+	return a.wrap("enable mod", id, list.Save(listPath))
+}
+
+// UpdateMod switches a mod to another version through the install pipeline. A version that is already in the
+// mods directory is pinned in mod-list.json, the others are downloaded from the Source and replace the installed
+// versions.
+func (a *ProviderAdapter) UpdateMod(ctx context.Context, id string, version string) (*mods.Mod, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	plan, err := a.planUpdate(ctx, id, version)
+	if err != nil {
+		return nil, a.wrap("update mod", id, err)
+	}
+	if err := a.provider.pipeline().Run(ctx, plan); err != nil {
+		return nil, a.wrap("update mod", id, err)
+	}
+	return a.installed("update mod", id)
+}
+
+// UpdateMods is UpdateMod for several mods, carried out as one install so a failed update rolls back the others.
+func (a *ProviderAdapter) UpdateMods(ctx context.Context, versions map[string]string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ids := slices.Sorted(maps.Keys(versions))
+	plans := make([]*install.Plan, 0, len(ids))
+	enabledList := a.provider.enabledList()
+	for _, id := range ids {
+		plan, err := a.planUpdate(ctx, id, versions[id])
+		if err != nil {
+			return a.wrap("update mod", id, err)
+		}
+		plans = append(plans, plan)
+		// Merge keeps a single enabled list, the version pins of every plan have to go into it
+		if list, ok := plan.EnabledList.(*EnabledList); ok && list.versions != nil {
+			if enabledList.versions == nil {
+				enabledList.versions = make(map[string]string)
+			}
+			maps.Copy(enabledList.versions, list.versions)
+		}
+	}
+	plan, err := install.Merge(plans...)
+	if err != nil {
+		return a.wrap("update mods", "", err)
+	}
+	plan.EnabledList = enabledList
+	return a.wrap("update mods", "", a.provider.pipeline().Run(ctx, plan))
+}
+
+// planUpdate pins a version that is already in the mods directory, or else downloads it from the Source.
+func (a *ProviderAdapter) planUpdate(ctx context.Context, id string, version string) (*install.Plan, error) {
+	if _, err := a.provider.GetModFile(id); err != nil {
+		return nil, mods.ErrModNotFound
+	}
+	if version == "" || !a.provider.HasModVersion(id, version) {
+		if a.Source == nil {
+			return nil, mods.ErrNotSupported
+		}
+		release, err := a.Source(ctx, id, version)
+		if err != nil {
+			return nil, err
+		}
+		version = release.Version
+		if !a.provider.HasModVersion(id, version) {
+			return a.provider.PlanInstall(*release)
+		}
+	}
+	return a.provider.PlanPin(id, version)
+}
+
+// installed returns a mod after op changed it.
+func (a *ProviderAdapter) installed(op string, id string) (*mods.Mod, error) {
+	mod, err := a.provider.GetModByID(id)
+	if err != nil {
+		return nil, a.wrap(op, id, err)
+	}
+	return mod, nil
+}
+
+// HasModVersion lets profile switches pin versions that are already installed with ApplyEnabled.
+func (a *ProviderAdapter) HasModVersion(id string, version string) bool {
+	return a.provider.HasModVersion(id, version)
+}
+
+func (a *ProviderAdapter) IsGameRunning(ctx context.Context) (bool, error) {
+	return process.IsRunning(ctx, "factorio")
+}
+
+func (a *ProviderAdapter) ModSettingsFiles() []string {
+	return []string{filepath.Join(a.provider.GetGameModDirectory(), ModSettingsFile)}
+}
+
+// ApplyEnabled rewrites mod-list.json in one go, pinning the versions of the profile. Entries without a mod file,
+// like base and the DLCs, are left as they are.
+func (a *ProviderAdapter) ApplyEnabled(ctx context.Context, enabled []profiles.ModRef) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	listPath := filepath.Join(a.provider.GetGameModDirectory(), ModListFile)
+	list, err := ReadModList(listPath)
+	if err != nil {
+		return a.wrap("apply profile", "", err)
+	}
+	found, err := a.provider.modFiles()
+	if err != nil {
+		return a.wrap("apply profile", "", err)
+	}
+	unlisted := unlistedMods(found, list)
+	wanted := make(map[string]profiles.ModRef, len(enabled))
+	for _, ref := range enabled {
+		wanted[strings.ToLower(ref.ID)] = ref
+	}
+	for i := range list.Mods {
+		entry := &list.Mods[i]
+		if ref, ok := wanted[strings.ToLower(entry.Name)]; ok {
+			entry.Enabled = true
+			entry.Version = ref.Version
+			delete(wanted, strings.ToLower(entry.Name))
+		} else if _, err := a.provider.GetModFile(entry.Name); err == nil {
+			entry.Enabled = false
+		}
+	}
+	// Factorio would enable the mods it finds without an entry
+	for _, name := range unlisted {
+		if _, ok := wanted[strings.ToLower(name)]; !ok {
+			list.Mods = append(list.Mods, ModListEntry{Name: name, Enabled: false})
+		}
+	}
+	for _, ref := range enabled {
+		if _, ok := wanted[strings.ToLower(ref.ID)]; !ok {
+			continue
+		}
+		modFile, err := a.provider.GetModFile(ref.ID)
+		if err != nil {
+			return a.wrap("apply profile", ref.ID, mods.ErrModNotFound)
+		}
+		info, err := a.provider.ReadModInfo(modFile)
+		if err != nil {
+			return a.wrap("apply profile", ref.ID, fmt.Errorf("invalid mod file %s: %w", modFile, err))
+		}
+		list.Mods = append(list.Mods, ModListEntry{Name: info.Name, Enabled: true, Version: ref.Version})
+	}
+	return a.wrap("apply profile", "", list.Save(listPath))
+}
+
+func (a *ProviderAdapter) ModFile(id string) (string, error) {
+	return a.provider.GetModFile(id)
+}
+
+// InstallModFile installs a mod archive, e.g. from a modpack bundle, replacing the installed version.
+func (a *ProviderAdapter) InstallModFile(ctx context.Context, id string, version string, path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	plan, err := a.provider.PlanInstallFile(path)
+	if err != nil {
+		return a.wrap("install mod", id, err)
+	}
+	artifact := plan.Artifacts[0]
+	if !strings.EqualFold(artifact.ModID, id) || (version != "" && artifact.Target != artifact.ModID+"_"+version+".zip") {
+		return a.wrap("install mod", id, fmt.Errorf("the archive contains %s", strings.TrimSuffix(artifact.Target, ".zip")))
+	}
+	return a.wrap("install mod", id, a.provider.pipeline().Run(ctx, plan))
+}
+
+// SyncToSave enables exactly the mods a save was made with. It is refused while the game is running, as Factorio
+// writes mod-list.json when it exits.
+func (a *ProviderAdapter) SyncToSave(ctx context.Context, savePath string) (*SaveSync, error) {
+	running, err := a.IsGameRunning(ctx)
+	if err != nil {
+		return nil, a.wrap("sync mods to save", "", err)
+	}
+	if running {
+		return nil, a.wrap("sync mods to save", "", mods.ErrGameRunning)
+	}
+	info, err := ReadSave(savePath)
+	if err != nil {
+		return nil, a.wrap("sync mods to save", "", err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sync, err := a.provider.SyncToSave(info)
+	if err != nil {
+		return nil, a.wrap("sync mods to save", "", err)
+	}
+	return sync, nil
+}
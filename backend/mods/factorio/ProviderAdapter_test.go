@@ -0,0 +1,257 @@
+package factorio
+
+import (
+	"TotalControl/backend/install"
+	"TotalControl/backend/loadorder"
+	"TotalControl/backend/mods"
+	"TotalControl/backend/profiles"
+	"archive/zip"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestMod(t *testing.T, dir string, name string, version string) {
+	file, err := os.Create(filepath.Join(dir, name+"_"+version+".zip"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+	info, _ := archive.Create(name + "_" + version + "/info.json")
+	_, _ = info.Write([]byte(`{"name":"` + name + `","version":"` + version + `","title":"` + name + `","factorio_version":"2.0"}`))
+	assert.NoError(t, archive.Close())
+}
+
+func setupModsDirectory(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv("FACTORIO_MODS_DIR", dir)
+	writeTestMod(t, dir, "Krastorio2", "1.3.24")
+	writeTestMod(t, dir, "helmod", "2.2.12")
+	writeTestMod(t, dir, "helmod-extras", "0.1.0")
+	list := &ModList{Mods: []ModListEntry{{Name: "base", Enabled: true}, {Name: "helmod", Enabled: true}}}
+	assert.NoError(t, list.Save(filepath.Join(dir, ModListFile)))
+	return dir
+}
+
+func TestProviderAdapter_SetModEnabled(t *testing.T) {
+	dir := setupModsDirectory(t)
+	adapter := NewProviderAdapter(&FactorioModProvider{})
+	ctx := context.Background()
+
+	assert.NoError(t, adapter.SetModEnabled(ctx, "helmod", false))
+	// Unlisted mods are added with the name from their info.json
+	assert.NoError(t, adapter.SetModEnabled(ctx, "krastorio2", true))
+	assert.ErrorIs(t, adapter.SetModEnabled(ctx, "missing", true), mods.ErrModNotFound)
+
+	list, err := ReadModList(filepath.Join(dir, ModListFile))
+	assert.NoError(t, err)
+	assert.Equal(t, []ModListEntry{
+		{Name: "base", Enabled: true},
+		{Name: "helmod", Enabled: false},
+		{Name: "Krastorio2", Enabled: true},
+	}, list.Mods)
+
+	// helmod-extras is not listed, so it is enabled
+	installed, err := adapter.InstalledMods(ctx)
+	assert.NoError(t, err)
+	var enabled []string
+	for _, mod := range installed {
+		if mod.Enabled {
+			enabled = append(enabled, mod.ID)
+		}
+	}
+	assert.Equal(t, []string{"krastorio2", "helmod-extras"}, enabled)
+}
+
+func TestProviderAdapter_UninstallMod(t *testing.T) {
+	dir := setupModsDirectory(t)
+	adapter := NewProviderAdapter(&FactorioModProvider{Installer: install.NewPipeline(nil, t.TempDir())})
+	ctx := context.Background()
+
+	assert.NoError(t, adapter.UninstallMod(ctx, "helmod"))
+	assert.NoFileExists(t, filepath.Join(dir, "helmod_2.2.12.zip"))
+	// A mod whose name starts with the uninstalled one is left alone
+	assert.FileExists(t, filepath.Join(dir, "helmod-extras_0.1.0.zip"))
+
+	_, err := adapter.GetMod(ctx, "helmod")
+	assert.ErrorIs(t, err, mods.ErrModNotFound)
+	list, err := ReadModList(filepath.Join(dir, ModListFile))
+	assert.NoError(t, err)
+	assert.Nil(t, list.Find("helmod"))
+
+	_, err = adapter.InstallMod(ctx, "helmod", "")
+	assert.ErrorIs(t, err, mods.ErrNotSupported)
+}
+
+func TestProviderAdapter_InstallAndUpdate(t *testing.T) {
+	dir := setupModsDirectory(t)
+	portal := t.TempDir()
+	writeTestMod(t, portal, "rso-mod", "6.2.23")
+	writeTestMod(t, portal, "helmod", "2.3.0")
+	latest := map[string]string{"rso-mod": "6.2.23", "helmod": "2.3.0"}
+	adapter := NewProviderAdapter(&FactorioModProvider{Installer: install.NewPipeline(nil, t.TempDir())})
+	adapter.Source = func(ctx context.Context, id string, version string) (*mods.Mod, error) {
+		if version == "" {
+			version = latest[id]
+		}
+		path := filepath.Join(portal, id+"_"+version+".zip")
+		if _, err := os.Stat(path); err != nil {
+			return nil, mods.ErrModNotFound
+		}
+		return &mods.Mod{ID: id, Version: version, DownloadURL: path}, nil
+	}
+	assert.Equal(t, mods.Capabilities{Install: true, Uninstall: true, Toggle: true, Update: true}, adapter.Capabilities())
+	ctx := context.Background()
+
+	mod, err := adapter.InstallMod(ctx, "rso-mod", "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "6.2.23", mod.Version)
+		assert.True(t, mod.Enabled)
+	}
+	_, err = adapter.InstallMod(ctx, "rso-mod", "")
+	assert.ErrorIs(t, err, mods.ErrAlreadyInstalled)
+	_, err = adapter.InstallMod(ctx, "missing", "")
+	assert.ErrorIs(t, err, mods.ErrModNotFound)
+
+	// A version that is not on disk is downloaded and replaces the installed one
+	mod, err = adapter.UpdateMod(ctx, "helmod", "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2.3.0", mod.Version)
+	}
+	assert.NoFileExists(t, filepath.Join(dir, "helmod_2.2.12.zip"))
+	assert.FileExists(t, filepath.Join(dir, "helmod_2.3.0.zip"))
+	_, err = adapter.UpdateMod(ctx, "helmod", "9.9.9")
+	assert.ErrorIs(t, err, mods.ErrModNotFound)
+	_, err = adapter.UpdateMod(ctx, "missing", "")
+	assert.ErrorIs(t, err, mods.ErrModNotFound)
+
+	// Without a Source only the versions on disk can be used
+	assert.Equal(t, mods.Capabilities{Uninstall: true, Toggle: true}, NewProviderAdapter(&FactorioModProvider{}).Capabilities())
+}
+
+func TestProviderAdapter_UpdateMods(t *testing.T) {
+	dir := setupModsDirectory(t)
+	list := &ModList{Mods: []ModListEntry{
+		{Name: "base", Enabled: true},
+		{Name: "helmod", Enabled: true, Version: "2.2.12"},
+		{Name: "Krastorio2", Enabled: false, Version: "1.3.24"},
+	}}
+	assert.NoError(t, list.Save(filepath.Join(dir, ModListFile)))
+	portal := t.TempDir()
+	writeTestMod(t, portal, "helmod", "2.3.0")
+	writeTestMod(t, portal, "Krastorio2", "1.4.0")
+	adapter := NewProviderAdapter(&FactorioModProvider{Installer: install.NewPipeline(nil, t.TempDir())})
+	adapter.Source = func(ctx context.Context, id string, version string) (*mods.Mod, error) {
+		// Missing releases are handed out anyway, so the download fails in the pipeline
+		return &mods.Mod{ID: id, Version: version, DownloadURL: filepath.Join(portal, id+"_"+version+".zip")}, nil
+	}
+	ctx := context.Background()
+
+	assert.NoError(t, adapter.UpdateMods(ctx, map[string]string{"helmod": "2.3.0", "Krastorio2": "1.4.0"}))
+	assert.NoFileExists(t, filepath.Join(dir, "helmod_2.2.12.zip"))
+	assert.FileExists(t, filepath.Join(dir, "helmod_2.3.0.zip"))
+	assert.FileExists(t, filepath.Join(dir, "Krastorio2_1.4.0.zip"))
+	list, err := ReadModList(filepath.Join(dir, ModListFile))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2.3.0", list.Find("helmod").Version)
+		assert.Equal(t, "1.4.0", list.Find("Krastorio2").Version)
+		assert.False(t, list.Find("Krastorio2").Enabled)
+	}
+
+	// One failed update leaves the others as they were
+	writeTestMod(t, portal, "helmod", "2.4.0")
+	err = adapter.UpdateMods(ctx, map[string]string{"helmod": "2.4.0", "Krastorio2": "9.9.9"})
+	assert.Error(t, err)
+	assert.FileExists(t, filepath.Join(dir, "helmod_2.3.0.zip"))
+	assert.NoFileExists(t, filepath.Join(dir, "helmod_2.4.0.zip"))
+	assert.FileExists(t, filepath.Join(dir, "Krastorio2_1.4.0.zip"))
+	assert.ErrorIs(t, adapter.UpdateMods(ctx, map[string]string{"missing": "1.0.0"}), mods.ErrModNotFound)
+}
+
+func TestProviderAdapter_ApplyEnabled(t *testing.T) {
+	dir := setupModsDirectory(t)
+	adapter := NewProviderAdapter(&FactorioModProvider{})
+
+	err := adapter.ApplyEnabled(context.Background(), []profiles.ModRef{{ID: "krastorio2", Version: "1.3.24"}})
+	assert.NoError(t, err)
+	list, err := ReadModList(filepath.Join(dir, ModListFile))
+	assert.NoError(t, err)
+	assert.Equal(t, []ModListEntry{
+		{Name: "base", Enabled: true},
+		{Name: "helmod", Enabled: false},
+		// Factorio would enable an unlisted mod
+		{Name: "helmod-extras", Enabled: false},
+		{Name: "Krastorio2", Enabled: true, Version: "1.3.24"},
+	}, list.Mods)
+
+	err = adapter.ApplyEnabled(context.Background(), []profiles.ModRef{{ID: "missing"}})
+	assert.ErrorIs(t, err, mods.ErrModNotFound)
+	assert.Equal(t, []string{filepath.Join(dir, ModSettingsFile)}, adapter.ModSettingsFiles())
+}
+
+func TestProviderAdapter_InstallModFile(t *testing.T) {
+	dir := setupModsDirectory(t)
+	adapter := NewProviderAdapter(&FactorioModProvider{Installer: install.NewPipeline(nil, t.TempDir())})
+	ctx := context.Background()
+	source := t.TempDir()
+	writeTestMod(t, source, "rso-mod", "6.2.23")
+
+	archive := filepath.Join(source, "rso-mod_6.2.23.zip")
+	assert.Error(t, adapter.InstallModFile(ctx, "rso-mod", "6.2.24", archive))
+	assert.Error(t, adapter.InstallModFile(ctx, "helmod", "", archive))
+	assert.NoError(t, adapter.InstallModFile(ctx, "rso-mod", "6.2.23", archive))
+	assert.FileExists(t, filepath.Join(dir, "rso-mod_6.2.23.zip"))
+
+	path, err := adapter.ModFile("rso-mod")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "rso-mod_6.2.23.zip"), path)
+}
+
+func TestProviderAdapter_SwitchProfilesWithPinnedVersions(t *testing.T) {
+	dir := setupModsDirectory(t)
+	writeTestMod(t, dir, "helmod", "2.2.10")
+	listPath := filepath.Join(dir, ModListFile)
+	settingsPath := filepath.Join(dir, ModSettingsFile)
+	list := &ModList{Mods: []ModListEntry{{Name: "base", Enabled: true}, {Name: "helmod", Enabled: true, Version: "2.2.10"}}}
+	assert.NoError(t, list.Save(listPath))
+	assert.NoError(t, os.WriteFile(settingsPath, []byte("old settings"), 0644))
+
+	adapter := NewProviderAdapter(&FactorioModProvider{Installer: install.NewPipeline(nil, t.TempDir())})
+	manager := profiles.NewManager(t.TempDir(), loadorder.NewStore(t.TempDir()))
+	ctx := context.Background()
+	_, err := manager.Create(ctx, adapter, "Old")
+	assert.NoError(t, err)
+
+	mod, err := adapter.UpdateMod(ctx, "helmod", "2.2.12")
+	assert.NoError(t, err)
+	assert.Equal(t, "2.2.12", mod.Version)
+	_, err = manager.Create(ctx, adapter, "New")
+	assert.NoError(t, err)
+	assert.NoError(t, manager.Switch(ctx, adapter, "New"))
+	assert.NoError(t, os.WriteFile(settingsPath, []byte("new settings"), 0644))
+
+	for _, step := range []struct{ profile, version, settings string }{
+		{"Old", "2.2.10", "old settings"},
+		{"New", "2.2.12", "new settings"},
+	} {
+		assert.NoError(t, manager.Switch(ctx, adapter, step.profile))
+		mod, err := adapter.GetMod(ctx, "helmod")
+		if assert.NoError(t, err) {
+			assert.Equal(t, step.version, mod.Version)
+		}
+		list, err := ReadModList(listPath)
+		assert.NoError(t, err)
+		assert.Equal(t, &ModListEntry{Name: "helmod", Enabled: true, Version: step.version}, list.Find("helmod"))
+		content, _ := os.ReadFile(settingsPath)
+		assert.Equal(t, step.settings, string(content))
+	}
+	// Both versions stay installed, switching back does not need the portal
+	assert.FileExists(t, filepath.Join(dir, "helmod_2.2.10.zip"))
+	assert.FileExists(t, filepath.Join(dir, "helmod_2.2.12.zip"))
+
+	_, err = adapter.UpdateMod(ctx, "helmod", "3.0.0")
+	assert.ErrorIs(t, err, mods.ErrNotSupported)
+}
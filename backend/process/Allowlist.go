@@ -0,0 +1,76 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var ErrNotAllowed = errors.New("executable is not allowed")
+
+// Allowlist is the list of executables a plugin declared it needs. An entry without a path separator, like
+// "factorio", allows running that name as found in PATH, but not a file with that name given by its path. Other
+// entries are glob patterns for the absolute path and may use environment variables, like
+// "$HOME/.factorio/bin/*/factorio". On Windows ".exe" may be left out and matching ignores case.
+type Allowlist []string
+
+// Resolve finds the executable like a shell would and returns its absolute path if it is allowed.
+func (a Allowlist) Resolve(executable string) (string, error) {
+	if executable == "" {
+		return "", errors.New("no executable given")
+	}
+	resolved, err := exec.LookPath(executable)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err = filepath.Abs(resolved); err != nil {
+		return "", err
+	}
+	if !strings.ContainsAny(executable, `/\`) && a.allowsName(executable) {
+		return resolved, nil
+	}
+	if !a.Allows(resolved) {
+		return "", fmt.Errorf("%w: %s", ErrNotAllowed, resolved)
+	}
+	return resolved, nil
+}
+
+// allowsName reports whether a bare name is one of the entries without a path separator.
+func (a Allowlist) allowsName(name string) bool {
+	name = normalizeExecutable(name)
+	for _, entry := range a {
+		entry = strings.TrimSpace(entry)
+		if entry != "" && !strings.ContainsAny(entry, `/\`) && normalizeExecutable(entry) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the absolute path matches a pattern entry. Entries without a path separator only allow
+// names looked up in PATH by Resolve, never a path.
+func (a Allowlist) Allows(path string) bool {
+	path = normalizeExecutable(filepath.Clean(path))
+	for _, entry := range a {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || !strings.ContainsAny(entry, `/\`) {
+			continue
+		}
+		pattern := normalizeExecutable(filepath.Clean(os.ExpandEnv(entry)))
+		if matched, err := filepath.Match(pattern, path); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeExecutable(path string) string {
+	if runtime.GOOS != "windows" {
+		return path
+	}
+	return strings.TrimSuffix(strings.ToLower(path), ".exe")
+}
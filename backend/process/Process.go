@@ -0,0 +1,153 @@
+package process
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxOutputSize limits how much of stdout and stderr is kept each, so a chatty server cannot exhaust memory.
+// Streaming still sees every line.
+const MaxOutputSize = 4 << 20
+
+// Options describes a process to run. There is no shell, Args are passed to the executable as they are.
+type Options struct {
+	Executable string
+	Args       []string
+	// Dir is the working directory, the current one if empty.
+	Dir string
+	// Env adds to or overrides the environment inherited from TotalControl.
+	Env   map[string]string
+	Stdin string
+	// Timeout kills the process after the given duration. Zero means no timeout.
+	Timeout time.Duration
+	// OnStdout and OnStderr receive the output line by line while the process runs.
+	OnStdout func(line string)
+	OnStderr func(line string)
+}
+
+type Result struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Duration time.Duration
+	TimedOut bool
+	// Truncated is set if the output exceeded MaxOutputSize.
+	Truncated bool
+}
+
+func (r *Result) Success() bool {
+	return r.ExitCode == 0 && !r.TimedOut
+}
+
+// Run starts the process and waits for it. A process that exits with a non-zero code is not an error, that is
+// reported in the result. The error is only set if the process could not be started or the context was cancelled.
+func Run(ctx context.Context, options Options) (*Result, error) {
+	if options.Executable == "" {
+		return nil, errors.New("no executable given")
+	}
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, options.Executable, options.Args...)
+	cmd.Dir = options.Dir
+	if len(options.Env) > 0 {
+		cmd.Env = os.Environ()
+		for key, value := range options.Env {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+	if options.Stdin != "" {
+		cmd.Stdin = strings.NewReader(options.Stdin)
+	}
+	// Don't wait forever for output pipes inherited by child processes after the process was killed
+	cmd.WaitDelay = time.Second
+
+	stdout := &lineWriter{onLine: options.OnStdout}
+	stderr := &lineWriter{onLine: options.OnStderr}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	stdout.flush()
+	stderr.flush()
+	result := &Result{
+		ExitCode:  -1,
+		Stdout:    stdout.buffer.String(),
+		Stderr:    stderr.buffer.String(),
+		Duration:  time.Since(start),
+		Truncated: stdout.truncated || stderr.truncated,
+	}
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && options.Timeout > 0 {
+		result.TimedOut = true
+		return result, nil
+	}
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) && !errors.Is(err, exec.ErrWaitDelay) {
+		return nil, fmt.Errorf("failed to run %s: %w", options.Executable, err)
+	}
+	return result, nil
+}
+
+// lineWriter keeps the output up to MaxOutputSize and passes complete lines to onLine.
+type lineWriter struct {
+	mu        sync.Mutex
+	buffer    bytes.Buffer
+	partial   []byte
+	truncated bool
+	onLine    func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if remaining := MaxOutputSize - w.buffer.Len(); remaining < len(p) {
+		w.buffer.Write(p[:max(remaining, 0)])
+		w.truncated = true
+	} else {
+		w.buffer.Write(p)
+	}
+
+	if w.onLine != nil {
+		w.partial = append(w.partial, p...)
+		consumed := 0
+		for {
+			index := bytes.IndexByte(w.partial[consumed:], '\n')
+			if index == -1 {
+				break
+			}
+			w.onLine(strings.TrimSuffix(string(w.partial[consumed:consumed+index]), "\r"))
+			consumed += index + 1
+		}
+		w.partial = append(w.partial[:0], w.partial[consumed:]...)
+	}
+	return len(p), nil
+}
+
+// flush passes on a last line without a trailing newline.
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.onLine != nil && len(w.partial) > 0 {
+		w.onLine(strings.TrimSuffix(string(w.partial), "\r"))
+		w.partial = nil
+	}
+}
@@ -0,0 +1,142 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess is not a real test, the other tests run the test binary with it as a portable child process.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("TOTALCONTROL_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	args = args[1:]
+	switch args[0] {
+	case "echo":
+		fmt.Println(strings.Join(args[1:], " "))
+		fmt.Fprint(os.Stderr, "warning\npartial")
+	case "env":
+		fmt.Print(os.Getenv(args[1]))
+	case "cat":
+		_, _ = io.Copy(os.Stdout, os.Stdin)
+	case "pwd":
+		dir, _ := os.Getwd()
+		fmt.Print(dir)
+	case "exit":
+		code, _ := strconv.Atoi(args[1])
+		os.Exit(code)
+	case "sleep":
+		time.Sleep(10 * time.Second)
+	}
+	os.Exit(0)
+}
+
+func helperOptions(args ...string) Options {
+	return Options{
+		Executable: os.Args[0],
+		Args:       append([]string{"-test.run=TestHelperProcess", "--"}, args...),
+		Env:        map[string]string{"TOTALCONTROL_HELPER_PROCESS": "1"},
+	}
+}
+
+func TestRun(t *testing.T) {
+	options := helperOptions("echo", "hello", "world with spaces")
+	var stderrLines []string
+	options.OnStderr = func(line string) {
+		stderrLines = append(stderrLines, line)
+	}
+	result, err := Run(context.Background(), options)
+	assert.NoError(t, err)
+	assert.True(t, result.Success())
+	assert.Equal(t, "hello world with spaces\n", result.Stdout)
+	assert.Equal(t, "warning\npartial", result.Stderr)
+	assert.Equal(t, []string{"warning", "partial"}, stderrLines)
+
+	options = helperOptions("env", "MOD_DIR")
+	options.Env["MOD_DIR"] = "/mods"
+	result, err = Run(context.Background(), options)
+	assert.NoError(t, err)
+	assert.Equal(t, "/mods", result.Stdout)
+
+	options = helperOptions("cat")
+	options.Stdin = "input"
+	result, err = Run(context.Background(), options)
+	assert.NoError(t, err)
+	assert.Equal(t, "input", result.Stdout)
+
+	dir := t.TempDir()
+	options = helperOptions("pwd")
+	options.Dir = dir
+	result, err = Run(context.Background(), options)
+	assert.NoError(t, err)
+	resolved, _ := filepath.EvalSymlinks(dir)
+	assert.Contains(t, []string{dir, resolved}, result.Stdout)
+}
+
+func TestRun_ExitCodeAndTimeout(t *testing.T) {
+	result, err := Run(context.Background(), helperOptions("exit", "3"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.False(t, result.Success())
+
+	options := helperOptions("sleep")
+	options.Timeout = 200 * time.Millisecond
+	result, err = Run(context.Background(), options)
+	assert.NoError(t, err)
+	assert.True(t, result.TimedOut)
+	assert.False(t, result.Success())
+	assert.Less(t, result.Duration, 5*time.Second)
+
+	_, err = Run(context.Background(), Options{Executable: filepath.Join(t.TempDir(), "missing")})
+	assert.Error(t, err)
+}
+
+func TestAllowlist(t *testing.T) {
+	allowlist := Allowlist{"factorio", "/opt/games/*/bin/server"}
+	// A bare name is not a pattern, any file with that name could be run otherwise
+	assert.False(t, allowlist.Allows("/home/user/.factorio/bin/x64/factorio"))
+	assert.True(t, allowlist.Allows("/opt/games/starbound/bin/server"))
+	assert.False(t, allowlist.Allows("/opt/games/starbound/bin/client"))
+	assert.False(t, allowlist.Allows("/usr/bin/sh"))
+	assert.False(t, Allowlist(nil).Allows("/usr/bin/factorio"))
+
+	t.Setenv("GAMES_DIR", "/srv/games")
+	assert.True(t, Allowlist{"$GAMES_DIR/terraria/TerrariaServer"}.Allows("/srv/games/terraria/TerrariaServer"))
+
+	_, err := Allowlist{"other"}.Resolve(os.Args[0])
+	assert.ErrorIs(t, err, ErrNotAllowed)
+	_, err = Allowlist{filepath.Base(os.Args[0])}.Resolve(os.Args[0])
+	assert.ErrorIs(t, err, ErrNotAllowed)
+	resolved, err := Allowlist{filepath.Dir(os.Args[0]) + "/*"}.Resolve(os.Args[0])
+	assert.NoError(t, err)
+	assert.True(t, filepath.IsAbs(resolved))
+
+	// Bare names are looked up in PATH
+	t.Setenv("PATH", filepath.Dir(os.Args[0]))
+	resolved, err = Allowlist{filepath.Base(os.Args[0])}.Resolve(filepath.Base(os.Args[0]))
+	assert.NoError(t, err)
+	assert.Equal(t, os.Args[0], resolved)
+}
+
+func TestIsRunning(t *testing.T) {
+	// The test binary itself is always running
+	running, err := IsRunning(context.Background(), "not-a-real-game", os.Args[0])
+	assert.NoError(t, err)
+	assert.True(t, running)
+
+	running, err = IsRunning(context.Background(), "not-a-real-game")
+	assert.NoError(t, err)
+	assert.False(t, running)
+}
@@ -0,0 +1,90 @@
+package process
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// IsRunning reports whether a process with one of the given executable names is running. Names are compared like
+// Allowlist entries without a path, so on Windows "factorio" matches "Factorio.exe".
+func IsRunning(ctx context.Context, names ...string) (bool, error) {
+	running, err := runningExecutables(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range names {
+		name = normalizeExecutable(filepath.Base(name))
+		for _, executable := range running {
+			if normalizeExecutable(executable) == name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// runningExecutables returns the executable names of all processes the user can see.
+func runningExecutables(ctx context.Context) ([]string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return procExecutables()
+	case "windows":
+		result, err := Run(ctx, Options{Executable: "tasklist", Args: []string{"/fo", "csv", "/nh"}})
+		if err != nil {
+			return nil, err
+		}
+		records, err := csv.NewReader(strings.NewReader(result.Stdout)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, record := range records {
+			if len(record) > 0 {
+				names = append(names, record[0])
+			}
+		}
+		return names, nil
+	default:
+		result, err := Run(ctx, Options{Executable: "ps", Args: []string{"-axo", "comm="}})
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, line := range strings.Split(result.Stdout, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				names = append(names, filepath.Base(line))
+			}
+		}
+		return names, nil
+	}
+}
+
+// procExecutables reads /proc. The comm file is cut off after 15 characters, so the executable link is preferred
+// and the first argument used for processes of other users, whose link cannot be read.
+func procExecutables() ([]string, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		dir := filepath.Join("/proc", entry.Name())
+		if executable, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+			names = append(names, filepath.Base(strings.TrimSuffix(executable, " (deleted)")))
+			continue
+		}
+		if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil && len(cmdline) > 0 {
+			argument, _, _ := strings.Cut(string(cmdline), "\x00")
+			names = append(names, filepath.Base(argument))
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,302 @@
+package profiles
+
+import (
+	"TotalControl/backend/loadorder"
+	"TotalControl/backend/mods"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// DefaultDir holds a profile file per game and the saved mod settings of each profile.
+const DefaultDir = "data/profiles"
+
+var (
+	ErrProfileNotFound = errors.New("profile not found")
+	ErrProfileExists   = errors.New("a profile with this name already exists")
+)
+
+// ModRef is an enabled mod of a profile. An empty Version accepts whatever version is installed.
+type ModRef struct {
+	ID      string `json:"id"`
+	Version string `json:"version,omitempty"`
+}
+
+// Profile is a named set of enabled mods of a game, e.g. "Vanilla+" or "Space Exploration".
+type Profile struct {
+	// ID names the directory of the saved settings, so renaming does not move files.
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Mods      []ModRef `json:"mods"`
+	LoadOrder []string `json:"load_order,omitempty"`
+	// Settings are the names of the settings files saved with the profile.
+	Settings []string `json:"settings,omitempty"`
+}
+
+// gameProfiles is the format of a game's profile file.
+type gameProfiles struct {
+	// Active is the ID of the profile the installed mods match.
+	Active   string     `json:"active,omitempty"`
+	Profiles []*Profile `json:"profiles"`
+}
+
+func (g *gameProfiles) find(name string) *Profile {
+	for _, profile := range g.Profiles {
+		if strings.EqualFold(profile.Name, name) {
+			return profile
+		}
+	}
+	return nil
+}
+
+func (g *gameProfiles) byID(id string) *Profile {
+	for _, profile := range g.Profiles {
+		if profile.ID == id {
+			return profile
+		}
+	}
+	return nil
+}
+
+// Manager creates, edits and switches the profiles of every game.
+type Manager struct {
+	dir        string
+	loadOrders *loadorder.Store
+	// mu serializes all changes, a switch must not run while its profile is renamed or deleted.
+	mu sync.Mutex
+}
+
+var defaultManager = NewManager(DefaultDir, loadorder.DefaultStore())
+
+func NewManager(dir string, loadOrders *loadorder.Store) *Manager {
+	return &Manager{dir: dir, loadOrders: loadOrders}
+}
+
+// DefaultManager returns the manager in DefaultDir, using the default load order store.
+func DefaultManager() *Manager {
+	return defaultManager
+}
+
+func (m *Manager) path(gameID string) (string, error) {
+	if gameID == "" || !filepath.IsLocal(gameID) || filepath.Base(gameID) != gameID {
+		return "", fmt.Errorf("invalid game ID %q", gameID)
+	}
+	return filepath.Join(m.dir, gameID+".json"), nil
+}
+
+// settingsDir is where the settings files of a profile are saved.
+func (m *Manager) settingsDir(gameID string, profile *Profile) string {
+	return filepath.Join(m.dir, gameID, profile.ID)
+}
+
+func (m *Manager) load(gameID string) (*gameProfiles, error) {
+	path, err := m.path(gameID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &gameProfiles{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	profiles := &gameProfiles{}
+	if err := json.Unmarshal(data, profiles); err != nil {
+		return nil, fmt.Errorf("invalid profile file %s: %w", path, err)
+	}
+	return profiles, nil
+}
+
+func (m *Manager) save(gameID string, profiles *gameProfiles) error {
+	path, err := m.path(gameID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func checkName(profiles *gameProfiles, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("the profile needs a name")
+	}
+	if profiles.find(name) != nil {
+		return fmt.Errorf("%w: %s", ErrProfileExists, name)
+	}
+	return nil
+}
+
+// List returns the profiles of a game and the name of the active one, "" if none is.
+func (m *Manager) List(gameID string) ([]Profile, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profiles, err := m.load(gameID)
+	if err != nil {
+		return nil, "", err
+	}
+	result := make([]Profile, len(profiles.Profiles))
+	for i, profile := range profiles.Profiles {
+		result[i] = *profile
+	}
+	active := ""
+	if profile := profiles.byID(profiles.Active); profile != nil {
+		active = profile.Name
+	}
+	return result, active, nil
+}
+
+// Create saves the currently enabled mods, their load order and the mod settings as a new profile. The first
+// profile of a game becomes the active one.
+func (m *Manager) Create(ctx context.Context, provider mods.ModProvider, name string) (*Profile, error) {
+	gameID := provider.GameID()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profiles, err := m.load(gameID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkName(profiles, name); err != nil {
+		return nil, err
+	}
+
+	installed, err := provider.InstalledMods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	profile := &Profile{ID: uuid.New().String(), Name: name, Mods: []ModRef{}}
+	for _, mod := range installed {
+		if mod.Enabled {
+			profile.Mods = append(profile.Mods, ModRef{ID: mod.ID, Version: mod.Version})
+		}
+	}
+	order, err := m.loadOrders.Load(gameID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range order.Order {
+		if slices.ContainsFunc(profile.Mods, func(ref ModRef) bool { return strings.EqualFold(ref.ID, id) }) {
+			profile.LoadOrder = append(profile.LoadOrder, id)
+		}
+	}
+	if files, ok := provider.(SettingsFiles); ok {
+		if err := m.saveSettings(gameID, profile, files.ModSettingsFiles()); err != nil {
+			return nil, fmt.Errorf("failed to save the mod settings: %w", err)
+		}
+	}
+
+	profiles.Profiles = append(profiles.Profiles, profile)
+	if len(profiles.Profiles) == 1 {
+		profiles.Active = profile.ID
+	}
+	if err := m.save(gameID, profiles); err != nil {
+		_ = os.RemoveAll(m.settingsDir(gameID, profile))
+		return nil, err
+	}
+	return profile, nil
+}
+
+// Clone copies a profile including its settings under a new name.
+func (m *Manager) Clone(gameID string, name string, newName string) (*Profile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profiles, err := m.load(gameID)
+	if err != nil {
+		return nil, err
+	}
+	original := profiles.find(name)
+	if original == nil {
+		return nil, fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+	}
+	if err := checkName(profiles, newName); err != nil {
+		return nil, err
+	}
+
+	clone := &Profile{
+		ID:        uuid.New().String(),
+		Name:      newName,
+		Mods:      slices.Clone(original.Mods),
+		LoadOrder: slices.Clone(original.LoadOrder),
+		Settings:  slices.Clone(original.Settings),
+	}
+	if len(clone.Settings) > 0 {
+		if err := os.MkdirAll(m.settingsDir(gameID, clone), 0755); err != nil {
+			return nil, err
+		}
+		for _, file := range clone.Settings {
+			err := copyFile(filepath.Join(m.settingsDir(gameID, original), file), filepath.Join(m.settingsDir(gameID, clone), file))
+			if err != nil {
+				_ = os.RemoveAll(m.settingsDir(gameID, clone))
+				return nil, err
+			}
+		}
+	}
+	profiles.Profiles = append(profiles.Profiles, clone)
+	if err := m.save(gameID, profiles); err != nil {
+		_ = os.RemoveAll(m.settingsDir(gameID, clone))
+		return nil, err
+	}
+	return clone, nil
+}
+
+func (m *Manager) Rename(gameID string, name string, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profiles, err := m.load(gameID)
+	if err != nil {
+		return err
+	}
+	profile := profiles.find(name)
+	if profile == nil {
+		return fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+	}
+	// Changing only the case of the name is allowed
+	if !strings.EqualFold(name, newName) {
+		if err := checkName(profiles, newName); err != nil {
+			return err
+		}
+	} else if strings.TrimSpace(newName) == "" {
+		return errors.New("the profile needs a name")
+	}
+	profile.Name = newName
+	return m.save(gameID, profiles)
+}
+
+// Delete removes a profile and its settings. The installed mods are left as they are.
+func (m *Manager) Delete(gameID string, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profiles, err := m.load(gameID)
+	if err != nil {
+		return err
+	}
+	profile := profiles.find(name)
+	if profile == nil {
+		return fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+	}
+	profiles.Profiles = slices.DeleteFunc(profiles.Profiles, func(other *Profile) bool { return other == profile })
+	if profiles.Active == profile.ID {
+		profiles.Active = ""
+	}
+	if err := m.save(gameID, profiles); err != nil {
+		return err
+	}
+	return os.RemoveAll(m.settingsDir(gameID, profile))
+}
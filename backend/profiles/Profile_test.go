@@ -0,0 +1,203 @@
+package profiles
+
+import (
+	"TotalControl/backend/loadorder"
+	"TotalControl/backend/mods"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testProvider keeps the installed mods in memory and the mod settings in a file.
+type testProvider struct {
+	installed []mods.Mod
+	catalogue map[string]string
+	settings  string
+	running   bool
+	order     []string
+	orderErr  error
+}
+
+func (p *testProvider) GameID() string { return "game" }
+func (p *testProvider) Capabilities() mods.Capabilities {
+	return mods.Capabilities{Install: true, Toggle: true, LoadOrder: true}
+}
+func (p *testProvider) InstalledMods(ctx context.Context) ([]mods.Mod, error) {
+	return append([]mods.Mod(nil), p.installed...), nil
+}
+func (p *testProvider) SearchMods(ctx context.Context, query mods.SearchQuery) ([]mods.Mod, error) {
+	return nil, mods.ErrNotSupported
+}
+func (p *testProvider) GetMod(ctx context.Context, id string) (*mods.Mod, error) {
+	return nil, mods.ErrNotSupported
+}
+func (p *testProvider) InstallMod(ctx context.Context, id string, version string) (*mods.Mod, error) {
+	if p.catalogue[id] != version {
+		return nil, mods.ErrModNotFound
+	}
+	p.installed = append(p.installed, mods.Mod{ID: id, Version: version})
+	return &p.installed[len(p.installed)-1], nil
+}
+func (p *testProvider) UninstallMod(ctx context.Context, id string) error {
+	return mods.ErrNotSupported
+}
+func (p *testProvider) SetModEnabled(ctx context.Context, id string, enabled bool) error {
+	mod, ok := mods.FindMod(p.installed, id)
+	if !ok {
+		return mods.ErrModNotFound
+	}
+	mod.Enabled = enabled
+	return nil
+}
+func (p *testProvider) UpdateMod(ctx context.Context, id string, version string) (*mods.Mod, error) {
+	return nil, mods.ErrNotSupported
+}
+func (p *testProvider) IsGameRunning(ctx context.Context) (bool, error) { return p.running, nil }
+func (p *testProvider) ModSettingsFiles() []string                      { return []string{p.settings} }
+func (p *testProvider) WriteLoadOrder(ctx context.Context, order []string) error {
+	if p.orderErr != nil {
+		return p.orderErr
+	}
+	p.order = order
+	return nil
+}
+
+func (p *testProvider) enabled() []string {
+	var ids []string
+	for _, mod := range p.installed {
+		if mod.Enabled {
+			ids = append(ids, mod.ID)
+		}
+	}
+	return ids
+}
+
+func setup(t *testing.T) (*Manager, *testProvider) {
+	dir := t.TempDir()
+	provider := &testProvider{
+		installed: []mods.Mod{
+			{ID: "core", Version: "1.0.0", Enabled: true},
+			{ID: "ui", Version: "2.0.0", Enabled: true, Dependencies: []string{"core"}},
+			{ID: "planets", Version: "0.6.0"},
+		},
+		catalogue: map[string]string{"rockets": "1.1.0"},
+		settings:  filepath.Join(dir, "game", "mod-settings.dat"),
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Dir(provider.settings), 0755))
+	assert.NoError(t, os.WriteFile(provider.settings, []byte("vanilla settings"), 0644))
+	return NewManager(filepath.Join(dir, "profiles"), loadorder.NewStore(filepath.Join(dir, "loadorder"))), provider
+}
+
+func TestManager_Switch(t *testing.T) {
+	manager, provider := setup(t)
+	ctx := context.Background()
+
+	_, err := manager.Create(ctx, provider, "Vanilla+")
+	assert.NoError(t, err)
+	_, err = manager.Clone("game", "vanilla+", "Space")
+	assert.NoError(t, err)
+	_, err = manager.Clone("game", "Vanilla+", "space")
+	assert.ErrorIs(t, err, ErrProfileExists)
+
+	// Edit the clone the way the UI would
+	profiles, err := manager.load("game")
+	assert.NoError(t, err)
+	space := profiles.find("Space")
+	space.Mods = []ModRef{{ID: "core"}, {ID: "planets", Version: "0.6.0"}, {ID: "rockets", Version: "1.1.0"}}
+	space.LoadOrder = []string{"rockets", "planets", "core"}
+	assert.NoError(t, manager.save("game", profiles))
+
+	assert.NoError(t, manager.Switch(ctx, provider, "Space"))
+	assert.Equal(t, []string{"core", "planets", "rockets"}, provider.enabled())
+	assert.Equal(t, []string{"rockets", "planets", "core"}, provider.order)
+	_, active, _ := manager.List("game")
+	assert.Equal(t, "Space", active)
+
+	// Settings changed while Space is active stay with Space
+	assert.NoError(t, os.WriteFile(provider.settings, []byte("space settings"), 0644))
+	assert.NoError(t, manager.Switch(ctx, provider, "Vanilla+"))
+	assert.Equal(t, []string{"core", "ui"}, provider.enabled())
+	content, _ := os.ReadFile(provider.settings)
+	assert.Equal(t, "vanilla settings", string(content))
+
+	assert.NoError(t, manager.Switch(ctx, provider, "Space"))
+	content, _ = os.ReadFile(provider.settings)
+	assert.Equal(t, "space settings", string(content))
+}
+
+func TestManager_SwitchRefused(t *testing.T) {
+	manager, provider := setup(t)
+	ctx := context.Background()
+	_, err := manager.Create(ctx, provider, "Vanilla+")
+	assert.NoError(t, err)
+	_, err = manager.Clone("game", "Vanilla+", "Broken")
+	assert.NoError(t, err)
+	profiles, _ := manager.load("game")
+	profiles.find("Broken").Mods = []ModRef{{ID: "core"}, {ID: "rockets", Version: "9.9.9"}}
+	assert.NoError(t, manager.save("game", profiles))
+
+	provider.running = true
+	assert.ErrorIs(t, manager.Switch(ctx, provider, "Broken"), mods.ErrGameRunning)
+	provider.running = false
+
+	// A mod that cannot be installed stops the switch before anything changes
+	assert.ErrorIs(t, manager.Switch(ctx, provider, "Broken"), mods.ErrModNotFound)
+	assert.Equal(t, []string{"core", "ui"}, provider.enabled())
+	_, active, _ := manager.List("game")
+	assert.Equal(t, "Vanilla+", active)
+
+	assert.ErrorIs(t, manager.Switch(ctx, provider, "missing"), ErrProfileNotFound)
+}
+
+func TestManager_SwitchRestoresSettings(t *testing.T) {
+	manager, provider := setup(t)
+	ctx := context.Background()
+	_, err := manager.Create(ctx, provider, "Vanilla+")
+	assert.NoError(t, err)
+	_, err = manager.Clone("game", "Vanilla+", "Space")
+	assert.NoError(t, err)
+	assert.NoError(t, manager.Switch(ctx, provider, "Space"))
+	assert.NoError(t, os.WriteFile(provider.settings, []byte("space settings"), 0644))
+
+	// The settings were already swapped when writing the load order fails
+	provider.orderErr = errors.New("disk full")
+	assert.ErrorIs(t, manager.Switch(ctx, provider, "Vanilla+"), provider.orderErr)
+	content, _ := os.ReadFile(provider.settings)
+	assert.Equal(t, "space settings", string(content))
+	_, active, _ := manager.List("game")
+	assert.Equal(t, "Space", active)
+
+	provider.orderErr = nil
+	assert.NoError(t, manager.Switch(ctx, provider, "Vanilla+"))
+	content, _ = os.ReadFile(provider.settings)
+	assert.Equal(t, "vanilla settings", string(content))
+	entries, _ := os.ReadDir(filepath.Join(filepath.Dir(filepath.Dir(provider.settings)), "profiles", "game"))
+	for _, entry := range entries {
+		assert.False(t, strings.HasPrefix(entry.Name(), ".switch-"), "backup %s was left behind", entry.Name())
+	}
+}
+
+func TestManager_RenameAndDelete(t *testing.T) {
+	manager, provider := setup(t)
+	created, err := manager.Create(context.Background(), provider, "Vanilla+")
+	assert.NoError(t, err)
+	assert.Equal(t, []ModRef{{ID: "core", Version: "1.0.0"}, {ID: "ui", Version: "2.0.0"}}, created.Mods)
+	assert.Equal(t, []string{"mod-settings.dat"}, created.Settings)
+
+	assert.NoError(t, manager.Rename("game", "Vanilla+", "vanilla+"))
+	assert.ErrorIs(t, manager.Rename("game", "other", "x"), ErrProfileNotFound)
+	profiles, active, err := manager.List("game")
+	assert.NoError(t, err)
+	assert.Equal(t, "vanilla+", active)
+	assert.Len(t, profiles, 1)
+
+	assert.NoError(t, manager.Delete("game", "vanilla+"))
+	profiles, active, _ = manager.List("game")
+	assert.Empty(t, profiles)
+	assert.Empty(t, active)
+	assert.NoDirExists(t, manager.settingsDir("game", created))
+}
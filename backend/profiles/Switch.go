@@ -0,0 +1,297 @@
+package profiles
+
+import (
+	"TotalControl/backend/dependencies"
+	"TotalControl/backend/loadorder"
+	"TotalControl/backend/mods"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SettingsFiles is implemented by providers whose games keep mod settings in files, like Factorio's
+// mod-settings.dat. Switching saves them into the profile that was active and restores those of the new one.
+type SettingsFiles interface {
+	ModSettingsFiles() []string
+}
+
+// EnabledApplier is implemented by providers that can set all enabled mods in one step, like rewriting Factorio's
+// mod-list.json. Without it, Switch calls SetModEnabled for every mod that changes.
+type EnabledApplier interface {
+	// ApplyEnabled enables exactly the given mods, pinned to their version if it is set, and disables the others.
+	ApplyEnabled(ctx context.Context, enabled []ModRef) error
+}
+
+// InstalledVersions is implemented by providers that keep several versions of a mod side by side, like Factorio.
+// If they are an EnabledApplier as well, Switch leaves pinning a version that is already installed to ApplyEnabled
+// instead of updating the mod.
+type InstalledVersions interface {
+	HasModVersion(id string, version string) bool
+}
+
+// Switch makes the installed mods match a profile: missing mods and versions are installed, the profile's mods
+// enabled and all others disabled, its load order and mod settings restored. It is refused with
+// mods.ErrGameRunning while the game runs.
+func (m *Manager) Switch(ctx context.Context, provider mods.ModProvider, name string) (err error) {
+	gameID := provider.GameID()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profiles, err := m.load(gameID)
+	if err != nil {
+		return err
+	}
+	profile := profiles.find(name)
+	if profile == nil {
+		return fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+	}
+
+	if process, ok := provider.(mods.GameProcess); ok {
+		running, err := process.IsGameRunning(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to check whether %s is running: %w", gameID, err)
+		}
+		if running {
+			return mods.ErrGameRunning
+		}
+	}
+
+	installed, err := m.installMissing(ctx, provider, profile)
+	if err != nil {
+		return err
+	}
+
+	// Switching to the active profile keeps the current settings
+	if files, ok := provider.(SettingsFiles); ok && profiles.Active != profile.ID {
+		paths := files.ModSettingsFiles()
+		backup, backupErr := m.backupSettings(gameID, paths)
+		if backupErr != nil {
+			return fmt.Errorf("failed to back up the mod settings: %w", backupErr)
+		}
+		defer backup.remove()
+		active := profiles.byID(profiles.Active)
+		if active != nil {
+			if err := m.saveSettings(gameID, active, paths); err != nil {
+				return fmt.Errorf("failed to save the mod settings of %s: %w", active.Name, err)
+			}
+		}
+		// Without an active profile the current settings were never saved, so they are only overwritten
+		if err := m.restoreSettings(gameID, profile, paths, active != nil); err != nil {
+			return backup.restore(fmt.Errorf("failed to restore the mod settings of %s: %w", profile.Name, err))
+		}
+		// From here on a failed switch puts the previous settings back, like the install pipeline rolls back
+		defer func() {
+			if err != nil {
+				err = backup.restore(err)
+			}
+		}()
+	}
+
+	if applier, ok := provider.(EnabledApplier); ok {
+		if err = applier.ApplyEnabled(ctx, profile.Mods); err == nil {
+			// Applying may have pinned versions that were installed but not active
+			installed, err = provider.InstalledMods(ctx)
+		}
+	} else {
+		for _, mod := range installed {
+			if enabled := profile.has(mod.ID); mod.Enabled != enabled {
+				err = errors.Join(err, provider.SetModEnabled(ctx, mod.ID, enabled))
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	var enabled []dependencies.Release
+	for _, mod := range installed {
+		if profile.has(mod.ID) {
+			enabled = append(enabled, dependencies.ReleaseFromMod(mod))
+		}
+	}
+	var writer loadorder.Writer
+	if w, ok := provider.(loadorder.Writer); ok && provider.Capabilities().LoadOrder {
+		writer = w
+	}
+	if _, err := m.loadOrders.Restore(ctx, gameID, profile.LoadOrder, enabled, writer); err != nil {
+		return err
+	}
+
+	profiles.Active = profile.ID
+	return m.save(gameID, profiles)
+}
+
+func (p *Profile) has(modID string) bool {
+	return slices.ContainsFunc(p.Mods, func(ref ModRef) bool { return strings.EqualFold(ref.ID, modID) })
+}
+
+// installMissing installs the mods of the profile that are missing or installed in another version, and returns
+// the installed mods afterwards. Nothing else has changed yet if it fails.
+func (m *Manager) installMissing(ctx context.Context, provider mods.ModProvider, profile *Profile) ([]mods.Mod, error) {
+	installed, err := provider.InstalledMods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions, _ := provider.(InstalledVersions)
+	if _, ok := provider.(EnabledApplier); !ok {
+		versions = nil
+	}
+	changed := false
+	for _, ref := range profile.Mods {
+		mod, ok := mods.FindMod(installed, ref.ID)
+		switch {
+		case !ok:
+			_, err = provider.InstallMod(ctx, ref.ID, ref.Version)
+		case ref.Version == "" || mod.Version == ref.Version:
+			continue
+		case versions != nil && versions.HasModVersion(ref.ID, ref.Version):
+			// Installed but not the active version, ApplyEnabled pins it
+			continue
+		default:
+			_, err = provider.UpdateMod(ctx, ref.ID, ref.Version)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("profile %s needs %s %s: %w", profile.Name, ref.ID, ref.Version, err)
+		}
+		changed = true
+	}
+	if !changed {
+		return installed, nil
+	}
+	return provider.InstalledMods(ctx)
+}
+
+// settingsBackup holds copies of the game's settings files from before a switch, so a switch that fails after
+// replacing them can put them back.
+type settingsBackup struct {
+	dir   string
+	paths []string
+	// saved are the files that existed.
+	saved map[string]bool
+}
+
+func (m *Manager) backupSettings(gameID string, paths []string) (*settingsBackup, error) {
+	parent := filepath.Join(m.dir, gameID)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(parent, ".switch-")
+	if err != nil {
+		return nil, err
+	}
+	backup := &settingsBackup{dir: dir, paths: paths, saved: make(map[string]bool)}
+	for i, path := range paths {
+		err := copyFile(path, filepath.Join(dir, strconv.Itoa(i)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			backup.remove()
+			return nil, err
+		}
+		backup.saved[path] = true
+	}
+	return backup, nil
+}
+
+// restore puts the files back as they were and returns cause, joined with any error restoring them.
+func (b *settingsBackup) restore(cause error) error {
+	var errs []error
+	for i, path := range b.paths {
+		if !b.saved[path] {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		tmp := path + ".tmp"
+		if err := copyFile(filepath.Join(b.dir, strconv.Itoa(i)), tmp); err != nil {
+			_ = os.Remove(tmp)
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			_ = os.Remove(tmp)
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w (restoring the mod settings failed: %w)", cause, errors.Join(errs...))
+	}
+	return cause
+}
+
+func (b *settingsBackup) remove() {
+	_ = os.RemoveAll(b.dir)
+}
+
+// saveSettings copies the game's settings files into the profile. Files the game does not have are left out.
+func (m *Manager) saveSettings(gameID string, profile *Profile, paths []string) error {
+	dir := m.settingsDir(gameID, profile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	profile.Settings = nil
+	for _, path := range paths {
+		name := filepath.Base(path)
+		err := copyFile(path, filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		profile.Settings = append(profile.Settings, name)
+	}
+	return nil
+}
+
+// restoreSettings copies the profile's settings files into the game. With remove, files the profile has no copy
+// of are deleted, so the game starts with its defaults.
+func (m *Manager) restoreSettings(gameID string, profile *Profile, paths []string, remove bool) error {
+	for _, path := range paths {
+		name := filepath.Base(path)
+		if !slices.Contains(profile.Settings, name) {
+			if remove {
+				if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+			continue
+		}
+		// Replaced through a temporary file, a half written settings file would break the game
+		tmp := path + ".tmp"
+		if err := copyFile(filepath.Join(m.settingsDir(gameID, profile), name), tmp); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
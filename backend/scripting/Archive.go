@@ -0,0 +1,111 @@
+package scripting
+
+import (
+	"TotalControl/backend/archive"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// luaStringList reads either a single string or a table of strings, as used for glob arguments.
+func luaStringList(value lua.LValue) []string {
+	var result []string
+	switch v := value.(type) {
+	case lua.LString:
+		result = append(result, string(v))
+	case *lua.LTable:
+		v.ForEach(func(_ lua.LValue, item lua.LValue) {
+			if item.Type() == lua.LTString {
+				result = append(result, item.String())
+			}
+		})
+	}
+	return result
+}
+
+func luaArchiveList(L *lua.LState) int {
+	archivePath := L.CheckString(1)
+
+	entries, err := archive.List(archivePath)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	result := L.CreateTable(len(entries), 0)
+	for _, entry := range entries {
+		entryTable := L.NewTable()
+		entryTable.RawSetString("name", lua.LString(entry.Name))
+		entryTable.RawSetString("size", lua.LNumber(entry.Size))
+		entryTable.RawSetString("is_dir", lua.LBool(entry.IsDir))
+		entryTable.RawSetString("modified", lua.LNumber(entry.ModTime.Unix()))
+		result.Append(entryTable)
+	}
+	L.Push(result)
+	return 1
+}
+
+func luaArchiveExtract(L *lua.LState) int {
+	archivePath := L.CheckString(1)
+	destination := L.CheckString(2)
+
+	options := archive.ExtractOptions{}
+	if optionsTable, ok := L.Get(3).(*lua.LTable); ok {
+		options.Include = luaStringList(optionsTable.RawGetString("include"))
+		options.Exclude = luaStringList(optionsTable.RawGetString("exclude"))
+		if strip, ok := optionsTable.RawGetString("strip_components").(lua.LNumber); ok {
+			options.StripComponents = int(strip)
+		}
+	}
+
+	files, err := archive.Extract(archivePath, destination, options)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	result := L.CreateTable(len(files), 0)
+	for _, file := range files {
+		result.Append(lua.LString(file))
+	}
+	L.Push(result)
+	return 1
+}
+
+func luaArchiveCreate(L *lua.LState) int {
+	archivePath := L.CheckString(1)
+	sourceDir := L.CheckString(2)
+
+	options := archive.CreateOptions{}
+	if optionsTable, ok := L.Get(3).(*lua.LTable); ok {
+		options.Include = luaStringList(optionsTable.RawGetString("include"))
+		options.Exclude = luaStringList(optionsTable.RawGetString("exclude"))
+	}
+
+	if err := archive.Create(archivePath, sourceDir, options); err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+func luaArchiveFormat(L *lua.LState) int {
+	format := archive.DetectFormat(L.CheckString(1))
+	if format == archive.FormatUnknown {
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(lua.LString(format.String()))
+	return 1
+}
+
+func luaRegisterArchiveObject(L *lua.LState) {
+	archiveTable := L.NewTable()
+	archiveTable.RawSetString("list", L.NewFunction(luaArchiveList))
+	archiveTable.RawSetString("extract", L.NewFunction(luaArchiveExtract))
+	archiveTable.RawSetString("create", L.NewFunction(luaArchiveCreate))
+	archiveTable.RawSetString("format", L.NewFunction(luaArchiveFormat))
+	L.SetGlobal("archive", archiveTable)
+}
@@ -0,0 +1,37 @@
+package scripting
+
+import (
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLuaArchive(t *testing.T) {
+	engine := newTestLuaEngine(t)
+	defer engine.Close()
+
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "source", "mymod"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "source", "mymod", "info.json"), []byte("{}"), 0644))
+
+	engine.L.SetGlobal("dir", lua.LString(filepath.ToSlash(dir)))
+	err := engine.LoadScript(`
+		assert(archive.create(dir .. "/mymod.zip", dir .. "/source"))
+		assert(archive.format(dir .. "/mymod.zip") == "zip")
+		local entries = assert(archive.list(dir .. "/mymod.zip"))
+		assert(#entries > 0)
+		local files = assert(archive.extract(dir .. "/mymod.zip", dir .. "/mods", { strip_components = 1 }))
+		assert(#files == 1)
+
+		local missing, err = archive.list(dir .. "/missing.zip")
+		assert(missing == nil and type(err) == "string")
+		missing, err = archive.extract(dir .. "/missing.zip", dir .. "/mods")
+		assert(missing == nil and type(err) == "string")
+		missing, err = archive.create(dir .. "/missing/mymod.unknown", dir .. "/source")
+		assert(missing == nil and type(err) == "string")
+	`)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "mods", "info.json"))
+}
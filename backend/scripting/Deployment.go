@@ -0,0 +1,127 @@
+package scripting
+
+import (
+	"TotalControl/backend/archive"
+	"TotalControl/backend/install"
+	lua "github.com/yuin/gopher-lua"
+	"time"
+)
+
+const luaDeploymentTypeName = "Deployment"
+
+func luaCheckDeployment(L *lua.LState) *install.Deployment {
+	if deployment, ok := L.CheckUserData(1).Value.(*install.Deployment); ok {
+		return deployment
+	}
+	L.ArgError(1, "Deployment expected")
+	return nil
+}
+
+func luaStringTable(L *lua.LState, values []string) *lua.LTable {
+	result := L.CreateTable(len(values), 0)
+	for _, value := range values {
+		result.Append(lua.LString(value))
+	}
+	return result
+}
+
+// luaDeploymentRecord converts a record to {method, mods, files = {{path, mod_id, replaced}}, dirs, conflicts =
+// {{path, mod_ids}}, deployed_at}, or nil.
+func luaDeploymentRecord(L *lua.LState, record *install.DeploymentRecord) lua.LValue {
+	if record == nil {
+		return lua.LNil
+	}
+	files := L.CreateTable(len(record.Files), 0)
+	for _, file := range record.Files {
+		entry := L.NewTable()
+		entry.RawSetString("path", lua.LString(file.Path))
+		entry.RawSetString("mod_id", lua.LString(file.ModID))
+		entry.RawSetString("replaced", lua.LBool(file.Replaced))
+		files.Append(entry)
+	}
+	conflicts := L.CreateTable(len(record.Conflicts), 0)
+	for _, conflict := range record.Conflicts {
+		entry := L.NewTable()
+		entry.RawSetString("path", lua.LString(conflict.Path))
+		entry.RawSetString("mod_ids", luaStringTable(L, conflict.ModIDs))
+		conflicts.Append(entry)
+	}
+	result := L.NewTable()
+	result.RawSetString("method", lua.LString(record.Method))
+	result.RawSetString("mods", luaStringTable(L, record.Mods))
+	result.RawSetString("files", files)
+	result.RawSetString("dirs", luaStringTable(L, record.Dirs))
+	result.RawSetString("conflicts", conflicts)
+	result.RawSetString("deployed_at", lua.LString(record.DeployedAt.Format(time.RFC3339)))
+	return result
+}
+
+// pushDeploymentRecord pushes the record, or nil and an error message.
+func pushDeploymentRecord(L *lua.LState, record *install.DeploymentRecord, err error) int {
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(luaDeploymentRecord(L, record))
+	return 1
+}
+
+var luaDeploymentMethods = map[string]lua.LGFunction{
+	// stage(mod_id, source, strip_components) extracts an archive or copies a directory into the staging directory.
+	"stage": func(L *lua.LState) int {
+		deployment := luaCheckDeployment(L)
+		options := archive.ExtractOptions{StripComponents: L.OptInt(4, 0)}
+		return luaPushResult(L, deployment.Stage(L.CheckString(2), L.CheckString(3), options))
+	},
+	"unstage": func(L *lua.LState) int {
+		deployment := luaCheckDeployment(L)
+		return luaPushResult(L, deployment.Unstage(L.CheckString(2)))
+	},
+	"staged": func(L *lua.LState) int {
+		staged, err := luaCheckDeployment(L).Staged()
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(luaStringTable(L, staged))
+		return 1
+	},
+	// deploy(mod_ids) replaces the current deployment with the given mods, later ones win conflicts.
+	"deploy": func(L *lua.LState) int {
+		deployment := luaCheckDeployment(L)
+		record, err := deployment.Deploy(luaStringList(L.CheckTable(2)))
+		return pushDeploymentRecord(L, record, err)
+	},
+	"purge": func(L *lua.LState) int {
+		return luaPushResult(L, luaCheckDeployment(L).Purge())
+	},
+	"record": func(L *lua.LState) int {
+		record, err := luaCheckDeployment(L).Record()
+		return pushDeploymentRecord(L, record, err)
+	},
+}
+
+// luaRegisterDeploymentObject registers the deployment table, which deploys staged mods into loose-file games.
+func luaRegisterDeploymentObject(L *lua.LState) {
+	mt := L.NewTypeMetatable(luaDeploymentTypeName)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), luaDeploymentMethods))
+
+	deploymentTable := L.NewTable()
+	// open(game_id, game_dir) returns the game's deployment, staged mods are kept per game.
+	deploymentTable.RawSetString("open", L.NewFunction(func(L *lua.LState) int {
+		deployment, err := install.ForGame(L.CheckString(1), L.CheckString(2))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		ud := L.NewUserData()
+		ud.Value = deployment
+		L.SetMetatable(ud, L.GetTypeMetatable(luaDeploymentTypeName))
+		L.Push(ud)
+		return 1
+	}))
+	L.SetGlobal("deployment", deploymentTable)
+}
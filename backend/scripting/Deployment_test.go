@@ -0,0 +1,25 @@
+package scripting
+
+import (
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"testing"
+)
+
+func TestLuaDeployment(t *testing.T) {
+	engine := newTestLuaEngine(t)
+	defer engine.Close()
+
+	engine.L.SetGlobal("game_dir", lua.LString(t.TempDir()))
+	err := engine.LoadScript(`
+		local broken, message = deployment.open("../game", game_dir)
+		assert(broken == nil and message ~= nil)
+		local game = assert(deployment.open("deployment-test", game_dir))
+		assert(game:record() == nil)
+		assert(#game:staged() == 0)
+		local ok, message = game:stage("../escape", game_dir)
+		assert(not ok and message ~= nil)
+		assert(game:deploy({ "missing" }) == nil)
+	`)
+	assert.NoError(t, err)
+}
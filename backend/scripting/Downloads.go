@@ -0,0 +1,103 @@
+package scripting
+
+import (
+	"TotalControl/backend/downloads"
+	"context"
+	lua "github.com/yuin/gopher-lua"
+)
+
+func luaProgressToTable(L *lua.LState, progress downloads.Progress) *lua.LTable {
+	tbl := L.NewTable()
+	tbl.RawSetString("id", lua.LString(progress.ID))
+	tbl.RawSetString("url", lua.LString(progress.URL))
+	tbl.RawSetString("path", lua.LString(progress.Destination))
+	tbl.RawSetString("status", lua.LString(progress.Status))
+	tbl.RawSetString("bytes_done", lua.LNumber(progress.BytesDone))
+	tbl.RawSetString("bytes_total", lua.LNumber(progress.BytesTotal))
+	if progress.Error != "" {
+		tbl.RawSetString("error", lua.LString(progress.Error))
+	}
+	return tbl
+}
+
+// luaDownloadsEnqueue queues a download and returns its id without waiting for it.
+func luaDownloadsEnqueue(L *lua.LState) int {
+	options := L.CheckTable(1)
+	url, _ := options.RawGetString("url").(lua.LString)
+	path, _ := options.RawGetString("path").(lua.LString)
+	request := downloads.Request{
+		URL:         string(url),
+		Destination: string(path),
+		Client:      getHttpClient(L),
+	}
+	if priority, ok := options.RawGetString("priority").(lua.LNumber); ok {
+		request.Priority = int(priority)
+	}
+	if retries, ok := options.RawGetString("retries").(lua.LNumber); ok {
+		request.Retries = int(retries)
+	}
+	if algorithm, expected := luaChecksumFromTable(options); algorithm != "" {
+		request.Checksum = &downloads.Checksum{Algorithm: algorithm, Value: expected}
+	}
+
+	download, err := downloads.Default().Enqueue(request)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LString(download.ID))
+	L.Push(lua.LNil)
+	return 2
+}
+
+func luaDownloadsWait(L *lua.LState) int {
+	download, ok := downloads.Default().Get(L.CheckString(1))
+	if !ok {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString("download not found"))
+		return 2
+	}
+	ctx := L.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := download.Wait(ctx); err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	L.Push(lua.LNil)
+	return 2
+}
+
+func luaDownloadsCancel(L *lua.LState) int {
+	if err := downloads.Default().Cancel(L.CheckString(1)); err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	L.Push(lua.LNil)
+	return 2
+}
+
+func luaDownloadsStatus(L *lua.LState) int {
+	download, ok := downloads.Default().Get(L.CheckString(1))
+	if !ok {
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(luaProgressToTable(L, download.Progress()))
+	return 1
+}
+
+func luaRegisterDownloadsObject(L *lua.LState) {
+	downloadsTable := L.NewTable()
+	downloadsTable.RawSetString("enqueue", L.NewFunction(luaDownloadsEnqueue))
+	downloadsTable.RawSetString("wait", L.NewFunction(luaDownloadsWait))
+	downloadsTable.RawSetString("cancel", L.NewFunction(luaDownloadsCancel))
+	downloadsTable.RawSetString("status", L.NewFunction(luaDownloadsStatus))
+	L.SetGlobal("downloads", downloadsTable)
+}
@@ -0,0 +1,133 @@
+package scripting
+
+import (
+	"TotalControl/backend/formats"
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLuaIni(t *testing.T) {
+	engine := newTestLuaEngine(t)
+	defer engine.Close()
+
+	err := engine.LoadScript(`
+		local file = ini.parse("; comment\n[General]\nsLanguage=ENGLISH\n")
+		assert(file:get("General", "sLanguage") == "ENGLISH")
+		assert(file:get("General", "missing") == nil)
+		file:set("General", "bAlwaysActive", true)
+		file:set("Display", "iSize W", 1920)
+		assert(file:delete("General", "sLanguage"))
+		result = tostring(file)
+
+		local locale = cfg.parse("name=Helmod\n[mod-description]\nhelmod=Planner\n")
+		assert(locale:get("name") == "Helmod")
+		assert(locale:to_table()["mod-description"].helmod == "Planner")
+		assert(ini.parse == cfg.parse)
+	`)
+	assert.NoError(t, err)
+	assert.Equal(t, lua.LString("; comment\n[General]\nbAlwaysActive=1\n\n[Display]\niSize W=1920\n"), engine.L.GetGlobal("result"))
+}
+
+func TestLuaXml(t *testing.T) {
+	engine := newTestLuaEngine(t)
+	defer engine.Close()
+
+	path := filepath.Join(t.TempDir(), "ModsConfig.xml")
+	err := os.WriteFile(path, []byte("<ModsConfigData>\n  <activeMods>\n    <li>ludeon.rimworld</li>\n  </activeMods>\n</ModsConfigData>\n"), 0644)
+	assert.NoError(t, err)
+
+	engine.L.SetGlobal("path", lua.LString(path))
+	err = engine.LoadScript(`
+		local document = assert(xml.load(path))
+		local activeMods = xml.find(document, "ModsConfigData/activeMods")
+		assert(xml.text(xml.find_all(activeMods, "li")[1]) == "ludeon.rimworld")
+		table.insert(activeMods.children, #activeMods.children, "\n    ")
+		table.insert(activeMods.children, #activeMods.children, xml.element("li", { enabled = "true" }, "brrainz.harmony"))
+		assert(xml.save(path, document))
+		assert(xml.parse("<a><b></a>") == nil)
+	`)
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "<ModsConfigData>\n  <activeMods>\n    <li>ludeon.rimworld</li>\n    <li enabled=\"true\">brrainz.harmony</li>\n  </activeMods>\n</ModsConfigData>\n", string(data))
+}
+
+func TestLuaYamlAndToml(t *testing.T) {
+	engine := newTestLuaEngine(t)
+	defer engine.Close()
+
+	err := engine.LoadScript(`
+		local config = assert(yaml.decode("mods:\n  - name: a\n    enabled: true\n"))
+		assert(config.mods[1].name == "a" and config.mods[1].enabled == true)
+		yamlResult = yaml.encode({ count = 3, names = { "a", "b" } })
+
+		local modengine = assert(toml.decode('[modengine]\ndebug = false\n[[mods]]\npath = "mod"\n'))
+		assert(modengine.modengine.debug == false and modengine.mods[1].path == "mod")
+		modengine.modengine.debug = true
+		tomlResult = toml.encode(modengine)
+		local value, err = toml.encode({ "not", "a", "table" })
+		assert(value == nil and err ~= nil)
+	`)
+	assert.NoError(t, err)
+	assert.Equal(t, lua.LString("count: 3\nnames:\n  - a\n  - b\n"), engine.L.GetGlobal("yamlResult"))
+	assert.Equal(t, lua.LString("[modengine]\ndebug = true\n\n[[mods]]\npath = \"mod\"\n"), engine.L.GetGlobal("tomlResult"))
+}
+
+func TestLuaTomlSave_KeepsComments(t *testing.T) {
+	engine := newTestLuaEngine(t)
+	defer engine.Close()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	assert.NoError(t, os.WriteFile(path, []byte("# ModEngine\n[modengine]\ndebug = false # logging\nscale = 1.0\n"), 0644))
+	engine.L.SetGlobal("path", lua.LString(path))
+	err := engine.LoadScript(`
+		local config = assert(toml.load(path))
+		config.modengine.debug = true
+		config.modengine.scale = 2
+		assert(toml.save(path, config))
+	`)
+	assert.NoError(t, err)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "# ModEngine\n[modengine]\ndebug = true # logging\nscale = 2.0\n", string(data))
+}
+
+func TestLuaModSettings(t *testing.T) {
+	engine := newTestLuaEngine(t)
+	defer engine.Close()
+
+	path := filepath.Join(t.TempDir(), "mod-settings.dat")
+	settings := formats.NewModSettings([4]uint16{2, 0, 28, 0})
+	assert.NoError(t, settings.Set(formats.SettingStartup, "helmod_size", 0.5))
+	assert.NoError(t, settings.Set(formats.SettingRuntimeGlobal, "helmod_color", map[string]any{"r": 1.0}))
+	assert.NoError(t, settings.Save(path))
+
+	engine.L.SetGlobal("path", lua.LString(path))
+	err := engine.LoadScript(`
+		local settings = assert(modsettings.load(path))
+		assert(settings:version() == "2.0.28")
+		assert(settings:get("startup", "helmod_size") == 0.5)
+		assert(settings:get("runtime-global", "helmod_color").r == 1)
+		assert(#settings:settings("helmod_") == 2)
+		assert(settings:set("startup", "helmod_size", 2))
+		assert(settings:set("runtime-per-user", "rso_enabled", true))
+		local ok, message = settings:set("runtime", "rso_enabled", true)
+		assert(not ok and message ~= nil)
+		assert(settings:to_table()["runtime-per-user"].rso_enabled)
+		assert(settings:save(path))
+		assert(modsettings.parse("broken") == nil)
+	`)
+	assert.NoError(t, err)
+
+	saved, err := formats.LoadModSettings(path)
+	if assert.NoError(t, err) {
+		value, _ := saved.Get(formats.SettingStartup, "helmod_size")
+		assert.Equal(t, 2.0, value)
+		value, _ = saved.Get(formats.SettingRuntimePerUser, "rso_enabled")
+		assert.Equal(t, true, value)
+	}
+}
@@ -0,0 +1,82 @@
+package scripting
+
+import (
+	"TotalControl/backend/utils"
+	lua "github.com/yuin/gopher-lua"
+	"strings"
+)
+
+func luaHashString(L *lua.LState) int {
+	content := L.CheckString(1)
+	algorithm := L.OptString(2, utils.HashSHA256)
+
+	digest, err := utils.HashString(content, algorithm)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LString(digest))
+	return 1
+}
+
+func luaHashFile(L *lua.LState) int {
+	filePath := L.CheckString(1)
+	algorithm := L.OptString(2, utils.HashSHA256)
+
+	digest, err := utils.HashFile(filePath, algorithm)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LString(digest))
+	return 1
+}
+
+// luaHashVerify returns true when the file matches, or false and the reason when it does not.
+func luaHashVerify(L *lua.LState) int {
+	filePath := L.CheckString(1)
+	algorithm := L.CheckString(2)
+	expected := L.CheckString(3)
+
+	if err := utils.VerifyFile(filePath, algorithm, expected); err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	L.Push(lua.LNil)
+	return 2
+}
+
+// luaChecksumFromTable reads a checksum description like { sha1 = "..." } or
+// { algorithm = "sha1", value = "..." }. It returns empty strings if none was given.
+func luaChecksumFromTable(tbl *lua.LTable) (string, string) {
+	if tbl == nil {
+		return "", ""
+	}
+	if algorithm, ok := tbl.RawGetString("algorithm").(lua.LString); ok {
+		if value, ok := tbl.RawGetString("value").(lua.LString); ok {
+			return strings.ToLower(string(algorithm)), string(value)
+		}
+		return "", ""
+	}
+	for _, algorithm := range utils.SupportedHashAlgorithms {
+		if value, ok := tbl.RawGetString(algorithm).(lua.LString); ok {
+			return algorithm, string(value)
+		}
+	}
+	return "", ""
+}
+
+func luaRegisterHashObject(L *lua.LState) {
+	hashTable := L.NewTable()
+	hashTable.RawSetString("string", L.NewFunction(luaHashString))
+	hashTable.RawSetString("file", L.NewFunction(luaHashFile))
+	hashTable.RawSetString("verify", L.NewFunction(luaHashVerify))
+	for _, algorithm := range utils.SupportedHashAlgorithms {
+		hashTable.RawSetString(strings.ToUpper(algorithm), lua.LString(algorithm))
+	}
+	L.SetGlobal("hash", hashTable)
+}
@@ -0,0 +1,31 @@
+package scripting
+
+import (
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLuaHash(t *testing.T) {
+	engine := newTestLuaEngine(t)
+	defer engine.Close()
+
+	path := filepath.Join(t.TempDir(), "mod.zip")
+	assert.NoError(t, os.WriteFile(path, []byte("abc"), 0644))
+
+	engine.L.SetGlobal("path", lua.LString(path))
+	err := engine.LoadScript(`
+		local digest = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+		assert(hash.string("abc") == digest)
+		assert(hash.file(path, hash.SHA256) == digest)
+		assert(hash.verify(path, "sha256", digest))
+
+		local missing, err = hash.file(path .. ".missing")
+		assert(missing == nil and type(err) == "string")
+		missing, err = hash.string("abc", "whirlpool")
+		assert(missing == nil and type(err) == "string")
+	`)
+	assert.NoError(t, err)
+}
@@ -1,13 +1,16 @@
 package scripting
 
 import (
+	"TotalControl/backend/downloads"
+	"TotalControl/backend/httpclient"
 	"TotalControl/backend/utils"
+	"context"
 	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/yuin/gopher-lua"
-	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,10 +18,12 @@ import (
 )
 
 type HttpResponse struct {
-	StatusCode int
-	Headers    http.Header
-	Body       string
-	JsonBody   map[string]interface{}
+	StatusCode  int
+	Headers     http.Header
+	Body        string
+	JsonBody    interface{}
+	ContentType string
+	URL         string
 }
 
 type HttpRequest struct {
@@ -45,16 +50,7 @@ func newHttpResponseUserData(L *lua.LState, resp *HttpResponse) *lua.LUserData {
 			sb.WriteString("}, Body: ")
 			sb.WriteString(v.Body)
 			if v.JsonBody != nil {
-				sb.WriteString(", JsonBody: {")
-				first := true
-				for k, val := range v.JsonBody {
-					if !first {
-						sb.WriteString(", ")
-					}
-					first = false
-					sb.WriteString(fmt.Sprintf("%s: %v", k, val))
-				}
-				sb.WriteString("}")
+				sb.WriteString(fmt.Sprintf(", JsonBody: %v", v.JsonBody))
 			}
 			sb.WriteString(")")
 			L.Push(lua.LString(sb.String()))
@@ -85,6 +81,7 @@ func newHttpRequestUserData(L *lua.LState, req *HttpRequest) *lua.LUserData {
 
 func luaRegisterHttpObject(L *lua.LState) {
 	httpTable := L.NewTable()
+	httpTable.RawSetString("request", L.NewFunction(luaHttpRequest))
 	httpTable.RawSetString("get", L.NewFunction(luaHttpGet))
 	httpTable.RawSetString("post", L.NewFunction(luaHttpPost))
 	httpTable.RawSetString("downloadFile", L.NewFunction(luaHttpDownloadFile))
@@ -142,10 +139,22 @@ func registerHttpResponseType(l *lua.LState) {
 				L.Push(headersTable)
 			case "body":
 				if v.JsonBody != nil {
-					L.Push(utils.MapToLuaTable(L, v.JsonBody)) // Convert JsonBody to Lua table
+					L.Push(utils.ToLuaValue(L, v.JsonBody)) // Convert JsonBody to Lua table
 				} else {
 					L.Push(lua.LString(v.Body))
 				}
+			case "raw_body":
+				L.Push(lua.LString(v.Body))
+			case "json":
+				L.Push(utils.ToLuaValue(L, v.JsonBody))
+			case "ok":
+				L.Push(lua.LBool(v.StatusCode >= 200 && v.StatusCode < 300))
+			case "content_type":
+				L.Push(lua.LString(v.ContentType))
+			case "url":
+				L.Push(lua.LString(v.URL))
+			case "cache_status":
+				L.Push(lua.LString(v.Headers.Get(httpclient.CacheStatusHeader)))
 			default:
 				L.Push(lua.LNil) // Return nil for unknown fields
 			}
@@ -206,7 +215,6 @@ func getHttpRequestFromStack(L *lua.LState) (*HttpRequest, error) {
 		if !ok {
 			return nil, fmt.Errorf("expected HttpRequest userdata, got %s", L.Get(1).Type().String())
 		}
-		println("HTTP GET request:", httpReq.URL)
 	} else {
 		httpReq = &HttpRequest{
 			URL: L.ToString(1),
@@ -215,98 +223,131 @@ func getHttpRequestFromStack(L *lua.LState) (*HttpRequest, error) {
 	return httpReq, nil
 }
 
-func httpRequest(request *HttpRequest) (*HttpResponse, error) {
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+// getHttpClient returns the client of the engine running L, so requests carry the plugin's User-Agent.
+func getHttpClient(L *lua.LState) *httpclient.Client {
+	if engine := GetLuaEngine(L); engine != nil && engine.http != nil {
+		return engine.http
 	}
+	return httpclient.Default()
+}
 
-	var req *http.Request
-	var err error
+func httpRequest(L *lua.LState, request *HttpRequest) (*HttpResponse, error) {
+	return doHttpRequest(L, &httpclient.Request{
+		Method:  request.Method,
+		URL:     request.URL,
+		Headers: request.Headers,
+		Body:    []byte(request.Body),
+	})
+}
 
-	if request.Method == "GET" {
-		req, err = http.NewRequest("GET", request.URL, http.NoBody)
-	} else if request.Method == "POST" {
-		req, err = http.NewRequest("POST", request.URL, strings.NewReader(request.Body))
-		if err == nil {
-			for key, value := range request.Headers {
-				req.Header.Set(key, value)
-			}
-		}
-	} else if request.Method == "PUT" {
-		req, err = http.NewRequest("PUT", request.URL, strings.NewReader(request.Body))
-		if err == nil {
-			for key, value := range request.Headers {
-				req.Header.Set(key, value)
-			}
-		}
-	} else if request.Method == "DELETE" {
-		req, err = http.NewRequest("DELETE", request.URL, http.NoBody)
-		if err == nil {
-			for key, value := range request.Headers {
-				req.Header.Set(key, value)
-			}
-		}
-	} else if request.Method == "PATCH" {
-		req, err = http.NewRequest("PATCH", request.URL, strings.NewReader(request.Body))
-		if err == nil {
-			for key, value := range request.Headers {
-				req.Header.Set(key, value)
-			}
-		}
-	} else if request.Method == "HEAD" {
-		req, err = http.NewRequest("HEAD", request.URL, http.NoBody)
-		if err == nil {
-			for key, value := range request.Headers {
-				req.Header.Set(key, value)
-			}
-		}
-	} else if request.Method == "OPTIONS" {
-		req, err = http.NewRequest("OPTIONS", request.URL, http.NoBody)
-		if err == nil {
-			for key, value := range request.Headers {
-				req.Header.Set(key, value)
-			}
-		}
-	} else {
-		return nil, fmt.Errorf("unsupported HTTP method: %s", request.Method)
+func doHttpRequest(L *lua.LState, request *httpclient.Request) (*HttpResponse, error) {
+	ctx := L.Context()
+	if ctx == nil {
+		ctx = context.Background()
 	}
-
+	resp, err := getHttpClient(L).Do(ctx, request)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+		return nil, err
 	}
+	return &HttpResponse{
+		StatusCode:  resp.StatusCode,
+		Headers:     resp.Headers,
+		Body:        string(resp.Body),
+		JsonBody:    resp.JSON,
+		ContentType: resp.ContentType,
+		URL:         resp.URL,
+	}, nil
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("HTTP request failed: %v", err)
+// luaHttpRequestFromTable converts the argument of http.request into a request.
+// Timeouts are given in seconds and may be fractional.
+func luaHttpRequestFromTable(tbl *lua.LTable) (*httpclient.Request, error) {
+	request := &httpclient.Request{
+		Method:  "GET",
+		Headers: map[string]string{},
+		Query:   url.Values{},
 	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Errorf("Failed to close response body: %v", err)
-		}
-	}(resp.Body)
 
-	bodyStr, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+	if method, ok := tbl.RawGetString("method").(lua.LString); ok {
+		request.Method = strings.ToUpper(string(method))
 	}
+	requestUrl, ok := tbl.RawGetString("url").(lua.LString)
+	if !ok || requestUrl == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	request.URL = string(requestUrl)
 
-	response := &HttpResponse{
-		StatusCode: resp.StatusCode,
-		Headers:    resp.Header,
-		Body:       string(bodyStr),
-		JsonBody:   nil,
+	if headers, ok := tbl.RawGetString("headers").(*lua.LTable); ok {
+		headers.ForEach(func(key lua.LValue, value lua.LValue) {
+			request.Headers[key.String()] = value.String()
+		})
+	}
+	if query, ok := tbl.RawGetString("query").(*lua.LTable); ok {
+		query.ForEach(func(key lua.LValue, value lua.LValue) {
+			if values, ok := value.(*lua.LTable); ok {
+				values.ForEach(func(_ lua.LValue, item lua.LValue) {
+					request.Query.Add(key.String(), item.String())
+				})
+				return
+			}
+			request.Query.Add(key.String(), value.String())
+		})
 	}
 
-	if resp.Header.Get("Content-Type") == "application/json" {
-		var jsonData map[string]interface{}
-		if err := json.Unmarshal(bodyStr, &jsonData); err != nil {
-			return nil, fmt.Errorf("failed to parse JSON response: %v", err)
+	switch body := tbl.RawGetString("body").(type) {
+	case lua.LString:
+		request.Body = []byte(body)
+	case *lua.LTable:
+		// Tables are sent as JSON, which is what every API we talk to expects
+		encoded, err := json.Marshal(utils.LuaValueToInterface(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode body as JSON: %w", err)
+		}
+		request.Body = encoded
+		if _, ok := request.Headers["Content-Type"]; !ok {
+			request.Headers["Content-Type"] = "application/json"
 		}
-		response.JsonBody = jsonData
 	}
 
-	return response, nil
+	if timeout, ok := tbl.RawGetString("timeout").(lua.LNumber); ok && timeout > 0 {
+		request.Timeout = time.Duration(float64(timeout) * float64(time.Second))
+	}
+	if retries, ok := tbl.RawGetString("retries").(lua.LNumber); ok && retries > 0 {
+		request.Retries = int(retries)
+	}
+	if follow, ok := tbl.RawGetString("follow_redirects").(lua.LBool); ok {
+		request.DisableRedirects = !bool(follow)
+	}
+	if cache, ok := tbl.RawGetString("cache").(*lua.LTable); ok {
+		request.Cache = &httpclient.CacheOptions{
+			NoCache: lua.LVAsBool(cache.RawGetString("no_cache")),
+			NoStore: lua.LVAsBool(cache.RawGetString("no_store")),
+		}
+		if maxStale, ok := cache.RawGetString("max_stale").(lua.LNumber); ok && maxStale > 0 {
+			request.Cache.MaxStale = time.Duration(float64(maxStale) * float64(time.Second))
+		}
+	}
+	return request, nil
+}
+
+func luaHttpRequest(L *lua.LState) int {
+	request, err := luaHttpRequestFromTable(L.CheckTable(1))
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(fmt.Sprintf("http.request: %v", err)))
+		return 2
+	}
+
+	resp, err := doHttpRequest(L, request)
+	if err != nil {
+		log.Errorf("HTTP %s request failed: %v", request.Method, err)
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(newHttpResponseUserData(L, resp))
+	L.Push(lua.LNil) // No error
+	return 2
 }
 
 func luaHttpGet(L *lua.LState) int {
@@ -323,7 +364,7 @@ func luaHttpGet(L *lua.LState) int {
 	}
 	httpReq.Method = "GET"
 
-	resp, err := httpRequest(httpReq)
+	resp, err := httpRequest(L, httpReq)
 	if err != nil {
 		log.Errorf("HTTP GET request failed: %v", err)
 		L.Push(lua.LNil)
@@ -351,7 +392,7 @@ func luaHttpPost(L *lua.LState) int {
 	}
 	httpReq.Method = "POST"
 
-	resp, err := httpRequest(httpReq)
+	resp, err := httpRequest(L, httpReq)
 	if err != nil {
 		log.Errorf("HTTP POST request failed: %v", err)
 		L.Push(lua.LNil)
@@ -366,13 +407,14 @@ func luaHttpPost(L *lua.LState) int {
 }
 
 func luaHttpDownloadFile(L *lua.LState) int {
-	if L.GetTop() != 2 {
+	if L.GetTop() < 2 || L.GetTop() > 3 {
 		L.Push(lua.LBool(false))
-		L.Push(lua.LString("Expected 2 arguments: url and filePath"))
+		L.Push(lua.LString("Expected 2 or 3 arguments: url, filePath and an optional checksum table"))
 		return 2
 	}
 	url := L.ToString(1)
 	filePath := L.ToString(2)
+	algorithm, expected := luaChecksumFromTable(L.OptTable(3, nil))
 
 	fileDir := filepath.Dir(filePath)
 	if _, err := os.Stat(fileDir); os.IsNotExist(err) {
@@ -381,34 +423,23 @@ func luaHttpDownloadFile(L *lua.LState) int {
 		return 2
 	}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		L.Push(lua.LBool(false))
-		L.Push(lua.LString(err.Error()))
-		return 2
+	// The plugin's client, so its rate limits and cassette apply to downloads too
+	request := downloads.Request{
+		URL:         url,
+		Destination: filePath,
+		Retries:     3,
+		Client:      getHttpClient(L),
 	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Errorf("Failed to close response body: %v", err)
-		}
-	}(resp.Body)
-
-	if resp.StatusCode != http.StatusOK {
-		L.Push(lua.LBool(false))
-		L.Push(lua.LString("Failed to download file: " + resp.Status))
-		return 2
+	if algorithm != "" {
+		// Verified before the file is moved into place, a mismatch never leaves a file behind
+		request.Checksum = &downloads.Checksum{Algorithm: algorithm, Value: expected}
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		L.Push(lua.LBool(false))
-		L.Push(lua.LString(err.Error()))
-		return 2
+	ctx := L.Context()
+	if ctx == nil {
+		ctx = context.Background()
 	}
-
-	err = os.WriteFile(filePath, body, 0644)
-	if err != nil {
+	if err := downloads.Default().Download(ctx, request); err != nil {
 		L.Push(lua.LBool(false))
 		L.Push(lua.LString(err.Error()))
 		return 2
@@ -0,0 +1,74 @@
+package scripting
+
+import (
+	"TotalControl/backend/httpclient"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLuaHttpRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "bar", r.URL.Query().Get("foo"))
+		assert.Equal(t, "yes", r.Header.Get("X-Test"))
+		assert.JSONEq(t, `{"ids":[1,2]}`, string(body))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write([]byte(`{"results":[{"name":"mod-a"}]}`))
+	}))
+	defer server.Close()
+
+	engine := newTestLuaEngine(t)
+	defer engine.Close()
+
+	engine.L.SetGlobal("server_url", lua.LString(server.URL))
+	err := engine.LoadScript(`
+		local response, err = http.request{
+			method = "put",
+			url = server_url,
+			query = { foo = "bar" },
+			headers = { ["X-Test"] = "yes" },
+			body = { ids = { 1, 2 } },
+			timeout = 5,
+			retries = 1,
+		}
+		assert(err == nil, err)
+		status = response.status_code
+		ok = response.ok
+		first = response.body.results[1].name
+	`)
+	assert.NoError(t, err)
+	assert.Equal(t, lua.LNumber(200), engine.L.GetGlobal("status"))
+	assert.Equal(t, lua.LTrue, engine.L.GetGlobal("ok"))
+	assert.Equal(t, lua.LString("mod-a"), engine.L.GetGlobal("first"))
+}
+
+func TestLuaHttpDownloadFile_Cassette(t *testing.T) {
+	dir := t.TempDir()
+	cassette := filepath.Join(dir, "cassette.json")
+	// The host does not exist, the download only succeeds if it is replayed
+	assert.NoError(t, os.WriteFile(cassette, []byte(`{"interactions": [{
+		"request": {"method": "GET", "url": "https://mods.invalid/mod.zip"},
+		"response": {"status_code": 200, "body": "mod archive"}
+	}]}`), 0644))
+	engine, err := NewLuaEngine(uuid.New(), WithCassette(cassette, httpclient.ModeReplay))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer engine.Close()
+
+	engine.L.SetGlobal("path", lua.LString(filepath.Join(dir, "mod.zip")))
+	assert.NoError(t, engine.LoadScript(`
+		assert(http.downloadFile("https://mods.invalid/mod.zip", path))
+	`))
+	content, err := os.ReadFile(filepath.Join(dir, "mod.zip"))
+	assert.NoError(t, err)
+	assert.Equal(t, "mod archive", string(content))
+}
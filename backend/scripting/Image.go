@@ -0,0 +1,103 @@
+package scripting
+
+import (
+	"TotalControl/backend/images"
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+	"image"
+)
+
+func luaPushImageError(L *lua.LState, err error) int {
+	L.Push(lua.LNil)
+	L.Push(lua.LString(err.Error()))
+	return 2
+}
+
+// luaPushEncodedImage encodes in the requested format, or in the source format if none was given.
+func luaPushEncodedImage(L *lua.LState, img image.Image, sourceFormat string, formatArg int) int {
+	data, err := images.Encode(img, L.OptString(formatArg, sourceFormat))
+	if err != nil {
+		return luaPushImageError(L, err)
+	}
+	L.Push(lua.LString(data))
+	return 1
+}
+
+// luaImageThumbnail returns a cached PNG of the image cropped and scaled to width x height, and its dominant colour.
+func luaImageThumbnail(L *lua.LState) int {
+	data := []byte(L.CheckString(1))
+	width := L.OptInt(2, images.ModThumbnailSize)
+	height := L.OptInt(3, width)
+	if width > images.MaxThumbnailSize || height > images.MaxThumbnailSize {
+		return luaPushImageError(L, fmt.Errorf("%w: thumbnails are at most %dx%d", images.ErrTooLarge,
+			images.MaxThumbnailSize, images.MaxThumbnailSize))
+	}
+
+	thumbnail, err := images.DefaultThumbnails().Get(data, width, height)
+	if err != nil {
+		return luaPushImageError(L, err)
+	}
+	L.Push(lua.LString(thumbnail.Data))
+	L.Push(lua.LString(thumbnail.DominantColor))
+	return 2
+}
+
+func luaImageInfo(L *lua.LState) int {
+	img, format, err := images.Decode([]byte(L.CheckString(1)))
+	if err != nil {
+		return luaPushImageError(L, err)
+	}
+	info := L.NewTable()
+	info.RawSetString("width", lua.LNumber(img.Bounds().Dx()))
+	info.RawSetString("height", lua.LNumber(img.Bounds().Dy()))
+	info.RawSetString("format", lua.LString(format))
+	info.RawSetString("dominant_color", lua.LString(images.HexColor(images.DominantColor(img))))
+	L.Push(info)
+	return 1
+}
+
+func luaImageResize(L *lua.LState) int {
+	data := L.CheckString(1)
+	width, height := L.CheckInt(2), L.CheckInt(3)
+	// Checked before decoding, which is the expensive part
+	if err := images.CheckSize(width, height); err != nil {
+		return luaPushImageError(L, err)
+	}
+	img, format, err := images.Decode([]byte(data))
+	if err != nil {
+		return luaPushImageError(L, err)
+	}
+	resized, err := images.Resize(img, width, height)
+	if err != nil {
+		return luaPushImageError(L, err)
+	}
+	return luaPushEncodedImage(L, resized, format, 4)
+}
+
+func luaImageCrop(L *lua.LState) int {
+	img, format, err := images.Decode([]byte(L.CheckString(1)))
+	if err != nil {
+		return luaPushImageError(L, err)
+	}
+	x, y := L.CheckInt(2), L.CheckInt(3)
+	cropped, err := images.Crop(img, image.Rect(x, y, x+L.CheckInt(4), y+L.CheckInt(5)))
+	if err != nil {
+		return luaPushImageError(L, err)
+	}
+	return luaPushEncodedImage(L, cropped, format, 6)
+}
+
+func luaImageDataURL(L *lua.LState) int {
+	L.Push(lua.LString(images.DataURL([]byte(L.CheckString(1)))))
+	return 1
+}
+
+func luaRegisterImageObject(L *lua.LState) {
+	imageTable := L.NewTable()
+	imageTable.RawSetString("thumbnail", L.NewFunction(luaImageThumbnail))
+	imageTable.RawSetString("info", L.NewFunction(luaImageInfo))
+	imageTable.RawSetString("resize", L.NewFunction(luaImageResize))
+	imageTable.RawSetString("crop", L.NewFunction(luaImageCrop))
+	imageTable.RawSetString("data_url", L.NewFunction(luaImageDataURL))
+	L.SetGlobal("image", imageTable)
+}
@@ -0,0 +1,42 @@
+package scripting
+
+import (
+	"TotalControl/backend/images"
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"image"
+	"testing"
+)
+
+func TestLuaImage(t *testing.T) {
+	engine := newTestLuaEngine(t)
+	defer engine.Close()
+
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+3] = 255, 255
+	}
+	data, err := images.Encode(img, images.FormatPNG)
+	assert.NoError(t, err)
+	engine.L.SetGlobal("png", lua.LString(data))
+
+	err = engine.LoadScript(`
+		local info = assert(image.info(png))
+		assert(info.width == 40 and info.height == 20 and info.format == "png")
+		assert(info.dominant_color == "#ff0000")
+
+		local small = assert(image.resize(png, 4, 2, "jpeg"))
+		assert(image.info(small).format == "jpeg")
+		local huge, err = image.resize(png, 100000, 100000)
+		assert(huge == nil and string.find(err, "too large"))
+		assert(image.resize(png, 0, 2) == nil)
+		local thumbnail, err = image.thumbnail(png, 100000, 100000)
+		assert(thumbnail == nil and string.find(err, "too large"))
+		local cropped = assert(image.crop(png, 10, 0, 10, 10))
+		assert(image.info(cropped).width == 10)
+		assert(image.crop(png, 35, 0, 10, 10) == nil)
+		assert(image.info("not an image") == nil)
+		assert(string.sub(image.data_url(png), 1, 22) == "data:image/png;base64,")
+	`)
+	assert.NoError(t, err)
+}
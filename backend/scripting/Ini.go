@@ -0,0 +1,175 @@
+package scripting
+
+import (
+	"TotalControl/backend/formats"
+	lua "github.com/yuin/gopher-lua"
+	"os"
+)
+
+const luaIniFileTypeName = "IniFile"
+
+func newIniFileUserData(L *lua.LState, file *formats.IniFile) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = file
+	L.SetMetatable(ud, L.GetTypeMetatable(luaIniFileTypeName))
+	return ud
+}
+
+func luaCheckIniFile(L *lua.LState) *formats.IniFile {
+	if file, ok := L.CheckUserData(1).Value.(*formats.IniFile); ok {
+		return file
+	}
+	L.ArgError(1, "IniFile expected")
+	return nil
+}
+
+// luaIniParse returns an IniFile, or nil and an error message.
+func luaIniParse(L *lua.LState) int {
+	file, err := formats.ParseIni([]byte(L.CheckString(1)))
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(newIniFileUserData(L, file))
+	return 1
+}
+
+func luaIniLoad(L *lua.LState) int {
+	data, err := os.ReadFile(L.CheckString(1))
+	if err == nil {
+		var file *formats.IniFile
+		if file, err = formats.ParseIni(data); err == nil {
+			L.Push(newIniFileUserData(L, file))
+			return 1
+		}
+	}
+	L.Push(lua.LNil)
+	L.Push(lua.LString(err.Error()))
+	return 2
+}
+
+func luaIniNew(L *lua.LState) int {
+	L.Push(newIniFileUserData(L, formats.NewIniFile()))
+	return 1
+}
+
+// The section is optional for methods taking a key, so file:get("key") reads keys before the first section.
+func luaIniSectionAndKey(L *lua.LState) (string, string, int) {
+	if L.GetTop() >= 3 && L.Get(3).Type() != lua.LTNil {
+		return L.CheckString(2), L.CheckString(3), 4
+	}
+	return "", L.CheckString(2), 3
+}
+
+var luaIniFileMethods = map[string]lua.LGFunction{
+	"get": func(L *lua.LState) int {
+		file := luaCheckIniFile(L)
+		section, key, _ := luaIniSectionAndKey(L)
+		if value, ok := file.Get(section, key); ok {
+			L.Push(lua.LString(value))
+		} else {
+			L.Push(lua.LNil)
+		}
+		return 1
+	},
+	"has": func(L *lua.LState) int {
+		file := luaCheckIniFile(L)
+		section, key, _ := luaIniSectionAndKey(L)
+		_, ok := file.Get(section, key)
+		L.Push(lua.LBool(ok))
+		return 1
+	},
+	"set": func(L *lua.LState) int {
+		file := luaCheckIniFile(L)
+		var section, key string
+		var valueIndex int
+		if L.GetTop() >= 4 {
+			section, key, valueIndex = L.CheckString(2), L.CheckString(3), 4
+		} else {
+			key, valueIndex = L.CheckString(2), 3
+		}
+		value := L.CheckAny(valueIndex)
+		if value.Type() == lua.LTBool {
+			// Most games read booleans in INI files as 0 and 1
+			if lua.LVAsBool(value) {
+				value = lua.LString("1")
+			} else {
+				value = lua.LString("0")
+			}
+		}
+		file.Set(section, key, lua.LVAsString(value))
+		return 0
+	},
+	"delete": func(L *lua.LState) int {
+		file := luaCheckIniFile(L)
+		section, key, _ := luaIniSectionAndKey(L)
+		L.Push(lua.LBool(file.Delete(section, key)))
+		return 1
+	},
+	"delete_section": func(L *lua.LState) int {
+		file := luaCheckIniFile(L)
+		L.Push(lua.LBool(file.DeleteSection(L.CheckString(2))))
+		return 1
+	},
+	"sections": func(L *lua.LState) int {
+		file := luaCheckIniFile(L)
+		result := L.NewTable()
+		for _, section := range file.Sections() {
+			result.Append(lua.LString(section))
+		}
+		L.Push(result)
+		return 1
+	},
+	"keys": func(L *lua.LState) int {
+		file := luaCheckIniFile(L)
+		result := L.NewTable()
+		for _, key := range file.Keys(L.OptString(2, "")) {
+			result.Append(lua.LString(key))
+		}
+		L.Push(result)
+		return 1
+	},
+	"to_table": func(L *lua.LState) int {
+		file := luaCheckIniFile(L)
+		result := L.NewTable()
+		for section, values := range file.Map() {
+			sectionTable := L.NewTable()
+			for key, value := range values {
+				sectionTable.RawSetString(key, lua.LString(value))
+			}
+			result.RawSetString(section, sectionTable)
+		}
+		L.Push(result)
+		return 1
+	},
+	"save": func(L *lua.LState) int {
+		file := luaCheckIniFile(L)
+		if err := os.WriteFile(L.CheckString(2), file.Bytes(), 0644); err != nil {
+			L.Push(lua.LFalse)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(lua.LTrue)
+		return 1
+	},
+	"tostring": func(L *lua.LState) int {
+		L.Push(lua.LString(luaCheckIniFile(L).String()))
+		return 1
+	},
+}
+
+// luaRegisterIniObject registers the ini table. Factorio's locale files use the same format, so it is also
+// available as cfg.
+func luaRegisterIniObject(L *lua.LState) {
+	mt := L.NewTypeMetatable(luaIniFileTypeName)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), luaIniFileMethods))
+	L.SetField(mt, "__tostring", L.NewFunction(luaIniFileMethods["tostring"]))
+
+	iniTable := L.NewTable()
+	iniTable.RawSetString("parse", L.NewFunction(luaIniParse))
+	iniTable.RawSetString("load", L.NewFunction(luaIniLoad))
+	iniTable.RawSetString("new", L.NewFunction(luaIniNew))
+	L.SetGlobal("ini", iniTable)
+	L.SetGlobal("cfg", iniTable)
+}
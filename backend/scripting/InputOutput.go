@@ -124,6 +124,8 @@ func luaExtendIoTable(l *lua.LState) {
 		tbl = l.NewTable()
 		l.SetGlobal("io", tbl)
 	}
+	// io.popen runs anything through the shell, plugins use the process module instead
+	l.SetField(tbl, "popen", lua.LNil)
 	l.SetField(tbl, "readFileFromZip", l.NewFunction(LuaReadFileFromZip))
 	l.SetField(tbl, "readFilesFromZip", l.NewFunction(LuaReadFilesFromZip))
 	l.SetField(tbl, "getFilesInDirectory", l.NewFunction(LuaGetFilesInDirectory))
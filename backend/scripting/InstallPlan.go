@@ -0,0 +1,131 @@
+package scripting
+
+import (
+	"TotalControl/backend/downloads"
+	"TotalControl/backend/install"
+	"context"
+	"errors"
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// luaEnabledList updates the game's enabled list by calling the plugin's UpdateEnabledList(self, enable, forget).
+type luaEnabledList struct {
+	provider *LuaProvider
+	path     string
+}
+
+func (l *luaEnabledList) Path() string {
+	return l.path
+}
+
+func (l *luaEnabledList) Update(ctx context.Context, enable map[string]bool, forget []string) error {
+	enableTable := l.provider.engine.L.NewTable()
+	for name, enabled := range enable {
+		enableTable.RawSetString(name, lua.LBool(enabled))
+	}
+	forgetTable := l.provider.engine.L.NewTable()
+	for _, name := range forget {
+		forgetTable.Append(lua.LString(name))
+	}
+	return l.provider.call(ctx, "UpdateEnabledList", nil, enableTable, forgetTable)
+}
+
+// luaInstallPlan converts the table returned by a plugin's PlanInstall, PlanUpdate or PlanUninstall:
+//
+//	{
+//	    root = "/path/to/mods",
+//	    artifacts = { { mod_id = "...", url = "...", checksum = { algorithm = "sha1", value = "..." },
+//	                    target = "mod_1.0.0.zip", extract = false, strip_components = 0 } },
+//	    remove = { "mod_0.9.0.zip" },
+//	    enabled_list = "/path/to/mods/mod-list.json",
+//	    enable = { mod = true },
+//	    forget = { "other" },
+//	}
+func (p *LuaProvider) luaInstallPlan(value lua.LValue) (*install.Plan, error) {
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("expected Lua table for the install plan, got %s", value.Type().String())
+	}
+	plan := &install.Plan{
+		GameID: p.gameID,
+		Root:   lua.LVAsString(table.RawGetString("root")),
+		Remove: luaStringList(table.RawGetString("remove")),
+		Forget: luaStringList(table.RawGetString("forget")),
+		Client: p.engine.http,
+	}
+
+	if artifacts, ok := table.RawGetString("artifacts").(*lua.LTable); ok {
+		var err error
+		artifacts.ForEach(func(_ lua.LValue, value lua.LValue) {
+			entry, ok := value.(*lua.LTable)
+			if !ok {
+				err = errors.Join(err, fmt.Errorf("expected Lua table for artifact, got %s", value.Type().String()))
+				return
+			}
+			artifact := install.Artifact{
+				ModID:           lua.LVAsString(entry.RawGetString("mod_id")),
+				URL:             lua.LVAsString(entry.RawGetString("url")),
+				Source:          lua.LVAsString(entry.RawGetString("source")),
+				Target:          lua.LVAsString(entry.RawGetString("target")),
+				Extract:         lua.LVAsBool(entry.RawGetString("extract")),
+				StripComponents: int(lua.LVAsNumber(entry.RawGetString("strip_components"))),
+			}
+			if headers, ok := entry.RawGetString("headers").(*lua.LTable); ok {
+				artifact.Headers = make(map[string]string)
+				headers.ForEach(func(key lua.LValue, value lua.LValue) {
+					artifact.Headers[key.String()] = value.String()
+				})
+			}
+			if checksum, ok := entry.RawGetString("checksum").(*lua.LTable); ok {
+				artifact.Checksum = &downloads.Checksum{
+					Algorithm: lua.LVAsString(checksum.RawGetString("algorithm")),
+					Value:     lua.LVAsString(checksum.RawGetString("value")),
+				}
+			}
+			plan.Artifacts = append(plan.Artifacts, artifact)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if enable, ok := table.RawGetString("enable").(*lua.LTable); ok {
+		plan.Enable = make(map[string]bool)
+		enable.ForEach(func(key lua.LValue, value lua.LValue) {
+			plan.Enable[key.String()] = lua.LVAsBool(value)
+		})
+	}
+	if path := lua.LVAsString(table.RawGetString("enabled_list")); path != "" {
+		if !p.hasFunction("UpdateEnabledList") {
+			return nil, errors.New("the plan has an enabled_list but the plugin has no UpdateEnabledList function")
+		}
+		plan.EnabledList = &luaEnabledList{provider: p, path: path}
+	}
+	return plan, nil
+}
+
+// runPlan asks the plugin for a plan and carries it out with the install pipeline.
+func (p *LuaProvider) runPlan(ctx context.Context, method string, args ...lua.LValue) error {
+	plan, err := p.plan(ctx, method, args...)
+	if err != nil {
+		return err
+	}
+	return p.installer().Run(ctx, plan)
+}
+
+func (p *LuaProvider) plan(ctx context.Context, method string, args ...lua.LValue) (*install.Plan, error) {
+	var plan *install.Plan
+	err := p.call(ctx, method, func(value lua.LValue) (err error) {
+		plan, err = p.luaInstallPlan(value)
+		return err
+	}, args...)
+	return plan, err
+}
+
+func (p *LuaProvider) installer() *install.Pipeline {
+	if p.Installer != nil {
+		return p.Installer
+	}
+	return install.Default()
+}
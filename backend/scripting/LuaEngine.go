@@ -4,6 +4,9 @@ package scripting
 // TODO: Create threads for each lua script execution
 
 import (
+	"TotalControl/backend/httpclient"
+	"TotalControl/backend/process"
+	"TotalControl/backend/secrets"
 	"TotalControl/backend/utils"
 	"context"
 	"errors"
@@ -12,21 +15,82 @@ import (
 	log "github.com/sirupsen/logrus"
 	lua "github.com/yuin/gopher-lua"
 	"strings"
+	"sync"
 	"time"
 )
 
 type LuaEngine struct {
-	uuid  uuid.UUID
-	L     *lua.LState
-	cache *utils.Cache
+	uuid    uuid.UUID
+	L       *lua.LState
+	cache   *utils.Cache
+	http    *httpclient.Client
+	secrets *secrets.Vault
+	// processes lists the executables the process module may start, nothing if empty.
+	processes process.Allowlist
+	// state serializes access to L between host calls and timers. Lua functions called from Go run while it is
+	// held, so they must not call back into the engine's locking methods.
+	state  sync.Mutex
+	timers *luaTimers
 }
 
-func NewLuaEngine(luaEngineId uuid.UUID) (*LuaEngine, error) {
+// EngineOption configures a LuaEngine before it is set up.
+type EngineOption func(*LuaEngine) error
+
+// WithHttpClient makes the engine send its HTTP requests through client.
+func WithHttpClient(client *httpclient.Client) EngineOption {
+	return func(l *LuaEngine) error {
+		l.http = client
+		return nil
+	}
+}
+
+// WithCassette records the engine's HTTP traffic to, or replays it from, the cassette at path.
+func WithCassette(path string, mode httpclient.CassetteMode) EngineOption {
+	return func(l *LuaEngine) error {
+		client, err := httpclient.NewCassetteClient(path, mode)
+		if err != nil {
+			return err
+		}
+		l.http = client
+		return nil
+	}
+}
+
+// WithSecrets makes the engine keep its secrets in vault instead of the default vault.
+func WithSecrets(vault *secrets.Vault) EngineOption {
+	return func(l *LuaEngine) error {
+		l.secrets = vault
+		return nil
+	}
+}
+
+// WithProcessPermission allows the engine to run the given executables, see process.Allowlist.
+func WithProcessPermission(executables ...string) EngineOption {
+	return func(l *LuaEngine) error {
+		l.processes = append(l.processes, executables...)
+		return nil
+	}
+}
+
+func (l *LuaEngine) applyOptions(options []EngineOption) error {
+	for _, option := range options {
+		if err := option(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewLuaEngine(luaEngineId uuid.UUID, options ...EngineOption) (*LuaEngine, error) {
 	L := lua.NewState()
 	engine := &LuaEngine{
 		L:    L,
 		uuid: luaEngineId,
 	}
+	if err := engine.applyOptions(options); err != nil {
+		L.Close()
+		return nil, err
+	}
 	err := engine.Setup()
 	if err != nil {
 		return nil, err
@@ -55,6 +119,10 @@ func (l *LuaEngine) Setup() error {
 		log.Errorf("Failed to create cache directory: %v", err)
 	}
 	l.cache = utils.NewCache(fmt.Sprintf("plugins/.cache/%s.json", l.uuid.String()))
+	if l.http == nil {
+		l.http = httpclient.Default()
+	}
+	l.timers = &luaTimers{pending: make(map[int]*luaTimer)}
 
 	l.L.OpenLibs() // This could be really bad if we allow all libraries!
 	l.L.SetGlobal("print", l.L.NewFunction(luaPrint))
@@ -74,11 +142,28 @@ func (l *LuaEngine) Setup() error {
 	luaExtendOsTable(l.L)
 	luaRegisterHttpObject(l.L)
 	luaRegisterCacheObject(l.L)
+	luaRegisterArchiveObject(l.L)
+	luaRegisterHashObject(l.L)
+	luaRegisterDownloadsObject(l.L)
+	luaRegisterSecretsObject(l.L)
+	luaRegisterVersionObject(l.L)
+	luaRegisterIniObject(l.L)
+	luaRegisterModSettingsObject(l.L)
+	luaRegisterDeploymentObject(l.L)
+	luaRegisterXmlObject(l.L)
+	luaRegisterYamlObject(l.L)
+	luaRegisterTomlObject(l.L)
+	luaRegisterImageObject(l.L)
+	luaRegisterProcessObject(l.L)
+	luaRegisterTimerObject(l.L)
 
 	return nil
 }
 
 func (l *LuaEngine) Shutdown() {
+	l.stopTimers()
+	l.state.Lock()
+	defer l.state.Unlock()
 	if l.cache != nil {
 		err := l.cache.Save(fmt.Sprintf("plugins/.cache/%s.json", l.uuid.String()))
 		if err != nil {
@@ -101,6 +186,8 @@ func (l *LuaEngine) CheckLuaError(err error) {
 }
 
 func (l *LuaEngine) LoadScript(script string) error {
+	l.state.Lock()
+	defer l.state.Unlock()
 	if err := l.L.DoString(script); err != nil {
 		return err
 	}
@@ -108,6 +195,8 @@ func (l *LuaEngine) LoadScript(script string) error {
 }
 
 func (l *LuaEngine) LoadFile(filename string) error {
+	l.state.Lock()
+	defer l.state.Unlock()
 	if err := l.L.DoFile(filename); err != nil {
 		return err
 	}
@@ -120,11 +209,14 @@ func (l *LuaEngine) DoStringWithTimeout(script string, timeout time.Duration) er
 
 	errCh := make(chan error, 1)
 	go func() {
+		l.state.Lock()
+		defer l.state.Unlock()
 		errCh <- l.L.DoString(script)
 	}()
 
 	select {
 	case <-ctx.Done():
+		l.stopTimers()
 		l.L.Close() // forcibly close the Lua state
 		return errors.New("Lua script execution timed out")
 	case err := <-errCh:
@@ -133,6 +225,13 @@ func (l *LuaEngine) DoStringWithTimeout(script string, timeout time.Duration) er
 }
 
 func (l *LuaEngine) CallFunc(obj *lua.LTable, method *lua.LFunction, args ...lua.LValue) (lua.LValue, error) {
+	l.state.Lock()
+	defer l.state.Unlock()
+	return l.callFunc(obj, method, args...)
+}
+
+// callFunc is CallFunc for callers that already hold the state lock.
+func (l *LuaEngine) callFunc(obj *lua.LTable, method *lua.LFunction, args ...lua.LValue) (lua.LValue, error) {
 	if obj == nil || method == nil {
 		return lua.LNil, errors.New("object or method is nil")
 	}
@@ -158,6 +257,8 @@ func (l *LuaEngine) CallFunc(obj *lua.LTable, method *lua.LFunction, args ...lua
 }
 
 func (l *LuaEngine) CallGlobal(method string, args ...lua.LValue) (lua.LValue, error) {
+	l.state.Lock()
+	defer l.state.Unlock()
 	fn := l.L.GetGlobal(method)
 	if fn.Type() != lua.LTFunction {
 		return lua.LNil, fmt.Errorf("method '%s' not found in Lua engine", method)
@@ -175,6 +276,8 @@ func (l *LuaEngine) CallGlobal(method string, args ...lua.LValue) (lua.LValue, e
 }
 
 func (l *LuaEngine) Call(obj lua.LValue, method string, args ...lua.LValue) (lua.LValue, error) {
+	l.state.Lock()
+	defer l.state.Unlock()
 	if obj.Type() != lua.LTTable {
 		return lua.LNil, fmt.Errorf("object is not a Lua table")
 	}
@@ -219,6 +322,9 @@ func (l *LuaEngine) HasMethod(obj lua.LValue, method string) bool {
 }
 
 func (l *LuaEngine) Close() {
+	l.stopTimers()
+	l.state.Lock()
+	defer l.state.Unlock()
 	l.L.Close()
 }
 
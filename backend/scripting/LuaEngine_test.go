@@ -4,22 +4,30 @@ import (
 	"os"
 	"testing"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	lua "github.com/yuin/gopher-lua"
 )
 
+// Helper to create a LuaEngine with a random ID
+func newTestLuaEngine(t *testing.T) *LuaEngine {
+	engine, err := NewLuaEngine(uuid.New())
+	assert.NoError(t, err)
+	return engine
+}
+
 func TestNewLuaEngine_Setup(t *testing.T) {
-	engine := NewLuaEngine()
+	engine := newTestLuaEngine(t)
 	defer engine.Close()
 
 	assert.NotNil(t, engine.L)
 	assert.Equal(t, lua.LTFunction, engine.L.GetGlobal("print").Type())
-	assert.Equal(t, lua.LTTable, engine.L.GetGlobal("operating_system").Type())
+	assert.Equal(t, lua.LTTable, engine.L.GetGlobal("os").Type())
 	assert.Equal(t, lua.LTTable, engine.L.GetGlobal("log").Type())
 }
 
 func TestLuaEngine_LoadScript(t *testing.T) {
-	engine := NewLuaEngine()
+	engine := newTestLuaEngine(t)
 	defer engine.Close()
 
 	err := engine.LoadScript(`a = 123`)
@@ -30,7 +38,7 @@ func TestLuaEngine_LoadScript(t *testing.T) {
 }
 
 func TestLuaEngine_LoadFile(t *testing.T) {
-	engine := NewLuaEngine()
+	engine := newTestLuaEngine(t)
 	defer engine.Close()
 
 	f, err := os.CreateTemp("", "test.lua")
@@ -54,7 +62,7 @@ func TestLuaEngine_LoadFile(t *testing.T) {
 }
 
 func TestLuaEngine_CallGlobal(t *testing.T) {
-	engine := NewLuaEngine()
+	engine := newTestLuaEngine(t)
 	defer engine.Close()
 
 	err := engine.LoadScript(`function add(a, b) return a + b end`)
@@ -65,7 +73,7 @@ func TestLuaEngine_CallGlobal(t *testing.T) {
 }
 
 func TestLuaEngine_Call(t *testing.T) {
-	engine := NewLuaEngine()
+	engine := newTestLuaEngine(t)
 	defer engine.Close()
 
 	err := engine.LoadScript(`
@@ -81,7 +89,7 @@ func TestLuaEngine_Call(t *testing.T) {
 }
 
 func TestLuaEngine_CheckLuaError(t *testing.T) {
-	engine := NewLuaEngine()
+	engine := newTestLuaEngine(t)
 	defer engine.Close()
 
 	defer func() {
@@ -93,13 +101,13 @@ func TestLuaEngine_CheckLuaError(t *testing.T) {
 }
 
 func TestLuaEngine_debugPrintLuaState(t *testing.T) {
-	engine := NewLuaEngine()
+	engine := newTestLuaEngine(t)
 	defer engine.Close()
 	engine.debugPrintLuaState()
 }
 
 func TestLuaOsGetenv(t *testing.T) {
-	engine := NewLuaEngine()
+	engine := newTestLuaEngine(t)
 	defer engine.Close()
 
 	err := os.Setenv("LUA_TEST_ENV", "hello")
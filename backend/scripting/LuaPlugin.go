@@ -1,21 +1,41 @@
 package scripting
 
 import (
+	"TotalControl/backend/httpclient"
 	"TotalControl/backend/mods"
+	"TotalControl/backend/secrets"
 	"TotalControl/backend/utils"
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 	lua "github.com/yuin/gopher-lua"
+	"os"
 	"path/filepath"
+	"strings"
 )
 
+// PluginExtension is the extension of packaged (zipped) plugins.
+const PluginExtension = ".tcplugin"
+
 type PluginInfo struct {
 	Id         uuid.UUID `json:"id"`
 	Name       string    `json:"name"`
 	Version    string    `json:"version"`
 	EntryPoint string    `json:"entry"`
+	// UserAgent overrides the User-Agent sent with the plugin's HTTP requests.
+	UserAgent string `json:"user_agent,omitempty"`
+	// RateLimits tightens the host limits for the plugin's requests, keyed by hostname.
+	RateLimits map[string]httpclient.HostLimit `json:"rate_limits,omitempty"`
+	// Secrets lists the secrets the user is asked for, the plugin reads them with secrets.get.
+	Secrets []secrets.Declaration `json:"secrets,omitempty"`
+	// Permissions grants access to APIs that are off by default.
+	Permissions PluginPermissions `json:"permissions,omitempty"`
+}
+
+type PluginPermissions struct {
+	// Process lists the executables the plugin may run with the process module, see process.Allowlist.
+	Process []string `json:"process,omitempty"`
 }
 
 type Plugin struct {
@@ -33,14 +53,27 @@ type LuaPlugin struct {
 	getMods             *lua.LFunction
 	getInstalledMods    *lua.LFunction
 	getModByID          *lua.LFunction
-	addMod              *lua.LFunction
-	removeMod           *lua.LFunction
-	updateMod           *lua.LFunction
 	getGameModDirectory *lua.LFunction
 	getGameID           *lua.LFunction
 }
 
+// GetUserAgent returns the User-Agent for the plugin's HTTP requests, identifying both the host and the plugin.
+func (p *PluginInfo) GetUserAgent() string {
+	if p.UserAgent != "" {
+		return p.UserAgent
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '/' {
+			return '-'
+		}
+		return r
+	}, p.Name)
+	return fmt.Sprintf("%s %s/%s (plugin %s)", httpclient.DefaultUserAgent, name, p.Version, p.Id.String())
+}
+
 func (p *LuaPlugin) Initialize() error {
+	p.state.Lock()
+	defer p.state.Unlock()
 	if p.plugin == nil {
 		return fmt.Errorf("plugin table is not initialized")
 	}
@@ -60,20 +93,8 @@ func (p *LuaPlugin) Initialize() error {
 		return fmt.Errorf("GetModByID function not found in plugin table")
 	}
 
-	p.addMod = p.L.GetField(p.plugin, "AddMod").(*lua.LFunction)
-	if p.addMod == nil {
-		return fmt.Errorf("AddMod function not found in plugin table")
-	}
-
-	p.removeMod = p.L.GetField(p.plugin, "RemoveMod").(*lua.LFunction)
-	if p.removeMod == nil {
-		return fmt.Errorf("RemoveMod function not found in plugin table")
-	}
-
-	p.updateMod = p.L.GetField(p.plugin, "UpdateMod").(*lua.LFunction)
-	if p.updateMod == nil {
-		return fmt.Errorf("UpdateMod function not found in plugin table")
-	}
+	// Installs go through PlanInstall, PlanUpdate and PlanUninstall, so the
+	// older AddMod, RemoveMod and UpdateMod hooks are not required.
 
 	p.getGameModDirectory = p.L.GetField(p.plugin, "GetGameModDirectory").(*lua.LFunction)
 	if p.getGameModDirectory == nil {
@@ -94,7 +115,10 @@ func (p *LuaPlugin) GetMods() (map[string]interface{}, error) {
 		return nil, fmt.Errorf("GetMods function is not initialized")
 	}
 
-	callFunc, err := p.CallFunc(p.plugin, p.getMods)
+	// Keep timers away until the returned table is converted
+	p.state.Lock()
+	defer p.state.Unlock()
+	callFunc, err := p.callFunc(p.plugin, p.getMods)
 	if err != nil {
 		return nil, err
 	}
@@ -122,8 +146,69 @@ func (p *LuaPlugin) GetMods() (map[string]interface{}, error) {
 	return foundMods, nil
 }
 
+// ReadPluginInfo reads the manifest of a plugin directory or ".tcplugin" file without running the plugin.
+func ReadPluginInfo(path string) (*Plugin, error) {
+	var content []byte
+	if strings.HasSuffix(path, PluginExtension) {
+		files, err := utils.ReadFilesFromZip(path)
+		if err != nil {
+			return nil, err
+		}
+		info, ok := files["info.json"]
+		if !ok {
+			return nil, fmt.Errorf("info.json not found in %s", path)
+		}
+		content = info
+	} else {
+		info, err := utils.ReadFile(filepath.Join(path, "info.json"))
+		if err != nil {
+			return nil, err
+		}
+		content = info
+	}
+
+	plugin := &Plugin{PluginDir: path}
+	if err := json.Unmarshal(content, &plugin.PluginInfo); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal plugin info: %w", err)
+	}
+	if plugin.Id == uuid.Nil {
+		return nil, fmt.Errorf("plugin ID is not set or is invalid")
+	}
+	return plugin, nil
+}
+
+// FindPlugins reads the manifests of all plugins in dir. A plugin that exists both as a directory and
+// as a ".tcplugin" file is only returned once.
+func FindPlugins(dir string) ([]*Plugin, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uuid.UUID]bool)
+	var plugins []*Plugin
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasSuffix(entry.Name(), PluginExtension) {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		plugin, err := ReadPluginInfo(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			log.Warnf("Skipping plugin %s: %v", entry.Name(), err)
+			continue
+		}
+		if seen[plugin.Id] {
+			continue
+		}
+		seen[plugin.Id] = true
+		plugins = append(plugins, plugin)
+	}
+	return plugins, nil
+}
+
 // LoadLuaPluginFromZip Loads a plugin using a zip with the custom extension ".tcplugin".
-func LoadLuaPluginFromZip(pluginZipPath string) (*LuaPlugin, error) {
+func LoadLuaPluginFromZip(pluginZipPath string, options ...EngineOption) (*LuaPlugin, error) {
 	files, err := utils.ReadFilesFromZip(pluginZipPath)
 	if err != nil {
 		return nil, err
@@ -148,15 +233,22 @@ func LoadLuaPluginFromZip(pluginZipPath string) (*LuaPlugin, error) {
 		uuid: plugin.Id,
 	}
 
+	if err := plugin.applyOptions(options); err != nil {
+		return nil, fmt.Errorf("failed to configure Lua plugin: %w", err)
+	}
 	if err := plugin.Setup(); err != nil {
 		return nil, fmt.Errorf("failed to setup Lua plugin: %w", err)
 	}
+	plugin.http = plugin.http.WithUserAgent(plugin.GetUserAgent()).WithRateLimits(plugin.RateLimits)
+	plugin.processes = append(plugin.processes, plugin.Permissions.Process...)
 
 	scriptFile, ok := files[plugin.EntryPoint]
 	if !ok {
 		return nil, fmt.Errorf("plugin entry point %s not found in zip", plugin.EntryPoint)
 	}
+	plugin.state.Lock()
 	luaPlugin, err := loadPluginScript(plugin.L, string(scriptFile))
+	plugin.state.Unlock()
 	if err != nil {
 		return nil, fmt.Errorf("failed to load Lua plugin script: %w", err)
 	}
@@ -170,7 +262,7 @@ func LoadLuaPluginFromZip(pluginZipPath string) (*LuaPlugin, error) {
 	return &plugin, nil
 }
 
-func LoadLuaPlugin(pluginDir string) (*LuaPlugin, error) {
+func LoadLuaPlugin(pluginDir string, options ...EngineOption) (*LuaPlugin, error) {
 	infoFile := filepath.Join(pluginDir, "info.json")
 	pluginInfo, err := utils.ReadFile(infoFile)
 	if err != nil {
@@ -196,12 +288,19 @@ func LoadLuaPlugin(pluginDir string) (*LuaPlugin, error) {
 		uuid: plugin.Id,
 	}
 
+	if err := plugin.applyOptions(options); err != nil {
+		return nil, err
+	}
 	if err := plugin.Setup(); err != nil {
 		return nil, err
 	}
+	plugin.http = plugin.http.WithUserAgent(plugin.GetUserAgent()).WithRateLimits(plugin.RateLimits)
+	plugin.processes = append(plugin.processes, plugin.Permissions.Process...)
 
 	scriptPath := filepath.Join(pluginDir, plugin.EntryPoint)
+	plugin.state.Lock()
 	luaPlugin, err := loadPluginScriptFile(plugin.L, scriptPath)
+	plugin.state.Unlock()
 	if err != nil {
 		return nil, err
 	}
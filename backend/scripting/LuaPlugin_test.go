@@ -0,0 +1,65 @@
+package scripting
+
+import (
+	"TotalControl/backend/httpclient"
+	"TotalControl/backend/mods"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// The portal traffic is replayed from testdata, re-record it with TOTALCONTROL_HTTP_MODE=record.
+func TestFactorioPlugin_GetModsFromPortal(t *testing.T) {
+	plugin, err := LoadLuaPlugin("../../plugins/factorio",
+		WithCassette("testdata/factorio_mods.json", httpclient.CassetteModeFromEnv(httpclient.ModeReplay)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer plugin.Close()
+	assert.NoError(t, plugin.Initialize())
+
+	found, err := plugin.GetMods()
+	assert.NoError(t, err)
+	assert.Len(t, found, 3)
+
+	var helmod *mods.Mod
+	for _, value := range found {
+		if mod := value.(*mods.Mod); mod.ID == "helmod" {
+			helmod = mod
+		}
+	}
+	if assert.NotNil(t, helmod) {
+		assert.Equal(t, "2.2.12", helmod.Version)
+		assert.Equal(t, "Helfima", helmod.Author)
+		assert.Equal(t, []mods.GameVersion{{Version: "2.0", ModVersion: "2.2.12"}}, helmod.GameVersions)
+	}
+}
+
+// The portal may split the list even with page_size=max, every page belongs to the complete catalogue.
+func TestFactorioPlugin_FollowsPortalPages(t *testing.T) {
+	plugin, err := LoadLuaPlugin("../../plugins/factorio",
+		WithCassette("testdata/factorio_mods_paged.json", httpclient.CassetteModeFromEnv(httpclient.ModeReplay)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer plugin.Close()
+	provider, err := plugin.Provider()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	batch, err := provider.FetchCatalogue(context.Background(), time.Time{})
+	if assert.NoError(t, err) {
+		assert.True(t, batch.Complete)
+		var ids []string
+		for _, entry := range batch.Entries {
+			ids = append(ids, entry.ID)
+		}
+		assert.Equal(t, []string{"even-distribution", "Krastorio2", "helmod", "rso-mod"}, ids)
+	}
+	found, err := provider.SearchMods(context.Background(), mods.SearchQuery{Text: "rso-mod"})
+	if assert.NoError(t, err) && assert.Len(t, found, 1) {
+		assert.Equal(t, "7.0.9", found[0].Version)
+	}
+}
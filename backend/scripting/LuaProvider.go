@@ -0,0 +1,520 @@
+package scripting
+
+import (
+	"TotalControl/backend/catalogue"
+	"TotalControl/backend/install"
+	"TotalControl/backend/loadorder"
+	"TotalControl/backend/mods"
+	"TotalControl/backend/process"
+	"TotalControl/backend/updates"
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	lua "github.com/yuin/gopher-lua"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Error codes a plugin function can return as its second value to report one of the typed mods errors.
+var luaProviderErrors = map[string]error{
+	"not_found":         mods.ErrModNotFound,
+	"not_supported":     mods.ErrNotSupported,
+	"already_installed": mods.ErrAlreadyInstalled,
+}
+
+// LuaProvider exposes a plugin table as a mods.ModProvider. Every method maps to a plugin function called with
+// the plugin as self, functions that return nil and an error message fail with that message:
+//
+//	GetInstalledMods(self)               -> {mod, ...}
+//	GetInstalledModByID(self, id)        -> mod or nil, optional
+//	SearchMods(self, query)              -> {mod, ...}, if missing GetMods(self) is filtered instead
+//	GetModByID(self, id)                 -> mod or nil, looks up the catalogue
+//	InstallMod(self, id, version)        -> mod or true
+//	UninstallMod(self, id)               -> true, RemoveMod(self, id) is used if it is missing
+//	SetModEnabled(self, id, enabled)     -> true
+//	UpdateMod(self, id, version)         -> mod or true
+//	WriteLoadOrder(self, {id, ...})      -> true, writes the game's load order file
+//	GetModReleases(self, id)             -> {release, ...}, every version of a mod in the catalogue
+//	GetCatalogue(self, since)            -> {mods = {entry, ...}, complete = bool}, see FetchCatalogue
+//
+// Instead of installing, updating and uninstalling mods itself, a plugin should return a plan from
+// PlanInstall(self, id, version), PlanUpdate(self, id, version) or PlanUninstall(self, id), which the install
+// pipeline carries out transactionally. These are preferred over the functions above.
+//
+// A plugin can declare what it supports with a capabilities table, e.g. { search = true }. Without one, every
+// operation with a function is assumed to work.
+type LuaProvider struct {
+	engine       *LuaEngine
+	plugin       *lua.LTable
+	name         string
+	gameID       string
+	capabilities mods.Capabilities
+	// executables are the names of the game's processes from the plugin's game_executables list.
+	executables []string
+	// Installer carries out the plugin's install plans, the default pipeline if nil.
+	Installer *install.Pipeline
+}
+
+var (
+	_ mods.ModProvider     = (*LuaProvider)(nil)
+	_ mods.GameProcess     = (*LuaProvider)(nil)
+	_ loadorder.Writer     = (*LuaProvider)(nil)
+	_ updates.Releases     = (*LuaProvider)(nil)
+	_ updates.BatchUpdater = (*LuaProvider)(nil)
+	_ catalogue.Source     = (*LuaProvider)(nil)
+)
+
+func NewLuaProvider(engine *LuaEngine, plugin *lua.LTable, name string) (*LuaProvider, error) {
+	if plugin == nil {
+		return nil, errors.New("plugin table is not initialized")
+	}
+	p := &LuaProvider{engine: engine, plugin: plugin, name: name}
+	err := p.call(context.Background(), "GetGameID", func(value lua.LValue) error {
+		if value.Type() != lua.LTString {
+			return fmt.Errorf("expected Lua string for game ID, got %s", value.Type().String())
+		}
+		p.gameID = value.String()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get game ID of plugin %s: %w", name, err)
+	}
+
+	engine.state.Lock()
+	defer engine.state.Unlock()
+	p.executables = luaStringList(engine.L.GetField(plugin, "game_executables"))
+	if declared, ok := engine.L.GetField(plugin, "capabilities").(*lua.LTable); ok {
+		p.capabilities = mods.Capabilities{
+			Search:    lua.LVAsBool(declared.RawGetString("search")),
+			Install:   lua.LVAsBool(declared.RawGetString("install")),
+			Uninstall: lua.LVAsBool(declared.RawGetString("uninstall")),
+			Toggle:    lua.LVAsBool(declared.RawGetString("toggle")),
+			Update:    lua.LVAsBool(declared.RawGetString("update")),
+			LoadOrder: lua.LVAsBool(declared.RawGetString("load_order")),
+		}
+	} else {
+		p.capabilities = mods.Capabilities{
+			Search:    p.hasFunction("SearchMods") || p.hasFunction("GetMods"),
+			Install:   p.hasFunction("PlanInstall") || p.hasFunction("InstallMod"),
+			Uninstall: p.hasFunction("PlanUninstall") || p.hasFunction("UninstallMod") || p.hasFunction("RemoveMod"),
+			Toggle:    p.hasFunction("SetModEnabled"),
+			Update:    p.hasFunction("PlanUpdate") || p.hasFunction("UpdateMod"),
+			LoadOrder: p.hasFunction("WriteLoadOrder"),
+		}
+	}
+	return p, nil
+}
+
+// Provider returns the plugin as a mods.ModProvider.
+func (p *LuaPlugin) Provider() (*LuaProvider, error) {
+	return NewLuaProvider(&p.LuaEngine, p.plugin, p.Name)
+}
+
+// Deprecated: Use LuaPlugin instead
+func (p *LuaModProviderEngine) Provider() (*LuaProvider, error) {
+	p.state.Lock()
+	plugin, ok := p.L.GetGlobal("plugin").(*lua.LTable)
+	p.state.Unlock()
+	if !ok {
+		return nil, errors.New("plugin global is not a Lua table")
+	}
+	return NewLuaProvider(&p.LuaEngine, plugin, "lua")
+}
+
+// hasFunction must be called with the state lock held.
+func (p *LuaProvider) hasFunction(name string) bool {
+	return p.engine.L.GetField(p.plugin, name).Type() == lua.LTFunction
+}
+
+func (p *LuaProvider) hasFunctionLocked(name string) bool {
+	p.engine.state.Lock()
+	defer p.engine.state.Unlock()
+	return p.hasFunction(name)
+}
+
+// call runs plugin:method(args...) with ctx as the Lua context, so cancelling it stops the plugin as well as its
+// HTTP requests and processes. convert receives the first result while the state is still locked, which keeps
+// timers from changing the returned table during the conversion.
+func (p *LuaProvider) call(ctx context.Context, method string, convert func(lua.LValue) error, args ...lua.LValue) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.engine.state.Lock()
+	defer p.engine.state.Unlock()
+
+	L := p.engine.L
+	fn, ok := L.GetField(p.plugin, method).(*lua.LFunction)
+	if !ok {
+		return mods.ErrNotSupported
+	}
+
+	previous := L.Context()
+	L.SetContext(context.WithValue(ctx, "luaengine", p.engine))
+	defer func() {
+		if previous != nil {
+			L.SetContext(previous)
+		} else {
+			L.RemoveContext()
+		}
+	}()
+
+	top := L.GetTop()
+	defer L.SetTop(top)
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: 2, Protect: true}, append([]lua.LValue{p.plugin}, args...)...); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("error calling %s: %w", method, err)
+	}
+
+	result, message := L.Get(-2), L.Get(-1)
+	if result == lua.LNil && message != lua.LNil {
+		if err, ok := luaProviderErrors[message.String()]; ok {
+			return err
+		}
+		return errors.New(message.String())
+	}
+	if convert != nil {
+		return convert(result)
+	}
+	return nil
+}
+
+func (p *LuaProvider) wrap(op string, id string, err error) error {
+	return mods.NewProviderError(p.name, op, id, err)
+}
+
+// luaModList converts a table of mod tables, skipping the entries that are not valid mods.
+func luaModList(value lua.LValue) ([]mods.Mod, error) {
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("expected Lua table of mods, got %s", value.Type().String())
+	}
+	var list []mods.Mod
+	table.ForEach(func(key lua.LValue, value lua.LValue) {
+		modTable, ok := value.(*lua.LTable)
+		if !ok {
+			log.Warnf("Unexpected value type %s for mod %s, expected table", value.Type().String(), key.String())
+			return
+		}
+		mod, err := mods.NewModFromLuaTable(modTable)
+		if err != nil {
+			log.Warnf("Failed to create mod from Lua table for key %s: %v", key.String(), err)
+			return
+		}
+		list = append(list, *mod)
+	})
+	return list, nil
+}
+
+// luaOptionalMod converts a function result that may be a mod table, anything else leaves mod nil.
+func luaOptionalMod(mod **mods.Mod) func(lua.LValue) error {
+	return func(value lua.LValue) error {
+		table, ok := value.(*lua.LTable)
+		if !ok {
+			return nil
+		}
+		converted, err := mods.NewModFromLuaTable(table)
+		if err != nil {
+			return err
+		}
+		*mod = converted
+		return nil
+	}
+}
+
+func (p *LuaProvider) GameID() string {
+	return p.gameID
+}
+
+func (p *LuaProvider) Capabilities() mods.Capabilities {
+	return p.capabilities
+}
+
+func (p *LuaProvider) InstalledMods(ctx context.Context) ([]mods.Mod, error) {
+	var list []mods.Mod
+	err := p.call(ctx, "GetInstalledMods", func(value lua.LValue) (err error) {
+		list, err = luaModList(value)
+		return err
+	})
+	if err != nil {
+		return nil, p.wrap("list installed mods", "", err)
+	}
+	return list, nil
+}
+
+func (p *LuaProvider) SearchMods(ctx context.Context, query mods.SearchQuery) ([]mods.Mod, error) {
+	if !p.capabilities.Search {
+		return nil, p.wrap("search mods", "", mods.ErrNotSupported)
+	}
+	var list []mods.Mod
+	convert := func(value lua.LValue) (err error) {
+		list, err = luaModList(value)
+		return err
+	}
+
+	if p.hasFunctionLocked("SearchMods") {
+		queryTable := p.engine.L.NewTable()
+		queryTable.RawSetString("text", lua.LString(query.Text))
+		queryTable.RawSetString("game_version", lua.LString(query.GameVersion))
+		queryTable.RawSetString("limit", lua.LNumber(query.Limit))
+		if err := p.call(ctx, "SearchMods", convert, queryTable); err != nil {
+			return nil, p.wrap("search mods", "", err)
+		}
+		return list, nil
+	}
+
+	if err := p.call(ctx, "GetMods", convert); err != nil {
+		return nil, p.wrap("search mods", "", err)
+	}
+	return mods.FilterMods(list, query), nil
+}
+
+func (p *LuaProvider) GetMod(ctx context.Context, id string) (*mods.Mod, error) {
+	var mod *mods.Mod
+	if p.hasFunctionLocked("GetInstalledModByID") {
+		if err := p.call(ctx, "GetInstalledModByID", luaOptionalMod(&mod), lua.LString(id)); err != nil {
+			return nil, p.wrap("get mod", id, err)
+		}
+	} else {
+		installed, err := p.InstalledMods(ctx)
+		if err != nil {
+			return nil, err
+		}
+		mod, _ = mods.FindMod(installed, id)
+	}
+	if mod != nil {
+		return mod, nil
+	}
+
+	if p.capabilities.Search && p.hasFunctionLocked("GetModByID") {
+		if err := p.call(ctx, "GetModByID", luaOptionalMod(&mod), lua.LString(id)); err != nil {
+			return nil, p.wrap("get mod", id, err)
+		}
+	}
+	if mod == nil {
+		return nil, p.wrap("get mod", id, mods.ErrModNotFound)
+	}
+	return mod, nil
+}
+
+func (p *LuaProvider) InstallMod(ctx context.Context, id string, version string) (*mods.Mod, error) {
+	if !p.capabilities.Install {
+		return nil, p.wrap("install mod", id, mods.ErrNotSupported)
+	}
+	var mod *mods.Mod
+	var err error
+	if p.hasFunctionLocked("PlanInstall") {
+		err = p.runPlan(ctx, "PlanInstall", lua.LString(id), luaOptionalString(version))
+	} else {
+		err = p.call(ctx, "InstallMod", luaOptionalMod(&mod), lua.LString(id), luaOptionalString(version))
+	}
+	if err != nil {
+		return nil, p.wrap("install mod", id, err)
+	}
+	if mod != nil {
+		return mod, nil
+	}
+	return p.GetMod(ctx, id)
+}
+
+func (p *LuaProvider) UninstallMod(ctx context.Context, id string) error {
+	if !p.capabilities.Uninstall {
+		return p.wrap("uninstall mod", id, mods.ErrNotSupported)
+	}
+	if p.hasFunctionLocked("PlanUninstall") {
+		return p.wrap("uninstall mod", id, p.runPlan(ctx, "PlanUninstall", lua.LString(id)))
+	}
+	method := "UninstallMod"
+	if !p.hasFunctionLocked(method) {
+		method = "RemoveMod"
+	}
+	return p.wrap("uninstall mod", id, p.call(ctx, method, nil, lua.LString(id)))
+}
+
+func (p *LuaProvider) SetModEnabled(ctx context.Context, id string, enabled bool) error {
+	if !p.capabilities.Toggle {
+		return p.wrap("enable mod", id, mods.ErrNotSupported)
+	}
+	return p.wrap("enable mod", id, p.call(ctx, "SetModEnabled", nil, lua.LString(id), lua.LBool(enabled)))
+}
+
+func (p *LuaProvider) UpdateMod(ctx context.Context, id string, version string) (*mods.Mod, error) {
+	if !p.capabilities.Update {
+		return nil, p.wrap("update mod", id, mods.ErrNotSupported)
+	}
+	var mod *mods.Mod
+	var err error
+	if p.hasFunctionLocked("PlanUpdate") {
+		err = p.runPlan(ctx, "PlanUpdate", lua.LString(id), luaOptionalString(version))
+	} else {
+		err = p.call(ctx, "UpdateMod", luaOptionalMod(&mod), lua.LString(id), luaOptionalString(version))
+	}
+	if err != nil {
+		return nil, p.wrap("update mod", id, err)
+	}
+	if mod != nil {
+		return mod, nil
+	}
+	return p.GetMod(ctx, id)
+}
+
+// IsGameRunning looks for the processes the plugin lists in game_executables. Without them the game is assumed
+// not to be running.
+func (p *LuaProvider) IsGameRunning(ctx context.Context) (bool, error) {
+	if len(p.executables) == 0 {
+		return false, nil
+	}
+	return process.IsRunning(ctx, p.executables...)
+}
+
+// WriteLoadOrder passes the load order to the plugin's WriteLoadOrder(self, order), first loaded first.
+func (p *LuaProvider) WriteLoadOrder(ctx context.Context, order []string) error {
+	if !p.capabilities.LoadOrder {
+		return p.wrap("write load order", "", mods.ErrNotSupported)
+	}
+	table := p.engine.L.NewTable()
+	for _, id := range order {
+		table.Append(lua.LString(id))
+	}
+	return p.wrap("write load order", "", p.call(ctx, "WriteLoadOrder", nil, table))
+}
+
+// UpdateMods asks the plugin's PlanUpdate for every mod and carries out the plans as one install, so a failed
+// update rolls back the others. Plugins without PlanUpdate cannot do that and return ErrNotSupported.
+func (p *LuaProvider) UpdateMods(ctx context.Context, versions map[string]string) error {
+	if !p.capabilities.Update || !p.hasFunctionLocked("PlanUpdate") {
+		return p.wrap("update mods", "", mods.ErrNotSupported)
+	}
+	ids := slices.Sorted(maps.Keys(versions))
+	plans := make([]*install.Plan, 0, len(ids))
+	for _, id := range ids {
+		plan, err := p.plan(ctx, "PlanUpdate", lua.LString(id), luaOptionalString(versions[id]))
+		if err != nil {
+			return p.wrap("update mod", id, err)
+		}
+		plans = append(plans, plan)
+	}
+	plan, err := install.Merge(plans...)
+	if err != nil {
+		return p.wrap("update mods", "", err)
+	}
+	return p.wrap("update mods", "", p.installer().Run(ctx, plan))
+}
+
+// ModReleases lists the versions of a mod with the plugin's GetModReleases(self, id).
+func (p *LuaProvider) ModReleases(ctx context.Context, id string) ([]updates.Release, error) {
+	if !p.hasFunctionLocked("GetModReleases") {
+		return nil, p.wrap("list releases", id, mods.ErrNotSupported)
+	}
+	var releases []updates.Release
+	err := p.call(ctx, "GetModReleases", func(value lua.LValue) (err error) {
+		releases, err = luaReleases(value)
+		return err
+	}, lua.LString(id))
+	if err != nil {
+		return nil, p.wrap("list releases", id, err)
+	}
+	return releases, nil
+}
+
+// luaReleases converts a list of release tables:
+//
+//	{ version = "1.2.0", game_versions = { "2.0" }, changelog = "...", released_at = "2024-11-02T10:00:00Z" }
+//
+// released_at may also be a Unix timestamp.
+func luaReleases(value lua.LValue) ([]updates.Release, error) {
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("expected Lua table of releases, got %s", value.Type().String())
+	}
+	var releases []updates.Release
+	table.ForEach(func(key lua.LValue, value lua.LValue) {
+		entry, ok := value.(*lua.LTable)
+		if !ok || entry.RawGetString("version").Type() != lua.LTString {
+			log.Warnf("Skipping release %s, it is not a table with a version", key.String())
+			return
+		}
+		release := updates.Release{
+			Version:      entry.RawGetString("version").String(),
+			GameVersions: luaStringList(entry.RawGetString("game_versions")),
+		}
+		if changelog, ok := entry.RawGetString("changelog").(lua.LString); ok {
+			release.Changelog = string(changelog)
+		}
+		release.ReleasedAt = luaTime(entry.RawGetString("released_at"))
+		releases = append(releases, release)
+	})
+	return releases, nil
+}
+
+// luaTime converts an RFC 3339 string or a Unix timestamp, anything else is the zero time.
+func luaTime(value lua.LValue) time.Time {
+	switch v := value.(type) {
+	case lua.LNumber:
+		return time.Unix(int64(v), 0).UTC()
+	case lua.LString:
+		if parsed, err := time.Parse(time.RFC3339, string(v)); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
+
+// FetchCatalogue lists the plugin's catalogue with GetCatalogue(self, since), since being nil for the whole
+// catalogue or an RFC 3339 time. The plugin returns { mods = {entry, ...}, complete = true } where entries are
+// mod tables with optional category, downloads and updated_at fields.
+func (p *LuaProvider) FetchCatalogue(ctx context.Context, since time.Time) (*catalogue.Batch, error) {
+	if !p.hasFunctionLocked("GetCatalogue") {
+		return nil, p.wrap("fetch catalogue", "", mods.ErrNotSupported)
+	}
+	sinceValue := lua.LValue(lua.LNil)
+	if !since.IsZero() {
+		sinceValue = lua.LString(since.UTC().Format(time.RFC3339))
+	}
+	var batch catalogue.Batch
+	err := p.call(ctx, "GetCatalogue", func(value lua.LValue) error {
+		table, ok := value.(*lua.LTable)
+		if !ok {
+			return fmt.Errorf("expected Lua table with the catalogue, got %s", value.Type().String())
+		}
+		batch.Complete = lua.LVAsBool(table.RawGetString("complete"))
+		entries, ok := table.RawGetString("mods").(*lua.LTable)
+		if !ok {
+			return errors.New("the catalogue has no mods table")
+		}
+		entries.ForEach(func(key lua.LValue, value lua.LValue) {
+			entryTable, ok := value.(*lua.LTable)
+			if !ok {
+				return
+			}
+			mod, err := mods.NewModFromLuaTable(entryTable)
+			if err != nil {
+				log.Warnf("Skipping catalogue entry %s: %v", key.String(), err)
+				return
+			}
+			entry := catalogue.Entry{Mod: *mod, UpdatedAt: luaTime(entryTable.RawGetString("updated_at"))}
+			if category, ok := entryTable.RawGetString("category").(lua.LString); ok {
+				entry.Category = string(category)
+			}
+			if downloads, ok := entryTable.RawGetString("downloads").(lua.LNumber); ok {
+				entry.Downloads = int(downloads)
+			}
+			batch.Entries = append(batch.Entries, entry)
+		})
+		return nil
+	}, sinceValue)
+	if err != nil {
+		return nil, p.wrap("fetch catalogue", "", err)
+	}
+	return &batch, nil
+}
+
+func luaOptionalString(value string) lua.LValue {
+	if value == "" {
+		return lua.LNil
+	}
+	return lua.LString(value)
+}
@@ -0,0 +1,321 @@
+package scripting
+
+import (
+	"TotalControl/backend/httpclient"
+	"TotalControl/backend/install"
+	"TotalControl/backend/mods"
+	"TotalControl/backend/secrets"
+	"TotalControl/backend/updates"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testProviderPlugin = `
+local installed = {
+	{ id = "mod1", name = "Mod One", version = "1.0.0", game_id = "game1", enabled = true },
+}
+return {
+	game_executables = { "not-a-real-game" },
+	GetGameID = function(self) return "game1" end,
+	GetInstalledMods = function(self) return installed end,
+	GetMods = function(self)
+		return {
+			{ id = "mod1", name = "Mod One", version = "1.1.0", game_id = "game1" },
+			{ id = "mod2", name = "Mod Two", version = "2.0.0", game_id = "game1" },
+		}
+	end,
+	SetModEnabled = function(self, id, enabled)
+		for _, mod in ipairs(installed) do
+			if mod.id == id then
+				mod.enabled = enabled
+				return true
+			end
+		end
+		return nil, "not_found"
+	end,
+	UninstallMod = function(self, id)
+		return nil, "the mods directory is read-only"
+	end,
+	UpdateMod = function(self, id, version)
+		while true do end
+	end,
+	WriteLoadOrder = function(self, order)
+		-- Shows up in GetInstalledMods for the test
+		installed[1].description = table.concat(order, ",")
+		return true
+	end,
+}
+`
+
+func loadTestProvider(t *testing.T, script string) *LuaProvider {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "info.json"),
+		[]byte(`{"id": "0b4c5fb1-51a3-4bd4-9d36-5a0d1c8b4e7e", "name": "Test", "version": "1.0.0", "entry": "plugin.lua"}`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "plugin.lua"), []byte(script), 0644))
+	plugin, err := LoadLuaPlugin(dir)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(plugin.Close)
+	provider, err := plugin.Provider()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return provider
+}
+
+func TestLuaProvider(t *testing.T) {
+	provider := loadTestProvider(t, testProviderPlugin)
+	ctx := context.Background()
+
+	assert.Equal(t, "game1", provider.GameID())
+	assert.Equal(t, mods.Capabilities{Search: true, Uninstall: true, Toggle: true, Update: true, LoadOrder: true}, provider.Capabilities())
+
+	found, err := provider.SearchMods(ctx, mods.SearchQuery{Text: "two"})
+	if assert.NoError(t, err) && assert.Len(t, found, 1) {
+		assert.Equal(t, "mod2", found[0].ID)
+	}
+
+	assert.NoError(t, provider.SetModEnabled(ctx, "mod1", false))
+	mod, err := provider.GetMod(ctx, "mod1")
+	if assert.NoError(t, err) {
+		assert.False(t, mod.Enabled)
+		assert.Equal(t, "1.0.0", mod.Version)
+	}
+
+	// Error codes map to the typed errors, other messages are passed on
+	assert.ErrorIs(t, provider.SetModEnabled(ctx, "missing", true), mods.ErrModNotFound)
+	assert.EqualError(t, provider.UninstallMod(ctx, "mod1"), "Test: uninstall mod mod1: the mods directory is read-only")
+	_, err = provider.InstallMod(ctx, "mod2", "")
+	assert.ErrorIs(t, err, mods.ErrNotSupported)
+
+	running, err := provider.IsGameRunning(ctx)
+	assert.NoError(t, err)
+	assert.False(t, running)
+
+	assert.NoError(t, provider.WriteLoadOrder(ctx, []string{"mod2", "mod1"}))
+	mod, _ = provider.GetMod(ctx, "mod1")
+	assert.Equal(t, "mod2,mod1", mod.Description)
+}
+
+func TestLuaProvider_ContextCancelsPlugin(t *testing.T) {
+	provider := loadTestProvider(t, testProviderPlugin)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := provider.UpdateMod(ctx, "mod1", "")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The plugin is still usable afterwards
+	installed, err := provider.InstalledMods(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, installed, 1)
+}
+
+func TestFactorioPlugin_Provider(t *testing.T) {
+	plugin, err := LoadLuaPlugin("../../plugins/factorio",
+		WithCassette("testdata/factorio_mods.json", httpclient.CassetteModeFromEnv(httpclient.ModeReplay)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer plugin.Close()
+	provider, err := plugin.Provider()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "factorio", provider.GameID())
+	assert.Equal(t, mods.Capabilities{Search: true, Install: true, Uninstall: true, Update: true}, provider.Capabilities())
+
+	found, err := provider.SearchMods(context.Background(), mods.SearchQuery{Text: "helmod"})
+	if assert.NoError(t, err) && assert.Len(t, found, 1) {
+		assert.Equal(t, "2.2.12", found[0].Version)
+	}
+	batch, err := provider.FetchCatalogue(context.Background(), time.Time{})
+	if assert.NoError(t, err) {
+		assert.True(t, batch.Complete)
+		assert.Len(t, batch.Entries, 3)
+	}
+	t.Setenv("HOME", t.TempDir())
+	_, err = provider.UpdateMod(context.Background(), "helmod", "")
+	assert.ErrorIs(t, err, mods.ErrModNotFound)
+}
+
+// The portal traffic, including the downloads, is replayed from testdata.
+func TestFactorioPlugin_InstallUpdateUninstall(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	modDir := filepath.Join(home, ".factorio", "mods")
+	assert.NoError(t, os.MkdirAll(modDir, 0755))
+	listPath := filepath.Join(modDir, "mod-list.json")
+	assert.NoError(t, os.WriteFile(listPath, []byte(`{"mods": [{"name": "base", "enabled": true}]}`), 0644))
+
+	vault := secrets.NewVault(filepath.Join(t.TempDir(), "secrets.vault"))
+	assert.NoError(t, vault.UnlockWithKeyFile(filepath.Join(t.TempDir(), "secrets.key")))
+	plugin, err := LoadLuaPlugin("../../plugins/factorio", WithSecrets(vault),
+		WithCassette("testdata/factorio_install.json", httpclient.CassetteModeFromEnv(httpclient.ModeReplay)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer plugin.Close()
+	provider, err := plugin.Provider()
+	if !assert.NoError(t, err) {
+		return
+	}
+	provider.Installer = install.NewPipeline(nil, t.TempDir())
+	ctx := context.Background()
+
+	// Downloads need a factorio.com account
+	_, err = provider.InstallMod(ctx, "helmod", "2.2.11")
+	assert.ErrorContains(t, err, "username and token")
+	scope := plugin.Id.String()
+	assert.NoError(t, vault.Set(scope, "username", "tester"))
+	assert.NoError(t, vault.Set(scope, "token", "s3cret"))
+
+	mod, err := provider.InstallMod(ctx, "helmod", "2.2.11")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2.2.11", mod.Version)
+		assert.True(t, mod.Enabled)
+	}
+	assert.FileExists(t, filepath.Join(modDir, "helmod_2.2.11.zip"))
+	_, err = provider.InstallMod(ctx, "helmod", "")
+	assert.ErrorIs(t, err, mods.ErrAlreadyInstalled)
+
+	mod, err = provider.UpdateMod(ctx, "helmod", "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2.2.12", mod.Version)
+	}
+	assert.NoFileExists(t, filepath.Join(modDir, "helmod_2.2.11.zip"))
+	assert.FileExists(t, filepath.Join(modDir, "helmod_2.2.12.zip"))
+
+	assert.NoError(t, provider.UninstallMod(ctx, "helmod"))
+	assert.NoFileExists(t, filepath.Join(modDir, "helmod_2.2.12.zip"))
+	list, err := os.ReadFile(listPath)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"mods": [{"name": "base", "enabled": true}]}`, string(list))
+	assert.ErrorIs(t, provider.UninstallMod(ctx, "helmod"), mods.ErrModNotFound)
+}
+
+func TestLuaProvider_InstallPlan(t *testing.T) {
+	root := t.TempDir()
+	source := filepath.Join(t.TempDir(), "mod2.zip")
+	assert.NoError(t, os.WriteFile(source, []byte("mod two"), 0644))
+
+	provider := loadTestProvider(t, `
+local enabled = {}
+return {
+	GetGameID = function(self) return "game1" end,
+	GetInstalledMods = function(self)
+		local installed = {}
+		for id, state in pairs(enabled) do
+			installed[#installed + 1] = { id = id, name = id, version = "2.0.0", game_id = "game1", enabled = state }
+		end
+		return installed
+	end,
+	PlanInstall = function(self, id, version)
+		return {
+			root = "`+filepath.ToSlash(root)+`",
+			artifacts = { { mod_id = id, source = "`+filepath.ToSlash(source)+`", target = id .. "_2.0.0.zip" } },
+			enabled_list = "`+filepath.ToSlash(filepath.Join(root, "enabled.txt"))+`",
+			enable = { [id] = true },
+		}
+	end,
+	UpdateEnabledList = function(self, enable, forget)
+		for id, state in pairs(enable) do
+			enabled[id] = state
+		end
+		return true
+	end,
+}
+`)
+	provider.Installer = install.NewPipeline(nil, t.TempDir())
+	assert.True(t, provider.Capabilities().Install)
+
+	mod, err := provider.InstallMod(context.Background(), "mod2", "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "mod2", mod.ID)
+		assert.True(t, mod.Enabled)
+	}
+	content, err := os.ReadFile(filepath.Join(root, "mod2_2.0.0.zip"))
+	assert.NoError(t, err)
+	assert.Equal(t, "mod two", string(content))
+}
+
+func TestLuaProvider_UpdateMods(t *testing.T) {
+	root := t.TempDir()
+	sources := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(sources, "a.zip"), []byte("mod a"), 0644))
+
+	provider := loadTestProvider(t, `
+return {
+	GetGameID = function(self) return "game1" end,
+	GetInstalledMods = function(self) return {} end,
+	PlanUpdate = function(self, id, version)
+		return {
+			root = "`+filepath.ToSlash(root)+`",
+			artifacts = { { mod_id = id, source = "`+filepath.ToSlash(sources)+`/" .. id .. ".zip", target = id .. "_" .. version .. ".zip" } },
+		}
+	end,
+	GetModReleases = function(self, id)
+		return {
+			{ version = "1.0.0", game_versions = { "2.0" }, released_at = 1730541600 },
+			{ version = "1.1.0", changelog = "Fixes", released_at = "2024-11-03T10:00:00Z" },
+		}
+	end,
+}
+`)
+	provider.Installer = install.NewPipeline(nil, t.TempDir())
+	ctx := context.Background()
+
+	// b has no archive, so a is not installed either
+	assert.Error(t, provider.UpdateMods(ctx, map[string]string{"a": "1.1.0", "b": "2.0.0"}))
+	entries, _ := os.ReadDir(root)
+	assert.Empty(t, entries)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(sources, "b.zip"), []byte("mod b"), 0644))
+	assert.NoError(t, provider.UpdateMods(ctx, map[string]string{"a": "1.1.0", "b": "2.0.0"}))
+	assert.FileExists(t, filepath.Join(root, "a_1.1.0.zip"))
+	assert.FileExists(t, filepath.Join(root, "b_2.0.0.zip"))
+
+	releases, err := provider.ModReleases(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, []updates.Release{
+		{Version: "1.0.0", GameVersions: []string{"2.0"}, ReleasedAt: time.Unix(1730541600, 0).UTC()},
+		{Version: "1.1.0", Changelog: "Fixes", ReleasedAt: time.Date(2024, 11, 3, 10, 0, 0, 0, time.UTC)},
+	}, releases)
+}
+
+func TestLuaProvider_FetchCatalogue(t *testing.T) {
+	provider := loadTestProvider(t, `
+return {
+	GetGameID = function(self) return "game1" end,
+	GetInstalledMods = function(self) return {} end,
+	GetCatalogue = function(self, since)
+		local entries = {
+			{ id = "helmod", name = "Helmod", version = "2.2.12", game_id = "game1", game_versions = { ["2.0"] = "2.2.12" },
+			  category = "tweaks", downloads = 900, updated_at = "2025-03-01T12:00:00Z" },
+			{ name = "missing id" },
+		}
+		return { mods = entries, complete = since == nil }
+	end,
+}
+`)
+	batch, err := provider.FetchCatalogue(context.Background(), time.Time{})
+	assert.NoError(t, err)
+	assert.True(t, batch.Complete)
+	if assert.Len(t, batch.Entries, 1) {
+		entry := batch.Entries[0]
+		assert.Equal(t, "helmod", entry.ID)
+		assert.Equal(t, "tweaks", entry.Category)
+		assert.Equal(t, 900, entry.Downloads)
+		assert.Equal(t, time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC), entry.UpdatedAt)
+	}
+
+	batch, err = provider.FetchCatalogue(context.Background(), time.Now())
+	assert.NoError(t, err)
+	assert.False(t, batch.Complete)
+}
@@ -0,0 +1,159 @@
+package scripting
+
+import (
+	"TotalControl/backend/formats"
+	"TotalControl/backend/utils"
+	lua "github.com/yuin/gopher-lua"
+	"math"
+)
+
+const luaModSettingsTypeName = "ModSettings"
+
+func newModSettingsUserData(L *lua.LState, settings *formats.ModSettings) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = settings
+	L.SetMetatable(ud, L.GetTypeMetatable(luaModSettingsTypeName))
+	return ud
+}
+
+func luaCheckModSettings(L *lua.LState) *formats.ModSettings {
+	if settings, ok := L.CheckUserData(1).Value.(*formats.ModSettings); ok {
+		return settings
+	}
+	L.ArgError(1, "ModSettings expected")
+	return nil
+}
+
+// pushModSettings pushes the settings, or nil and an error message.
+func pushModSettings(L *lua.LState, settings *formats.ModSettings, err error) int {
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(newModSettingsUserData(L, settings))
+	return 1
+}
+
+// luaSettingValue converts a Lua value for a setting. Lua has a single number type, whole numbers become integers
+// so new integer settings get the type Factorio 2.0 uses for them.
+func luaSettingValue(value lua.LValue) any {
+	if number, ok := value.(lua.LNumber); ok && float64(number) == math.Trunc(float64(number)) &&
+		math.Abs(float64(number)) < 1<<53 {
+		return int64(number)
+	}
+	return utils.LuaValueToInterface(value)
+}
+
+func luaPushResult(L *lua.LState, err error) int {
+	if err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+var luaModSettingsMethods = map[string]lua.LGFunction{
+	"get": func(L *lua.LState) int {
+		settings := luaCheckModSettings(L)
+		value, ok := settings.Get(L.CheckString(2), L.CheckString(3))
+		if !ok {
+			L.Push(lua.LNil)
+			return 1
+		}
+		L.Push(utils.ToLuaValue(L, value))
+		return 1
+	},
+	"set": func(L *lua.LState) int {
+		settings := luaCheckModSettings(L)
+		return luaPushResult(L, settings.Set(L.CheckString(2), L.CheckString(3), luaSettingValue(L.CheckAny(4))))
+	},
+	"delete": func(L *lua.LState) int {
+		settings := luaCheckModSettings(L)
+		L.Push(lua.LBool(settings.Delete(L.CheckString(2), L.CheckString(3))))
+		return 1
+	},
+	"names": func(L *lua.LState) int {
+		settings := luaCheckModSettings(L)
+		result := L.NewTable()
+		for _, name := range settings.Names(L.CheckString(2)) {
+			result.Append(lua.LString(name))
+		}
+		L.Push(result)
+		return 1
+	},
+	// settings returns a list of {scope, name, value} tables, optionally only those starting with a prefix.
+	"settings": func(L *lua.LState) int {
+		settings := luaCheckModSettings(L)
+		result := L.NewTable()
+		for _, setting := range settings.Settings(L.OptString(2, "")) {
+			entry := L.NewTable()
+			entry.RawSetString("scope", lua.LString(setting.Scope))
+			entry.RawSetString("name", lua.LString(setting.Name))
+			entry.RawSetString("value", utils.ToLuaValue(L, setting.Value))
+			result.Append(entry)
+		}
+		L.Push(result)
+		return 1
+	},
+	"version": func(L *lua.LState) int {
+		L.Push(lua.LString(luaCheckModSettings(L).GameVersion()))
+		return 1
+	},
+	"to_table": func(L *lua.LState) int {
+		settings := luaCheckModSettings(L)
+		result := L.NewTable()
+		for _, setting := range settings.Settings(L.OptString(2, "")) {
+			scope, ok := result.RawGetString(setting.Scope).(*lua.LTable)
+			if !ok {
+				scope = L.NewTable()
+				result.RawSetString(setting.Scope, scope)
+			}
+			scope.RawSetString(setting.Name, utils.ToLuaValue(L, setting.Value))
+		}
+		L.Push(result)
+		return 1
+	},
+	"to_json": func(L *lua.LState) int {
+		data, err := luaCheckModSettings(L).ExportJSON(L.OptString(2, ""))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(lua.LString(data))
+		return 1
+	},
+	"from_json": func(L *lua.LState) int {
+		settings := luaCheckModSettings(L)
+		return luaPushResult(L, settings.ImportJSON([]byte(L.CheckString(2))))
+	},
+	"save": func(L *lua.LState) int {
+		settings := luaCheckModSettings(L)
+		return luaPushResult(L, settings.Save(L.CheckString(2)))
+	},
+	"tostring": func(L *lua.LState) int {
+		L.Push(lua.LString("ModSettings " + luaCheckModSettings(L).GameVersion()))
+		return 1
+	},
+}
+
+// luaRegisterModSettingsObject registers the modsettings table for Factorio's mod-settings.dat.
+func luaRegisterModSettingsObject(L *lua.LState) {
+	mt := L.NewTypeMetatable(luaModSettingsTypeName)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), luaModSettingsMethods))
+	L.SetField(mt, "__tostring", L.NewFunction(luaModSettingsMethods["tostring"]))
+
+	settingsTable := L.NewTable()
+	settingsTable.RawSetString("load", L.NewFunction(func(L *lua.LState) int {
+		settings, err := formats.LoadModSettings(L.CheckString(1))
+		return pushModSettings(L, settings, err)
+	}))
+	settingsTable.RawSetString("parse", L.NewFunction(func(L *lua.LState) int {
+		settings, err := formats.ParseModSettings([]byte(L.CheckString(1)))
+		return pushModSettings(L, settings, err)
+	}))
+	L.SetGlobal("modsettings", settingsTable)
+}
@@ -80,6 +80,8 @@ func luaExtendOsTable(l *lua.LState) {
 		tbl = l.NewTable()
 		l.SetGlobal("os", tbl)
 	}
+	// os.execute runs anything through the shell, plugins use the process module instead
+	l.SetField(tbl, "execute", lua.LNil)
 	l.SetField(tbl, "getOperatingSystem", l.NewFunction(luaGetOperatingSystem))
 	l.SetField(tbl, "isWindows", l.NewFunction(luaIsWindows))
 	l.SetField(tbl, "isLinux", l.NewFunction(luaIsLinux))
@@ -0,0 +1,122 @@
+package scripting
+
+import (
+	"TotalControl/backend/process"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	lua "github.com/yuin/gopher-lua"
+	"path/filepath"
+	"time"
+)
+
+func luaProcessAllowlist(L *lua.LState) process.Allowlist {
+	if engine := GetLuaEngine(L); engine != nil {
+		return engine.processes
+	}
+	return nil
+}
+
+// luaProcessOptionsFromTable reads { "executable", "arg", ..., dir=, env={}, stdin=, timeout=, stream= }.
+func luaProcessOptionsFromTable(L *lua.LState, tbl *lua.LTable) (process.Options, error) {
+	var options process.Options
+	for i := 1; i <= tbl.Len(); i++ {
+		value := tbl.RawGetInt(i)
+		if value.Type() != lua.LTString && value.Type() != lua.LTNumber {
+			return options, fmt.Errorf("argument %d must be a string, got %s", i, value.Type())
+		}
+		if i == 1 {
+			options.Executable = value.String()
+		} else {
+			options.Args = append(options.Args, value.String())
+		}
+	}
+	if options.Executable == "" {
+		return options, fmt.Errorf("no executable given")
+	}
+
+	if dir, ok := tbl.RawGetString("dir").(lua.LString); ok {
+		options.Dir = string(dir)
+	}
+	if stdin, ok := tbl.RawGetString("stdin").(lua.LString); ok {
+		options.Stdin = string(stdin)
+	}
+	if timeout, ok := tbl.RawGetString("timeout").(lua.LNumber); ok {
+		options.Timeout = time.Duration(float64(timeout) * float64(time.Second))
+	}
+	if env, ok := tbl.RawGetString("env").(*lua.LTable); ok {
+		options.Env = make(map[string]string)
+		env.ForEach(func(key lua.LValue, value lua.LValue) {
+			options.Env[key.String()] = value.String()
+		})
+	}
+	if lua.LVAsBool(tbl.RawGetString("stream")) {
+		name := filepath.Base(options.Executable)
+		options.OnStdout = func(line string) {
+			log.WithFields(log.Fields{"lua": true, "process": name}).Info(line)
+		}
+		options.OnStderr = func(line string) {
+			log.WithFields(log.Fields{"lua": true, "process": name}).Warn(line)
+		}
+	}
+	return options, nil
+}
+
+// luaProcessRun runs an allowed executable and returns { exit_code, success, stdout, stderr, timed_out, duration,
+// truncated }, or nil and an error message if it could not be started.
+func luaProcessRun(L *lua.LState) int {
+	options, err := luaProcessOptionsFromTable(L, L.CheckTable(1))
+	if err != nil {
+		L.ArgError(1, err.Error())
+		return 0
+	}
+	if options.Executable, err = luaProcessAllowlist(L).Resolve(options.Executable); err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	log.WithField("lua", true).Debugf("Running %s %v", options.Executable, options.Args)
+	result, err := process.Run(L.Context(), options)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	tbl := L.NewTable()
+	tbl.RawSetString("exit_code", lua.LNumber(result.ExitCode))
+	tbl.RawSetString("success", lua.LBool(result.Success()))
+	tbl.RawSetString("stdout", lua.LString(result.Stdout))
+	tbl.RawSetString("stderr", lua.LString(result.Stderr))
+	tbl.RawSetString("timed_out", lua.LBool(result.TimedOut))
+	tbl.RawSetString("truncated", lua.LBool(result.Truncated))
+	tbl.RawSetString("duration", lua.LNumber(result.Duration.Seconds()))
+	L.Push(tbl)
+	return 1
+}
+
+// luaProcessFind returns the absolute path of an allowed executable, or nil and an error message.
+func luaProcessFind(L *lua.LState) int {
+	path, err := luaProcessAllowlist(L).Resolve(L.CheckString(1))
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LString(path))
+	return 1
+}
+
+func luaProcessAllowed(L *lua.LState) int {
+	_, err := luaProcessAllowlist(L).Resolve(L.CheckString(1))
+	L.Push(lua.LBool(err == nil))
+	return 1
+}
+
+func luaRegisterProcessObject(L *lua.LState) {
+	processTable := L.NewTable()
+	processTable.RawSetString("run", L.NewFunction(luaProcessRun))
+	processTable.RawSetString("find", L.NewFunction(luaProcessFind))
+	processTable.RawSetString("allowed", L.NewFunction(luaProcessAllowed))
+	L.SetGlobal("process", processTable)
+}
@@ -0,0 +1,53 @@
+package scripting
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"os"
+	"testing"
+)
+
+// TestHelperProcess is run as the child process of TestLuaProcess.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("TOTALCONTROL_HELPER_PROCESS") != "1" {
+		return
+	}
+	fmt.Println("dumped", os.Args[len(os.Args)-1])
+	os.Exit(2)
+}
+
+func TestLuaProcess(t *testing.T) {
+	engine, err := NewLuaEngine(uuid.New(), WithProcessPermission(os.Args[0]))
+	assert.NoError(t, err)
+	defer engine.Close()
+
+	engine.L.SetGlobal("executable", lua.LString(os.Args[0]))
+	err = engine.LoadScript(`
+		assert(os.execute == nil and io.popen == nil)
+		assert(process.allowed(executable))
+		assert(not process.allowed("sh"))
+
+		local result, err = process.run{ "sh", "-c", "echo unsafe" }
+		assert(result == nil and string.find(err, "not allowed"))
+
+		result = assert(process.run{
+			executable, "-test.run=TestHelperProcess", "--", "--dump-data",
+			env = { TOTALCONTROL_HELPER_PROCESS = "1" },
+			timeout = 10,
+			stream = true,
+		})
+		exitCode = result.exit_code
+		stdout = result.stdout
+		assert(result.success == false and result.timed_out == false)
+	`)
+	assert.NoError(t, err)
+	assert.Equal(t, lua.LNumber(2), engine.L.GetGlobal("exitCode"))
+	assert.Equal(t, lua.LString("dumped --dump-data\n"), engine.L.GetGlobal("stdout"))
+
+	unprivileged := newTestLuaEngine(t)
+	defer unprivileged.Close()
+	unprivileged.L.SetGlobal("executable", lua.LString(os.Args[0]))
+	assert.NoError(t, unprivileged.LoadScript(`assert(process.run{ executable } == nil)`))
+}
@@ -0,0 +1,80 @@
+package scripting
+
+import (
+	"TotalControl/backend/secrets"
+	"errors"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// getSecrets returns the vault and the scope of the calling engine. Each engine (plugin) only sees its own secrets.
+func getSecrets(L *lua.LState) (*secrets.Vault, string) {
+	engine := GetLuaEngine(L)
+	if engine == nil {
+		L.RaiseError("secrets: LuaEngine not found in context")
+		return nil, ""
+	}
+	if engine.secrets == nil {
+		engine.secrets = secrets.Default()
+	}
+	return engine.secrets, engine.uuid.String()
+}
+
+// luaSecretsGet returns the secret, nil if it is not set, or nil and an error if the vault is locked.
+func luaSecretsGet(L *lua.LState) int {
+	name := L.CheckString(1)
+	vault, scope := getSecrets(L)
+	value, err := vault.Get(scope, name)
+	switch {
+	case errors.Is(err, secrets.ErrNotFound):
+		L.Push(lua.LNil)
+		return 1
+	case err != nil:
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LString(value))
+	return 1
+}
+
+func luaSecretsSet(L *lua.LState) int {
+	name := L.CheckString(1)
+	value := L.CheckString(2)
+	vault, scope := getSecrets(L)
+	if err := vault.Set(scope, name, value); err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+func luaSecretsDelete(L *lua.LState) int {
+	name := L.CheckString(1)
+	vault, scope := getSecrets(L)
+	if err := vault.Delete(scope, name); err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+func luaSecretsHas(L *lua.LState) int {
+	name := L.CheckString(1)
+	vault, scope := getSecrets(L)
+	_, err := vault.Get(scope, name)
+	L.Push(lua.LBool(err == nil))
+	return 1
+}
+
+func luaRegisterSecretsObject(L *lua.LState) {
+	secretsTable := L.NewTable()
+	secretsTable.RawSetString("get", L.NewFunction(luaSecretsGet))
+	secretsTable.RawSetString("set", L.NewFunction(luaSecretsSet))
+	secretsTable.RawSetString("delete", L.NewFunction(luaSecretsDelete))
+	secretsTable.RawSetString("has", L.NewFunction(luaSecretsHas))
+	L.SetGlobal("secrets", secretsTable)
+}
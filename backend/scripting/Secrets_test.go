@@ -0,0 +1,48 @@
+package scripting
+
+import (
+	"TotalControl/backend/secrets"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"path/filepath"
+	"testing"
+)
+
+func TestLuaSecrets_ScopedPerEngine(t *testing.T) {
+	dir := t.TempDir()
+	vault := secrets.NewVault(filepath.Join(dir, "secrets.vault"))
+	assert.NoError(t, vault.UnlockWithKeyFile(filepath.Join(dir, "secrets.key")))
+
+	first, err := NewLuaEngine(uuid.New(), WithSecrets(vault))
+	assert.NoError(t, err)
+	defer first.Close()
+	second, err := NewLuaEngine(uuid.New(), WithSecrets(vault))
+	assert.NoError(t, err)
+	defer second.Close()
+
+	assert.NoError(t, first.LoadScript(`
+		assert(secrets.get("token") == nil)
+		assert(secrets.set("token", "abc"))
+		result = secrets.get("token")
+	`))
+	assert.Equal(t, lua.LString("abc"), first.L.GetGlobal("result"))
+
+	assert.NoError(t, second.LoadScript(`result = secrets.has("token")`))
+	assert.Equal(t, lua.LFalse, second.L.GetGlobal("result"))
+
+	vault.Lock()
+	assert.NoError(t, first.LoadScript(`value, err = secrets.get("token")`))
+	assert.Equal(t, lua.LNil, first.L.GetGlobal("value"))
+	assert.Equal(t, lua.LString(secrets.ErrLocked.Error()), first.L.GetGlobal("err"))
+}
+
+func TestFindPlugins_ReadsManifests(t *testing.T) {
+	plugins, err := FindPlugins("../../plugins")
+	assert.NoError(t, err)
+	// The Factorio plugin exists as a directory and as a .tcplugin, but is only listed once
+	if assert.Len(t, plugins, 1) {
+		assert.Equal(t, "Factorio", plugins[0].Name)
+		assert.Len(t, plugins[0].Secrets, 2)
+	}
+}
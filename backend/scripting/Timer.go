@@ -0,0 +1,215 @@
+package scripting
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	lua "github.com/yuin/gopher-lua"
+	"sync"
+	"time"
+)
+
+const (
+	luaTimerTypeName = "Timer"
+	// minTimerInterval keeps a plugin from keeping its Lua state permanently busy with timer.every(0, ...).
+	minTimerInterval = 10 * time.Millisecond
+)
+
+// luaTimer is a pending timer.after or timer.every callback.
+type luaTimer struct {
+	id       int
+	fn       *lua.LFunction
+	interval time.Duration
+	repeat   bool
+	timer    *time.Timer
+	// handle is passed to the callback so timer.every can cancel itself.
+	handle *lua.LUserData
+}
+
+// luaTimers keeps track of an engine's timers so they can all be stopped when the plugin is unloaded.
+type luaTimers struct {
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]*luaTimer
+	stopped bool
+}
+
+func (l *LuaEngine) timerList() *luaTimers {
+	if l.timers == nil {
+		l.timers = &luaTimers{pending: make(map[int]*luaTimer)}
+	}
+	return l.timers
+}
+
+// schedule starts a timer. It is called from Lua, so the state lock is already held.
+func (l *LuaEngine) schedule(fn *lua.LFunction, delay time.Duration, repeat bool) (*luaTimer, error) {
+	timers := l.timerList()
+	timers.mu.Lock()
+	defer timers.mu.Unlock()
+	if timers.stopped {
+		return nil, fmt.Errorf("the plugin is shutting down")
+	}
+
+	timers.nextID++
+	t := &luaTimer{id: timers.nextID, fn: fn, interval: delay, repeat: repeat}
+	t.handle = l.L.NewUserData()
+	t.handle.Value = t
+	l.L.SetMetatable(t.handle, l.L.GetTypeMetatable(luaTimerTypeName))
+	timers.pending[t.id] = t
+	t.timer = time.AfterFunc(delay, func() {
+		l.fire(t)
+	})
+	return t, nil
+}
+
+// cancel stops a timer and reports whether it was still pending.
+func (l *LuaEngine) cancel(t *luaTimer) bool {
+	timers := l.timerList()
+	timers.mu.Lock()
+	defer timers.mu.Unlock()
+	if _, ok := timers.pending[t.id]; !ok {
+		return false
+	}
+	delete(timers.pending, t.id)
+	t.timer.Stop()
+	return true
+}
+
+func (l *LuaEngine) isPending(t *luaTimer) bool {
+	timers := l.timerList()
+	timers.mu.Lock()
+	defer timers.mu.Unlock()
+	_, ok := timers.pending[t.id]
+	return ok
+}
+
+// fire runs the callback once no host call is using the Lua state. Errors are logged, a failing timer.every
+// callback keeps running.
+func (l *LuaEngine) fire(t *luaTimer) {
+	l.state.Lock()
+	defer l.state.Unlock()
+
+	timers := l.timerList()
+	timers.mu.Lock()
+	_, pending := timers.pending[t.id]
+	if pending && !t.repeat {
+		delete(timers.pending, t.id)
+	}
+	timers.mu.Unlock()
+	if !pending {
+		return
+	}
+
+	top := l.L.GetTop()
+	err := l.L.CallByParam(lua.P{Fn: t.fn, NRet: 0, Protect: true}, t.handle)
+	l.L.SetTop(top)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"lua":   true,
+			"timer": t.id,
+			"stack": err.Error(),
+		}).Error("Lua timer callback failed")
+	}
+
+	if t.repeat {
+		timers.mu.Lock()
+		// The callback may have cancelled the timer
+		if _, ok := timers.pending[t.id]; ok && !timers.stopped {
+			t.timer.Reset(t.interval)
+		}
+		timers.mu.Unlock()
+	}
+}
+
+// stopTimers cancels every timer. Callbacks that are already waiting for the state lock return without running.
+func (l *LuaEngine) stopTimers() {
+	timers := l.timerList()
+	timers.mu.Lock()
+	defer timers.mu.Unlock()
+	timers.stopped = true
+	for id, t := range timers.pending {
+		t.timer.Stop()
+		delete(timers.pending, id)
+	}
+}
+
+// ActiveTimers returns the number of pending timers.
+func (l *LuaEngine) ActiveTimers() int {
+	timers := l.timerList()
+	timers.mu.Lock()
+	defer timers.mu.Unlock()
+	return len(timers.pending)
+}
+
+func luaCheckTimer(L *lua.LState, n int) *luaTimer {
+	if t, ok := L.CheckUserData(n).Value.(*luaTimer); ok {
+		return t
+	}
+	L.ArgError(n, "timer expected")
+	return nil
+}
+
+func luaTimerStart(L *lua.LState, repeat bool) int {
+	milliseconds := L.CheckNumber(1)
+	fn := L.CheckFunction(2)
+	delay := time.Duration(float64(milliseconds) * float64(time.Millisecond))
+	if delay < 0 {
+		L.ArgError(1, "delay must not be negative")
+		return 0
+	}
+	if repeat {
+		delay = max(delay, minTimerInterval)
+	}
+
+	engine := GetLuaEngine(L)
+	if engine == nil {
+		L.RaiseError("timers are only available in plugins")
+		return 0
+	}
+	t, err := engine.schedule(fn, delay, repeat)
+	if err != nil {
+		L.RaiseError("%v", err)
+		return 0
+	}
+	L.Push(t.handle)
+	return 1
+}
+
+// luaTimerAfter runs fn once after the delay in milliseconds and returns a handle to cancel it.
+func luaTimerAfter(L *lua.LState) int {
+	return luaTimerStart(L, false)
+}
+
+// luaTimerEvery runs fn repeatedly. The next run is scheduled after the previous one finished, so slow callbacks
+// never pile up.
+func luaTimerEvery(L *lua.LState) int {
+	return luaTimerStart(L, true)
+}
+
+func luaTimerCancel(L *lua.LState) int {
+	t := luaCheckTimer(L, 1)
+	engine := GetLuaEngine(L)
+	L.Push(lua.LBool(engine != nil && engine.cancel(t)))
+	return 1
+}
+
+func luaTimerActive(L *lua.LState) int {
+	t := luaCheckTimer(L, 1)
+	engine := GetLuaEngine(L)
+	L.Push(lua.LBool(engine != nil && engine.isPending(t)))
+	return 1
+}
+
+func luaRegisterTimerObject(L *lua.LState) {
+	mt := L.NewTypeMetatable(luaTimerTypeName)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"cancel": luaTimerCancel,
+		"active": luaTimerActive,
+	}))
+
+	timerTable := L.NewTable()
+	timerTable.RawSetString("after", L.NewFunction(luaTimerAfter))
+	timerTable.RawSetString("every", L.NewFunction(luaTimerEvery))
+	timerTable.RawSetString("cancel", L.NewFunction(luaTimerCancel))
+	timerTable.RawSetString("active", L.NewFunction(luaTimerActive))
+	L.SetGlobal("timer", timerTable)
+}
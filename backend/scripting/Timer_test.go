@@ -0,0 +1,91 @@
+package scripting
+
+import (
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"testing"
+	"time"
+)
+
+// globalNumber reads a global under the state lock, since timers may be running.
+func globalNumber(engine *LuaEngine, name string) float64 {
+	engine.state.Lock()
+	defer engine.state.Unlock()
+	return float64(lua.LVAsNumber(engine.L.GetGlobal(name)))
+}
+
+func TestLuaTimer_AfterAndEvery(t *testing.T) {
+	engine := newTestLuaEngine(t)
+	defer engine.Close()
+
+	err := engine.LoadScript(`
+		once = 0
+		ticks = 0
+		cancelled = 0
+		timer.after(10, function() once = once + 1 end)
+		timer.every(10, function(handle)
+			ticks = ticks + 1
+			if ticks == 3 then
+				handle:cancel()
+			end
+			error("errors do not stop the timer")
+		end)
+		local pending = timer.after(10, function() cancelled = 1 end)
+		assert(pending:active())
+		assert(timer.cancel(pending))
+		assert(not pending:active())
+	`)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return globalNumber(engine, "ticks") == 3 && globalNumber(engine, "once") == 1
+	}, 2*time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, float64(3), globalNumber(engine, "ticks"))
+	assert.Equal(t, float64(0), globalNumber(engine, "cancelled"))
+	assert.Equal(t, 0, engine.ActiveTimers())
+}
+
+func TestLuaTimer_NotConcurrentWithHostCalls(t *testing.T) {
+	engine := newTestLuaEngine(t)
+	defer engine.Close()
+
+	err := engine.LoadScript(`
+		inside = false
+		overlap = 0
+		fired = 0
+		function busy()
+			inside = true
+			-- Due while busy is still running
+			timer.after(5, function()
+				if inside then overlap = 1 end
+				fired = 1
+			end)
+			local start = os.clock()
+			while os.clock() - start < 0.1 do end
+			inside = false
+		end
+	`)
+	assert.NoError(t, err)
+	_, err = engine.CallGlobal("busy")
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return globalNumber(engine, "fired") == 1
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, float64(0), globalNumber(engine, "overlap"))
+}
+
+func TestLuaTimer_StoppedOnClose(t *testing.T) {
+	engine := newTestLuaEngine(t)
+	err := engine.LoadScript(`
+		timer.every(10, function() end)
+		timer.after(10000, function() end)
+	`)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, engine.ActiveTimers())
+
+	engine.Close()
+	assert.Equal(t, 0, engine.ActiveTimers())
+	time.Sleep(30 * time.Millisecond)
+}
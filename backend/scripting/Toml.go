@@ -0,0 +1,21 @@
+package scripting
+
+import (
+	"TotalControl/backend/formats"
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+)
+
+func luaRegisterTomlObject(L *lua.LState) {
+	decode := func(data []byte) (interface{}, error) {
+		return formats.DecodeToml(data)
+	}
+	update := func(original []byte, value interface{}) ([]byte, error) {
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("toml: top level value must be a table with string keys")
+		}
+		return formats.UpdateToml(original, table)
+	}
+	luaRegisterDataFormat(L, "toml", decode, update)
+}
@@ -0,0 +1,138 @@
+package scripting
+
+import (
+	"TotalControl/backend/version"
+	lua "github.com/yuin/gopher-lua"
+)
+
+func luaVersionToTable(L *lua.LState, v *version.Version) *lua.LTable {
+	tbl := L.NewTable()
+	tbl.RawSetString("major", lua.LNumber(v.Major()))
+	tbl.RawSetString("minor", lua.LNumber(v.Minor()))
+	tbl.RawSetString("patch", lua.LNumber(v.Patch()))
+	segments := L.NewTable()
+	for _, segment := range v.Segments {
+		segments.Append(lua.LNumber(segment))
+	}
+	tbl.RawSetString("segments", segments)
+	if v.Prerelease != "" {
+		tbl.RawSetString("prerelease", lua.LString(v.Prerelease))
+	}
+	if v.Build != "" {
+		tbl.RawSetString("build", lua.LString(v.Build))
+	}
+	return tbl
+}
+
+func luaCheckVersion(L *lua.LState, n int) *version.Version {
+	v, err := version.Parse(L.CheckString(n))
+	if err != nil {
+		L.ArgError(n, err.Error())
+		return nil
+	}
+	return v
+}
+
+// luaVersionParse returns the parts of a version, or nil and an error message.
+func luaVersionParse(L *lua.LState) int {
+	v, err := version.Parse(L.CheckString(1))
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(luaVersionToTable(L, v))
+	return 1
+}
+
+func luaVersionValid(L *lua.LState) int {
+	_, err := version.Parse(L.CheckString(1))
+	L.Push(lua.LBool(err == nil))
+	return 1
+}
+
+// luaVersionCompare returns -1, 0 or 1. Invalid versions raise an error.
+func luaVersionCompare(L *lua.LState) int {
+	a := luaCheckVersion(L, 1)
+	b := luaCheckVersion(L, 2)
+	L.Push(lua.LNumber(a.Compare(b)))
+	return 1
+}
+
+func luaVersionIsNewer(L *lua.LState) int {
+	a := luaCheckVersion(L, 1)
+	b := luaCheckVersion(L, 2)
+	L.Push(lua.LBool(a.GreaterThan(b)))
+	return 1
+}
+
+// luaVersionSatisfies returns whether the version matches the constraint, or nil and an error message.
+func luaVersionSatisfies(L *lua.LState) int {
+	matches, err := version.Satisfies(L.CheckString(1), L.CheckString(2))
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LBool(matches))
+	return 1
+}
+
+// luaVersionSort returns a new list of the versions in ascending order. Invalid versions raise an error.
+func luaVersionSort(L *lua.LState) int {
+	list := L.CheckTable(1)
+	var versions []*version.Version
+	for i := 1; i <= list.Len(); i++ {
+		v, err := version.Parse(list.RawGetInt(i).String())
+		if err != nil {
+			L.RaiseError("version.sort: %v", err)
+			return 0
+		}
+		versions = append(versions, v)
+	}
+	version.Sort(versions)
+
+	sorted := L.NewTable()
+	for _, v := range versions {
+		sorted.Append(lua.LString(v.Original()))
+	}
+	L.Push(sorted)
+	return 1
+}
+
+// luaVersionLatest returns the highest version in the list that matches the optional constraint, or nil.
+func luaVersionLatest(L *lua.LState) int {
+	list := L.CheckTable(1)
+	var constraint *version.Constraint
+	if L.GetTop() >= 2 && L.Get(2) != lua.LNil {
+		var err error
+		constraint, err = version.ParseConstraint(L.CheckString(2))
+		if err != nil {
+			L.ArgError(2, err.Error())
+			return 0
+		}
+	}
+
+	var versions []string
+	for i := 1; i <= list.Len(); i++ {
+		versions = append(versions, list.RawGetInt(i).String())
+	}
+	if latest := version.Latest(versions, constraint); latest != "" {
+		L.Push(lua.LString(latest))
+	} else {
+		L.Push(lua.LNil)
+	}
+	return 1
+}
+
+func luaRegisterVersionObject(L *lua.LState) {
+	versionTable := L.NewTable()
+	versionTable.RawSetString("parse", L.NewFunction(luaVersionParse))
+	versionTable.RawSetString("valid", L.NewFunction(luaVersionValid))
+	versionTable.RawSetString("compare", L.NewFunction(luaVersionCompare))
+	versionTable.RawSetString("is_newer", L.NewFunction(luaVersionIsNewer))
+	versionTable.RawSetString("satisfies", L.NewFunction(luaVersionSatisfies))
+	versionTable.RawSetString("sort", L.NewFunction(luaVersionSort))
+	versionTable.RawSetString("latest", L.NewFunction(luaVersionLatest))
+	L.SetGlobal("version", versionTable)
+}
@@ -0,0 +1,32 @@
+package scripting
+
+import (
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"testing"
+)
+
+func TestLuaVersion(t *testing.T) {
+	engine := newTestLuaEngine(t)
+	defer engine.Close()
+
+	err := engine.LoadScript(`
+		local v = version.parse("1.1.110-rc.1")
+		assert(v.major == 1 and v.minor == 1 and v.patch == 110 and v.prerelease == "rc.1")
+		assert(version.parse("nope") == nil)
+		assert(version.compare("2.0", "1.1.110") == 1)
+		assert(version.is_newer("1.1.110", "1.1.19"))
+		assert(version.satisfies("2.0.28", "2.0"))
+		assert(not version.satisfies("2.0.28", ">= 1.1, < 2.0"))
+		sorted = version.sort({ "2.0", "1.1.110", "1.1.19" })
+		latest = version.latest({ "1.1.110", "2.0.28", "2.0.7" }, "^2")
+	`)
+	assert.NoError(t, err)
+
+	sorted := engine.L.GetGlobal("sorted").(*lua.LTable)
+	assert.Equal(t, lua.LString("1.1.19"), sorted.RawGetInt(1))
+	assert.Equal(t, lua.LString("2.0"), sorted.RawGetInt(3))
+	assert.Equal(t, lua.LString("2.0.28"), engine.L.GetGlobal("latest"))
+
+	assert.Error(t, engine.LoadScript(`version.compare("1.0", "bad")`))
+}
@@ -0,0 +1,282 @@
+package scripting
+
+import (
+	"TotalControl/backend/formats"
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+	"os"
+	"sort"
+	"strings"
+)
+
+// luaXmlNodeToTable converts a node into { type, name, attributes, attribute_order, children, text }.
+func luaXmlNodeToTable(L *lua.LState, node *formats.XmlNode) *lua.LTable {
+	tbl := L.NewTable()
+	tbl.RawSetString("type", lua.LString(node.Type))
+	if node.Name != "" {
+		tbl.RawSetString("name", lua.LString(node.Name))
+	}
+	switch node.Type {
+	case formats.XmlDocument, formats.XmlElement:
+		if node.Type == formats.XmlElement {
+			attributes := L.NewTable()
+			order := L.NewTable()
+			for _, attr := range node.Attributes {
+				attributes.RawSetString(attr.Name, lua.LString(attr.Value))
+				order.Append(lua.LString(attr.Name))
+			}
+			tbl.RawSetString("attributes", attributes)
+			tbl.RawSetString("attribute_order", order)
+		}
+		children := L.CreateTable(len(node.Children), 0)
+		for _, child := range node.Children {
+			children.Append(luaXmlNodeToTable(L, child))
+		}
+		tbl.RawSetString("children", children)
+	default:
+		tbl.RawSetString("text", lua.LString(node.Text))
+	}
+	return tbl
+}
+
+// luaXmlNodeFromTable is the inverse of luaXmlNodeToTable. Plain strings in children are text nodes, and
+// attributes missing from attribute_order are written in alphabetical order after the others.
+func luaXmlNodeFromTable(value lua.LValue) (*formats.XmlNode, error) {
+	if text, ok := value.(lua.LString); ok {
+		return &formats.XmlNode{Type: formats.XmlText, Text: string(text)}, nil
+	}
+	tbl, ok := value.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("expected a node table, got %s", value.Type())
+	}
+
+	node := &formats.XmlNode{
+		Type: formats.XmlNodeType(lua.LVAsString(tbl.RawGetString("type"))),
+		Name: lua.LVAsString(tbl.RawGetString("name")),
+		Text: lua.LVAsString(tbl.RawGetString("text")),
+	}
+	switch node.Type {
+	case "":
+		node.Type = formats.XmlElement
+	case formats.XmlDocument, formats.XmlElement, formats.XmlText, formats.XmlComment, formats.XmlProcInst, formats.XmlDirective:
+	default:
+		return nil, fmt.Errorf("unknown node type %q", node.Type)
+	}
+	if node.Type == formats.XmlElement && node.Name == "" {
+		return nil, fmt.Errorf("element without name")
+	}
+
+	if attributes, ok := tbl.RawGetString("attributes").(*lua.LTable); ok {
+		written := make(map[string]bool)
+		if order, ok := tbl.RawGetString("attribute_order").(*lua.LTable); ok {
+			for i := 1; i <= order.Len(); i++ {
+				name := lua.LVAsString(order.RawGetInt(i))
+				if value := attributes.RawGetString(name); value != lua.LNil && !written[name] {
+					node.SetAttr(name, lua.LVAsString(value))
+					written[name] = true
+				}
+			}
+		}
+		var remaining []string
+		attributes.ForEach(func(key lua.LValue, _ lua.LValue) {
+			if !written[key.String()] {
+				remaining = append(remaining, key.String())
+			}
+		})
+		sort.Strings(remaining)
+		for _, name := range remaining {
+			node.SetAttr(name, lua.LVAsString(attributes.RawGetString(name)))
+		}
+	}
+
+	if children, ok := tbl.RawGetString("children").(*lua.LTable); ok {
+		for i := 1; i <= children.Len(); i++ {
+			child, err := luaXmlNodeFromTable(children.RawGetInt(i))
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+	}
+	return node, nil
+}
+
+// luaXmlFindAll walks the Lua tables directly so the results can be modified in place.
+func luaXmlFindAll(node *lua.LTable, path string) []*lua.LTable {
+	current := []*lua.LTable{node}
+	names := strings.Split(strings.Trim(path, "/"), "/")
+	if lua.LVAsString(node.RawGetString("type")) == "document" {
+		// The first name matches the root element itself
+		current = luaXmlChildElements(node, names[0])
+		names = names[1:]
+	}
+	for _, name := range names {
+		var next []*lua.LTable
+		for _, element := range current {
+			next = append(next, luaXmlChildElements(element, name)...)
+		}
+		current = next
+	}
+	return current
+}
+
+func luaXmlChildElements(node *lua.LTable, name string) []*lua.LTable {
+	var result []*lua.LTable
+	children, ok := node.RawGetString("children").(*lua.LTable)
+	if !ok {
+		return nil
+	}
+	for i := 1; i <= children.Len(); i++ {
+		child, ok := children.RawGetInt(i).(*lua.LTable)
+		if !ok {
+			continue
+		}
+		childType := lua.LVAsString(child.RawGetString("type"))
+		if (childType == "" || childType == "element") && (name == "*" || lua.LVAsString(child.RawGetString("name")) == name) {
+			result = append(result, child)
+		}
+	}
+	return result
+}
+
+func luaXmlInnerText(value lua.LValue) string {
+	if text, ok := value.(lua.LString); ok {
+		return string(text)
+	}
+	tbl, ok := value.(*lua.LTable)
+	if !ok {
+		return ""
+	}
+	switch lua.LVAsString(tbl.RawGetString("type")) {
+	case "text":
+		return lua.LVAsString(tbl.RawGetString("text"))
+	case "", "element", "document":
+		var sb strings.Builder
+		if children, ok := tbl.RawGetString("children").(*lua.LTable); ok {
+			for i := 1; i <= children.Len(); i++ {
+				sb.WriteString(luaXmlInnerText(children.RawGetInt(i)))
+			}
+		}
+		return sb.String()
+	}
+	return ""
+}
+
+func luaXmlParseBytes(L *lua.LState, data []byte) int {
+	document, err := formats.ParseXml(data)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(luaXmlNodeToTable(L, document))
+	return 1
+}
+
+// luaXmlParse returns the document table, or nil and an error message.
+func luaXmlParse(L *lua.LState) int {
+	return luaXmlParseBytes(L, []byte(L.CheckString(1)))
+}
+
+func luaXmlLoad(L *lua.LState) int {
+	data, err := os.ReadFile(L.CheckString(1))
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	return luaXmlParseBytes(L, data)
+}
+
+func luaXmlEncode(L *lua.LState) int {
+	node, err := luaXmlNodeFromTable(L.CheckTable(1))
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LString(node.String()))
+	return 1
+}
+
+func luaXmlSave(L *lua.LState) int {
+	path := L.CheckString(1)
+	node, err := luaXmlNodeFromTable(L.CheckTable(2))
+	if err == nil {
+		err = os.WriteFile(path, node.Bytes(), 0644)
+	}
+	if err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+func luaXmlFind(L *lua.LState) int {
+	if matches := luaXmlFindAll(L.CheckTable(1), L.CheckString(2)); len(matches) > 0 {
+		L.Push(matches[0])
+	} else {
+		L.Push(lua.LNil)
+	}
+	return 1
+}
+
+func luaXmlFindAllFunction(L *lua.LState) int {
+	result := L.NewTable()
+	for _, match := range luaXmlFindAll(L.CheckTable(1), L.CheckString(2)) {
+		result.Append(match)
+	}
+	L.Push(result)
+	return 1
+}
+
+func luaXmlText(L *lua.LState) int {
+	L.Push(lua.LString(luaXmlInnerText(L.CheckAny(1))))
+	return 1
+}
+
+// luaXmlElement creates an element table: xml.element(name, attributes, children).
+func luaXmlElement(L *lua.LState) int {
+	tbl := L.NewTable()
+	tbl.RawSetString("type", lua.LString(formats.XmlElement))
+	tbl.RawSetString("name", lua.LString(L.CheckString(1)))
+
+	attributes := L.OptTable(2, L.NewTable())
+	var names []string
+	attributes.ForEach(func(key lua.LValue, _ lua.LValue) {
+		names = append(names, key.String())
+	})
+	sort.Strings(names)
+	order := L.NewTable()
+	for _, name := range names {
+		order.Append(lua.LString(name))
+	}
+	tbl.RawSetString("attributes", attributes)
+	tbl.RawSetString("attribute_order", order)
+
+	children := L.NewTable()
+	switch value := L.Get(3).(type) {
+	case lua.LString:
+		children.Append(value)
+	case *lua.LTable:
+		children = value
+	}
+	tbl.RawSetString("children", children)
+	L.Push(tbl)
+	return 1
+}
+
+func luaRegisterXmlObject(L *lua.LState) {
+	xmlTable := L.NewTable()
+	xmlTable.RawSetString("parse", L.NewFunction(luaXmlParse))
+	xmlTable.RawSetString("load", L.NewFunction(luaXmlLoad))
+	xmlTable.RawSetString("encode", L.NewFunction(luaXmlEncode))
+	xmlTable.RawSetString("save", L.NewFunction(luaXmlSave))
+	xmlTable.RawSetString("find", L.NewFunction(luaXmlFind))
+	xmlTable.RawSetString("find_all", L.NewFunction(luaXmlFindAllFunction))
+	xmlTable.RawSetString("text", L.NewFunction(luaXmlText))
+	xmlTable.RawSetString("element", L.NewFunction(luaXmlElement))
+	L.SetGlobal("xml", xmlTable)
+}
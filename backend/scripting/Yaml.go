@@ -0,0 +1,73 @@
+package scripting
+
+import (
+	"TotalControl/backend/formats"
+	"TotalControl/backend/utils"
+	"errors"
+	lua "github.com/yuin/gopher-lua"
+	"os"
+)
+
+// luaRegisterDataFormat registers decode/encode/load/save for a format that maps to plain Lua values. update
+// encodes a value on top of an original document, which is empty for new files.
+func luaRegisterDataFormat(L *lua.LState, name string, decode func([]byte) (interface{}, error), update func([]byte, interface{}) ([]byte, error)) {
+	pushDecoded := func(L *lua.LState, data []byte, err error) int {
+		var value interface{}
+		if err == nil {
+			value, err = decode(data)
+		}
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(utils.ToLuaValue(L, value))
+		return 1
+	}
+
+	formatTable := L.NewTable()
+	formatTable.RawSetString("decode", L.NewFunction(func(L *lua.LState) int {
+		return pushDecoded(L, []byte(L.CheckString(1)), nil)
+	}))
+	formatTable.RawSetString("load", L.NewFunction(func(L *lua.LState) int {
+		data, err := os.ReadFile(L.CheckString(1))
+		return pushDecoded(L, data, err)
+	}))
+	formatTable.RawSetString("encode", L.NewFunction(func(L *lua.LState) int {
+		data, err := update([]byte(L.OptString(2, "")), utils.LuaValueToInterface(L.CheckAny(1)))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(lua.LString(data))
+		return 1
+	}))
+	formatTable.RawSetString("save", L.NewFunction(func(L *lua.LState) int {
+		path := L.CheckString(1)
+		// Writing on top of the existing file keeps its comments and formatting
+		original, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		var data []byte
+		if err == nil {
+			data, err = update(original, utils.LuaValueToInterface(L.CheckAny(2)))
+		}
+		if err == nil {
+			err = os.WriteFile(path, data, 0644)
+		}
+		if err != nil {
+			L.Push(lua.LFalse)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(lua.LTrue)
+		return 1
+	}))
+	L.SetGlobal(name, formatTable)
+}
+
+func luaRegisterYamlObject(L *lua.LState) {
+	luaRegisterDataFormat(L, "yaml", formats.DecodeYaml, formats.UpdateYaml)
+}
@@ -0,0 +1,369 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	DefaultVaultPath = "data/secrets.vault"
+	DefaultKeyFile   = "data/secrets.key"
+	// EnvPassphrase unlocks the default vault without user interaction, e.g. on headless machines.
+	EnvPassphrase = "TOTALCONTROL_SECRETS_PASSPHRASE"
+
+	kdfScrypt  = "scrypt"
+	kdfKeyFile = "keyfile"
+	keySize    = 32
+	// scrypt parameters recommended for interactive logins
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	// Bounds for the parameters read from a vault file, so a tampered file cannot make unlocking take hours or
+	// gigabytes of memory
+	minScryptN      = 1 << 14
+	maxScryptN      = 1 << 20
+	maxScryptR      = 16
+	maxScryptP      = 4
+	maxScryptMemory = 1 << 30
+)
+
+var (
+	ErrLocked   = errors.New("secrets vault is locked")
+	ErrWrongKey = errors.New("wrong passphrase or key for secrets vault")
+	ErrNotFound = errors.New("secret not found")
+)
+
+// Declaration describes a secret a plugin needs, so the frontend can prompt for it.
+type Declaration struct {
+	Name        string `json:"name"`
+	Label       string `json:"label"`
+	Description string `json:"description,omitempty"`
+}
+
+// vaultFile is the on-disk format. Only the key derivation parameters are stored in plain text.
+type vaultFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt,omitempty"`
+	N          int    `json:"n,omitempty"`
+	R          int    `json:"r,omitempty"`
+	P          int    `json:"p,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Vault stores secrets per scope (usually a plugin ID), encrypted at rest with AES-256-GCM.
+// The key is derived from a passphrase with scrypt, or read from a key file.
+type Vault struct {
+	path string
+
+	mu   sync.Mutex
+	key  []byte
+	kdf  string
+	salt []byte
+	// params are the scrypt parameters the key was derived with, save writes them back unchanged.
+	params scryptParams
+	data   map[string]map[string]string
+}
+
+type scryptParams struct {
+	N, R, P int
+}
+
+func (p scryptParams) validate() error {
+	if p.N < minScryptN || p.N > maxScryptN || p.N&(p.N-1) != 0 {
+		return fmt.Errorf("corrupt secrets vault: scrypt N %d is not a power of two from %d to %d", p.N, minScryptN, maxScryptN)
+	}
+	if p.R < 1 || p.R > maxScryptR || p.P < 1 || p.P > maxScryptP {
+		return fmt.Errorf("corrupt secrets vault: scrypt r %d and p %d must be at most %d and %d", p.R, p.P, maxScryptR, maxScryptP)
+	}
+	// scrypt needs 128 * N * r bytes
+	if 128*p.N*p.R > maxScryptMemory {
+		return fmt.Errorf("corrupt secrets vault: scrypt N %d and r %d need more than %d bytes", p.N, p.R, maxScryptMemory)
+	}
+	return nil
+}
+
+var (
+	defaultVault     *Vault
+	defaultVaultOnce sync.Once
+)
+
+// Default returns the application vault. It is unlocked with EnvPassphrase if set, otherwise with
+// DefaultKeyFile unless the vault was created with a passphrase, in which case it stays locked.
+func Default() *Vault {
+	defaultVaultOnce.Do(func() {
+		defaultVault = NewVault(DefaultVaultPath)
+		var err error
+		if passphrase := os.Getenv(EnvPassphrase); passphrase != "" {
+			err = defaultVault.UnlockWithPassphrase(passphrase)
+		} else if defaultVault.kdfOnDisk() != kdfScrypt {
+			err = defaultVault.UnlockWithKeyFile(DefaultKeyFile)
+		}
+		if err != nil {
+			log.Errorf("Failed to unlock secrets vault: %v", err)
+		}
+	})
+	return defaultVault
+}
+
+func NewVault(path string) *Vault {
+	return &Vault{path: path}
+}
+
+// RequiresPassphrase reports whether the vault on disk was created with a passphrase.
+func (v *Vault) RequiresPassphrase() bool {
+	return v.kdfOnDisk() == kdfScrypt
+}
+
+func (v *Vault) kdfOnDisk() string {
+	file, err := v.readFile()
+	if err != nil || file == nil {
+		return ""
+	}
+	return file.KDF
+}
+
+func (v *Vault) Locked() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.key == nil
+}
+
+// UnlockWithPassphrase derives the key from passphrase. A new vault is created if none exists.
+func (v *Vault) UnlockWithPassphrase(passphrase string) error {
+	if passphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+	file, err := v.readFile()
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, 16)
+	params := scryptParams{N: scryptN, R: scryptR, P: scryptP}
+	if file != nil {
+		if file.KDF != kdfScrypt {
+			return fmt.Errorf("%w: the vault is protected by a key file", ErrWrongKey)
+		}
+		salt, params = file.Salt, scryptParams{N: file.N, R: file.R, P: file.P}
+		if err := params.validate(); err != nil {
+			return err
+		}
+	} else if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, params.N, params.R, params.P, keySize)
+	if err != nil {
+		return err
+	}
+	return v.unlock(file, key, kdfScrypt, salt, params)
+}
+
+// UnlockWithKeyFile reads the key from path, creating a random key file readable only by the user if
+// it does not exist yet. This works without a passphrase or an OS keyring.
+func (v *Vault) UnlockWithKeyFile(path string) error {
+	file, err := v.readFile()
+	if err != nil {
+		return err
+	}
+	if file != nil && file.KDF != kdfKeyFile {
+		return fmt.Errorf("%w: the vault is protected by a passphrase", ErrWrongKey)
+	}
+
+	key, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && file == nil {
+		key = make([]byte, keySize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, key, 0600); err != nil {
+			return fmt.Errorf("failed to create key file: %w", err)
+		}
+		log.Infof("Created secrets key file %s", path)
+	} else if err != nil {
+		return fmt.Errorf("failed to read key file: %w", err)
+	}
+	if len(key) != keySize {
+		return fmt.Errorf("%w: key file %s must contain %d bytes", ErrWrongKey, path, keySize)
+	}
+	return v.unlock(file, key, kdfKeyFile, nil, scryptParams{})
+}
+
+func (v *Vault) unlock(file *vaultFile, key []byte, kdf string, salt []byte, params scryptParams) error {
+	data := make(map[string]map[string]string)
+	if file != nil {
+		plaintext, err := decrypt(key, file.Nonce, file.Ciphertext)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(plaintext, &data); err != nil {
+			return fmt.Errorf("corrupt secrets vault: %w", err)
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.key, v.kdf, v.salt, v.params, v.data = key, kdf, salt, params, data
+	if file == nil {
+		// Write the empty vault right away so the next start knows how it is protected
+		return v.save()
+	}
+	return nil
+}
+
+// Lock forgets the key and the decrypted secrets.
+func (v *Vault) Lock() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.key, v.data = nil, nil
+}
+
+func (v *Vault) Get(scope string, name string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return "", ErrLocked
+	}
+	value, ok := v.data[scope][name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (v *Vault) Set(scope string, name string, value string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return ErrLocked
+	}
+	if v.data[scope] == nil {
+		v.data[scope] = make(map[string]string)
+	}
+	v.data[scope][name] = value
+	return v.save()
+}
+
+func (v *Vault) Delete(scope string, name string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return ErrLocked
+	}
+	delete(v.data[scope], name)
+	if len(v.data[scope]) == 0 {
+		delete(v.data, scope)
+	}
+	return v.save()
+}
+
+// Names returns the names of the secrets stored for scope, never their values.
+func (v *Vault) Names(scope string) ([]string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return nil, ErrLocked
+	}
+	names := make([]string, 0, len(v.data[scope]))
+	for name := range v.data[scope] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (v *Vault) readFile() (*vaultFile, error) {
+	content, err := os.ReadFile(v.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file vaultFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("corrupt secrets vault: %w", err)
+	}
+	return &file, nil
+}
+
+// save encrypts and writes the vault. Must be called with v.mu held.
+func (v *Vault) save() error {
+	plaintext, err := json.Marshal(v.data)
+	if err != nil {
+		return err
+	}
+	nonce, ciphertext, err := encrypt(v.key, plaintext)
+	if err != nil {
+		return err
+	}
+	file := vaultFile{Version: 1, KDF: v.kdf, Nonce: nonce, Ciphertext: ciphertext}
+	if v.kdf == kdfScrypt {
+		file.Salt, file.N, file.R, file.P = v.salt, v.params.N, v.params.R, v.params.P
+	}
+	content, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(v.path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := v.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, v.path)
+}
+
+func encrypt(key []byte, plaintext []byte) ([]byte, []byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, nil), nil
+}
+
+func decrypt(key []byte, nonce []byte, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("corrupt secrets vault: invalid nonce")
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		// GCM cannot tell a wrong key from tampering, both mean we must not trust the content
+		return nil, ErrWrongKey
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,118 @@
+package secrets
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/scrypt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVault_Passphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.vault")
+	vault := NewVault(path)
+	assert.True(t, vault.Locked())
+	assert.ErrorIs(t, vault.Set("plugin", "token", "x"), ErrLocked)
+
+	assert.NoError(t, vault.UnlockWithPassphrase("correct horse"))
+	assert.NoError(t, vault.Set("plugin-a", "token", "s3cr3t-value"))
+	assert.NoError(t, vault.Set("plugin-b", "token", "other"))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "s3cr3t-value")
+	assert.NotContains(t, string(content), "plugin-a")
+
+	reopened := NewVault(path)
+	assert.True(t, reopened.RequiresPassphrase())
+	assert.ErrorIs(t, reopened.UnlockWithPassphrase("wrong"), ErrWrongKey)
+	assert.ErrorIs(t, reopened.UnlockWithKeyFile(filepath.Join(t.TempDir(), "key")), ErrWrongKey)
+	assert.True(t, reopened.Locked())
+
+	assert.NoError(t, reopened.UnlockWithPassphrase("correct horse"))
+	value, err := reopened.Get("plugin-a", "token")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t-value", value)
+	_, err = reopened.Get("plugin-a", "username")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, reopened.Delete("plugin-b", "token"))
+	names, err := reopened.Names("plugin-b")
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+
+	reopened.Lock()
+	_, err = reopened.Get("plugin-a", "token")
+	assert.ErrorIs(t, err, ErrLocked)
+}
+
+func TestVault_KeepsScryptParameters(t *testing.T) {
+	// A vault written with other parameters than the current defaults, e.g. by an older build
+	path := filepath.Join(t.TempDir(), "secrets.vault")
+	salt := []byte("0123456789abcdef")
+	key, err := scrypt.Key([]byte("passphrase"), salt, 1<<14, 4, 2, keySize)
+	assert.NoError(t, err)
+	nonce, ciphertext, err := encrypt(key, []byte("{}"))
+	assert.NoError(t, err)
+	content, _ := json.Marshal(vaultFile{Version: 1, KDF: kdfScrypt, Salt: salt, N: 1 << 14, R: 4, P: 2,
+		Nonce: nonce, Ciphertext: ciphertext})
+	assert.NoError(t, os.WriteFile(path, content, 0600))
+
+	vault := NewVault(path)
+	assert.NoError(t, vault.UnlockWithPassphrase("passphrase"))
+	assert.NoError(t, vault.Set("plugin", "token", "value"))
+
+	reopened := NewVault(path)
+	assert.NoError(t, reopened.UnlockWithPassphrase("passphrase"))
+	value, err := reopened.Get("plugin", "token")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+	file, err := reopened.readFile()
+	if assert.NoError(t, err) {
+		assert.Equal(t, []int{1 << 14, 4, 2}, []int{file.N, file.R, file.P})
+	}
+}
+
+func TestVault_RejectsUnsafeScryptParameters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.vault")
+	for _, params := range []scryptParams{
+		{N: 1 << 10, R: 8, P: 1},
+		{N: 1 << 24, R: 8, P: 1},
+		{N: 3 << 14, R: 8, P: 1},
+		{N: 1 << 15, R: 0, P: 1},
+		{N: 1 << 15, R: 8, P: 1 << 20},
+		{N: 1 << 20, R: 16, P: 1},
+	} {
+		content, _ := json.Marshal(vaultFile{Version: 1, KDF: kdfScrypt, Salt: []byte("0123456789abcdef"),
+			N: params.N, R: params.R, P: params.P})
+		assert.NoError(t, os.WriteFile(path, content, 0600))
+		err := NewVault(path).UnlockWithPassphrase("passphrase")
+		assert.ErrorContains(t, err, "corrupt secrets vault", "%+v", params)
+	}
+}
+
+func TestVault_KeyFile(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "secrets.key")
+	vault := NewVault(filepath.Join(dir, "secrets.vault"))
+	assert.NoError(t, vault.UnlockWithKeyFile(keyFile))
+	assert.NoError(t, vault.Set("plugin", "api_key", "abc"))
+	assert.False(t, vault.RequiresPassphrase())
+
+	info, err := os.Stat(keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	reopened := NewVault(filepath.Join(dir, "secrets.vault"))
+	assert.NoError(t, reopened.UnlockWithKeyFile(keyFile))
+	value, err := reopened.Get("plugin", "api_key")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", value)
+
+	// A different key must not open the vault, and must not be silently replaced
+	assert.NoError(t, os.WriteFile(keyFile, make([]byte, keySize), 0600))
+	assert.ErrorIs(t, NewVault(filepath.Join(dir, "secrets.vault")).UnlockWithKeyFile(keyFile), ErrWrongKey)
+	assert.NoError(t, os.Remove(keyFile))
+	assert.Error(t, NewVault(filepath.Join(dir, "secrets.vault")).UnlockWithKeyFile(keyFile))
+}
@@ -1,35 +1,46 @@
 package steam
 
 import (
+	"TotalControl/backend/httpclient"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 )
 
+// appDetailsMaxStale lets store data be reused for a day; it rarely changes and Steam rate limits the endpoint.
+const appDetailsMaxStale = 24 * time.Hour
+
 func GetAppDetails(appID int) (*AppDetailsResponse, error) {
-	resp, err := http.Get("https://store.steampowered.com/api/appdetails?appids=" + strconv.Itoa(appID))
+	return FetchAppDetails(context.Background(), httpclient.Default(), appID)
+}
+
+// FetchAppDetails fetches the store page data of an app through client.
+func FetchAppDetails(ctx context.Context, client *httpclient.Client, appID int) (*AppDetailsResponse, error) {
+	resp, err := client.Do(ctx, &httpclient.Request{
+		Method:  http.MethodGet,
+		URL:     "https://store.steampowered.com/api/appdetails?appids=" + strconv.Itoa(appID),
+		Retries: 2,
+		Cache:   &httpclient.CacheOptions{MaxStale: appDetailsMaxStale},
+	})
 	if err != nil {
+		log.Errorf("Failed to make request to Steam API: %v", err)
 		return nil, errors.New("failed to make request to Steam API")
 	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			fmt.Println("Error closing response body:", err)
-		}
-	}(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.New("received non-200 response from Steam API")
 	}
+	log.Debugf("Steam app details for %d: %s", appID, resp.Headers.Get(httpclient.CacheStatusHeader))
 
 	// The respsonse has a structure like {"<appID>": { "success": true, "data": { ... } }}, we want to ignore the appID key and decode the inner object directly.
 	var rawResponse map[string]json.RawMessage
-	err = json.NewDecoder(resp.Body).Decode(&rawResponse)
+	err = json.Unmarshal(resp.Body, &rawResponse)
 	if err != nil {
 		return nil, err
 	}
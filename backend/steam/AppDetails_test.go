@@ -0,0 +1,25 @@
+package steam
+
+import (
+	"TotalControl/backend/httpclient"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Re-record with TOTALCONTROL_HTTP_MODE=record go test ./backend/steam
+func TestFetchAppDetails_Replay(t *testing.T) {
+	client, err := httpclient.NewCassetteClient("testdata/app_details_427520.json", httpclient.CassetteModeFromEnv(httpclient.ModeReplay))
+	assert.NoError(t, err)
+
+	details, err := FetchAppDetails(context.Background(), client, 427520)
+	assert.NoError(t, err)
+	if assert.NotNil(t, details) {
+		assert.True(t, details.Success)
+		assert.Equal(t, "Factorio", details.Data.Name)
+		assert.Equal(t, 427520, details.Data.SteamAppid)
+	}
+
+	_, err = FetchAppDetails(context.Background(), client, 1)
+	assert.Error(t, err, "requests that are not on the cassette must fail")
+}
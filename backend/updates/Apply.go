@@ -0,0 +1,96 @@
+package updates
+
+import (
+	"TotalControl/backend/mods"
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// BatchUpdater is implemented by providers that can install several updates in one transaction, versions maps
+// mod IDs to the version to install. Either all of them are installed or none.
+type BatchUpdater interface {
+	UpdateMods(ctx context.Context, versions map[string]string) error
+}
+
+// BatchError says which update of a batch failed. Rollback is set if returning the updated mods to their
+// previous version failed as well.
+type BatchError struct {
+	ModID    string
+	Err      error
+	Rollback error
+}
+
+func (e *BatchError) Error() string {
+	message := "update failed"
+	if e.ModID != "" {
+		message = fmt.Sprintf("update of %s failed", e.ModID)
+	}
+	if e.Rollback != nil {
+		return fmt.Sprintf("%s: %v (rollback failed: %v)", message, e.Err, e.Rollback)
+	}
+	return fmt.Sprintf("%s: %v", message, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Apply installs the selected updates as one batch. If any of them fails, every mod keeps the version it had
+// before. Providers that are not a BatchUpdater are updated one mod at a time, and the mods updated before the
+// failure are then downgraded again. The same applies to BatchUpdaters that return ErrNotSupported.
+func Apply(ctx context.Context, provider mods.ModProvider, selected []Update) error {
+	if len(selected) == 0 {
+		return nil
+	}
+	if !provider.Capabilities().Update {
+		return mods.NewProviderError(provider.GameID(), "update mods", "", mods.ErrNotSupported)
+	}
+	if process, ok := provider.(mods.GameProcess); ok {
+		running, err := process.IsGameRunning(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to check whether %s is running: %w", provider.GameID(), err)
+		}
+		if running {
+			return mods.ErrGameRunning
+		}
+	}
+
+	if batch, ok := provider.(BatchUpdater); ok {
+		versions := make(map[string]string, len(selected))
+		for _, update := range selected {
+			versions[update.ModID] = update.Available
+		}
+		err := batch.UpdateMods(ctx, versions)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, mods.ErrNotSupported) {
+			return &BatchError{Err: err}
+		}
+	}
+
+	for i, update := range selected {
+		if _, err := provider.UpdateMod(ctx, update.ModID, update.Available); err != nil {
+			return &BatchError{ModID: update.ModID, Err: err, Rollback: rollback(ctx, provider, selected[:i])}
+		}
+	}
+	return nil
+}
+
+// rollback returns the updated mods to their previous version, newest change first. It keeps going after errors
+// to restore as many mods as possible.
+func rollback(ctx context.Context, provider mods.ModProvider, updated []Update) error {
+	// A cancelled batch must still be rolled back
+	ctx = context.WithoutCancel(ctx)
+	var errs []error
+	for i := len(updated) - 1; i >= 0; i-- {
+		update := updated[i]
+		if _, err := provider.UpdateMod(ctx, update.ModID, update.Installed); err != nil {
+			log.Errorf("Failed to restore %s %s: %v", update.ModID, update.Installed, err)
+			errs = append(errs, fmt.Errorf("%s: %w", update.ModID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
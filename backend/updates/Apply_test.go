@@ -0,0 +1,60 @@
+package updates
+
+import (
+	"TotalControl/backend/mods"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type batchProvider struct {
+	testProvider
+	batches []map[string]string
+}
+
+func (p *batchProvider) UpdateMods(ctx context.Context, versions map[string]string) error {
+	p.batches = append(p.batches, versions)
+	if _, ok := versions[p.failing]; ok {
+		return errors.New("download failed")
+	}
+	return nil
+}
+
+func TestApply_RollsBackOnFailure(t *testing.T) {
+	provider := &testProvider{installed: []mods.Mod{
+		{ID: "a", Version: "1.0.0"},
+		{ID: "b", Version: "1.0.0"},
+		{ID: "c", Version: "1.0.0"},
+	}}
+	selected := []Update{
+		{ModID: "a", Installed: "1.0.0", Available: "1.1.0"},
+		{ModID: "b", Installed: "1.0.0", Available: "2.0.0"},
+		{ModID: "c", Installed: "1.0.0", Available: "1.0.1"},
+	}
+
+	provider.failing = "c"
+	err := Apply(context.Background(), provider, selected)
+	var batchErr *BatchError
+	if assert.ErrorAs(t, err, &batchErr) {
+		assert.Equal(t, "c", batchErr.ModID)
+		assert.NoError(t, batchErr.Rollback)
+	}
+	for _, mod := range provider.installed {
+		assert.Equal(t, "1.0.0", mod.Version, mod.ID)
+	}
+
+	provider.failing = ""
+	assert.NoError(t, Apply(context.Background(), provider, selected))
+	assert.Equal(t, "2.0.0", provider.installed[1].Version)
+}
+
+func TestApply_Batch(t *testing.T) {
+	provider := &batchProvider{testProvider: testProvider{failing: "b"}}
+	selected := []Update{
+		{ModID: "a", Installed: "1.0.0", Available: "1.1.0"},
+		{ModID: "b", Installed: "1.0.0", Available: "2.0.0"},
+	}
+	assert.EqualError(t, Apply(context.Background(), provider, selected), "update failed: download failed")
+	assert.Equal(t, []map[string]string{{"a": "1.1.0", "b": "2.0.0"}}, provider.batches)
+}
@@ -0,0 +1,227 @@
+package updates
+
+import (
+	"TotalControl/backend/mods"
+	"TotalControl/backend/version"
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxExcerpt limits the changelog text of a single version, the UI links to the full changelog.
+const maxExcerpt = 1000
+
+// Release is a version of a mod in the provider's catalogue.
+type Release struct {
+	Version string `json:"version"`
+	// GameVersions are constraints for the game versions the release supports. An empty list supports all of them.
+	GameVersions []string  `json:"game_versions,omitempty"`
+	Changelog    string    `json:"changelog,omitempty"`
+	ReleasedAt   time.Time `json:"released_at,omitempty"`
+}
+
+// Releases is implemented by providers that can list every version of a mod. Providers without it are checked
+// with SearchMods, which only knows the latest version for each game version and no changelog.
+type Releases interface {
+	// ModReleases returns ErrModNotFound for mods that are not in the catalogue, e.g. ones the user made.
+	ModReleases(ctx context.Context, id string) ([]Release, error)
+}
+
+type ChangelogEntry struct {
+	Version string `json:"version"`
+	Text    string `json:"text"`
+}
+
+// Update is a newer version of an installed mod.
+type Update struct {
+	ModID     string `json:"mod_id"`
+	Name      string `json:"name"`
+	Installed string `json:"installed"`
+	Available string `json:"available"`
+	// Incompatible is a version newer than Available that does not support the game version, so the UI can say
+	// why it is not offered.
+	Incompatible string `json:"incompatible,omitempty"`
+	// Changelog has the versions after Installed up to Available, newest first.
+	Changelog []ChangelogEntry `json:"changelog,omitempty"`
+}
+
+// Report is the result of an update check. Mods that could not be checked are listed in Failed with the reason,
+// a network error should not hide the updates of every other mod.
+type Report struct {
+	Updates []Update          `json:"updates"`
+	Pinned  map[string]string `json:"pinned,omitempty"`
+	Failed  map[string]string `json:"failed,omitempty"`
+}
+
+type CheckOptions struct {
+	// GameVersion only offers releases that support this game version, every release if it is empty.
+	GameVersion string
+	// Pins is the store of pinned mods, the default one if nil.
+	Pins *Pins
+}
+
+// Check compares the installed mods to the provider's catalogue. Pinned mods are skipped.
+func Check(ctx context.Context, provider mods.ModProvider, options CheckOptions) (*Report, error) {
+	var game *version.Version
+	if options.GameVersion != "" {
+		var err error
+		if game, err = version.Parse(options.GameVersion); err != nil {
+			return nil, fmt.Errorf("invalid game version: %w", err)
+		}
+	}
+	pins := options.Pins
+	if pins == nil {
+		pins = DefaultPins()
+	}
+	pinned, err := pins.Load(provider.GameID())
+	if err != nil {
+		return nil, err
+	}
+	source, ok := provider.(Releases)
+	if !ok && !provider.Capabilities().Search {
+		return nil, mods.NewProviderError(provider.GameID(), "check updates", "", mods.ErrNotSupported)
+	}
+	installed, err := provider.InstalledMods(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Updates: []Update{}, Pinned: pinned}
+	for _, mod := range installed {
+		if _, ok := pinned[mod.ID]; ok {
+			continue
+		}
+		var releases []Release
+		if source != nil {
+			releases, err = source.ModReleases(ctx, mod.ID)
+		}
+		if source == nil || errors.Is(err, mods.ErrNotSupported) {
+			releases, err = searchReleases(ctx, provider, mod.ID)
+			if errors.Is(err, mods.ErrNotSupported) {
+				return nil, err
+			}
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if errors.Is(err, mods.ErrModNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Warnf("Failed to check %s for updates: %v", mod.ID, err)
+			if report.Failed == nil {
+				report.Failed = make(map[string]string)
+			}
+			report.Failed[mod.ID] = err.Error()
+			continue
+		}
+		if update := findUpdate(mod, releases, game); update != nil {
+			report.Updates = append(report.Updates, *update)
+		}
+	}
+	return report, nil
+}
+
+// searchReleases builds the releases from a catalogue entry, which names the newest mod version for every game
+// version it supports.
+func searchReleases(ctx context.Context, provider mods.ModProvider, id string) ([]Release, error) {
+	results, err := provider.SearchMods(ctx, mods.SearchQuery{Text: id})
+	if err != nil {
+		return nil, err
+	}
+	mod, ok := mods.FindMod(results, id)
+	if !ok {
+		return nil, mods.ErrModNotFound
+	}
+	releases := make(map[string]*Release)
+	var order []string
+	add := func(modVersion string, gameVersion string) {
+		release, ok := releases[modVersion]
+		if !ok {
+			release = &Release{Version: modVersion}
+			releases[modVersion] = release
+			order = append(order, modVersion)
+		}
+		if gameVersion != "" {
+			release.GameVersions = append(release.GameVersions, gameVersion)
+		}
+	}
+	for _, gameVersion := range mod.GameVersions {
+		add(gameVersion.ModVersion, gameVersion.Version)
+	}
+	if _, ok := releases[mod.Version]; !ok && mod.Version != "" {
+		add(mod.Version, "")
+	}
+	result := make([]Release, 0, len(order))
+	for _, modVersion := range order {
+		result = append(result, *releases[modVersion])
+	}
+	return result, nil
+}
+
+// findUpdate picks the newest release that supports the game and is newer than the installed version.
+func findUpdate(mod mods.Mod, releases []Release, game *version.Version) *Update {
+	installed, err := version.Parse(mod.Version)
+	if err != nil {
+		log.Warnf("Cannot check %s for updates, its version %q is invalid", mod.ID, mod.Version)
+		return nil
+	}
+	type candidate struct {
+		version *version.Version
+		release Release
+	}
+	var newer []candidate
+	for _, release := range releases {
+		parsed, err := version.Parse(release.Version)
+		if err != nil || !parsed.GreaterThan(installed) {
+			continue
+		}
+		newer = append(newer, candidate{parsed, release})
+	}
+	slices.SortFunc(newer, func(a, b candidate) int { return b.version.Compare(a.version) })
+
+	update := &Update{ModID: mod.ID, Name: mod.Name, Installed: mod.Version}
+	for _, c := range newer {
+		if !supports(c.release, game) {
+			if update.Available == "" && update.Incompatible == "" {
+				update.Incompatible = c.release.Version
+			}
+			continue
+		}
+		if update.Available == "" {
+			update.Available = c.release.Version
+		}
+		if text := strings.TrimSpace(c.release.Changelog); text != "" {
+			update.Changelog = append(update.Changelog, ChangelogEntry{Version: c.release.Version, Text: excerpt(text)})
+		}
+	}
+	if update.Available == "" {
+		return nil
+	}
+	return update
+}
+
+func supports(release Release, game *version.Version) bool {
+	if game == nil || len(release.GameVersions) == 0 {
+		return true
+	}
+	for _, gameVersion := range release.GameVersions {
+		constraint, err := version.ParseConstraint(gameVersion)
+		if err == nil && constraint.Check(game) {
+			return true
+		}
+	}
+	return false
+}
+
+func excerpt(text string) string {
+	runes := []rune(text)
+	if len(runes) <= maxExcerpt {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:maxExcerpt])) + "…"
+}
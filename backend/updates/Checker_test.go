@@ -0,0 +1,133 @@
+package updates
+
+import (
+	"TotalControl/backend/mods"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// testProvider has a catalogue of releases and updates mods in memory.
+type testProvider struct {
+	installed []mods.Mod
+	releases  map[string][]Release
+	// failing makes updates to this mod fail.
+	failing string
+	search  bool
+}
+
+func (p *testProvider) GameID() string { return "game" }
+func (p *testProvider) Capabilities() mods.Capabilities {
+	return mods.Capabilities{Search: p.search, Update: true}
+}
+func (p *testProvider) InstalledMods(ctx context.Context) ([]mods.Mod, error) {
+	return append([]mods.Mod(nil), p.installed...), nil
+}
+func (p *testProvider) SearchMods(ctx context.Context, query mods.SearchQuery) ([]mods.Mod, error) {
+	if !p.search {
+		return nil, mods.ErrNotSupported
+	}
+	return []mods.Mod{{ID: "bobs", Version: "2.1.0", GameVersions: []mods.GameVersion{
+		{Version: "1.1", ModVersion: "1.9.0"},
+		{Version: "2.0", ModVersion: "2.1.0"},
+	}}}, nil
+}
+func (p *testProvider) GetMod(ctx context.Context, id string) (*mods.Mod, error) {
+	return nil, mods.ErrNotSupported
+}
+func (p *testProvider) InstallMod(ctx context.Context, id string, version string) (*mods.Mod, error) {
+	return nil, mods.ErrNotSupported
+}
+func (p *testProvider) UninstallMod(ctx context.Context, id string) error {
+	return mods.ErrNotSupported
+}
+func (p *testProvider) SetModEnabled(ctx context.Context, id string, enabled bool) error {
+	return mods.ErrNotSupported
+}
+func (p *testProvider) UpdateMod(ctx context.Context, id string, version string) (*mods.Mod, error) {
+	if id == p.failing {
+		return nil, errors.New("download failed")
+	}
+	mod, ok := mods.FindMod(p.installed, id)
+	if !ok {
+		return nil, mods.ErrModNotFound
+	}
+	mod.Version = version
+	return mod, nil
+}
+
+type releaseProvider struct {
+	testProvider
+}
+
+func (p *releaseProvider) ModReleases(ctx context.Context, id string) ([]Release, error) {
+	if id == "broken" {
+		return nil, errors.New("portal unavailable")
+	}
+	releases, ok := p.releases[id]
+	if !ok {
+		return nil, mods.ErrModNotFound
+	}
+	return releases, nil
+}
+
+func newReleaseProvider() *releaseProvider {
+	return &releaseProvider{testProvider{
+		installed: []mods.Mod{
+			{ID: "bobs", Name: "Bob's mods", Version: "1.0.0"},
+			{ID: "helmod", Version: "2.2.12"},
+			{ID: "local", Version: "0.1.0"},
+			{ID: "broken", Version: "1.0.0"},
+			{ID: "pinned", Version: "1.0.0"},
+		},
+		releases: map[string][]Release{
+			"bobs": {
+				{Version: "1.0.0", GameVersions: []string{"1.1"}},
+				{Version: "1.2.0", GameVersions: []string{"1.1", "2.0"}, Changelog: "Ported to 2.0"},
+				{Version: "1.1.0", GameVersions: []string{"1.1"}, Changelog: "\n  Fixed a crash\n"},
+				{Version: "3.0.0", GameVersions: []string{"2.1"}, Changelog: "Space"},
+			},
+			"helmod": {{Version: "2.2.12", GameVersions: []string{"2.0"}}},
+			"pinned": {{Version: "2.0.0"}},
+		},
+	}}
+}
+
+func TestCheck(t *testing.T) {
+	provider := newReleaseProvider()
+	pins := NewPins(t.TempDir())
+	assert.NoError(t, pins.Pin("game", "pinned", "1.0.0"))
+
+	report, err := Check(context.Background(), provider, CheckOptions{GameVersion: "1.1.110", Pins: pins})
+	assert.NoError(t, err)
+	assert.Equal(t, []Update{{
+		ModID: "bobs", Name: "Bob's mods", Installed: "1.0.0", Available: "1.2.0", Incompatible: "3.0.0",
+		Changelog: []ChangelogEntry{{Version: "1.2.0", Text: "Ported to 2.0"}, {Version: "1.1.0", Text: "Fixed a crash"}},
+	}}, report.Updates)
+	assert.Equal(t, map[string]string{"pinned": "1.0.0"}, report.Pinned)
+	assert.Equal(t, map[string]string{"broken": "portal unavailable"}, report.Failed)
+
+	// Without a game version every release is offered
+	assert.NoError(t, pins.Unpin("game", "pinned"))
+	report, err = Check(context.Background(), provider, CheckOptions{Pins: pins})
+	assert.NoError(t, err)
+	if assert.Len(t, report.Updates, 2) {
+		assert.Equal(t, "3.0.0", report.Updates[0].Available)
+		assert.Equal(t, "2.0.0", report.Updates[1].Available)
+	}
+}
+
+func TestCheck_Search(t *testing.T) {
+	provider := &testProvider{installed: []mods.Mod{{ID: "bobs", Version: "1.0.0"}}}
+	_, err := Check(context.Background(), provider, CheckOptions{Pins: NewPins(t.TempDir())})
+	assert.ErrorIs(t, err, mods.ErrNotSupported)
+
+	provider.search = true
+	report, err := Check(context.Background(), provider, CheckOptions{GameVersion: "1.1", Pins: NewPins(t.TempDir())})
+	assert.NoError(t, err)
+	if assert.Len(t, report.Updates, 1) {
+		assert.Equal(t, "1.9.0", report.Updates[0].Available)
+		assert.Equal(t, "2.1.0", report.Updates[0].Incompatible)
+	}
+}
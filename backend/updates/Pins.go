@@ -0,0 +1,99 @@
+package updates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultDir holds one file of pinned mods per game.
+const DefaultDir = "data/updates"
+
+// Pins persists the mods of every game that are kept at their version, mapped to the pinned version.
+type Pins struct {
+	dir string
+	mu  sync.Mutex
+}
+
+var defaultPins = NewPins(DefaultDir)
+
+func NewPins(dir string) *Pins {
+	return &Pins{dir: dir}
+}
+
+// DefaultPins returns the pins in DefaultDir.
+func DefaultPins() *Pins {
+	return defaultPins
+}
+
+func (p *Pins) path(gameID string) (string, error) {
+	if gameID == "" || !filepath.IsLocal(gameID) || filepath.Base(gameID) != gameID {
+		return "", fmt.Errorf("invalid game ID %q", gameID)
+	}
+	return filepath.Join(p.dir, gameID+".json"), nil
+}
+
+// Load returns the pinned mods of a game.
+func (p *Pins) Load(gameID string) (map[string]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.load(gameID)
+}
+
+func (p *Pins) load(gameID string) (map[string]string, error) {
+	path, err := p.path(gameID)
+	if err != nil {
+		return nil, err
+	}
+	pinned := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return pinned, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &pinned); err != nil {
+		return nil, fmt.Errorf("invalid pins file %s: %w", path, err)
+	}
+	return pinned, nil
+}
+
+// Pin keeps a mod at modVersion, Check no longer offers updates for it.
+func (p *Pins) Pin(gameID string, modID string, modVersion string) error {
+	return p.change(gameID, func(pinned map[string]string) {
+		pinned[modID] = modVersion
+	})
+}
+
+func (p *Pins) Unpin(gameID string, modID string) error {
+	return p.change(gameID, func(pinned map[string]string) {
+		delete(pinned, modID)
+	})
+}
+
+func (p *Pins) change(gameID string, change func(pinned map[string]string)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pinned, err := p.load(gameID)
+	if err != nil {
+		return err
+	}
+	change(pinned)
+	path, _ := p.path(gameID)
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pinned, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
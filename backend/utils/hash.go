@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	HashMD5    = "md5"
+	HashSHA1   = "sha1"
+	HashSHA256 = "sha256"
+	HashCRC32  = "crc32"
+)
+
+// ErrChecksumMismatch is returned by VerifyFile when the file does not match the expected hash.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// SupportedHashAlgorithms lists the algorithms understood by NewHasher, strongest first.
+var SupportedHashAlgorithms = []string{HashSHA256, HashSHA1, HashMD5, HashCRC32}
+
+func NewHasher(algorithm string) (hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case HashMD5:
+		return md5.New(), nil
+	case HashSHA1:
+		return sha1.New(), nil
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashCRC32:
+		return crc32.NewIEEE(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", algorithm)
+	}
+}
+
+// HashReader streams r through the given algorithm and returns the lowercase hex digest.
+func HashReader(r io.Reader, algorithm string) (string, error) {
+	hasher, err := NewHasher(algorithm)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func HashFile(filePath string, algorithm string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	return HashReader(file, algorithm)
+}
+
+func HashString(content string, algorithm string) (string, error) {
+	return HashReader(strings.NewReader(content), algorithm)
+}
+
+// VerifyFile hashes filePath and compares it with expected (hex, case-insensitive).
+// A mismatch is reported as an error wrapping ErrChecksumMismatch.
+func VerifyFile(filePath string, algorithm string, expected string) error {
+	actual, err := HashFile(filePath, algorithm)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(actual, strings.TrimSpace(expected)) {
+		return fmt.Errorf("%w for %s: expected %s %s, got %s", ErrChecksumMismatch, filePath, algorithm, expected, actual)
+	}
+	return nil
+}
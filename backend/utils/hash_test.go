@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashString_KnownVectors(t *testing.T) {
+	vectors := map[string]string{
+		HashMD5:    "900150983cd24fb0d6963f7d28e17f72",
+		HashSHA1:   "a9993e364706816aba3e25717850c26c9cd0d89d",
+		HashSHA256: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		HashCRC32:  "352441c2",
+	}
+	for algorithm, expected := range vectors {
+		digest, err := HashString("abc", algorithm)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, digest, algorithm)
+	}
+
+	_, err := HashString("abc", "sha512")
+	assert.Error(t, err)
+}
+
+func TestVerifyFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "mod.zip")
+	assert.NoError(t, os.WriteFile(filePath, []byte("abc"), 0644))
+
+	assert.NoError(t, VerifyFile(filePath, HashSHA1, "A9993E364706816ABA3E25717850C26C9CD0D89D"))
+
+	err := VerifyFile(filePath, HashSHA1, "0000000000000000000000000000000000000000")
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+}
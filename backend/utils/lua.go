@@ -48,6 +48,40 @@ func FromLuaValue(L *lua.LState, value lua.LValue) interface{} {
 	}
 }
 
+// LuaValueToInterface converts a Lua value into plain Go values suitable for encoding/json.
+// Tables with consecutive integer keys starting at 1 become slices, other tables become maps.
+// Functions, userdata and other unsupported values become nil instead of raising an error.
+func LuaValueToInterface(value lua.LValue) interface{} {
+	switch v := value.(type) {
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LBool:
+		return bool(v)
+	case *lua.LTable:
+		length := v.Len()
+		count := 0
+		v.ForEach(func(_ lua.LValue, _ lua.LValue) {
+			count++
+		})
+		if length > 0 && length == count {
+			list := make([]interface{}, 0, length)
+			for i := 1; i <= length; i++ {
+				list = append(list, LuaValueToInterface(v.RawGetInt(i)))
+			}
+			return list
+		}
+		result := make(map[string]interface{}, count)
+		v.ForEach(func(key lua.LValue, item lua.LValue) {
+			result[key.String()] = LuaValueToInterface(item)
+		})
+		return result
+	default:
+		return nil
+	}
+}
+
 func ToLuaValue(L *lua.LState, value interface{}) lua.LValue {
 	switch v := value.(type) {
 	case nil:
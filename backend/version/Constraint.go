@@ -0,0 +1,245 @@
+package version
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidConstraint = errors.New("invalid version constraint")
+
+type comparator struct {
+	operator string
+	version  *Version
+}
+
+func (c comparator) check(version *Version) bool {
+	result := version.Compare(c.version)
+	switch c.operator {
+	case "=":
+		return result == 0
+	case "!=":
+		return result != 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	}
+	return false
+}
+
+// Constraint is a set of version ranges, e.g. ">= 1.1.0, < 2.0 || ^3.1".
+//
+// Supported are the operators =, !=, >, >=, <, <=, ~ (same minor), ^ (same major, or the first non-zero part
+// for 0.x versions), hyphen ranges ("1.0 - 1.2") and wildcards ("1.x", "1.1.*", "*"). Comparators separated by
+// commas or spaces must all match, ranges separated by || are alternatives. A version with fewer than three
+// parts is a wildcard, so "2.0" matches every 2.0.x, which is how games like Factorio declare compatibility.
+type Constraint struct {
+	ranges   [][]comparator
+	original string
+}
+
+func ParseConstraint(value string) (*Constraint, error) {
+	constraint := &Constraint{original: value}
+	if strings.TrimSpace(value) == "" {
+		// No constraint at all, like "*"
+		constraint.ranges = [][]comparator{nil}
+		return constraint, nil
+	}
+	for _, part := range strings.Split(value, "||") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("%w %q: empty range", ErrInvalidConstraint, value)
+		}
+		comparators, err := parseRange(part)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidConstraint, value, err)
+		}
+		constraint.ranges = append(constraint.ranges, comparators)
+	}
+	return constraint, nil
+}
+
+// MustParseConstraint is like ParseConstraint but panics on invalid input. Only use it for constants.
+func MustParseConstraint(value string) *Constraint {
+	constraint, err := ParseConstraint(value)
+	if err != nil {
+		panic(err)
+	}
+	return constraint
+}
+
+// Check reports whether version satisfies the constraint.
+func (c *Constraint) Check(version *Version) bool {
+	for _, comparators := range c.ranges {
+		matches := true
+		for _, comparator := range comparators {
+			if !comparator.check(version) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Constraint) String() string {
+	return c.original
+}
+
+// Satisfies parses version and constraint and reports whether the version satisfies it.
+func Satisfies(version string, constraint string) (bool, error) {
+	parsedVersion, err := Parse(version)
+	if err != nil {
+		return false, err
+	}
+	parsedConstraint, err := ParseConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+	return parsedConstraint.Check(parsedVersion), nil
+}
+
+var operators = []string{">=", "<=", "!=", "==", ">", "<", "=", "~", "^"}
+
+func parseRange(value string) ([]comparator, error) {
+	if from, to, ok := strings.Cut(value, " - "); ok {
+		lower, err := parsePartial(strings.TrimSpace(from))
+		if err != nil {
+			return nil, err
+		}
+		upper, err := parsePartial(strings.TrimSpace(to))
+		if err != nil {
+			return nil, err
+		}
+		return append(lower.comparators(">="), upper.comparators("<=")...), nil
+	}
+
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	var result []comparator
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		operator := ""
+		for _, candidate := range operators {
+			if strings.HasPrefix(field, candidate) {
+				operator = candidate
+				field = field[len(candidate):]
+				break
+			}
+		}
+		// Allow a space between operator and version, e.g. ">= 1.1.0"
+		if field == "" && operator != "" && i+1 < len(fields) {
+			i++
+			field = fields[i]
+		}
+		if operator == "==" {
+			operator = "="
+		}
+
+		partial, err := parsePartial(field)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, partial.comparators(operator)...)
+	}
+	return result, nil
+}
+
+// partial is a version that may end in wildcards. known is the number of concrete segments.
+type partial struct {
+	version *Version
+	known   int
+}
+
+func parsePartial(value string) (*partial, error) {
+	if value == "" {
+		return nil, errors.New("missing version")
+	}
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "v"), "V")
+	numbers := value
+	suffix := ""
+	if index := strings.IndexAny(value, "-+"); index != -1 {
+		numbers, suffix = value[:index], value[index:]
+	}
+
+	var concrete []string
+	for _, segment := range strings.Split(numbers, ".") {
+		if segment == "x" || segment == "X" || segment == "*" {
+			break
+		}
+		if _, err := strconv.Atoi(segment); err != nil {
+			return nil, fmt.Errorf("invalid version %q", value)
+		}
+		concrete = append(concrete, segment)
+	}
+	if len(concrete) == 0 {
+		return &partial{}, nil
+	}
+	if len(concrete) < len(strings.Split(numbers, ".")) && suffix != "" {
+		return nil, fmt.Errorf("wildcard version %q cannot have a pre-release", value)
+	}
+
+	version, err := Parse(strings.Join(concrete, ".") + suffix)
+	if err != nil {
+		return nil, err
+	}
+	return &partial{version: version, known: len(concrete)}, nil
+}
+
+// isWildcard reports whether the partial stands for a range of versions rather than a single one.
+func (p *partial) isWildcard() bool {
+	return p.known < 3 && p.version.Prerelease == ""
+}
+
+// bump returns the smallest version above every version that shares the first index+1 segments.
+func (p *partial) bump(index int) *Version {
+	segments := append([]int{}, p.version.Segments[:index+1]...)
+	segments[index]++
+	// The pre-release makes the upper bound exclude pre-releases of the next version, e.g. 2.0.0-alpha for ^1.0
+	return &Version{Segments: segments, Prerelease: "0"}
+}
+
+func (p *partial) comparators(operator string) []comparator {
+	if p.version == nil {
+		// "*" matches everything, whatever the operator
+		return nil
+	}
+	last := p.known - 1
+	switch operator {
+	case "", "=":
+		if p.isWildcard() {
+			return []comparator{{">=", p.version}, {"<", p.bump(last)}}
+		}
+		return []comparator{{"=", p.version}}
+	case ">":
+		if p.isWildcard() {
+			return []comparator{{">=", p.bump(last)}}
+		}
+	case "<=":
+		if p.isWildcard() {
+			return []comparator{{"<", p.bump(last)}}
+		}
+	case "~":
+		return []comparator{{">=", p.version}, {"<", p.bump(min(last, 1))}}
+	case "^":
+		index := last
+		for i := 0; i < p.known; i++ {
+			if p.version.Segments[i] != 0 {
+				index = i
+				break
+			}
+		}
+		return []comparator{{">=", p.version}, {"<", p.bump(index)}}
+	}
+	return []comparator{{operator, p.version}}
+}
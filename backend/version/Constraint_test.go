@@ -0,0 +1,57 @@
+package version
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConstraint_Check(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matches    []string
+		rejects    []string
+	}{
+		{">= 1.1.0, < 2.0", []string{"1.1.0", "1.1.110", "1.9"}, []string{"1.0.9", "2.0", "2.0.28"}},
+		{">=1.1.0 <2.0", []string{"1.5.0"}, []string{"2.0.0"}},
+		{"2.0", []string{"2.0", "2.0.0", "2.0.28"}, []string{"2.1.0", "1.1.110"}},
+		{"1.1.110", []string{"1.1.110", "1.1.110.0"}, []string{"1.1.111"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.99"}, []string{"1.3.0", "1.2.2"}},
+		{"~1", []string{"1.0.0", "1.9.9"}, []string{"2.0.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"2.0.0", "2.0.0-alpha", "1.2.2"}},
+		{"^0.2.3", []string{"0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"1.0 - 1.2", []string{"1.0.0", "1.2.9"}, []string{"1.3.0", "0.9"}},
+		{"1.x", []string{"1.0.0", "1.99"}, []string{"2.0.0"}},
+		{"1.1.*", []string{"1.1.0", "1.1.110"}, []string{"1.2.0"}},
+		{"*", []string{"0.0.1", "99.0"}, nil},
+		{"< 1.0 || >= 2.0", []string{"0.18.47", "2.0"}, []string{"1.1.110"}},
+		{"!= 1.1.0", []string{"1.1.1"}, []string{"1.1.0"}},
+		{"> 1.1", []string{"1.2.0"}, []string{"1.1.110"}},
+		{"<= 1.1", []string{"1.1.110"}, []string{"1.2.0"}},
+	}
+	for _, test := range tests {
+		constraint, err := ParseConstraint(test.constraint)
+		if !assert.NoError(t, err, test.constraint) {
+			continue
+		}
+		for _, value := range test.matches {
+			assert.True(t, constraint.Check(MustParse(value)), "%s should satisfy %s", value, test.constraint)
+		}
+		for _, value := range test.rejects {
+			assert.False(t, constraint.Check(MustParse(value)), "%s should not satisfy %s", value, test.constraint)
+		}
+	}
+}
+
+func TestParseConstraint_Invalid(t *testing.T) {
+	for _, invalid := range []string{">= ", "1.a", "~", "1.x-beta", ">= 1.0 ||"} {
+		_, err := ParseConstraint(invalid)
+		assert.ErrorIs(t, err, ErrInvalidConstraint, invalid)
+	}
+
+	ok, err := Satisfies("2.0.28", "2.0")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, err = Satisfies("bad", "2.0")
+	assert.ErrorIs(t, err, ErrInvalidVersion)
+}
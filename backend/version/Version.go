@@ -0,0 +1,222 @@
+package version
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidVersion = errors.New("invalid version")
+
+// Version is a dotted numeric version with an optional semver pre-release and build metadata.
+// Besides semver ("1.2.3-beta.1+build") it accepts game versions with fewer or more parts ("2.0", "1.1.110", "1.2.3.4").
+// Missing parts compare as zero, so "2.0" equals "2.0.0".
+type Version struct {
+	Segments   []int
+	Prerelease string
+	Build      string
+	original   string
+}
+
+func Parse(value string) (*Version, error) {
+	original := value
+	value = strings.TrimSpace(value)
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "v"), "V")
+	if value == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidVersion, original)
+	}
+
+	version := &Version{original: original}
+	if plus := strings.IndexByte(value, '+'); plus != -1 {
+		version.Build = value[plus+1:]
+		value = value[:plus]
+		if version.Build == "" {
+			return nil, fmt.Errorf("%w: %q has empty build metadata", ErrInvalidVersion, original)
+		}
+	}
+	if dash := strings.IndexByte(value, '-'); dash != -1 {
+		version.Prerelease = value[dash+1:]
+		value = value[:dash]
+		if version.Prerelease == "" {
+			return nil, fmt.Errorf("%w: %q has an empty pre-release", ErrInvalidVersion, original)
+		}
+	}
+
+	for _, part := range strings.Split(value, ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 || part == "" || part[0] == '+' {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidVersion, original)
+		}
+		version.Segments = append(version.Segments, number)
+	}
+	return version, nil
+}
+
+// MustParse is like Parse but panics on invalid input. Only use it for constants.
+func MustParse(value string) *Version {
+	version, err := Parse(value)
+	if err != nil {
+		panic(err)
+	}
+	return version
+}
+
+func (v *Version) segment(i int) int {
+	if i < len(v.Segments) {
+		return v.Segments[i]
+	}
+	return 0
+}
+
+func (v *Version) Major() int {
+	return v.segment(0)
+}
+
+func (v *Version) Minor() int {
+	return v.segment(1)
+}
+
+func (v *Version) Patch() int {
+	return v.segment(2)
+}
+
+// Original returns the string the version was parsed from.
+func (v *Version) Original() string {
+	return v.original
+}
+
+func (v *Version) String() string {
+	parts := make([]string, len(v.Segments))
+	for i, segment := range v.Segments {
+		parts[i] = strconv.Itoa(segment)
+	}
+	result := strings.Join(parts, ".")
+	if v.Prerelease != "" {
+		result += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		result += "+" + v.Build
+	}
+	return result
+}
+
+// Compare returns -1, 0 or 1. Build metadata is ignored, as in semver.
+func (v *Version) Compare(other *Version) int {
+	for i := 0; i < max(len(v.Segments), len(other.Segments)); i++ {
+		if a, b := v.segment(i), other.segment(i); a != b {
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+	return comparePrerelease(v.Prerelease, other.Prerelease)
+}
+
+func (v *Version) LessThan(other *Version) bool {
+	return v.Compare(other) < 0
+}
+
+func (v *Version) GreaterThan(other *Version) bool {
+	return v.Compare(other) > 0
+}
+
+func (v *Version) Equal(other *Version) bool {
+	return v.Compare(other) == 0
+}
+
+// comparePrerelease orders pre-releases as semver does: a release is newer than any of its pre-releases,
+// numeric identifiers compare numerically and are older than alphanumeric ones.
+func comparePrerelease(a string, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	left, right := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < min(len(left), len(right)); i++ {
+		if result := compareIdentifier(left[i], right[i]); result != 0 {
+			return result
+		}
+	}
+	switch {
+	case len(left) < len(right):
+		return -1
+	case len(left) > len(right):
+		return 1
+	}
+	return 0
+}
+
+func compareIdentifier(a string, b string) int {
+	numberA, errA := strconv.Atoi(a)
+	numberB, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		if numberA == numberB {
+			return 0
+		}
+		if numberA < numberB {
+			return -1
+		}
+		return 1
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// Compare parses and compares two version strings.
+func Compare(a string, b string) (int, error) {
+	left, err := Parse(a)
+	if err != nil {
+		return 0, err
+	}
+	right, err := Parse(b)
+	if err != nil {
+		return 0, err
+	}
+	return left.Compare(right), nil
+}
+
+// IsNewer reports whether candidate is a newer version than current. Invalid versions are never newer.
+func IsNewer(candidate string, current string) bool {
+	result, err := Compare(candidate, current)
+	return err == nil && result > 0
+}
+
+// Sort sorts versions in ascending order.
+func Sort(versions []*Version) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LessThan(versions[j])
+	})
+}
+
+// Latest returns the highest of the given versions that satisfies constraint, which may be nil.
+// Invalid versions are skipped. It returns "" if none match.
+func Latest(versions []string, constraint *Constraint) string {
+	var latest *Version
+	for _, value := range versions {
+		version, err := Parse(value)
+		if err != nil {
+			continue
+		}
+		if constraint != nil && !constraint.Check(version) {
+			continue
+		}
+		if latest == nil || version.GreaterThan(latest) {
+			latest = version
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.Original()
+}
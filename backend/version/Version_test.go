@@ -0,0 +1,48 @@
+package version
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	version, err := Parse("v1.1.110-rc.1+linux")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 1, 110}, version.Segments)
+	assert.Equal(t, "rc.1", version.Prerelease)
+	assert.Equal(t, "linux", version.Build)
+	assert.Equal(t, "1.1.110-rc.1+linux", version.String())
+	assert.Equal(t, 110, version.Patch())
+
+	version, err = Parse("2.0")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, version.Major())
+	assert.Equal(t, 0, version.Patch())
+
+	for _, invalid := range []string{"", "1..2", "1.a", "1.2-", "1.+2", "-1.0"} {
+		_, err := Parse(invalid)
+		assert.ErrorIs(t, err, ErrInvalidVersion, invalid)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ordered := []string{"0.9", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0", "1.1.9", "1.1.10", "1.1.110", "2.0"}
+	for i := 0; i+1 < len(ordered); i++ {
+		result, err := Compare(ordered[i], ordered[i+1])
+		assert.NoError(t, err)
+		assert.Equal(t, -1, result, "%s < %s", ordered[i], ordered[i+1])
+	}
+
+	result, err := Compare("2.0", "2.0.0+build")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+	assert.True(t, IsNewer("1.1.110", "1.1.19"))
+	assert.False(t, IsNewer("not-a-version", "1.0"))
+}
+
+func TestLatest(t *testing.T) {
+	versions := []string{"1.1.110", "2.0.7", "invalid", "2.0.28", "1.1.19"}
+	assert.Equal(t, "2.0.28", Latest(versions, nil))
+	assert.Equal(t, "1.1.110", Latest(versions, MustParseConstraint("< 2.0")))
+	assert.Equal(t, "", Latest(versions, MustParseConstraint(">= 3")))
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"TotalControl/backend/mods"
 	"TotalControl/backend/scripting"
 	"TotalControl/backend/utils"
+	"context"
 	"flag"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
@@ -141,15 +143,19 @@ func main() {
 	log.Infof("Loaded Lua plugin: ID=%s, Name=%s, Version=%s, EntryPoint=%s, PluginDir=%s",
 		plugin.Id, plugin.Name, plugin.Version, plugin.EntryPoint, plugin.PluginDir)
 
-	modsAvailable, err := plugin.GetMods()
+	provider, err := plugin.Provider()
+	if err != nil {
+		log.Fatalf("Failed to use Lua plugin as mod provider: %v", err)
+	}
+	modsAvailable, err := provider.SearchMods(context.Background(), mods.SearchQuery{})
 	if err != nil {
 		log.Fatalf("Failed to get mods: %v", err)
 	}
 	if len(modsAvailable) == 0 {
 		log.Fatal("No mods found in Lua plugin")
 	}
-	for k, mod := range modsAvailable {
-		log.Debugf("Mod %s: %+v", k, mod)
+	for _, mod := range modsAvailable {
+		log.Debugf("Mod %s: %+v", mod.ID, mod)
 	}
 
 	plugin.Shutdown()
@@ -23,46 +23,273 @@ function readModListFile(plugin)
     return mod_ids
 end
 
-function loadModsFromApi()
+local portal_url = "https://mods.factorio.com/api/mods"
 
-    -- Do Http requests to https://mods.factorio.com/api/mods
-    local response = http.get("https://mods.factorio.com/api/mods")
+-- Requests a page of the portal's mod list, returns the response or nil and an error message.
+function portalRequest(url, cache)
+    local response, err = http.request({
+        url = url,
+        retries = 2,
+        cache = cache,
+    })
     if response == nil then
-        log.error("Failed to fetch mods from Factorio API: " .. tostring(response))
-        return {}
+        return nil, tostring(err)
     end
     if response.status_code ~= 200 then
-        log.error("Failed to fetch mods from Factorio API: " .. response.status_code)
+        return nil, "the mod portal answered " .. response.status_code
+    end
+    return response
+end
+
+-- Fetches the whole mod list, returns it or nil and an error message. Without page_size the portal only sends
+-- the first 25 mods, page_size=max asks for all of them and the pages are followed should it still split them.
+-- The host caches the responses on disk and revalidates them, an hour old list is good enough.
+function fetchAllMods()
+    local results = {}
+    local page = 1
+    while true do
+        local response, err = portalRequest(portal_url .. "?page_size=max&page=" .. page, { max_stale = 3600 })
+        if response == nil then
+            return nil, err
+        end
+        for _, mod in ipairs(response.body.results or {}) do
+            results[#results + 1] = mod
+        end
+        local pagination = response.body.pagination or {}
+        if pagination.page_count == nil or page >= pagination.page_count then
+            return results
+        end
+        page = page + 1
+    end
+end
+
+function loadMods()
+    local results, err = fetchAllMods()
+    if results == nil then
+        log.error("Failed to fetch mods from Factorio API: " .. err)
         return {}
     end
+    print("Got " .. #results .. " mods from the Factorio API.")
+    return results
+end
+
+-- The full list has the releases of a mod, the paged list only the latest one.
+function latestRelease(mod)
+    if mod.latest_release then
+        return mod.latest_release
+    end
+    if type(mod.releases) == "table" and #mod.releases > 0 then
+        return mod.releases[#mod.releases]
+    end
+    return {}
+end
+
+-- Converts an entry of the mod portal's result list to a mod table, nil for entries without a name.
+function portalMod(self, mod)
+    if type(mod) ~= "table" or mod.name == nil then
+        return nil
+    end
+    local release = latestRelease(mod)
+    local info = release.info_json or {}
+    local game_versions = {}
+    if info.factorio_version and release.version then
+        game_versions[info.factorio_version] = release.version
+    end
+    return {
+        id = mod.name,
+        name = mod.title or mod.name,
+        description = mod.summary or "",
+        version = release.version or "unknown",
+        game_id = self:GetGameID(),
+        author = mod.owner or "Unknown",
+        game_versions = game_versions,
+        -- Only the full mod details carry dependencies, the mod list leaves them out
+        dependencies = info.dependencies,
+    }
+end
+
+-- Converts a portal entry to a catalogue entry, which adds what the catalogue is filtered and sorted by.
+function portalEntry(self, mod)
+    local entry = portalMod(self, mod)
+    if entry == nil then
+        return nil
+    end
+    entry.category = mod.category
+    entry.downloads = mod.downloads_count
+    entry.updated_at = mod.updated_at or latestRelease(mod).released_at
+    return entry
+end
+
+-- Splits a changelog.txt into the text of each version, see https://wiki.factorio.com/Tutorial:Mod_changelog_format
+function changelogSections(changelog)
+    local sections = {}
+    local current
+    for line in (changelog or ""):gmatch("[^\r\n]+") do
+        local version = line:match("^Version:%s*(%S+)")
+        if version then
+            current = {}
+            sections[version] = current
+        elseif current and not line:match("^%-%-%-") and not line:match("^Date:") then
+            current[#current + 1] = line
+        end
+    end
+    local texts = {}
+    for version, lines in pairs(sections) do
+        texts[version] = table.concat(lines, "\n")
+    end
+    return texts
+end
 
-    local pagination = response.body.pagination
-    local results = response.body.results
-    print(serpent.block(response.body))
-    cache.set("factorio_mods", {
-        pagination = pagination,
-        results = results,
+function urlEscape(text)
+    return (text:gsub("[^%w%-_%.~]", function(c)
+        return string.format("%%%02X", string.byte(c))
+    end))
+end
+
+-- Looks up a release of a mod on the portal, the latest one if version is nil. Returns the mod's name as the
+-- portal spells it and the release, or nil and an error message.
+function portalRelease(id, version)
+    local response, err = http.request({
+        url = "https://mods.factorio.com/api/mods/" .. urlEscape(id) .. "/full",
+        retries = 2,
+        cache = { max_stale = 3600 },
     })
-    return response.body
+    if response == nil then
+        return nil, tostring(err)
+    end
+    if response.status_code == 404 then
+        return nil, "not_found"
+    end
+    if response.status_code ~= 200 then
+        return nil, "the mod portal answered " .. response.status_code
+    end
+    local releases = response.body.releases or {}
+    if version == nil then
+        local release = releases[#releases]
+        if release == nil then
+            return nil, "not_found"
+        end
+        return response.body.name, release
+    end
+    for _, release in ipairs(releases) do
+        if release.version == version then
+            return response.body.name, release
+        end
+    end
+    return nil, "not_found"
 end
 
-function loadMods()
-    if cache.has("factorio_mods") then
-        -- If the cache exists, return it
-        local cached_mods = cache.get("factorio_mods")
-        if cached_mods and cached_mods.results then
-            print("Got " .. table_size(cached_mods.results) ..
-                    " mods from cache.")
-            return cached_mods
+-- The mod portal only hands out downloads with the username and token of a factorio.com account.
+function downloadUrl(release)
+    local username, err = secrets.get("username")
+    if username == nil then
+        return nil, err or "set your Factorio username and token to download mods"
+    end
+    local token, token_err = secrets.get("token")
+    if token == nil then
+        return nil, token_err or "set your Factorio username and token to download mods"
+    end
+    return "https://mods.factorio.com" .. release.download_url .. "?username=" .. urlEscape(username) ..
+            "&token=" .. urlEscape(token)
+end
+
+-- Returns the zips of every installed version of a mod, relative to the mods directory, and the mod's name as
+-- its files spell it.
+function installedFiles(self, id)
+    local ok, files = pcall(io.getFilesInDirectory, self:GetGameModDirectory(), { "*.zip" })
+    if not ok or files == nil then
+        return {}, nil
+    end
+    local prefix = id:lower() .. "_"
+    local found, name = {}, nil
+    for _, path in ipairs(files) do
+        local file = io.getFileName(path)
+        if path == self:GetGameModDirectory() .. file and file:sub(1, #prefix):lower() == prefix
+                and file:sub(#prefix + 1):match("^%d+%.%d+%.%d+%.zip$") then
+            found[#found + 1] = file
+            name = file:sub(1, #prefix - 1)
+        end
+    end
+    return found, name
+end
+
+-- Reads mod-list.json, an empty list if there is none.
+function readModList(self)
+    local file = io.open(self:GetGameModDirectory() .. "mod-list.json", "r")
+    if file == nil then
+        return { mods = {} }
+    end
+    local content = file:read("*a")
+    file:close()
+    local list = json.decode(content)
+    if type(list) ~= "table" or type(list.mods) ~= "table" then
+        return { mods = {} }
+    end
+    return list
+end
+
+function jsonString(text)
+    return '"' .. text:gsub('[%c"\\]', function(c)
+        return string.format("\\u%04x", string.byte(c))
+    end) .. '"'
+end
+
+-- Writes mod-list.json in the layout Factorio uses. json.encode only handles flat tables.
+function writeModList(self, list)
+    local lines = {}
+    for _, entry in ipairs(list.mods) do
+        local fields = { '"name": ' .. jsonString(entry.name), '"enabled": ' .. tostring(entry.enabled == true) }
+        if entry.version ~= nil then
+            fields[#fields + 1] = '"version": ' .. jsonString(entry.version)
+        end
+        lines[#lines + 1] = "    {\n      " .. table.concat(fields, ",\n      ") .. "\n    }"
+    end
+    local file, err = io.open(self:GetGameModDirectory() .. "mod-list.json", "w")
+    if file == nil then
+        return nil, tostring(err)
+    end
+    file:write('{\n  "mods": [\n' .. table.concat(lines, ",\n") .. '\n  ]\n}\n')
+    file:close()
+    return true
+end
+
+-- Describes installing a release in place of every installed version of the mod. The mod stays disabled if
+-- mod-list.json says so.
+function planRelease(self, id, version)
+    local name, release = portalRelease(id, version)
+    if name == nil then
+        return nil, release
+    end
+    local url, err = downloadUrl(release)
+    if url == nil then
+        return nil, err
+    end
+    local enabled = true
+    for _, entry in ipairs(readModList(self).mods) do
+        if entry.name == name then
+            enabled = entry.enabled ~= false
         end
     end
-    print("Cache not found, loading mods from API...")
-    return loadModsFromApi()
+    local remove = installedFiles(self, name)
+    return {
+        root = self:GetGameModDirectory(),
+        artifacts = {
+            {
+                mod_id = name,
+                url = url,
+                checksum = { algorithm = "sha1", value = release.sha1 },
+                target = name .. "_" .. release.version .. ".zip",
+            },
+        },
+        remove = remove,
+        enabled_list = self:GetGameModDirectory() .. "mod-list.json",
+        enable = { [name] = enabled },
+    }
 end
 
 return {
-    -- The first load of the plugins will be slower.
-    mods = loadMods(),
+    capabilities = { search = true, install = true, uninstall = true, update = true },
+    game_executables = { "factorio" },
     GetInstalledMods = function(self)
         print("GetInstalledMods called")
         if self.mods ~= nil then
@@ -103,7 +330,12 @@ return {
         return self.mods
     end,
     GetInstalledModByID = function(self, id)
-        -- Example: return a mod by id
+        for _, mod in ipairs(self:GetInstalledMods()) do
+            if mod.id:lower() == id:lower() then
+                return mod
+            end
+        end
+        return nil
     end,
     GetGameModDirectory = function()
         -- This is usually located at:
@@ -122,12 +354,55 @@ return {
         end
         return nil -- Unsupported OS
     end,
-    AddMod = function(self, mod)
-        -- Use write_file/read_file for I/O
+    PlanInstall = function(self, id, version)
+        if #installedFiles(self, id) > 0 then
+            return nil, "already_installed"
+        end
+        return planRelease(self, id, version)
+    end,
+    PlanUpdate = function(self, id, version)
+        if #installedFiles(self, id) == 0 then
+            return nil, "not_found"
+        end
+        return planRelease(self, id, version)
     end,
-    RemoveMod = function(self, id)
+    PlanUninstall = function(self, id)
+        local files, name = installedFiles(self, id)
+        if name == nil then
+            return nil, "not_found"
+        end
+        return {
+            root = self:GetGameModDirectory(),
+            remove = files,
+            enabled_list = self:GetGameModDirectory() .. "mod-list.json",
+            forget = { name },
+        }
     end,
-    UpdateMod = function(self, mod)
+    -- Called by the install pipeline after the files changed. The replaced versions are gone, so pins are
+    -- dropped along with them.
+    UpdateEnabledList = function(self, enable, forget)
+        self.mods = nil
+        local list = readModList(self)
+        local dropped = {}
+        for _, name in ipairs(forget) do
+            dropped[name] = true
+        end
+        local mods = {}
+        for _, entry in ipairs(list.mods) do
+            if not dropped[entry.name] then
+                if enable[entry.name] ~= nil then
+                    entry.enabled = enable[entry.name]
+                    entry.version = nil
+                    enable[entry.name] = nil
+                end
+                mods[#mods + 1] = entry
+            end
+        end
+        for name, enabled in pairs(enable) do
+            mods[#mods + 1] = { name = name, enabled = enabled }
+        end
+        list.mods = mods
+        return writeModList(self, list)
     end,
     ListGameMods = function(self)
         return {}
@@ -136,26 +411,109 @@ return {
         return "factorio"
     end,
     GetMods = function(self)
-        -- Convert self.mods.results to a table with fields id, name, version, enabled, game_id
-        if self.mods == nil or self.mods.results == nil then
-            return {}
-        end
+        -- Loaded on first use, so loading the plugin does not wait for the portal
+        self.catalogue = self.catalogue or loadMods()
         local mods = {}
-        for k, mod in pairs(self.mods.results) do
-            mods[#mods + 1] = {
-                id = mod.name,
-                name = mod.name,
-                -- latest_release.version
-                version = mod.latest_release and mod.latest_release.version or "unknown",
-                game_id = self:GetGameID(),
-                author = mod.owner or "Unknown",
-                game_versions = {
-                    [mod.latest_release.info_json.factorio_version] = mod.latest_release.version,
-                }
-            }
+        for _, mod in ipairs(self.catalogue) do
+            mods[#mods + 1] = portalMod(self, mod)
         end
         return mods
     end,
+    -- The whole catalogue if since is nil, otherwise the mods updated since then. The portal lists them newest
+    -- first, so paging stops at the first older one.
+    GetCatalogue = function(self, since)
+        if since == nil then
+            local results, err = fetchAllMods()
+            if results == nil then
+                return nil, err
+            end
+            local entries = {}
+            for _, mod in ipairs(results) do
+                entries[#entries + 1] = portalEntry(self, mod)
+            end
+            return { mods = entries, complete = true }
+        end
+
+        local entries = {}
+        local page = 1
+        while true do
+            local response, err = portalRequest(portal_url .. "?sort=updated_at&sort_order=desc&page_size=100&page=" .. page)
+            if response == nil then
+                return nil, err
+            end
+            for _, mod in ipairs(response.body.results or {}) do
+                local entry = portalEntry(self, mod)
+                if entry ~= nil then
+                    -- Both are UTC timestamps in ISO 8601, which sort as strings
+                    if entry.updated_at ~= nil and entry.updated_at < since then
+                        return { mods = entries, complete = false }
+                    end
+                    entries[#entries + 1] = entry
+                end
+            end
+            local pagination = response.body.pagination or {}
+            if pagination.page_count == nil or page >= pagination.page_count then
+                return { mods = entries, complete = false }
+            end
+            page = page + 1
+        end
+    end,
+    GetModReleases = function(self, id)
+        local response, err = http.request({
+            url = "https://mods.factorio.com/api/mods/" .. urlEscape(id) .. "/full",
+            retries = 2,
+            cache = { max_stale = 3600 },
+        })
+        if response == nil then
+            return nil, tostring(err)
+        end
+        if response.status_code == 404 then
+            return nil, "not_found"
+        end
+        if response.status_code ~= 200 then
+            return nil, "the mod portal answered " .. response.status_code
+        end
+        local changelog = changelogSections(response.body.changelog)
+        local releases = {}
+        for _, release in ipairs(response.body.releases or {}) do
+            local info = release.info_json or {}
+            releases[#releases + 1] = {
+                version = release.version,
+                game_versions = { info.factorio_version },
+                changelog = changelog[release.version],
+                released_at = release.released_at,
+            }
+        end
+        return releases
+    end,
+    -- Settings of a mod from mod-settings.dat. Factorio does not record which mod owns a setting, but mods prefix
+    -- their setting names with the mod name by convention.
+    GetModSettings = function(self, id)
+        local settings, err = modsettings.load(self:GetGameModDirectory() .. "mod-settings.dat")
+        if settings == nil then
+            return nil, err
+        end
+        return settings:settings(id)
+    end,
+    SetModSetting = function(self, scope, name, value)
+        local path = self:GetGameModDirectory() .. "mod-settings.dat"
+        local settings, err = modsettings.load(path)
+        if settings == nil then
+            return nil, err
+        end
+        local ok, set_err = settings:set(scope, name, value)
+        if not ok then
+            return nil, set_err
+        end
+        return settings:save(path)
+    end,
     GetModByID = function(self, id)
+        self.catalogue = self.catalogue or loadMods()
+        for _, mod in ipairs(self.catalogue) do
+            if mod.name == id then
+                return portalMod(self, mod)
+            end
+        end
+        return nil
     end,
-}
\ No newline at end of file
+}